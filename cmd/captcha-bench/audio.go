@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gofency/internal/captcha"
+	"gofency/internal/opus/decoder"
+)
+
+// Speech analysis parameters
+const (
+	// audioRate is the rate voice messages are analyzed at, the digits are
+	// recorded at 8 kHz
+	audioRate = 8000
+
+	// audioFrame is the length of an analysis frame, 20 ms
+	audioFrame = audioRate / 50
+
+	// audioBands is the number of frequency bands of a frame
+	audioBands = 16
+
+	// audioSlices is the number of time slices a spoken digit is averaged into
+	audioSlices = 8
+
+	// speechThreshold is the share of the loudest frame a frame needs to
+	// count as speech
+	speechThreshold = 0.08
+
+	// minPauseFrames and minSpeechFrames drop short pauses inside a digit
+	// and short bursts of noise
+	minPauseFrames  = 8
+	minSpeechFrames = 6
+)
+
+// digitTemplate is the spectrogram of a spoken digit seen in training
+type digitTemplate struct {
+	digit    rune
+	features []float64
+}
+
+// audioSolver cuts voice messages at the pauses between digits and reads
+// every digit with the nearest spectrogram heard in training
+type audioSolver struct {
+	templates []digitTemplate
+}
+
+func (s *audioSolver) name() string { return "audio" }
+
+func (s *audioSolver) applies(typ string) bool { return typ == captcha.TypeAudio }
+
+// train keeps the spectrograms of the training challenges that split into
+// as many digits as their answer has
+func (s *audioSolver) train(challenges []*captcha.Challenge) error {
+	s.templates = nil
+	for _, challenge := range challenges {
+		digits, err := spokenDigits(challenge.Media)
+		if err != nil {
+			return err
+		}
+		if len(digits) != len(challenge.Answer) {
+			continue
+		}
+		for i, r := range challenge.Answer {
+			s.templates = append(s.templates, digitTemplate{digit: r, features: digits[i]})
+		}
+	}
+	return nil
+}
+
+func (s *audioSolver) solve(challenge *captcha.Challenge) (string, error) {
+	if len(s.templates) == 0 {
+		return "", fmt.Errorf("no templates for %s", challenge.Type)
+	}
+
+	digits, err := spokenDigits(challenge.Media)
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, features := range digits {
+		best, bestDistance := '?', math.Inf(1)
+		for _, t := range s.templates {
+			if d := distance(t.features, features); d < bestDistance {
+				best, bestDistance = t.digit, d
+			}
+		}
+		text.WriteRune(best)
+	}
+	return text.String(), nil
+}
+
+// spokenDigits decodes a voice message and returns the features of every
+// stretch of speech in it
+func spokenDigits(media *captcha.Media) ([][]float64, error) {
+	if media == nil || media.Kind != captcha.MediaVoice {
+		return nil, fmt.Errorf("challenge has no voice message")
+	}
+	samples, rate, err := decoder.Decode(media.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	spectrogram := bandEnergies(downsample(samples, rate/audioRate))
+	var digits [][]float64
+	for _, span := range speechSpans(spectrogram) {
+		digits = append(digits, digitFeatures(spectrogram[span[0]:span[1]]))
+	}
+	return digits, nil
+}
+
+// downsample averages every factor samples
+func downsample(samples []int16, factor int) []float64 {
+	out := make([]float64, len(samples)/factor)
+	for i := range out {
+		var sum float64
+		for _, v := range samples[i*factor : (i+1)*factor] {
+			sum += float64(v)
+		}
+		out[i] = sum / float64(factor)
+	}
+	return out
+}
+
+// bandEnergies returns the energy of every frame in evenly spaced frequency
+// bands below the Nyquist frequency
+func bandEnergies(samples []float64) [][audioBands]float64 {
+	frames := make([][audioBands]float64, len(samples)/audioFrame)
+	for f := range frames {
+		frame := samples[f*audioFrame : (f+1)*audioFrame]
+		for band := range audioBands {
+			// Goertzel filter at the band center
+			freq := (float64(band) + 0.5) / audioBands / 2
+			coeff := 2 * math.Cos(2*math.Pi*freq)
+			var s1, s2 float64
+			for i, v := range frame {
+				// Hann window
+				w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/audioFrame)
+				s1, s2 = v*w+coeff*s1-s2, s1
+			}
+			frames[f][band] = s1*s1 + s2*s2 - coeff*s1*s2
+		}
+	}
+	return frames
+}
+
+// speechSpans returns the frame ranges that are louder than the threshold,
+// joined over short pauses
+func speechSpans(frames [][audioBands]float64) [][2]int {
+	loudness := make([]float64, len(frames))
+	peak := 0.0
+	for i, frame := range frames {
+		for _, e := range frame {
+			loudness[i] += e
+		}
+		peak = max(peak, loudness[i])
+	}
+
+	var spans [][2]int
+	start, quiet := -1, 0
+	for i, l := range loudness {
+		if l >= speechThreshold*peak {
+			if start < 0 {
+				start = i
+			}
+			quiet = 0
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		if quiet++; quiet >= minPauseFrames {
+			if end := i - quiet + 1; end-start >= minSpeechFrames {
+				spans = append(spans, [2]int{start, end})
+			}
+			start, quiet = -1, 0
+		}
+	}
+	if start >= 0 && len(loudness)-quiet-start >= minSpeechFrames {
+		spans = append(spans, [2]int{start, len(loudness) - quiet})
+	}
+	return spans
+}
+
+// digitFeatures averages the log band energies of a spoken digit into time
+// slices and removes the mean, which cancels the gain of the recording
+func digitFeatures(frames [][audioBands]float64) []float64 {
+	features := make([]float64, audioSlices*audioBands)
+	for slice := range audioSlices {
+		from := slice * len(frames) / audioSlices
+		to := max((slice+1)*len(frames)/audioSlices, from+1)
+		for _, frame := range frames[from:to] {
+			for band, e := range frame {
+				features[slice*audioBands+band] += math.Log(e+1) / float64(to-from)
+			}
+		}
+	}
+
+	var mean float64
+	for _, v := range features {
+		mean += v / float64(len(features))
+	}
+	for i := range features {
+		features[i] -= mean
+	}
+	return features
+}
+
+// distance is the squared Euclidean distance of two feature vectors
+func distance(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"math"
+	"sort"
+
+	"gofency/internal/captcha"
+)
+
+// grayImage holds the luminance and the color of every pixel
+type grayImage struct {
+	w, h int
+	lum  []float64
+	rgb  [][3]float64
+}
+
+// bitmap marks the pixels taken for ink by the binarization
+type bitmap struct {
+	w, h int
+	ink  []bool
+}
+
+// box is the bounding box of a group of ink pixels
+type box struct {
+	minX, minY, maxX, maxY int
+	area                   int
+}
+
+func (b box) width() int  { return b.maxX - b.minX + 1 }
+func (b box) height() int { return b.maxY - b.minY + 1 }
+
+// decodeMedia decodes a photo, or stacks the frames of an animation by
+// keeping the darkest value of every pixel, which reveals glyphs that are
+// only visible in some frames
+func decodeMedia(media *captcha.Media) (*grayImage, error) {
+	switch media.Kind {
+	case captcha.MediaPhoto:
+		img, err := png.Decode(bytes.NewReader(media.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		g := newGrayImage(img.Bounds())
+		g.stack(img)
+		return g, nil
+	case captcha.MediaAnimation:
+		anim, err := gif.DecodeAll(bytes.NewReader(media.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode animation: %w", err)
+		}
+		if len(anim.Image) == 0 {
+			return nil, fmt.Errorf("animation has no frames")
+		}
+		bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+		canvas := image.NewRGBA(bounds)
+		g := newGrayImage(bounds)
+		for _, frame := range anim.Image {
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			g.stack(canvas)
+		}
+		return g, nil
+	default:
+		return nil, fmt.Errorf("unsupported media kind %q", media.Kind)
+	}
+}
+
+func newGrayImage(bounds image.Rectangle) *grayImage {
+	n := bounds.Dx() * bounds.Dy()
+	g := &grayImage{w: bounds.Dx(), h: bounds.Dy(), lum: make([]float64, n), rgb: make([][3]float64, n)}
+	for i := range g.lum {
+		g.lum[i] = math.Inf(1)
+	}
+	return g
+}
+
+// stack keeps the darker of the current and the image pixels
+func (g *grayImage) stack(img image.Image) {
+	bounds := img.Bounds()
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			r, gr, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rgb := [3]float64{float64(r) / 0xffff, float64(gr) / 0xffff, float64(b) / 0xffff}
+			lum := relativeLuminance(rgb)
+			if i := y*g.w + x; lum < g.lum[i] {
+				g.lum[i], g.rgb[i] = lum, rgb
+			}
+		}
+	}
+}
+
+// relativeLuminance is the WCAG luminance of an sRGB color with channels in [0, 1]
+func relativeLuminance(rgb [3]float64) float64 {
+	var linear [3]float64
+	for i, c := range rgb {
+		if c <= 0.03928 {
+			linear[i] = c / 12.92
+		} else {
+			linear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*linear[0] + 0.7152*linear[1] + 0.0722*linear[2]
+}
+
+// binarize separates ink from background with Otsu's threshold
+func (g *grayImage) binarize() *bitmap {
+	const bins = 256
+	var histogram [bins]int
+	for _, l := range g.lum {
+		histogram[min(int(l*bins), bins-1)]++
+	}
+
+	total := len(g.lum)
+	sum := 0.0
+	for i, n := range histogram {
+		sum += float64(i * n)
+	}
+
+	best, threshold := 0.0, 0
+	sumBackground, weightBackground := 0.0, 0
+	for i, n := range histogram {
+		weightBackground += n
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+		sumBackground += float64(i * n)
+		meanBackground := sumBackground / float64(weightBackground)
+		meanForeground := (sum - sumBackground) / float64(weightForeground)
+		between := float64(weightBackground) * float64(weightForeground) * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if between > best {
+			best, threshold = between, i
+		}
+	}
+
+	bm := &bitmap{w: g.w, h: g.h, ink: make([]bool, total)}
+	for i, l := range g.lum {
+		bm.ink[i] = min(int(l*bins), bins-1) <= threshold
+	}
+	return bm
+}
+
+// contrast returns the WCAG contrast ratio between the mean ink and the
+// mean background color and the share of ink pixels
+func (g *grayImage) contrast(bm *bitmap) (ratio, coverage float64) {
+	var ink, background [3]float64
+	inkPixels := 0
+	for i, rgb := range g.rgb {
+		target := &background
+		if bm.ink[i] {
+			target = &ink
+			inkPixels++
+		}
+		for c := range rgb {
+			target[c] += rgb[c]
+		}
+	}
+	if inkPixels == 0 || inkPixels == len(g.rgb) {
+		return 1, float64(inkPixels) / float64(len(g.rgb))
+	}
+
+	for c := range ink {
+		ink[c] /= float64(inkPixels)
+		background[c] /= float64(len(g.rgb) - inkPixels)
+	}
+	dark, light := relativeLuminance(ink), relativeLuminance(background)
+	if dark > light {
+		dark, light = light, dark
+	}
+	return (light + 0.05) / (dark + 0.05), float64(inkPixels) / float64(len(g.rgb))
+}
+
+// components labels 8-connected groups of ink pixels and returns the boxes of
+// those with at least minArea pixels, from left to right. Smaller groups are
+// erased from the bitmap as noise.
+func (bm *bitmap) components(minArea int) []box {
+	labels := make([]int, len(bm.ink))
+	var boxes []box
+	var stack, pixels []int
+
+	for start, ink := range bm.ink {
+		if !ink || labels[start] != 0 {
+			continue
+		}
+
+		label := len(boxes) + 1
+		b := box{minX: bm.w, minY: bm.h, maxX: -1, maxY: -1}
+		pixels = pixels[:0]
+		stack = append(stack[:0], start)
+		labels[start] = label
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pixels = append(pixels, i)
+
+			x, y := i%bm.w, i/bm.w
+			b.minX, b.maxX = min(b.minX, x), max(b.maxX, x)
+			b.minY, b.maxY = min(b.minY, y), max(b.maxY, y)
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= bm.w || ny >= bm.h {
+						continue
+					}
+					if j := ny*bm.w + nx; bm.ink[j] && labels[j] == 0 {
+						labels[j] = label
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+
+		b.area = len(pixels)
+		if b.area < minArea {
+			for _, i := range pixels {
+				bm.ink[i] = false
+			}
+			// Keep the label so the pixels are not visited again
+			boxes = append(boxes, box{})
+			continue
+		}
+		boxes = append(boxes, b)
+	}
+
+	kept := boxes[:0]
+	for _, b := range boxes {
+		if b.area > 0 {
+			kept = append(kept, b)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].minX < kept[j].minX })
+	return kept
+}
+
+// mergeStacked joins boxes lying mostly above one another, like the parts of "=" or "?"
+func mergeStacked(boxes []box) []box {
+	var merged []box
+	for _, b := range boxes {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			overlap := min(last.maxX, b.maxX) - max(last.minX, b.minX) + 1
+			if 2*overlap > min(last.width(), b.width()) {
+				last.minX, last.maxX = min(last.minX, b.minX), max(last.maxX, b.maxX)
+				last.minY, last.maxY = min(last.minY, b.minY), max(last.maxY, b.maxY)
+				last.area += b.area
+				continue
+			}
+		}
+		merged = append(merged, b)
+	}
+	return merged
+}
+
+// columnSegments cuts the ink into boxes at empty columns, from left to right
+func (bm *bitmap) columnSegments() []box {
+	var boxes []box
+	current := box{maxX: -1}
+
+	for x := 0; x < bm.w; x++ {
+		columnInk := 0
+		minY, maxY := bm.h, -1
+		for y := 0; y < bm.h; y++ {
+			if bm.ink[y*bm.w+x] {
+				columnInk++
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+
+		if columnInk == 0 {
+			if current.maxX >= 0 {
+				boxes = append(boxes, current)
+				current = box{maxX: -1}
+			}
+			continue
+		}
+
+		if current.maxX < 0 {
+			current = box{minX: x, minY: minY, maxX: x, maxY: maxY}
+		}
+		current.maxX = x
+		current.minY, current.maxY = min(current.minY, minY), max(current.maxY, maxY)
+		current.area += columnInk
+	}
+	if current.maxX >= 0 {
+		boxes = append(boxes, current)
+	}
+	return boxes
+}
+
+// splitWide splits boxes much wider than a glyph into glyph-wide parts, as
+// touching glyphs end up in the same box
+func splitWide(boxes []box, glyphWidth int) []box {
+	var split []box
+	for _, b := range boxes {
+		parts := int(math.Round(float64(b.width()) / float64(glyphWidth)))
+		if parts < 2 {
+			split = append(split, b)
+			continue
+		}
+		for i := 0; i < parts; i++ {
+			part := b
+			part.minX = b.minX + i*b.width()/parts
+			part.maxX = b.minX + (i+1)*b.width()/parts - 1
+			split = append(split, part)
+		}
+	}
+	return split
+}
+
+// shape samples the ink of the box on a fixed grid, so glyphs of any size compare
+func (bm *bitmap) shape(b box) []bool {
+	cells := make([]bool, shapeWidth*shapeHeight)
+	for cy := 0; cy < shapeHeight; cy++ {
+		for cx := 0; cx < shapeWidth; cx++ {
+			x0 := b.minX + cx*b.width()/shapeWidth
+			x1 := max(b.minX+(cx+1)*b.width()/shapeWidth, x0+1)
+			y0 := b.minY + cy*b.height()/shapeHeight
+			y1 := max(b.minY+(cy+1)*b.height()/shapeHeight, y0+1)
+
+			ink, total := 0, 0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					total++
+					if bm.ink[y*bm.w+x] {
+						ink++
+					}
+				}
+			}
+			cells[cy*shapeWidth+cx] = 2*ink >= total
+		}
+	}
+	return cells
+}
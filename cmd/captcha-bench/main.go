@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/config"
+)
+
+// benchTypes are the challenge types that need no chat data
+var benchTypes = []string{
+	captcha.TypeDigits,
+	captcha.TypeMath,
+	captcha.TypeAnimated,
+	captcha.TypeAudio,
+	captcha.TypeGrid,
+	captcha.TypeSequence,
+	captcha.TypeButton,
+}
+
+// result is the outcome of a benchmark of one type and difficulty
+type result struct {
+	typ        string
+	difficulty captcha.Difficulty
+
+	perSecond  float64
+	avgSize    float64
+	contrast   float64
+	coverage   float64
+	components float64
+	images     int
+
+	// solved counts the challenges each solver answered correctly,
+	// solvers that do not apply to the type are missing
+	solved map[string]int
+	total  int
+}
+
+func main() {
+	count := flag.Int("n", 100, "number of challenges attacked per type and difficulty")
+	trainCount := flag.Int("train", 100, "number of challenges with known answers the solvers learn from")
+	types := flag.String("types", strings.Join(benchTypes, ","), "comma-separated challenge types")
+	difficulties := flag.String("difficulties", "easy,normal,hard", "comma-separated difficulty profiles; custom is read from the CAPTCHA_* variables")
+	seed := flag.Uint64("seed", 0, "seed for reproducible output (random if not set)")
+	audioDir := flag.String("audio-dir", "", "directory with recorded digit samples for audio captchas")
+	language := flag.String("language", "", "language code of the attacked members, selects localized alphabets")
+	maxSolveRate := flag.Float64("max-solve-rate", 100, "exit with status 1 if any solver answers more than this percentage")
+	flag.Parse()
+
+	if *count < 1 || *trainCount < 1 {
+		log.Fatalf("Invalid -n or -train: both must be positive")
+	}
+
+	cfg, err := config.LoadCaptchaConfig()
+	if err != nil {
+		log.Fatalf("Failed to load captcha config: %v", err)
+	}
+
+	service := captcha.NewService("")
+	if err := service.SetProfile(captcha.DifficultyCustom, cfg.Custom); err != nil {
+		log.Fatalf("Invalid custom captcha profile: %v", err)
+	}
+	if isFlagSet("seed") {
+		service.SetSource(captcha.NewSeededSource(*seed))
+	}
+
+	registry, err := newRegistry(service, cfg.Math, *audioDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize captcha providers: %v", err)
+	}
+
+	var levels []captcha.Difficulty
+	for _, value := range strings.Split(*difficulties, ",") {
+		d, err := captcha.ParseDifficulty(strings.TrimSpace(value))
+		if err != nil {
+			log.Fatalf("Invalid -difficulties: %v", err)
+		}
+		levels = append(levels, d)
+	}
+
+	solvers := []solver{&frequencySolver{}, newTemplateSolver(), newComponentSolver(), &audioSolver{}}
+
+	var results []result
+	for _, typ := range strings.Split(*types, ",") {
+		typ = strings.TrimSpace(typ)
+		provider, ok := registry.Get(typ)
+		if !ok {
+			log.Fatalf("Invalid -types: unknown type %q, want some of %v", typ, benchTypes)
+		}
+		for _, d := range levels {
+			fmt.Fprintf(os.Stderr, "Benchmarking %s (%s)...\n", typ, d)
+			r, err := bench(registry, provider, d, *language, solvers, *trainCount, *count)
+			if err != nil {
+				log.Fatalf("Failed to benchmark %s (%s): %v", typ, d, err)
+			}
+			results = append(results, r)
+		}
+	}
+
+	printResults(os.Stdout, results, solvers)
+
+	failed := false
+	for _, r := range results {
+		for _, s := range solvers {
+			solved, ok := r.solved[s.name()]
+			if rate := percent(solved, r.total); ok && rate > *maxSolveRate {
+				fmt.Fprintf(os.Stderr, "%s solver answered %.1f%% of %s (%s) challenges, above %.1f%%\n", s.name(), rate, r.typ, r.difficulty, *maxSolveRate)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// bench trains the solvers on fresh challenges and attacks count more
+func bench(registry *captcha.Registry, provider captcha.ChallengeProvider, d captcha.Difficulty, language string, solvers []solver, trainCount, count int) (result, error) {
+	r := result{typ: provider.Type(), difficulty: d, solved: make(map[string]int), total: count}
+	req := captcha.Request{Difficulty: d, LanguageCode: language}
+
+	training := make([]*captcha.Challenge, trainCount)
+	for i := range training {
+		challenge, err := provider.NewChallenge(context.Background(), req)
+		if err != nil {
+			return result{}, fmt.Errorf("failed to generate challenge: %w", err)
+		}
+		training[i] = challenge
+	}
+
+	var active []solver
+	for _, s := range solvers {
+		if !s.applies(r.typ) {
+			continue
+		}
+		if err := s.train(training); err != nil {
+			return result{}, fmt.Errorf("failed to train %s solver: %w", s.name(), err)
+		}
+		active = append(active, s)
+		r.solved[s.name()] = 0
+	}
+
+	challenges := make([]*captcha.Challenge, count)
+	start := time.Now()
+	for i := range challenges {
+		challenge, err := provider.NewChallenge(context.Background(), req)
+		if err != nil {
+			return result{}, fmt.Errorf("failed to generate challenge: %w", err)
+		}
+		challenges[i] = challenge
+	}
+	r.perSecond = float64(count) / time.Since(start).Seconds()
+
+	for _, challenge := range challenges {
+		if challenge.Media != nil {
+			r.avgSize += float64(len(challenge.Media.Data)) / float64(count)
+			if err := r.addReadability(challenge.Media); err != nil {
+				return result{}, err
+			}
+		}
+
+		for _, s := range active {
+			guess, err := s.solve(challenge)
+			if err == nil && registry.Verify(r.typ, challenge.Answer, guess) {
+				r.solved[s.name()]++
+			}
+		}
+	}
+
+	if r.images > 0 {
+		r.contrast /= float64(r.images)
+		r.coverage /= float64(r.images)
+		r.components /= float64(r.images)
+	}
+	return r, nil
+}
+
+// addReadability adds the readability heuristics of an image challenge: the
+// contrast between ink and background, the share of ink and the number of
+// separate ink groups. Low contrast or heavy ink hurt humans first.
+func (r *result) addReadability(media *captcha.Media) error {
+	if media.Kind != captcha.MediaPhoto && media.Kind != captcha.MediaAnimation {
+		return nil
+	}
+
+	g, err := decodeMedia(media)
+	if err != nil {
+		return err
+	}
+	bm := g.binarize()
+	contrast, coverage := g.contrast(bm)
+
+	r.images++
+	r.contrast += contrast
+	r.coverage += coverage
+	r.components += float64(len(bm.components(minArea(bm))))
+	return nil
+}
+
+func printResults(out *os.File, results []result, solvers []solver) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	header := "TYPE\tDIFFICULTY\tGEN/S\tSIZE KB\tCONTRAST\tINK\tGROUPS\t"
+	for _, s := range solvers {
+		header += strings.ToUpper(s.name()) + "\t"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, r := range results {
+		line := fmt.Sprintf("%s\t%s\t%.1f\t%.1f\t", r.typ, r.difficulty, r.perSecond, r.avgSize/1024)
+		if r.images > 0 {
+			line += fmt.Sprintf("%.2f\t%.1f%%\t%.1f\t", r.contrast, 100*r.coverage, r.components)
+		} else {
+			line += "-\t-\t-\t"
+		}
+		for _, s := range solvers {
+			if solved, ok := r.solved[s.name()]; ok {
+				line += fmt.Sprintf("%.1f%%\t", percent(solved, r.total))
+			} else {
+				line += "-\t"
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+func percent(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
+
+func newRegistry(service *captcha.Service, mathOpts captcha.MathOptions, audioDir string) (*captcha.Registry, error) {
+	mathProvider, err := captcha.NewMathProvider(service, mathOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	audioProvider, err := captcha.NewAudioProvider(service, audioDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return captcha.NewRegistry(
+		service,
+		mathProvider,
+		captcha.NewAnimatedProvider(service),
+		audioProvider,
+		captcha.NewGridProvider(service),
+		captcha.NewSequenceProvider(service),
+		captcha.NewButtonProvider(),
+	)
+}
+
+// isFlagSet reports whether the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\nAttacks generated captchas with naive solvers and reports how many they answer.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
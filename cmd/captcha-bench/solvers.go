@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gofency/internal/captcha"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size of the grid glyph shapes are compared on
+const (
+	shapeWidth  = 12
+	shapeHeight = 16
+
+	// aspectWeight weighs the difference of the width to height ratios
+	// against the number of differing shape cells
+	aspectWeight = 24.0
+)
+
+// mathChars are the glyphs of rendered arithmetic expressions
+const mathChars = "0123456789+-×=?"
+
+// solver is a naive automated attack on challenges
+type solver interface {
+	name() string
+
+	// applies reports whether the solver can attack challenges of the type
+	applies(typ string) bool
+
+	// train lets the solver learn from challenges with known answers
+	train(challenges []*captcha.Challenge) error
+
+	// solve returns the guessed answer of the challenge
+	solve(challenge *captcha.Challenge) (string, error)
+}
+
+// frequencySolver always submits the answer seen most often in training,
+// or the most frequent character at every position when no answer repeats.
+// Button answers are learned as keyboard positions, tokens are random.
+type frequencySolver struct {
+	guess string
+}
+
+func (s *frequencySolver) name() string { return "frequency" }
+
+func (s *frequencySolver) applies(typ string) bool { return true }
+
+func (s *frequencySolver) train(challenges []*captcha.Challenge) error {
+	answers := make(map[string]int)
+	lengths := make(map[int]int)
+	for _, challenge := range challenges {
+		key := answerKey(challenge)
+		answers[key]++
+		lengths[len([]rune(key))]++
+	}
+
+	guess, count := mostFrequent(answers)
+	if count > 1 || len(challenges) == 0 {
+		s.guess = guess
+		return nil
+	}
+
+	// No answer repeats, build the most likely one character by character
+	length := 0
+	for l, n := range lengths {
+		if n > lengths[length] || (n == lengths[length] && l < length) {
+			length = l
+		}
+	}
+	positional := make([]rune, length)
+	for i := range positional {
+		chars := make(map[string]int)
+		for _, challenge := range challenges {
+			if key := []rune(answerKey(challenge)); i < len(key) {
+				chars[string(key[i])]++
+			}
+		}
+		char, _ := mostFrequent(chars)
+		positional[i] = []rune(char)[0]
+	}
+	s.guess = string(positional)
+	return nil
+}
+
+func (s *frequencySolver) solve(challenge *captcha.Challenge) (string, error) {
+	return keyAnswer(challenge, s.guess), nil
+}
+
+// answerKey describes the answer independently of random button tokens
+func answerKey(challenge *captcha.Challenge) string {
+	if challenge.Modality != captcha.ModalityButton && challenge.Modality != captcha.ModalitySequence {
+		return challenge.Answer
+	}
+
+	positions := make(map[string]int)
+	for _, button := range flatButtons(challenge) {
+		positions[button.Value] = len(positions)
+	}
+	var key []string
+	for _, token := range strings.Split(challenge.Answer, ",") {
+		key = append(key, strconv.Itoa(positions[token]))
+	}
+	return strings.Join(key, ",")
+}
+
+// keyAnswer turns a key of answerKey back into an answer of the challenge
+func keyAnswer(challenge *captcha.Challenge, key string) string {
+	if challenge.Modality != captcha.ModalityButton && challenge.Modality != captcha.ModalitySequence {
+		return key
+	}
+
+	buttons := flatButtons(challenge)
+	var answer []string
+	for _, field := range strings.Split(key, ",") {
+		if i, err := strconv.Atoi(field); err == nil && i < len(buttons) {
+			answer = append(answer, buttons[i].Value)
+		}
+	}
+	return strings.Join(answer, ",")
+}
+
+func flatButtons(challenge *captcha.Challenge) []captcha.Button {
+	var buttons []captcha.Button
+	for _, row := range challenge.Buttons {
+		buttons = append(buttons, row...)
+	}
+	return buttons
+}
+
+// mostFrequent returns the most frequent key, the smallest one on ties
+func mostFrequent(counts map[string]int) (string, int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	best := ""
+	for _, key := range keys {
+		if best == "" || counts[key] > counts[best] {
+			best = key
+		}
+	}
+	return best, counts[best]
+}
+
+// glyphTemplate is the shape of an undistorted glyph
+type glyphTemplate struct {
+	char   rune
+	cells  []bool
+	aspect float64
+}
+
+// ocrSolver binarizes text images, cuts the ink into glyph boxes and reads
+// every box with the nearest undistorted glyph template
+type ocrSolver struct {
+	label     string
+	segment   func(bm *bitmap) []box
+	templates map[string][]glyphTemplate
+}
+
+// newComponentSolver segments glyphs as connected groups of ink pixels
+func newComponentSolver() *ocrSolver {
+	return &ocrSolver{
+		label:   "components",
+		segment: func(bm *bitmap) []box { return mergeStacked(bm.components(minArea(bm))) },
+	}
+}
+
+// newTemplateSolver segments glyphs at the empty columns between them
+func newTemplateSolver() *ocrSolver {
+	return &ocrSolver{
+		label: "template",
+		segment: func(bm *bitmap) []box {
+			bm.components(minArea(bm))
+			return bm.columnSegments()
+		},
+	}
+}
+
+// minArea is the size of the smallest ink group taken for a glyph part
+func minArea(bm *bitmap) int {
+	return max(bm.h*bm.h/100, 4)
+}
+
+func (s *ocrSolver) name() string { return s.label }
+
+func (s *ocrSolver) applies(typ string) bool {
+	switch typ {
+	case captcha.TypeDigits, captcha.TypeMath, captcha.TypeAnimated:
+		return true
+	}
+	return false
+}
+
+// train renders templates of the characters seen in the training answers
+func (s *ocrSolver) train(challenges []*captcha.Challenge) error {
+	charsets := make(map[string]map[rune]bool)
+	for _, challenge := range challenges {
+		chars := charsets[challenge.Type]
+		if chars == nil {
+			chars = make(map[rune]bool)
+			charsets[challenge.Type] = chars
+		}
+
+		answer := challenge.Answer
+		if challenge.Type == captcha.TypeMath {
+			answer = mathChars
+		}
+		for _, r := range answer {
+			chars[r] = true
+		}
+	}
+
+	s.templates = make(map[string][]glyphTemplate, len(charsets))
+	for typ, chars := range charsets {
+		templates, err := renderTemplates(chars)
+		if err != nil {
+			return err
+		}
+		s.templates[typ] = templates
+	}
+	return nil
+}
+
+func (s *ocrSolver) solve(challenge *captcha.Challenge) (string, error) {
+	templates := s.templates[challenge.Type]
+	if len(templates) == 0 {
+		return "", fmt.Errorf("no templates for %s", challenge.Type)
+	}
+
+	g, err := decodeMedia(challenge.Media)
+	if err != nil {
+		return "", err
+	}
+	bm := g.binarize()
+	boxes := s.segment(bm)
+	if len(boxes) == 0 {
+		return "", nil
+	}
+
+	// Glyphs are about as wide as the templates at the height of the ink
+	heights := make([]int, len(boxes))
+	for i, b := range boxes {
+		heights[i] = b.height()
+	}
+	sort.Ints(heights)
+	glyphWidth := max(int(float64(heights[len(heights)/2])*meanAspect(templates)), 1)
+
+	var text strings.Builder
+	for _, b := range splitWide(boxes, glyphWidth) {
+		text.WriteRune(nearestGlyph(templates, bm.shape(b), float64(b.width())/float64(b.height())))
+	}
+
+	if challenge.Type == captcha.TypeMath {
+		return evaluateExpression(text.String())
+	}
+	return text.String(), nil
+}
+
+// renderTemplates draws the characters with the captcha font without distortion
+func renderTemplates(chars map[rune]bool) ([]glyphTemplate, error) {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	const size = 64
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	defer face.Close()
+
+	runes := make([]rune, 0, len(chars))
+	for r := range chars {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	templates := make([]glyphTemplate, 0, len(runes))
+	for _, r := range runes {
+		img := image.NewAlpha(image.Rect(0, 0, 2*size, 2*size))
+		d := &font.Drawer{Dst: img, Src: image.Opaque, Face: face, Dot: fixed.P(size/2, size*3/2)}
+		d.DrawString(string(r))
+
+		bm := &bitmap{w: img.Bounds().Dx(), h: img.Bounds().Dy(), ink: make([]bool, len(img.Pix))}
+		b := box{minX: bm.w, minY: bm.h, maxX: -1, maxY: -1}
+		for i, a := range img.Pix {
+			if a < 128 {
+				continue
+			}
+			bm.ink[i] = true
+			x, y := i%bm.w, i/bm.w
+			b.minX, b.maxX = min(b.minX, x), max(b.maxX, x)
+			b.minY, b.maxY = min(b.minY, y), max(b.maxY, y)
+		}
+		if b.maxX < 0 {
+			continue
+		}
+
+		templates = append(templates, glyphTemplate{
+			char:   r,
+			cells:  bm.shape(b),
+			aspect: float64(b.width()) / float64(b.height()),
+		})
+	}
+	return templates, nil
+}
+
+// meanAspect is the average width to height ratio of the templates
+func meanAspect(templates []glyphTemplate) float64 {
+	sum := 0.0
+	for _, t := range templates {
+		sum += min(t.aspect, 1)
+	}
+	return sum / float64(len(templates))
+}
+
+// nearestGlyph returns the character of the template closest to the shape
+func nearestGlyph(templates []glyphTemplate, cells []bool, aspect float64) rune {
+	best, bestDistance := templates[0].char, math.Inf(1)
+	for _, t := range templates {
+		distance := aspectWeight * math.Abs(math.Log(t.aspect/aspect))
+		for i, ink := range cells {
+			if ink != t.cells[i] {
+				distance++
+			}
+		}
+		if distance < bestDistance {
+			best, bestDistance = t.char, distance
+		}
+	}
+	return best
+}
+
+// evaluateExpression computes a recognized "a+b×c=?" expression
+func evaluateExpression(text string) (string, error) {
+	if i := strings.IndexRune(text, '='); i >= 0 {
+		text = text[:i]
+	}
+
+	var operands []int
+	var operators []rune
+	number := ""
+	for _, r := range text + "+" {
+		if r >= '0' && r <= '9' {
+			number += string(r)
+			continue
+		}
+		if !strings.ContainsRune("+-×", r) || number == "" {
+			return "", fmt.Errorf("unreadable expression %q", text)
+		}
+		n, _ := strconv.Atoi(number)
+		operands = append(operands, n)
+		operators = append(operators, r)
+		number = ""
+	}
+
+	// Multiplication binds first, then the terms are summed
+	result, sign, term := 0, 1, operands[0]
+	for i, op := range operators[:len(operators)-1] {
+		next := operands[i+1]
+		switch op {
+		case '×':
+			term *= next
+		default:
+			result += sign * term
+			term, sign = next, 1
+			if op == '-' {
+				sign = -1
+			}
+		}
+	}
+	return strconv.Itoa(result + sign*term), nil
+}
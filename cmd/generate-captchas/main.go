@@ -1,44 +1,201 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
 	"gofency/internal/captcha"
+	"gofency/internal/config"
 )
 
+// packableTypes are the challenge types answered with text that can be stored in packs
+var packableTypes = []string{captcha.TypeDigits, captcha.TypeMath, captcha.TypeAnimated, captcha.TypeAudio}
+
 func main() {
-	// Create captcha service
-	service := captcha.NewService("assets")
+	count := flag.Int("count", 20, "number of captchas to generate")
+	out := flag.String("out", "assets/captcha", "output directory of the pack")
+	typ := flag.String("type", captcha.TypeDigits, "challenge type: digits, math, animated or audio")
+	difficulty := flag.String("difficulty", string(captcha.DifficultyNormal), "difficulty profile: easy, normal, hard or custom, which is read from the CAPTCHA_* variables")
+	locale := flag.String("locale", "", "locale recorded in the manifest and selecting localized alphabets, empty for any language")
+	seed := flag.Uint64("seed", 0, "seed for reproducible output (random if not set)")
+	manifest := flag.String("manifest", "", "manifest path (default <out>/manifest.json)")
+	preview := flag.String("preview", "", "write a contact sheet PNG of the pack to this path")
+	previewAnswers := flag.Bool("preview-answers", false, "print plaintext answers on the contact sheet")
+	audioDir := flag.String("audio-dir", "", "directory with recorded digit samples for audio captchas")
+	replay := flag.Uint64("replay", 0, "regenerate the single challenge of a seed logged by the bot")
+	flag.Parse()
+
+	d, err := captcha.ParseDifficulty(*difficulty)
+	if err != nil {
+		log.Fatalf("Invalid -difficulty: %v", err)
+	}
+	if *count < 1 {
+		log.Fatalf("Invalid -count: %d", *count)
+	}
+
+	cfg, err := config.LoadCaptchaConfig()
+	if err != nil {
+		log.Fatalf("Failed to load captcha config: %v", err)
+	}
+
+	// Create captcha service with the custom profile the bot would use
+	service := captcha.NewService("")
+	if err := service.SetProfile(captcha.DifficultyCustom, cfg.Custom); err != nil {
+		log.Fatalf("Invalid custom captcha profile: %v", err)
+	}
+	if isFlagSet("seed") {
+		service.SetSource(captcha.NewSeededSource(*seed))
+	}
+
+	registry, err := newRegistry(service, cfg.Math, *audioDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize captcha providers: %v", err)
+	}
+
+	if isFlagSet("replay") {
+		if err := replayChallenge(registry, *typ, d, *locale, *replay, *out); err != nil {
+			log.Fatalf("Failed to replay captcha: %v", err)
+		}
+		return
+	}
 
-	// Generate sample captchas with specific answers
-	samples := []string{"1234", "5678", "9012", "3456", "7890", "2468", "1357", "9753"}
+	if !isPackable(*typ) {
+		log.Fatalf("Invalid -type %q, want one of %v", *typ, packableTypes)
+	}
 
-	captchaDir := "assets/captcha"
-	if err := os.MkdirAll(captchaDir, 0755); err != nil {
-		log.Fatalf("Failed to create directory: %v", err)
+	pack, err := captcha.NewPackWriter(*out)
+	if err != nil {
+		log.Fatalf("Failed to create pack: %v", err)
+	}
+	if *manifest != "" {
+		if err := pack.SetManifestPath(*manifest); err != nil {
+			log.Fatalf("Invalid -manifest: %v", err)
+		}
 	}
 
-	fmt.Println("Generating sample captcha images...")
+	fmt.Printf("Generating %d %s captchas (%s) in %s...\n", *count, *typ, d, *out)
 
-	for i, answer := range samples {
-		img, err := service.Generate()
+	var sheet []previewItem
+	for i := 0; i < *count; i++ {
+		challenge, err := registry.Generate(context.Background(), captcha.Request{
+			LanguageCode: *locale,
+			Difficulty:   d,
+		}, *typ)
 		if err != nil {
-			log.Printf("Failed to generate captcha %d: %v", i, err)
-			continue
+			log.Fatalf("Failed to generate captcha %d: %v", i, err)
 		}
 
-		// Save with the answer as filename
-		filename := filepath.Join(captchaDir, answer+".png")
-		if err := os.WriteFile(filename, img.Image, 0644); err != nil {
-			log.Printf("Failed to write captcha file %s: %v", filename, err)
-			continue
+		// Files get random names, answers are only stored hashed in the manifest
+		item, err := pack.Add(challenge, d, *locale)
+		if err != nil {
+			log.Fatalf("Failed to add captcha %d to pack: %v", i, err)
 		}
 
-		fmt.Printf("Generated %s\n", filename)
+		sheet = append(sheet, previewItem{item: item, answer: challenge.Answer, data: challenge.Media.Data})
+		fmt.Printf("Generated %s\n", item.File)
+	}
+
+	if err := pack.Close(); err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
 	}
 
-	fmt.Println("Done! Generated", len(samples), "captcha images.")
+	if *preview != "" {
+		if err := writeContactSheet(*preview, sheet, *previewAnswers); err != nil {
+			log.Fatalf("Failed to write preview: %v", err)
+		}
+		fmt.Println("Preview written to", *preview)
+	}
+
+	fmt.Println("Done! Generated", *count, "captchas in", *out)
+}
+
+func newRegistry(service *captcha.Service, mathOpts captcha.MathOptions, audioDir string) (*captcha.Registry, error) {
+	mathProvider, err := captcha.NewMathProvider(service, mathOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	audioProvider, err := captcha.NewAudioProvider(service, audioDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return captcha.NewRegistry(
+		service,
+		mathProvider,
+		captcha.NewAnimatedProvider(service),
+		audioProvider,
+		captcha.NewGridProvider(service),
+		captcha.NewSequenceProvider(service),
+	)
+}
+
+// replayChallenge regenerates the challenge of the seed and writes its media
+// to <out>/replay-<seed>.<ext>. The type, difficulty and locale must match
+// the logged ones.
+func replayChallenge(registry *captcha.Registry, typ string, d captcha.Difficulty, locale string, seed uint64, out string) error {
+	if seed == 0 {
+		return fmt.Errorf("invalid seed 0")
+	}
+	if _, ok := registry.Get(typ); !ok {
+		return fmt.Errorf("unknown type %q, want one of %v", typ, registry.Types())
+	}
+
+	challenge, err := registry.Generate(context.Background(), captcha.Request{
+		LanguageCode: locale,
+		Difficulty:   d,
+		Seed:         seed,
+	}, typ)
+	if err != nil {
+		return err
+	}
+	if challenge.Media == nil {
+		return fmt.Errorf("%s challenges have no media", typ)
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	path := filepath.Join(out, fmt.Sprintf("replay-%d%s", seed, filepath.Ext(challenge.Media.Filename)))
+	if err := os.WriteFile(path, challenge.Media.Data, 0o644); err != nil {
+		return fmt.Errorf("failed to write challenge: %w", err)
+	}
+
+	fmt.Printf("Replayed %s captcha (%s) with seed %d to %s\n", typ, d, seed, path)
+	// Button tokens are random on every run, only the image is reproduced
+	if challenge.Modality == captcha.ModalityText || challenge.Modality == captcha.ModalitySelection {
+		fmt.Println("Answer:", challenge.Answer)
+	}
+	return nil
+}
+
+func isPackable(typ string) bool {
+	for _, t := range packableTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// isFlagSet reports whether the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\nGenerates a captcha asset pack.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 }
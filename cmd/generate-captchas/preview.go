@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+
+	"gofency/internal/captcha"
+	"gofency/internal/opus/decoder"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Contact sheet layout
+const (
+	sheetColumns = 4
+	tileWidth    = 240
+	tileHeight   = 100
+	labelHeight  = 18
+	sheetPadding = 8
+)
+
+// previewItem is a generated captcha shown on the contact sheet
+type previewItem struct {
+	item   captcha.PackItem
+	answer string
+	data   []byte
+}
+
+// writeContactSheet draws all captchas of the pack in a grid, labelled with
+// their file names and optionally their answers
+func writeContactSheet(path string, items []previewItem, showAnswers bool) error {
+	rows := (len(items) + sheetColumns - 1) / sheetColumns
+	cellWidth := tileWidth + sheetPadding
+	cellHeight := tileHeight + labelHeight + sheetPadding
+
+	sheet := image.NewRGBA(image.Rect(0, 0, sheetColumns*cellWidth+sheetPadding, rows*cellHeight+sheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, item := range items {
+		x := sheetPadding + (i%sheetColumns)*cellWidth
+		y := sheetPadding + (i/sheetColumns)*cellHeight
+		tile := image.Rect(x, y, x+tileWidth, y+tileHeight)
+
+		label, err := drawTile(sheet, tile, item)
+		if err != nil {
+			return fmt.Errorf("failed to draw %s: %w", item.item.File, err)
+		}
+
+		// Short file prefix is enough to find the file in the pack
+		label = fmt.Sprintf("#%d %s %s", i+1, item.item.File[:8], label)
+		if showAnswers {
+			label += " = " + item.answer
+		}
+		drawLabel(sheet, x, y+tileHeight+labelHeight-5, label)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		return fmt.Errorf("failed to encode contact sheet: %w", err)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// drawTile draws the captcha media into the tile and returns a short media note
+func drawTile(dst *image.RGBA, tile image.Rectangle, item previewItem) (string, error) {
+	switch item.item.Media {
+	case captcha.MediaPhoto:
+		img, err := png.Decode(bytes.NewReader(item.data))
+		if err != nil {
+			return "", err
+		}
+		draw.Draw(dst, tile, img, img.Bounds().Min, draw.Src)
+		return "", nil
+	case captcha.MediaAnimation:
+		anim, err := gif.DecodeAll(bytes.NewReader(item.data))
+		if err != nil {
+			return "", err
+		}
+		// Frames show different glyphs, overlaying them reveals the whole answer
+		for _, frame := range anim.Image {
+			draw.DrawMask(dst, tile, frame, frame.Bounds().Min, image.NewUniform(color.Alpha{A: 96}), image.Point{}, draw.Over)
+		}
+		return fmt.Sprintf("(%d frames)", len(anim.Image)), nil
+	case captcha.MediaVoice:
+		seconds, err := drawWaveform(dst, tile, item.data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%.1fs)", seconds), nil
+	default:
+		return "", fmt.Errorf("unsupported media %q", item.item.Media)
+	}
+}
+
+// drawWaveform draws the peaks of a voice message and returns its duration
+func drawWaveform(dst *image.RGBA, tile image.Rectangle, data []byte) (float64, error) {
+	samples, rate, err := decoder.Decode(data)
+	if err != nil {
+		return 0, err
+	}
+
+	draw.Draw(dst, tile, image.NewUniform(color.RGBA{240, 240, 240, 255}), image.Point{}, draw.Src)
+	if len(samples) == 0 {
+		return 0, nil
+	}
+
+	mid := tile.Min.Y + tile.Dy()/2
+	perColumn := (len(samples) + tile.Dx() - 1) / tile.Dx()
+	for col := 0; col < tile.Dx(); col++ {
+		peak := 0
+		for i := col * perColumn; i < (col+1)*perColumn && i < len(samples); i++ {
+			v := int(samples[i])
+			if v < 0 {
+				v = -v
+			}
+			if v > peak {
+				peak = v
+			}
+		}
+		h := peak * (tile.Dy() / 2) / 32768
+		for y := mid - h; y <= mid+h; y++ {
+			dst.Set(tile.Min.X+col, y, color.RGBA{40, 90, 160, 255})
+		}
+	}
+
+	return float64(len(samples)) / float64(rate), nil
+}
+
+func drawLabel(dst *image.RGBA, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.Black,
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
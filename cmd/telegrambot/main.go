@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	"gofency/internal/captcha"
@@ -13,9 +15,12 @@ import (
 	"gofency/internal/database"
 	"gofency/internal/fsm"
 	"gofency/internal/localization"
+	"gofency/internal/messages"
 	"gofency/internal/models"
 	"gofency/internal/repositories"
+	"gofency/internal/scheduler"
 	"gofency/internal/telegrambot"
+	"gofency/internal/webapp"
 )
 
 func main() {
@@ -37,9 +42,63 @@ func main() {
 	log.Println("Database auto-migration completed successfully")
 
 	userRepository := repositories.NewUserRepository(db.DB())
+	chatSettingsRepository := repositories.NewChatSettingsRepository(db.DB())
+	quizQuestionRepository := repositories.NewQuizQuestionRepository(db.DB())
 
 	captchaService := captcha.NewService("")
-	captchaFSM := fsm.NewCaptchaFSM()
+	if err := captchaService.SetProfile(captcha.DifficultyCustom, cfg.Captcha.Custom); err != nil {
+		log.Fatalf("Invalid custom captcha profile: %v", err)
+	}
+	if err := captchaService.SetDefaultDifficulty(cfg.Captcha.Difficulty); err != nil {
+		log.Fatalf("Invalid CAPTCHA_DIFFICULTY: %v", err)
+	}
+
+	mathProvider, err := captcha.NewMathProvider(captchaService, cfg.Captcha.Math)
+	if err != nil {
+		log.Fatalf("Failed to initialize math captcha: %v", err)
+	}
+
+	audioProvider, err := captcha.NewAudioProvider(captchaService, cfg.Captcha.AudioDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize audio captcha: %v", err)
+	}
+
+	challengeProviders := []captcha.ChallengeProvider{
+		captchaService,
+		mathProvider,
+		captcha.NewButtonProvider(),
+		captcha.NewAnimatedProvider(captchaService),
+		audioProvider,
+		captcha.NewGridProvider(captchaService),
+		captcha.NewSequenceProvider(captchaService),
+		captcha.NewQuizProvider(quizSource(quizQuestionRepository)),
+	}
+	if cfg.Captcha.WebApp.Link != "" {
+		webAppProvider, err := captcha.NewWebAppProvider(cfg.Captcha.WebApp.Link)
+		if err != nil {
+			log.Fatalf("Invalid CAPTCHA_WEBAPP_LINK: %v", err)
+		}
+		challengeProviders = append(challengeProviders, webAppProvider)
+	}
+
+	providers, err := withCaptchaPack(cfg.Captcha, challengeProviders)
+	if err != nil {
+		log.Fatalf("Failed to load captcha pack: %v", err)
+	}
+
+	providers, captchaPools, err := withCaptchaPools(cfg.Captcha, providers)
+	if err != nil {
+		log.Fatalf("Failed to initialize captcha pools: %v", err)
+	}
+
+	captchaRegistry, err := captcha.NewRegistry(providers...)
+	if err != nil {
+		log.Fatalf("Failed to initialize captcha registry: %v", err)
+	}
+	if err := captchaRegistry.SetDefaults(cfg.Captcha.Types...); err != nil {
+		log.Fatalf("Invalid CAPTCHA_TYPES: %v", err)
+	}
+	captchaFSM := newCaptchaFSM(cfg.Captcha, db)
 
 	localizationService, err := localization.NewService(localization.ServiceConfig{
 		DefaultLanguage:  "ru",
@@ -51,12 +110,32 @@ func main() {
 		log.Fatalf("Failed to initialize localization service: %v", err)
 	}
 
+	// Text challenges use the letters of the member's language when the bot speaks it
+	captchaService.SetLanguages(localizationService.SupportedLanguages())
+	for _, lang := range localizationService.SupportedLanguages() {
+		if !captcha.HasLocaleAlphabet(lang) {
+			log.Printf("No captcha alphabet for language %s, its members get Latin letters", lang)
+		}
+	}
+
 	bot, err := telegrambot.NewBot(telegrambot.Config{
-		Token:               cfg.TelegramToken,
-		LocalizationService: localizationService,
-		UserRepository:      userRepository,
-		CaptchaService:      captchaService,
-		CaptchaFSM:          captchaFSM,
+		Token:                  cfg.TelegramToken,
+		LocalizationService:    localizationService,
+		UserRepository:         userRepository,
+		ChatSettingsRepository: chatSettingsRepository,
+		QuizQuestionRepository: quizQuestionRepository,
+		CaptchaRegistry:        captchaRegistry,
+		CaptchaFSM:             captchaFSM,
+		Scheduler:              newScheduler(cfg.Scheduler, db),
+		MessageStore:           newMessageStore(cfg.Scheduler, db),
+		CaptchaAttempts:        cfg.Captcha.Attempts,
+		CaptchaRefreshes:       cfg.Captcha.Refreshes,
+		CaptchaMinAnswerTime:   cfg.Captcha.MinAnswerTime,
+		WebAppListen:           cfg.Captcha.WebApp.Listen,
+		WebApp: webapp.Config{
+			MaxAge:       cfg.Captcha.WebApp.MaxAge,
+			MinSolveTime: cfg.Captcha.WebApp.MinSolveTime,
+		},
 	})
 	if err != nil {
 		log.Fatalf("Failed to create bot: %v", err)
@@ -65,6 +144,11 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Pre-generate challenges in the background
+	for _, pool := range captchaPools {
+		go pool.Run(ctx)
+	}
+
 	if err := bot.Start(ctx); err != nil {
 		log.Fatalf("Failed to start bot: %v", err)
 	}
@@ -72,17 +156,129 @@ func main() {
 	log.Println("Bot stopped gracefully")
 }
 
+// withCaptchaPack serves challenges from the configured asset pack before generating them
+func withCaptchaPack(cfg config.CaptchaConfig, providers []captcha.ChallengeProvider) ([]captcha.ChallengeProvider, error) {
+	if cfg.PackDir == "" {
+		return providers, nil
+	}
+
+	manifest := cfg.PackManifest
+	if manifest == "" {
+		manifest = filepath.Join(cfg.PackDir, captcha.ManifestFile)
+	}
+
+	pack, err := captcha.LoadPackManifest(manifest, cfg.PackDir)
+	if err != nil {
+		return nil, err
+	}
+
+	packed := make(map[string]bool)
+	for _, typ := range pack.Types() {
+		packed[typ] = true
+	}
+
+	for i, provider := range providers {
+		if packed[provider.Type()] {
+			providers[i] = captcha.NewPackProvider(provider, pack)
+		}
+	}
+
+	log.Printf("Loaded captcha pack with %d challenges of types %v", len(pack.Items()), pack.Types())
+	return providers, nil
+}
+
+// withCaptchaPools wraps the providers of the configured types with pre-generated pools
+func withCaptchaPools(cfg config.CaptchaConfig, providers []captcha.ChallengeProvider) ([]captcha.ChallengeProvider, []*captcha.Pool, error) {
+	if cfg.Pool.Size == 0 {
+		return providers, nil, nil
+	}
+
+	pooled := make(map[string]bool, len(cfg.PoolTypes))
+	for _, typ := range cfg.PoolTypes {
+		pooled[typ] = true
+	}
+
+	var pools []*captcha.Pool
+	for i, provider := range providers {
+		if !pooled[provider.Type()] {
+			continue
+		}
+		// Pools are shared by all chats, quiz questions belong to one and
+		// Mini App challenges only carry a link
+		if provider.Type() == captcha.TypeQuiz || provider.Type() == captcha.TypeWebApp {
+			log.Printf("Skipping pool for per-chat %s challenges", provider.Type())
+			continue
+		}
+
+		pool, err := captcha.NewPool(provider, cfg.Pool)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create %s pool: %w", provider.Type(), err)
+		}
+		providers[i] = pool
+		pools = append(pools, pool)
+	}
+
+	return providers, pools, nil
+}
+
+// quizSource reads the custom questions of a chat from the database
+func quizSource(repo repositories.QuizQuestionRepository) captcha.QuizSource {
+	return func(ctx context.Context, chatID int64) ([]captcha.QuizQuestion, error) {
+		records, err := repo.ListByChatID(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+
+		questions := make([]captcha.QuizQuestion, 0, len(records))
+		for _, record := range records {
+			q := captcha.QuizQuestion{
+				Question: record.Question,
+				Answers:  strings.Split(record.Answers, "\n"),
+			}
+			if record.Choices != "" {
+				q.Choices = strings.Split(record.Choices, "\n")
+			}
+			questions = append(questions, q)
+		}
+		return questions, nil
+	}
+}
+
+// newCaptchaFSM keeps pending verifications in the configured store
+func newCaptchaFSM(cfg config.CaptchaConfig, db *database.Database) *fsm.CaptchaFSM {
+	if cfg.StateStore == config.StateStoreMemory {
+		log.Printf("Captcha verifications are kept in memory and lost on restart")
+		return fsm.NewCaptchaFSM()
+	}
+	return fsm.NewCaptchaFSMWithStore(repositories.NewCaptchaStateRepository(db.DB()))
+}
+
+// newScheduler keeps scheduled jobs in the configured store
+func newScheduler(cfg config.SchedulerConfig, db *database.Database) *scheduler.Scheduler {
+	if cfg.Store == config.StateStoreMemory {
+		log.Printf("Scheduled jobs are kept in memory and lost on restart")
+		return scheduler.New(nil, cfg.Workers)
+	}
+	return scheduler.New(repositories.NewScheduledJobRepository(db.DB()), cfg.Workers)
+}
+
+// newMessageStore keeps pending message deletions in the store of scheduled jobs
+func newMessageStore(cfg config.SchedulerConfig, db *database.Database) messages.DeletionStore {
+	if cfg.Store == config.StateStoreMemory {
+		return messages.NewMemoryStore()
+	}
+	return repositories.NewPendingDeletionRepository(db.DB())
+}
+
 func initializeDataBase(cfg database.Config) (*database.Database, error) {
 	db, err := database.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	// Only migrate User model, captcha verification is now in-memory
-	if err := db.DB().AutoMigrate(&models.User{}); err != nil {
+	if err := db.DB().AutoMigrate(&models.User{}, &models.ChatSettings{}, &models.QuizQuestion{}, &models.CaptchaState{}, &models.ScheduledJob{}, &models.PendingDeletion{}); err != nil {
 		return nil, fmt.Errorf("failed to run auto-migration: %v", err)
 	}
 
 	return db, nil
 }
-
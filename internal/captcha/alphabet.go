@@ -0,0 +1,56 @@
+package captcha
+
+import "sort"
+
+// LocaleAlphabet is the set of letters text captchas use for a language
+type LocaleAlphabet struct {
+	Letters string
+	// Ambiguous letters look alike in distorted images and are dropped with
+	// ExcludeAmbiguous, in addition to ambiguousChars
+	Ambiguous string
+}
+
+// LatinAlphabet is used for languages without their own alphabet
+var LatinAlphabet = LocaleAlphabet{Letters: letterChars}
+
+// localeAlphabets maps primary language subtags to their alphabets. Cyrillic
+// letters that look like Latin ones (А, В, Е, К, М, Н, О, Р, С, Т, У, Х, І)
+// are left out: a Latin OCR would read them and members could not tell
+// which keyboard layout to type them with.
+var localeAlphabets = map[string]LocaleAlphabet{
+	"en": LatinAlphabet,
+	"ru": {
+		Letters: "БГДЖЗИЙЛПФЦЧШЩЪЫЬЭЮЯ",
+		// Б/6, З/Э/3, Й/И, Ч/4, Щ/Ш, Ъ/Ь
+		Ambiguous: "БЗЭЙЧЩЪ",
+	},
+	"uk": {
+		Letters: "БГҐДЄЖЗИЙЛПФЦЧШЩЬЮЯ",
+		// Б/6, З/3, Ґ/Г, Є/Е, Й/И, Ч/4, Щ/Ш
+		Ambiguous: "БЗҐЄЙЧЩ",
+	},
+}
+
+// HasLocaleAlphabet reports whether the language has its own alphabet
+func HasLocaleAlphabet(languageCode string) bool {
+	_, ok := localeAlphabets[poolLanguage(languageCode)]
+	return ok
+}
+
+// LocaleAlphabetLanguages lists the languages with their own alphabet
+func LocaleAlphabetLanguages() []string {
+	languages := make([]string, 0, len(localeAlphabets))
+	for lang := range localeAlphabets {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// localeAlphabet returns the alphabet of the language, falling back to Latin
+func localeAlphabet(languageCode string) LocaleAlphabet {
+	if alphabet, ok := localeAlphabets[poolLanguage(languageCode)]; ok {
+		return alphabet
+	}
+	return LatinAlphabet
+}
@@ -0,0 +1,79 @@
+package captcha
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// latinLookalikes are Cyrillic letters read as Latin ones by OCR and people
+const latinLookalikes = "АВЕКМНОРСТУХІЇ"
+
+func TestLocaleAlphabets(t *testing.T) {
+	f, err := loadFont()
+	if err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+
+	var buf sfnt.Buffer
+	for _, lang := range LocaleAlphabetLanguages() {
+		alphabet := localeAlphabet(lang)
+		for _, r := range alphabet.Letters {
+			if index, err := f.GlyphIndex(&buf, r); err != nil || index == 0 {
+				t.Errorf("Font has no glyph for %q of %s", r, lang)
+			}
+			if strings.ContainsRune(latinLookalikes, r) {
+				t.Errorf("Alphabet of %s contains %q, which looks like a Latin letter", lang, r)
+			}
+		}
+		for _, r := range alphabet.Ambiguous {
+			if !strings.ContainsRune(alphabet.Letters, r) {
+				t.Errorf("Ambiguous letter %q is not in the alphabet of %s", r, lang)
+			}
+		}
+	}
+
+	if !HasLocaleAlphabet("ru-RU") || HasLocaleAlphabet("xx") {
+		t.Error("Expected languages to be matched by their primary subtag")
+	}
+}
+
+func TestLocalizedChallenge(t *testing.T) {
+	service := NewService("")
+	opts := service.Options(DifficultyHard)
+	charset := string(opts.LocaleCharset(localeAlphabet("ru")))
+
+	for _, r := range charset {
+		if strings.ContainsRune(ambiguousChars, r) || strings.ContainsRune(localeAlphabet("ru").Ambiguous, r) {
+			t.Errorf("Charset contains ambiguous character %q", r)
+		}
+	}
+
+	challenge, err := service.NewChallenge(context.Background(), Request{LanguageCode: "ru", Difficulty: DifficultyHard, Seed: goldenSeed})
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	for _, r := range challenge.Answer {
+		if !strings.ContainsRune(charset, r) {
+			t.Errorf("Answer %q contains %q outside of the Russian alphabet", challenge.Answer, r)
+		}
+	}
+	if !service.Verify(challenge.Answer, strings.ToLower(challenge.Answer)) {
+		t.Errorf("Expected lowercase answer to be accepted for %q", challenge.Answer)
+	}
+
+	// Languages the bot does not speak get Latin letters
+	service.SetLanguages([]string{"en"})
+	challenge, err = service.NewChallenge(context.Background(), Request{LanguageCode: "ru", Difficulty: DifficultyHard, Seed: goldenSeed})
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	latin := string(opts.Charset())
+	for _, r := range challenge.Answer {
+		if !strings.ContainsRune(latin, r) {
+			t.Errorf("Answer %q contains %q outside of the Latin alphabet", challenge.Answer, r)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math"
+)
+
+// TypeAnimated is the type of animated text challenges
+const TypeAnimated = "animated"
+
+// Animation timing and motion
+const (
+	framesPerStep = 2
+	frameDelay    = 30 // hundredths of a second
+	// glyphSpeed is how many pixels a glyph moves per frame at most
+	glyphSpeed = 5.0
+	// glyphShiftX is how far a glyph may leave the center of its slot,
+	// as a share of the slot width
+	glyphShiftX = 0.2
+)
+
+// GenerateAnimated creates an animated GIF captcha with the given options
+func (s *Service) GenerateAnimated(opts Options) (*CaptchaImage, error) {
+	return s.generateAnimated(opts, "", 0)
+}
+
+func (s *Service) generateAnimated(opts Options, languageCode string, seed uint64) (*CaptchaImage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid captcha options: %w", err)
+	}
+
+	source, seed, err := s.challengeSource(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := randomAnswer(source, opts.Length, opts.LocaleCharset(s.alphabet(opts, languageCode)))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.renderAnimation(answer, opts, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptchaImage{
+		Image:  data,
+		Answer: answer,
+		Seed:   seed,
+	}, nil
+}
+
+// glyphPose is the place and shape of a glyph during one of its appearances
+type glyphPose struct {
+	col    color.RGBA
+	center image.Point
+	angle  float64
+	scale  float64
+	// velocityX and velocityY move the glyph across the noise while it is shown
+	velocityX float64
+	velocityY float64
+}
+
+// renderAnimation draws the text as an animated GIF where only a part of the
+// glyphs is visible in each frame, so no single frame contains the full answer
+func (s *Service) renderAnimation(text string, opts Options, source Source) ([]byte, error) {
+	fontSize := opts.Height / 2
+	slotWidth := fontSize * 3 / 4
+	distortion := opts.Distortion
+
+	face, err := newFace(fontSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	runes := []rune(text)
+	rnd := &randomizer{source: source}
+
+	width := opts.Width
+	if w := len(runes)*slotWidth + 2*glyphPadding; w > width {
+		width = w
+	}
+	bounds := image.Rect(0, 0, width, opts.Height)
+
+	steps := len(runes)
+	if steps < 2 {
+		steps = 2
+	}
+	visible := visibleGlyphs(len(runes))
+	frames := steps * framesPerStep
+
+	// Glyphs keep the order of their slots, so humans can read them, but
+	// every appearance puts them elsewhere in the slot's column with another
+	// shape and color. Consecutive appearances alternate between the upper
+	// and the lower half, so stacking the frames smears the glyphs instead
+	// of revealing the answer.
+	startX := (width - len(runes)*slotWidth) / 2
+	rotation := glyphMaxRotation * distortion
+	shiftX := int(glyphShiftX * float64(slotWidth))
+	motion := glyphSpeed * float64(framesPerStep-1) / 2
+	shiftY := max(int(float64(opts.Height-fontSize)/2-motion), 0)
+	poses := make([][]glyphPose, steps)
+	for step := range poses {
+		poses[step] = make([]glyphPose, len(runes))
+		for i := range runes {
+			if !glyphVisible(i, step, len(runes), visible) {
+				continue
+			}
+			offsetY := shiftY*2/3 + rnd.intn(shiftY/3+1)
+			if (i+step)%2 == 1 {
+				offsetY = -offsetY
+			}
+			poses[step][i] = glyphPose{
+				col: rnd.color(20, 110),
+				center: image.Point{
+					X: startX + i*slotWidth + slotWidth/2 + rnd.intn(2*shiftX+1) - shiftX,
+					Y: opts.Height/2 + offsetY,
+				},
+				angle:     rnd.float(-rotation, rotation),
+				scale:     rnd.float(1-glyphScaleSpread*distortion, 1+glyphScaleSpread*distortion),
+				velocityX: rnd.float(-glyphSpeed, glyphSpeed),
+				velocityY: rnd.float(-glyphSpeed, glyphSpeed),
+			}
+		}
+	}
+
+	background := image.NewRGBA(bounds)
+	drawBackground(background, rnd)
+
+	anim := &gif.GIF{LoopCount: 0}
+	for frame := 0; frame < frames; frame++ {
+		img := image.NewRGBA(bounds)
+		draw.Draw(img, bounds, background, image.Point{}, draw.Src)
+
+		// Fresh noise in every frame so it cannot be subtracted between frames
+		for i := 0; i < opts.NoiseLines; i++ {
+			s.drawNoiseLine(img, rnd)
+		}
+
+		step := frame / framesPerStep
+		moved := float64(frame%framesPerStep) - float64(framesPerStep-1)/2
+		var colors []color.RGBA
+		for i, glyph := range runes {
+			if !glyphVisible(i, step, len(runes), visible) {
+				continue
+			}
+			pose := poses[step][i]
+			center := image.Point{
+				X: pose.center.X + int(math.Round(pose.velocityX*moved)),
+				Y: pose.center.Y + int(math.Round(pose.velocityY*moved)),
+			}
+			drawGlyph(img, face, fontSize, glyph, pose.col, center, pose.angle, pose.scale)
+			colors = append(colors, pose.col)
+		}
+
+		img = warp(img, rnd, waveAmplitude*distortion)
+
+		// Strike lines in the colors of the shown glyphs are drawn anew in
+		// every frame, so they pile up when the frames are stacked
+		for i := 0; i < int(math.Round(strikeLines*distortion)) && len(colors) > 0; i++ {
+			drawWave(img, rnd, colors[rnd.intn(len(colors))])
+		}
+
+		for i := 0; i < opts.NoiseDots; i++ {
+			s.drawNoiseDot(img, rnd)
+		}
+
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.Draw(paletted, bounds, img, image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, frameDelay)
+	}
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, fmt.Errorf("failed to encode animation: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// visibleGlyphs returns how many glyphs are shown at once: about a half,
+// but never all of them unless the text is a single glyph
+func visibleGlyphs(n int) int {
+	visible := (n + 1) / 2
+	if visible >= n && n > 1 {
+		visible = n - 1
+	}
+	return visible
+}
+
+// glyphVisible reports whether glyph i is shown at the given step; a window
+// of visible glyphs slides over the text and wraps around
+func glyphVisible(i, step, n, visible int) bool {
+	if n == 0 {
+		return false
+	}
+	return ((i-step)%n+n)%n < visible
+}
+
+// AnimatedProvider produces text challenges delivered as animated GIFs
+type AnimatedProvider struct {
+	service *Service
+}
+
+// NewAnimatedProvider creates an animated challenge provider rendering with the service
+func NewAnimatedProvider(service *Service) *AnimatedProvider {
+	return &AnimatedProvider{service: service}
+}
+
+// Type returns the challenge type produced by the provider
+func (p *AnimatedProvider) Type() string {
+	return TypeAnimated
+}
+
+// NewChallenge creates an animated challenge to be answered with a text message
+func (p *AnimatedProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	img, err := p.service.generateAnimated(p.service.Options(req.Difficulty), req.LanguageCode, req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		Type:     TypeAnimated,
+		Modality: ModalityText,
+		Media: &Media{
+			Kind:     MediaAnimation,
+			Data:     img.Image,
+			Filename: "captcha.gif",
+		},
+		PromptID: "captcha_animated_prompt",
+		Answer:   img.Answer,
+		Seed:     img.Seed,
+	}, nil
+}
+
+// LanguageVariant returns the language whose alphabet the glyphs are drawn from
+func (p *AnimatedProvider) LanguageVariant(req Request) string {
+	return p.service.LanguageVariant(req)
+}
+
+// Verify compares the normalized answer ignoring case, see NormalizeAnswer
+func (p *AnimatedProvider) Verify(expected, answer string) bool {
+	return p.service.Verify(expected, answer)
+}
@@ -0,0 +1,59 @@
+package captcha
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+func TestGlyphVisibility(t *testing.T) {
+	for n := 2; n <= 12; n++ {
+		visible := visibleGlyphs(n)
+		seen := make([]bool, n)
+
+		for step := 0; step < n; step++ {
+			count := 0
+			for i := 0; i < n; i++ {
+				if glyphVisible(i, step, n, visible) {
+					seen[i] = true
+					count++
+				}
+			}
+			if count == 0 || count >= n {
+				t.Errorf("Step %d of %d glyphs shows %d glyphs", step, n, count)
+			}
+		}
+
+		for i, ok := range seen {
+			if !ok {
+				t.Errorf("Glyph %d of %d is never shown", i, n)
+			}
+		}
+	}
+}
+
+func TestGenerateAnimated(t *testing.T) {
+	service := NewService("")
+	opts := DefaultOptions()
+
+	img, err := service.GenerateAnimated(opts)
+	if err != nil {
+		t.Fatalf("Failed to generate animated captcha: %v", err)
+	}
+
+	if len([]rune(img.Answer)) != opts.Length {
+		t.Errorf("Answer %q has wrong length, want %d", img.Answer, opts.Length)
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(img.Image))
+	if err != nil {
+		t.Fatalf("Failed to decode animation: %v", err)
+	}
+
+	if want := opts.Length * framesPerStep; len(anim.Image) != want {
+		t.Errorf("Animation has %d frames, want %d", len(anim.Image), want)
+	}
+	if bounds := anim.Image[0].Bounds(); bounds.Dy() != opts.Height {
+		t.Errorf("Frame is %d px high, want %d", bounds.Dy(), opts.Height)
+	}
+}
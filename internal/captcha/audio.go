@@ -0,0 +1,255 @@
+package captcha
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strings"
+
+	"gofency/internal/opus"
+)
+
+//go:generate go run sounds/gen.go
+
+// TypeAudio is the type of spoken digits challenges
+const TypeAudio = "audio"
+
+// Audio timing in seconds and noise level at the normal distortion
+const (
+	audioLeadIn     = 0.5
+	audioTail       = 0.5
+	audioMinGap     = 0.6
+	audioMaxGap     = 1.0
+	audioNoiseLevel = 1500
+)
+
+// builtinDigitSounds are spoken digits in several languages copied by
+// sounds/gen.go, one directory per language code
+//
+//go:embed sounds/digits
+var builtinDigitSounds embed.FS
+
+// builtinDefaultLanguage is the built-in pack used for other languages
+const builtinDefaultLanguage = "en"
+
+// audioPack is a set of per-digit samples sharing a sample rate
+type audioPack struct {
+	digits     [10][]int16
+	sampleRate int
+}
+
+// loadAudioPack reads 0.wav to 9.wav from the directory
+func loadAudioPack(fsys fs.FS, dir string) (*audioPack, error) {
+	pack := &audioPack{}
+
+	for digit := range pack.digits {
+		name := path.Join(dir, fmt.Sprintf("%d.wav", digit))
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sample: %w", err)
+		}
+
+		samples, rate, err := decodeWAV(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample %s: %w", name, err)
+		}
+		if pack.sampleRate != 0 && rate != pack.sampleRate {
+			return nil, fmt.Errorf("sample %s has rate %d Hz, want %d Hz", name, rate, pack.sampleRate)
+		}
+
+		pack.sampleRate = rate
+		pack.digits[digit] = samples
+	}
+
+	return pack, nil
+}
+
+// loadLanguagePacks loads a pack from every subdirectory of dir, keyed by
+// the lowercased directory name
+func loadLanguagePacks(fsys fs.FS, dir string, packs map[string]*audioPack) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read audio samples directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pack, err := loadAudioPack(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to load %s audio samples: %w", entry.Name(), err)
+		}
+		packs[strings.ToLower(entry.Name())] = pack
+	}
+	return nil
+}
+
+// AudioProvider produces challenges with digits spoken in a voice message
+type AudioProvider struct {
+	service *Service
+
+	// packs are keyed by language code, "" is the default pack
+	packs map[string]*audioPack
+}
+
+// NewAudioProvider creates an audio challenge provider. Recorded samples in
+// samplesDir add to the built-in ones: files directly in the directory
+// replace the default pack and subdirectories named after language codes
+// add or replace per-language packs.
+func NewAudioProvider(service *Service, samplesDir string) (*AudioProvider, error) {
+	p := &AudioProvider{
+		service: service,
+		packs:   make(map[string]*audioPack),
+	}
+
+	if err := loadLanguagePacks(builtinDigitSounds, "sounds/digits", p.packs); err != nil {
+		return nil, fmt.Errorf("failed to load built-in audio samples: %w", err)
+	}
+	p.packs[""] = p.packs[builtinDefaultLanguage]
+
+	if samplesDir == "" {
+		return p, nil
+	}
+
+	fsys := os.DirFS(samplesDir)
+	if _, err := fs.Stat(fsys, "0.wav"); err == nil {
+		pack, err := loadAudioPack(fsys, ".")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load audio samples: %w", err)
+		}
+		p.packs[""] = pack
+	}
+
+	if err := loadLanguagePacks(fsys, ".", p.packs); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Type returns the challenge type produced by the provider
+func (p *AudioProvider) Type() string {
+	return TypeAudio
+}
+
+// pack returns the samples for the language, falling back to the default pack
+func (p *AudioProvider) pack(languageCode string) *audioPack {
+	return p.packs[p.packLanguage(languageCode)]
+}
+
+// packLanguage returns the key of the samples for the language, "" for the
+// default pack
+func (p *AudioProvider) packLanguage(languageCode string) string {
+	lang := strings.ToLower(languageCode)
+	if _, ok := p.packs[lang]; ok {
+		return lang
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if _, ok := p.packs[base]; ok {
+			return base
+		}
+	}
+	return ""
+}
+
+// LanguageVariant returns the language of the samples the digits are spoken
+// with, "" when they are the default ones
+func (p *AudioProvider) LanguageVariant(req Request) string {
+	lang := p.packLanguage(req.LanguageCode)
+	if p.packs[lang] == p.packs[""] {
+		return ""
+	}
+	return lang
+}
+
+// NewChallenge creates an audio challenge to be answered with a text message
+func (p *AudioProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	opts := p.service.Options(req.Difficulty)
+
+	// Only digits can be played, and none of them sound alike
+	opts.Alphabet = AlphabetDigits
+	opts.ExcludeAmbiguous = false
+
+	source, seed, err := p.service.challengeSource(req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := randomAnswer(source, opts.Length, opts.Charset())
+	if err != nil {
+		return nil, err
+	}
+
+	pack := p.pack(req.LanguageCode)
+	data, err := synthesize(source, pack, answer, opts.Distortion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		Type:     TypeAudio,
+		Modality: ModalityText,
+		Media: &Media{
+			Kind:     MediaVoice,
+			Data:     data,
+			Filename: "captcha.ogg",
+		},
+		PromptID: "captcha_audio_prompt",
+		Answer:   answer,
+		Seed:     seed,
+	}, nil
+}
+
+// Verify compares the digits ignoring any whitespace and punctuation between them
+func (p *AudioProvider) Verify(expected, answer string) bool {
+	return NormalizeAnswer(answer, NormalizeOptions{}) == expected
+}
+
+// synthesize joins the digit samples with random gaps and gains and mixes
+// white noise in, scaled by the distortion
+func synthesize(source Source, pack *audioPack, answer string, distortion float64) ([]byte, error) {
+	rnd := &randomizer{source: source}
+	seconds := func(s float64) int { return int(s * float64(pack.sampleRate)) }
+
+	out := make([]int16, seconds(audioLeadIn+rnd.float(0, 0.3)))
+	for _, r := range answer {
+		if r < '0' || r > '9' {
+			return nil, fmt.Errorf("cannot play %q", r)
+		}
+
+		gain := rnd.float(0.8, 1.1)
+		for _, sample := range pack.digits[r-'0'] {
+			out = append(out, clampSample(float64(sample)*gain))
+		}
+		out = append(out, make([]int16, seconds(rnd.float(audioMinGap, audioMaxGap)))...)
+	}
+	out = append(out, make([]int16, seconds(audioTail))...)
+
+	// Per-sample noise comes from a generator seeded once, drawing every
+	// sample from crypto/rand would be needlessly slow
+	noise := rand.New(rand.NewPCG(uint64(rnd.intn(1<<30)), uint64(rnd.intn(1<<30))))
+	level := audioNoiseLevel * distortion
+	for i, sample := range out {
+		out[i] = clampSample(float64(sample) + level*(2*noise.Float64()-1))
+	}
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	return opus.Encode(out, pack.sampleRate), nil
+}
+
+func clampSample(v float64) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
@@ -0,0 +1,136 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gofency/internal/opus/decoder"
+)
+
+// encodeWAV writes the samples as a mono PCM WAV file with 8 or 16 bits
+func encodeWAV(samples []int16, sampleRate, bits int) []byte {
+	var data bytes.Buffer
+	for _, sample := range samples {
+		if bits == 8 {
+			data.WriteByte(byte(sample>>8 + 128))
+		} else {
+			binary.Write(&data, binary.LittleEndian, sample)
+		}
+	}
+	bytesPerSample := bits / 8
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+data.Len()))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(wavFormatPCM), uint16(1), uint32(sampleRate),
+		uint32(bytesPerSample * sampleRate), uint16(bytesPerSample), uint16(bits)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(data.Len()))
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, 32767, -32768, 1234}
+
+	got, rate, err := decodeWAV(encodeWAV(samples, 16000, 16))
+	if err != nil {
+		t.Fatalf("Failed to decode WAV: %v", err)
+	}
+	if rate != 16000 {
+		t.Errorf("Sample rate is %d, want 16000", rate)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(samples) {
+		t.Errorf("Samples are %v, want %v", got, samples)
+	}
+
+	// 8-bit samples keep their high byte
+	got, _, err = decodeWAV(encodeWAV(samples, 8000, 8))
+	if err != nil {
+		t.Fatalf("Failed to decode 8-bit WAV: %v", err)
+	}
+	if want := "[0 0 -256 32512 -32768 1024]"; fmt.Sprint(got) != want {
+		t.Errorf("8-bit samples are %v, want %v", got, want)
+	}
+
+	if _, _, err := decodeWAV([]byte("not a wav file")); err == nil {
+		t.Error("Expected an error for invalid data")
+	}
+}
+
+func TestAudioChallenge(t *testing.T) {
+	provider, err := NewAudioProvider(NewService(""), "")
+	if err != nil {
+		t.Fatalf("Failed to create audio provider: %v", err)
+	}
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{Difficulty: DifficultyHard})
+	if err != nil {
+		t.Fatalf("Failed to create audio challenge: %v", err)
+	}
+
+	if challenge.Media == nil || challenge.Media.Kind != MediaVoice {
+		t.Fatalf("Expected voice media, got %+v", challenge.Media)
+	}
+	if challenge.PromptID != "captcha_audio_prompt" {
+		t.Errorf("Spoken digits use prompt %q", challenge.PromptID)
+	}
+	for _, r := range challenge.Answer {
+		if r < '0' || r > '9' {
+			t.Fatalf("Answer %q contains non-digits", challenge.Answer)
+		}
+	}
+
+	samples, rate, err := decoder.Decode(challenge.Media.Data)
+	if err != nil {
+		t.Fatalf("Failed to decode audio: %v", err)
+	}
+	if seconds := float64(len(samples)) / float64(rate); seconds < audioLeadIn+audioTail {
+		t.Errorf("Audio is only %.2f seconds long", seconds)
+	}
+
+	spaced := ""
+	for _, r := range challenge.Answer {
+		spaced += string(r) + " "
+	}
+	if !provider.Verify(challenge.Answer, spaced) {
+		t.Errorf("Answer %q with spaces was rejected", spaced)
+	}
+}
+
+func TestAudioLanguagePacks(t *testing.T) {
+	dir := t.TempDir()
+	langDir := filepath.Join(dir, "de")
+	if err := os.Mkdir(langDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for digit := 0; digit <= 9; digit++ {
+		data := encodeWAV(make([]int16, 100), 16000, 16)
+		if err := os.WriteFile(filepath.Join(langDir, fmt.Sprintf("%d.wav", digit)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider, err := NewAudioProvider(NewService(""), dir)
+	if err != nil {
+		t.Fatalf("Failed to create audio provider: %v", err)
+	}
+
+	if pack := provider.pack("de-AT"); pack.sampleRate != 16000 {
+		t.Errorf("Expected the recorded de pack for de-AT")
+	}
+	if provider.pack("ru-RU") != provider.packs["ru"] || provider.packs["ru"] == nil {
+		t.Errorf("Expected the built-in ru pack for ru-RU")
+	}
+	if provider.pack("fr") != provider.packs["en"] {
+		t.Errorf("Expected the built-in en pack for fr")
+	}
+}
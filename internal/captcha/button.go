@@ -0,0 +1,179 @@
+package captcha
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+// TypeButton is the type of challenges answered by pressing an inline button
+const TypeButton = "button"
+
+const (
+	buttonChoices    = 6
+	buttonsPerRow    = 3
+	buttonTokenBytes = 4
+)
+
+// buttonEmoji are easily distinguishable emoji used as button choices
+var buttonEmoji = []string{
+	"🍎", "🍌", "🍇", "🍋", "🍒", "🥕", "🐶", "🐱", "🐸", "🦊",
+	"🚗", "✈️", "⚽", "🎸", "🌙", "⭐", "🔥", "☂️", "🔑", "🎁",
+}
+
+// buttonLookalikes are emoji a bot matching by name or shape confuses with
+// the requested one, while people tell them apart by their colour or pose
+var buttonLookalikes = map[string]string{
+	"🍎": "🍏",
+	"🐶": "🐕",
+	"🐱": "🐈",
+	"🚗": "🚙",
+	"🌙": "🌛",
+	"🔑": "🗝️",
+}
+
+// ButtonProvider produces "tap the 🍎" challenges answered via callback queries
+type ButtonProvider struct{}
+
+// NewButtonProvider creates a new button challenge provider
+func NewButtonProvider() *ButtonProvider {
+	return &ButtonProvider{}
+}
+
+// Type returns the challenge type produced by the provider
+func (p *ButtonProvider) Type() string {
+	return TypeButton
+}
+
+// NewChallenge creates a challenge with one correct button among decoys.
+// Every button carries a random token, so callback data does not reveal the answer.
+//
+// Honeypot buttons catch automation: an "I am a bot" button, and a lookalike
+// of the requested emoji in place of a decoy when there is one.
+func (p *ButtonProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	source, seed, err := challengeSource(cryptoSource{}, req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	emoji, err := randomSample(source, buttonEmoji, buttonChoices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick button emoji: %w", err)
+	}
+
+	correct, err := source.Intn(len(emoji))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick correct button: %w", err)
+	}
+
+	lookalike := -1
+	if e, ok := buttonLookalikes[emoji[correct]]; ok {
+		n, err := source.Intn(len(emoji) - 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick lookalike button: %w", err)
+		}
+		// Skip the correct button
+		if n >= correct {
+			n++
+		}
+		lookalike = n
+		emoji[lookalike] = e
+	}
+
+	challenge := &Challenge{
+		Type:       TypeButton,
+		Modality:   ModalityButton,
+		PromptID:   "captcha_button_prompt",
+		PromptData: map[string]any{"Emoji": emoji[correct]},
+		Seed:       seed,
+	}
+
+	var row []Button
+	for i, e := range emoji {
+		token, err := randomToken(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate button token: %w", err)
+		}
+		if i == correct {
+			challenge.Answer = token
+		}
+
+		row = append(row, Button{Text: e, Value: token, Honeypot: i == lookalike})
+		if len(row) == buttonsPerRow {
+			challenge.Buttons = append(challenge.Buttons, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		challenge.Buttons = append(challenge.Buttons, row)
+	}
+
+	token, err := randomToken(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate button token: %w", err)
+	}
+	challenge.Buttons = append(challenge.Buttons, []Button{{TextID: "captcha_honeypot_button", Value: token, Honeypot: true}})
+
+	return challenge, nil
+}
+
+// IsHoneypot reports whether the value is sent by a honeypot button
+func IsHoneypot(buttons [][]Button, value string) bool {
+	for _, row := range buttons {
+		for _, button := range row {
+			if button.Value == value {
+				return button.Honeypot
+			}
+		}
+	}
+	return false
+}
+
+// randomSample returns n distinct random items of the slice
+func randomSample(source Source, items []string, n int) ([]string, error) {
+	shuffled := append([]string(nil), items...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j, err := source.Intn(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	if n > len(shuffled) {
+		n = len(shuffled)
+	}
+	return shuffled[:n], nil
+}
+
+// Callback values reserved for buttons that are not answers. Answer tokens
+// come from randomToken and are hex encoded, so none of these can clash
+// with them.
+const (
+	// AudioValue requests an audio version of the challenge
+	AudioValue = "audio"
+	// RefreshValue requests a new challenge of the same type
+	RefreshValue = "refresh"
+	// ApprovalValue asks the chat admins to let the user in
+	ApprovalValue = "approval"
+	// GridSubmitValue submits the selected grid tiles
+	GridSubmitValue = "submit"
+	// SequenceUndoValue removes the last tap of a sequence
+	SequenceUndoValue = "undo"
+
+	// gridTilePrefix is followed by the index of a grid tile
+	gridTilePrefix = "t"
+)
+
+// randomToken returns a short random hex string for callback data, drawn
+// from the source of the challenge so a replay has the same buttons
+func randomToken(source Source) (string, error) {
+	b := make([]byte, buttonTokenBytes)
+	for i := range b {
+		n, err := source.Intn(256)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte(n)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,91 @@
+package captcha
+
+import (
+	"context"
+	"testing"
+)
+
+func TestButtonChallenge(t *testing.T) {
+	provider := NewButtonProvider()
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Failed to generate button challenge: %v", err)
+	}
+
+	if challenge.Modality != ModalityButton {
+		t.Errorf("Expected modality %q, got %q", ModalityButton, challenge.Modality)
+	}
+
+	tokens := make(map[string]bool)
+	matches, honeypots := 0, 0
+	for _, row := range challenge.Buttons {
+		for _, button := range row {
+			if tokens[button.Value] {
+				t.Errorf("Duplicate button token %q", button.Value)
+			}
+			tokens[button.Value] = true
+
+			if button.Honeypot {
+				honeypots++
+				if button.Value == challenge.Answer {
+					t.Errorf("Correct button %q is a honeypot", button.Text)
+				}
+			}
+			if IsHoneypot(challenge.Buttons, button.Value) != button.Honeypot {
+				t.Errorf("IsHoneypot does not match button %q", button.Text)
+			}
+
+			if button.Value == challenge.Answer {
+				matches++
+				if button.Text != challenge.PromptData["Emoji"] {
+					t.Errorf("Correct button shows %q, prompt asks for %q", button.Text, challenge.PromptData["Emoji"])
+				}
+			}
+		}
+	}
+
+	// The answer buttons and the "I am a bot" button
+	if len(tokens) != buttonChoices+1 {
+		t.Errorf("Expected %d buttons, got %d", buttonChoices+1, len(tokens))
+	}
+	if honeypots < 1 {
+		t.Error("Expected a honeypot button")
+	}
+	if matches != 1 {
+		t.Errorf("Expected exactly one correct button, got %d", matches)
+	}
+}
+
+func TestButtonLookalikeHoneypot(t *testing.T) {
+	provider := NewButtonProvider()
+
+	// Find a seed whose requested emoji has a lookalike
+	for seed := uint64(1); seed < 1000; seed++ {
+		challenge, err := provider.NewChallenge(context.Background(), Request{Seed: seed})
+		if err != nil {
+			t.Fatalf("Failed to generate button challenge: %v", err)
+		}
+		lookalike, ok := buttonLookalikes[challenge.PromptData["Emoji"].(string)]
+		if !ok {
+			continue
+		}
+
+		found := false
+		for _, row := range challenge.Buttons {
+			for _, button := range row {
+				if button.Text == lookalike {
+					found = true
+					if !button.Honeypot {
+						t.Errorf("Lookalike %q is not a honeypot", lookalike)
+					}
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected lookalike %q among the buttons of seed %d", lookalike, seed)
+		}
+		return
+	}
+	t.Fatal("No seed produced an emoji with a lookalike")
+}
@@ -0,0 +1,118 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+)
+
+// Built-in challenge types
+const (
+	TypeDigits = "digits"
+)
+
+// ErrNoChallenge is returned by a provider that cannot produce a challenge for the request
+var ErrNoChallenge = errors.New("no challenge available")
+
+// Modality describes how the user is expected to answer a challenge
+type Modality string
+
+const (
+	// ModalityText means the user types the answer as a chat message
+	ModalityText Modality = "text"
+	// ModalityButton means the user answers by pressing inline keyboard buttons
+	ModalityButton Modality = "button"
+	// ModalitySelection means the user toggles inline buttons and submits the selection
+	ModalitySelection Modality = "selection"
+	// ModalitySequence means the user presses inline buttons in a given order
+	ModalitySequence Modality = "sequence"
+	// ModalityMedia means the user answers by sending a media message
+	ModalityMedia Modality = "media"
+	// ModalityWebApp means the user solves the challenge in a Telegram Mini App
+	ModalityWebApp Modality = "webapp"
+)
+
+// MediaKind describes how the challenge payload is delivered to the chat
+type MediaKind string
+
+const (
+	MediaPhoto     MediaKind = "photo"
+	MediaAnimation MediaKind = "animation"
+	MediaVoice     MediaKind = "voice"
+)
+
+// Media is the rendered payload of a challenge
+type Media struct {
+	Kind     MediaKind
+	Data     []byte
+	Filename string
+}
+
+// Button is a single inline keyboard button of a challenge
+type Button struct {
+	Text string
+
+	// TextID is a localization message ID used instead of Text when set
+	TextID string
+
+	Value string
+
+	// URL opens a link instead of sending Value as callback data
+	URL string
+
+	// Honeypot marks a decoy people do not press; pressing it is punished at once
+	Honeypot bool
+}
+
+// Challenge is a single verification task presented to a user
+type Challenge struct {
+	Type     string
+	Modality Modality
+
+	// Media is optional; challenges without media are sent as plain text
+	Media *Media
+
+	// PromptID is the localization message ID shown along with the challenge
+	PromptID   string
+	PromptData map[string]any
+
+	// Buttons are rendered as an inline keyboard, one slice per row
+	Buttons [][]Button
+
+	// Answer is the expected answer, checked by the provider's Verifier if any
+	Answer string
+
+	// Seed regenerates the same challenge when passed in Request.Seed
+	Seed uint64
+}
+
+// Request describes who a challenge is generated for
+type Request struct {
+	ChatID       int64
+	UserID       int64
+	LanguageCode string
+
+	// Difficulty selects the generation profile; empty means the provider default
+	Difficulty Difficulty
+
+	// Seed reproduces the challenge generated with it; zero draws a new seed
+	Seed uint64
+}
+
+// ChallengeProvider produces challenges of a single type
+type ChallengeProvider interface {
+	Type() string
+	NewChallenge(ctx context.Context, req Request) (*Challenge, error)
+}
+
+// LanguageDependent is implemented by providers whose challenges differ by
+// the language of the request
+type LanguageDependent interface {
+	// LanguageVariant returns the language code the challenges of the
+	// request are generated for, "" when any language gets the same ones
+	LanguageVariant(req Request) string
+}
+
+// Verifier is implemented by providers that need custom answer validation
+type Verifier interface {
+	Verify(expected, answer string) bool
+}
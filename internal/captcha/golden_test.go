@@ -0,0 +1,129 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenSeed is the seed all golden challenges are generated with
+const goldenSeed = 20240501
+
+func TestGoldenChallenges(t *testing.T) {
+	service := NewService("")
+	mathProvider, err := NewMathProvider(service, DefaultMathOptions())
+	if err != nil {
+		t.Fatalf("Failed to create math provider: %v", err)
+	}
+
+	tests := []struct {
+		file     string
+		provider ChallengeProvider
+	}{
+		{"digits.png", service},
+		{"math.png", mathProvider},
+		{"animated.gif", NewAnimatedProvider(service)},
+		{"grid.png", NewGridProvider(service)},
+		{"sequence.png", NewSequenceProvider(service)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			challenge, err := tt.provider.NewChallenge(context.Background(), Request{Seed: goldenSeed})
+			if err != nil {
+				t.Fatalf("Failed to generate challenge: %v", err)
+			}
+			if challenge.Seed != goldenSeed {
+				t.Errorf("Expected seed %d, got %d", goldenSeed, challenge.Seed)
+			}
+
+			path := filepath.Join("testdata", "golden", tt.file)
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("Failed to create golden directory: %v", err)
+				}
+				if err := os.WriteFile(path, challenge.Media.Data, 0o644); err != nil {
+					t.Fatalf("Failed to write golden file: %v", err)
+				}
+				return
+			}
+
+			golden, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read golden file, run go test -update: %v", err)
+			}
+			if !bytes.Equal(challenge.Media.Data, golden) {
+				t.Errorf("Challenge differs from %s; if the change is intended, run go test -update", path)
+			}
+		})
+	}
+}
+
+func TestSeedReplaysChallenge(t *testing.T) {
+	service := NewService("")
+	audio, err := NewAudioProvider(service, "")
+	if err != nil {
+		t.Fatalf("Failed to create audio provider: %v", err)
+	}
+
+	quiz := NewQuizProvider(func(ctx context.Context, chatID int64) ([]QuizQuestion, error) {
+		return []QuizQuestion{{Question: "Language?", Answers: []string{"Go"}, Choices: []string{"Go", "Rust", "Python"}}}, nil
+	})
+
+	providers := []ChallengeProvider{service, audio, NewButtonProvider(), NewGridProvider(service), NewSequenceProvider(service), quiz}
+	for _, provider := range providers {
+		t.Run(provider.Type(), func(t *testing.T) {
+			first, err := provider.NewChallenge(context.Background(), Request{})
+			if err != nil {
+				t.Fatalf("Failed to generate challenge: %v", err)
+			}
+			if first.Seed == 0 {
+				t.Fatalf("Challenge has no seed")
+			}
+
+			replay, err := provider.NewChallenge(context.Background(), Request{Seed: first.Seed})
+			if err != nil {
+				t.Fatalf("Failed to replay challenge: %v", err)
+			}
+			if replay.Seed != first.Seed {
+				t.Errorf("Expected seed %d, got %d", first.Seed, replay.Seed)
+			}
+			if replay.Media != nil && !bytes.Equal(replay.Media.Data, first.Media.Data) {
+				t.Errorf("Replayed media differs")
+			}
+			if replay.Answer != first.Answer {
+				t.Errorf("Expected answer %q, got %q", first.Answer, replay.Answer)
+			}
+			// Button tokens replay too
+			if fmt.Sprint(replay.Buttons) != fmt.Sprint(first.Buttons) {
+				t.Errorf("Expected buttons %v, got %v", first.Buttons, replay.Buttons)
+			}
+		})
+	}
+}
+
+func TestPoolBypassedForSeed(t *testing.T) {
+	service := NewService("")
+	pool, err := NewPool(service, DefaultPoolOptions())
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+
+	want, err := service.NewChallenge(context.Background(), Request{Seed: goldenSeed})
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	got, err := pool.NewChallenge(context.Background(), Request{Seed: goldenSeed})
+	if err != nil {
+		t.Fatalf("Failed to generate pooled challenge: %v", err)
+	}
+	if got.Answer != want.Answer || !bytes.Equal(got.Media.Data, want.Media.Data) {
+		t.Errorf("Pool did not regenerate the seeded challenge")
+	}
+}
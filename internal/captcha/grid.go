@@ -0,0 +1,346 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeGrid is the type of "select all tiles with a circle" challenges
+const TypeGrid = "grid"
+
+// Grid layout and shape sizes in pixels
+const (
+	gridSize        = 3
+	gridTileSize    = 110
+	gridGap         = 6
+	gridMinMatches  = 2
+	gridMaxMatches  = 4
+	gridShapeRadius = 34
+	gridLabelSize   = 16
+)
+
+// gridShapes are the shapes drawn on the tiles; the prompt of each shape is
+// the localization message ID "captcha_grid_prompt_<shape>"
+var gridShapes = []string{"circle", "square", "triangle", "star", "cross"}
+
+// Polygon outlines of the shapes in unit coordinates
+var (
+	trianglePoints = regularPolygon(3, 1, 1)
+	starPoints     = regularPolygon(5, 1, 0.45)
+)
+
+// gridShape is a shape drawn on a tile, normalized to the unit circle
+type gridShape struct {
+	name   string
+	angle  float64
+	scale  float64
+	offset image.Point
+	col    color.RGBA
+	hollow bool
+}
+
+// GridProvider produces 3×3 image grids where the user selects every tile
+// showing the asked shape
+type GridProvider struct {
+	service *Service
+}
+
+// NewGridProvider creates a grid challenge provider rendering with the service
+func NewGridProvider(service *Service) *GridProvider {
+	return &GridProvider{service: service}
+}
+
+// Type returns the challenge type produced by the provider
+func (p *GridProvider) Type() string {
+	return TypeGrid
+}
+
+// NewChallenge creates a grid challenge answered by toggling tile buttons
+// and pressing submit
+func (p *GridProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	opts := p.service.Options(req.Difficulty)
+
+	source, seed, err := p.service.challengeSource(req.Seed)
+	if err != nil {
+		return nil, err
+	}
+	rnd := &randomizer{source: source}
+
+	target := gridShapes[rnd.intn(len(gridShapes))]
+	matches := gridMinMatches + rnd.intn(gridMaxMatches-gridMinMatches+1)
+
+	// Pick the tiles showing the target shape, the others get decoys
+	tiles := make([]string, gridSize*gridSize)
+	for i, tile := range rnd.perm(len(tiles)) {
+		if i < matches {
+			tiles[tile] = target
+			continue
+		}
+		decoy := rnd.intn(len(gridShapes) - 1)
+		if gridShapes[decoy] == target {
+			decoy = len(gridShapes) - 1
+		}
+		tiles[tile] = gridShapes[decoy]
+	}
+
+	data, err := p.service.renderGrid(tiles, opts, rnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var answer []int
+	for i, shape := range tiles {
+		if shape == target {
+			answer = append(answer, i)
+		}
+	}
+
+	return &Challenge{
+		Type:     TypeGrid,
+		Modality: ModalitySelection,
+		Media: &Media{
+			Kind:     MediaPhoto,
+			Data:     data,
+			Filename: "captcha.png",
+		},
+		PromptID: "captcha_grid_prompt_" + target,
+		Buttons:  gridButtons(),
+		Answer:   formatSelection(answer),
+		Seed:     seed,
+	}, nil
+}
+
+// Verify compares the selected tiles regardless of the order they were picked in
+func (p *GridProvider) Verify(expected, answer string) bool {
+	return formatSelection(parseSelection(answer)) == expected
+}
+
+// gridButtons returns the tile buttons numbered like the tiles and a submit button below them
+func gridButtons() [][]Button {
+	buttons := make([][]Button, 0, gridSize+1)
+	for row := 0; row < gridSize; row++ {
+		buttonRow := make([]Button, 0, gridSize)
+		for col := 0; col < gridSize; col++ {
+			tile := row*gridSize + col
+			buttonRow = append(buttonRow, Button{Text: strconv.Itoa(tile + 1), Value: gridTilePrefix + strconv.Itoa(tile)})
+		}
+		buttons = append(buttons, buttonRow)
+	}
+
+	return append(buttons, []Button{{TextID: "captcha_grid_submit", Value: GridSubmitValue}})
+}
+
+// GridButtons returns the buttons of a grid challenge with the selected tiles marked
+func GridButtons(buttons [][]Button, selection string) [][]Button {
+	selected := make(map[string]bool)
+	for _, tile := range parseSelection(selection) {
+		selected[gridTilePrefix+strconv.Itoa(tile)] = true
+	}
+
+	marked := make([][]Button, len(buttons))
+	for i, row := range buttons {
+		marked[i] = append([]Button(nil), row...)
+		for j, button := range marked[i] {
+			if selected[button.Value] {
+				marked[i][j].Text = "✅ " + button.Text
+			}
+		}
+	}
+	return marked
+}
+
+// ToggleGridTile toggles the tile of a button value in the selection and
+// reports whether the value was a tile button
+func ToggleGridTile(selection, value string) (string, bool) {
+	tile, err := strconv.Atoi(strings.TrimPrefix(value, gridTilePrefix))
+	if !strings.HasPrefix(value, gridTilePrefix) || err != nil || tile < 0 || tile >= gridSize*gridSize {
+		return selection, false
+	}
+
+	tiles := parseSelection(selection)
+	for i, t := range tiles {
+		if t == tile {
+			return formatSelection(append(tiles[:i], tiles[i+1:]...)), true
+		}
+	}
+	return formatSelection(append(tiles, tile)), true
+}
+
+// formatSelection encodes tile indexes as a sorted comma separated list
+func formatSelection(tiles []int) string {
+	sorted := append([]int(nil), tiles...)
+	sort.Ints(sorted)
+
+	parts := make([]string, len(sorted))
+	for i, tile := range sorted {
+		parts[i] = strconv.Itoa(tile)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSelection decodes a list of tile indexes, skipping invalid and repeated ones
+func parseSelection(selection string) []int {
+	seen := make(map[int]bool)
+	var tiles []int
+	for _, part := range strings.Split(selection, ",") {
+		tile, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || tile < 0 || tile >= gridSize*gridSize || seen[tile] {
+			continue
+		}
+		seen[tile] = true
+		tiles = append(tiles, tile)
+	}
+	return tiles
+}
+
+// renderGrid draws the shapes on numbered tiles and encodes the image to PNG
+func (s *Service) renderGrid(tiles []string, opts Options, rnd *randomizer) ([]byte, error) {
+	face, err := newFace(gridLabelSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	size := gridSize*gridTileSize + (gridSize+1)*gridGap
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: 60, G: 60, B: 60, A: 255})
+		}
+	}
+
+	distortion := opts.Distortion
+	for i, name := range tiles {
+		x := gridGap + (i%gridSize)*(gridTileSize+gridGap)
+		y := gridGap + (i/gridSize)*(gridTileSize+gridGap)
+		tile := img.SubImage(image.Rect(x, y, x+gridTileSize, y+gridTileSize)).(*image.RGBA)
+		drawBackground(tile, rnd)
+
+		jitter := int(8 * distortion)
+		drawShape(tile, gridShape{
+			name:  name,
+			angle: rnd.float(0, 2*math.Pi),
+			scale: rnd.float(1-0.25*distortion, 1+0.1*distortion),
+			offset: image.Point{
+				X: rnd.intn(2*jitter+1) - jitter,
+				Y: rnd.intn(2*jitter+1) - jitter,
+			},
+			col:    rnd.color(20, 140),
+			hollow: rnd.intn(4) < int(math.Round(2*distortion)),
+		})
+
+		// Tile numbers match the button labels
+		label := []rune(strconv.Itoa(i + 1))[0]
+		drawGlyph(tile, face, gridLabelSize, label, color.RGBA{A: 255},
+			image.Point{X: x + gridLabelSize/2 + 2, Y: y + gridLabelSize/2 + 2}, 0, 1)
+	}
+
+	// Noise lines cross tile borders so tiles cannot be cut out cleanly
+	for i := 0; i < opts.NoiseLines; i++ {
+		s.drawNoiseLine(img, rnd)
+	}
+	for i := 0; i < opts.NoiseDots; i++ {
+		s.drawNoiseDot(img, rnd)
+	}
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawShape fills the shape around the tile center; hollow shapes are drawn
+// as thick outlines
+func drawShape(tile *image.RGBA, shape gridShape) {
+	bounds := tile.Bounds()
+	radius := gridShapeRadius * shape.scale
+	cx := float64(bounds.Min.X+bounds.Dx()/2+shape.offset.X) + 0.5
+	cy := float64(bounds.Min.Y+bounds.Dy()/2+shape.offset.Y) + 0.5
+	sin, cos := math.Sincos(-shape.angle)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Rotate the pixel into the shape's unit coordinates
+			dx, dy := (float64(x)-cx)/radius, (float64(y)-cy)/radius
+			ux, uy := dx*cos-dy*sin, dx*sin+dy*cos
+
+			if !insideShape(shape.name, ux, uy) {
+				continue
+			}
+			if shape.hollow && insideShape(shape.name, ux/0.65, uy/0.65) {
+				continue
+			}
+			tile.SetRGBA(x, y, shape.col)
+		}
+	}
+}
+
+// insideShape reports whether the point in unit coordinates lies in the shape
+func insideShape(name string, x, y float64) bool {
+	switch name {
+	case "circle":
+		return x*x+y*y <= 0.85
+	case "square":
+		return math.Abs(x) <= 0.75 && math.Abs(y) <= 0.75
+	case "triangle":
+		return insidePolygon(trianglePoints, x, y)
+	case "star":
+		return insidePolygon(starPoints, x, y)
+	case "diamond":
+		return math.Abs(x)+math.Abs(y) <= 1
+	case "cross":
+		return (math.Abs(x) <= 0.3 && math.Abs(y) <= 0.9) || (math.Abs(y) <= 0.3 && math.Abs(x) <= 0.9)
+	default:
+		return false
+	}
+}
+
+// regularPolygon returns the vertices of a polygon with n points, alternating
+// between the outer and inner radius; equal radii give a regular polygon
+func regularPolygon(n int, outer, inner float64) [][2]float64 {
+	if outer == inner {
+		points := make([][2]float64, n)
+		for i := range points {
+			sin, cos := math.Sincos(2*math.Pi*float64(i)/float64(n) - math.Pi/2)
+			points[i] = [2]float64{outer * cos, outer * sin}
+		}
+		return points
+	}
+
+	points := make([][2]float64, 2*n)
+	for i := range points {
+		r := outer
+		if i%2 == 1 {
+			r = inner
+		}
+		sin, cos := math.Sincos(math.Pi*float64(i)/float64(n) - math.Pi/2)
+		points[i] = [2]float64{r * cos, r * sin}
+	}
+	return points
+}
+
+// insidePolygon tests the point against the polygon with ray casting
+func insidePolygon(points [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		xi, yi := points[i][0], points[i][1]
+		xj, yj := points[j][0], points[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,85 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestGridChallenge(t *testing.T) {
+	provider := NewGridProvider(NewService(""))
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Failed to generate grid challenge: %v", err)
+	}
+
+	if challenge.Modality != ModalitySelection {
+		t.Errorf("Expected modality %q, got %q", ModalitySelection, challenge.Modality)
+	}
+	if !strings.HasPrefix(challenge.PromptID, "captcha_grid_prompt_") {
+		t.Errorf("Unexpected prompt %q", challenge.PromptID)
+	}
+
+	img, err := png.Decode(bytes.NewReader(challenge.Media.Data))
+	if err != nil {
+		t.Fatalf("Grid is not a valid PNG: %v", err)
+	}
+	if size := gridSize*gridTileSize + (gridSize+1)*gridGap; img.Bounds().Dx() != size {
+		t.Errorf("Expected grid width %d, got %d", size, img.Bounds().Dx())
+	}
+
+	tiles := parseSelection(challenge.Answer)
+	if len(tiles) < gridMinMatches || len(tiles) > gridMaxMatches {
+		t.Errorf("Expected %d to %d matching tiles, got %q", gridMinMatches, gridMaxMatches, challenge.Answer)
+	}
+
+	// Toggling the answer tiles in any order solves the challenge
+	selection := ""
+	for i := len(tiles) - 1; i >= 0; i-- {
+		var ok bool
+		selection, ok = ToggleGridTile(selection, gridTilePrefix+string(rune('0'+tiles[i])))
+		if !ok {
+			t.Fatalf("Tile %d was not toggled", tiles[i])
+		}
+	}
+	if !provider.Verify(challenge.Answer, selection) {
+		t.Errorf("Selection %q does not solve %q", selection, challenge.Answer)
+	}
+	if provider.Verify(challenge.Answer, "") {
+		t.Errorf("Empty selection solves %q", challenge.Answer)
+	}
+}
+
+func TestToggleGridTile(t *testing.T) {
+	selection, _ := ToggleGridTile("", "t4")
+	selection, _ = ToggleGridTile(selection, "t0")
+	if selection != "0,4" {
+		t.Errorf("Expected selection \"0,4\", got %q", selection)
+	}
+
+	selection, _ = ToggleGridTile(selection, "t4")
+	if selection != "0" {
+		t.Errorf("Expected selection \"0\", got %q", selection)
+	}
+
+	for _, value := range []string{"t9", "t-1", "4", GridSubmitValue, "audio"} {
+		if _, ok := ToggleGridTile(selection, value); ok {
+			t.Errorf("Value %q toggled a tile", value)
+		}
+	}
+
+	marked := 0
+	for _, row := range GridButtons(gridButtons(), "0,8") {
+		for _, button := range row {
+			if strings.HasPrefix(button.Text, "✅") {
+				marked++
+			}
+		}
+	}
+	if marked != 2 {
+		t.Errorf("Expected 2 marked buttons, got %d", marked)
+	}
+}
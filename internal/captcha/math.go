@@ -0,0 +1,218 @@
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TypeMath is the type of arithmetic challenges
+const TypeMath = "math"
+
+// maxMathAttempts limits the search for an expression within the result bounds
+const maxMathAttempts = 1000
+
+// Supported math operators
+const (
+	OperatorAdd      = "+"
+	OperatorSubtract = "-"
+	OperatorMultiply = "×"
+)
+
+// MathOptions configures generated arithmetic expressions
+type MathOptions struct {
+	Operators  []string
+	Operands   int
+	MinOperand int
+	MaxOperand int
+	MinResult  int
+	MaxResult  int
+}
+
+// DefaultMathOptions returns options for expressions like "7 + 5 × 2"
+func DefaultMathOptions() MathOptions {
+	return MathOptions{
+		Operators:  []string{OperatorAdd, OperatorSubtract, OperatorMultiply},
+		Operands:   3,
+		MinOperand: 1,
+		MaxOperand: 9,
+		MinResult:  0,
+		MaxResult:  99,
+	}
+}
+
+// Validate checks that the options can produce an expression
+func (o MathOptions) Validate() error {
+	if len(o.Operators) == 0 {
+		return fmt.Errorf("at least one operator is required")
+	}
+	for _, op := range o.Operators {
+		switch op {
+		case OperatorAdd, OperatorSubtract, OperatorMultiply:
+		default:
+			return fmt.Errorf("unsupported operator %q", op)
+		}
+	}
+	if o.Operands < 2 {
+		return fmt.Errorf("at least two operands are required, got %d", o.Operands)
+	}
+	if o.MinOperand < 0 || o.MinOperand > o.MaxOperand {
+		return fmt.Errorf("invalid operand range [%d, %d]", o.MinOperand, o.MaxOperand)
+	}
+	if o.MinResult > o.MaxResult {
+		return fmt.Errorf("invalid result range [%d, %d]", o.MinResult, o.MaxResult)
+	}
+	return nil
+}
+
+// GenerateMath creates a captcha image with an arithmetic expression
+// whose answer is the numeric result
+func (s *Service) GenerateMath(opts MathOptions) (*CaptchaImage, error) {
+	return s.generateMath(opts, s.Options(""), 0)
+}
+
+func (s *Service) generateMath(opts MathOptions, render Options, seed uint64) (*CaptchaImage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid math options: %w", err)
+	}
+
+	source, seed, err := s.challengeSource(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < maxMathAttempts; attempt++ {
+		operands, operators, err := randomExpression(source, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		result := evaluate(operands, operators)
+		if result < opts.MinResult || result > opts.MaxResult {
+			continue
+		}
+
+		var text strings.Builder
+		for i, operand := range operands {
+			if i > 0 {
+				text.WriteString(operators[i-1])
+			}
+			text.WriteString(strconv.Itoa(operand))
+		}
+		text.WriteString("=?")
+
+		data, err := s.render(text.String(), render, source)
+		if err != nil {
+			return nil, err
+		}
+
+		return &CaptchaImage{
+			Image:  data,
+			Answer: strconv.Itoa(result),
+			Seed:   seed,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no expression within result range [%d, %d] after %d attempts",
+		opts.MinResult, opts.MaxResult, maxMathAttempts)
+}
+
+func randomExpression(source Source, opts MathOptions) ([]int, []string, error) {
+	operands := make([]int, opts.Operands)
+	for i := range operands {
+		n, err := source.Intn(opts.MaxOperand - opts.MinOperand + 1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate random operand: %w", err)
+		}
+		operands[i] = opts.MinOperand + n
+	}
+
+	operators := make([]string, opts.Operands-1)
+	for i := range operators {
+		n, err := source.Intn(len(opts.Operators))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate random operator: %w", err)
+		}
+		operators[i] = opts.Operators[n]
+	}
+
+	return operands, operators, nil
+}
+
+// evaluate computes the expression honouring multiplication precedence
+func evaluate(operands []int, operators []string) int {
+	result := 0
+	sign := 1
+	term := operands[0]
+
+	for i, op := range operators {
+		next := operands[i+1]
+		switch op {
+		case OperatorMultiply:
+			term *= next
+		case OperatorAdd, OperatorSubtract:
+			result += sign * term
+			term = next
+			sign = 1
+			if op == OperatorSubtract {
+				sign = -1
+			}
+		}
+	}
+
+	return result + sign*term
+}
+
+// MathProvider produces arithmetic challenges
+type MathProvider struct {
+	service *Service
+	opts    MathOptions
+}
+
+// NewMathProvider creates a math challenge provider rendering with the service
+func NewMathProvider(service *Service, opts MathOptions) (*MathProvider, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid math options: %w", err)
+	}
+	return &MathProvider{service: service, opts: opts}, nil
+}
+
+// Type returns the challenge type produced by the provider
+func (p *MathProvider) Type() string {
+	return TypeMath
+}
+
+// NewChallenge creates an arithmetic challenge to be answered with a text message
+func (p *MathProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	img, err := p.service.generateMath(p.opts, p.service.Options(req.Difficulty), req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		Type:     TypeMath,
+		Modality: ModalityText,
+		Media: &Media{
+			Kind:     MediaPhoto,
+			Data:     img.Image,
+			Filename: "captcha.png",
+		},
+		PromptID: "captcha_math_prompt",
+		Answer:   img.Answer,
+		Seed:     img.Seed,
+	}, nil
+}
+
+// Verify compares the answer numerically after normalization, keeping the sign
+func (p *MathProvider) Verify(expected, answer string) bool {
+	want, err := strconv.Atoi(expected)
+	if err != nil {
+		return false
+	}
+	got, err := strconv.Atoi(NormalizeAnswer(answer, NormalizeOptions{KeepSign: true}))
+	if err != nil {
+		return false
+	}
+	return got == want
+}
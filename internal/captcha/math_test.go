@@ -0,0 +1,86 @@
+package captcha
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		operands  []int
+		operators []string
+		want      int
+	}{
+		{[]int{7, 5, 2}, []string{OperatorAdd, OperatorMultiply}, 17},
+		{[]int{7, 5, 2}, []string{OperatorMultiply, OperatorSubtract}, 33},
+		{[]int{9, 3, 4}, []string{OperatorSubtract, OperatorMultiply}, -3},
+		{[]int{2, 3, 4}, []string{OperatorMultiply, OperatorMultiply}, 24},
+		{[]int{8, 1}, []string{OperatorSubtract}, 7},
+	}
+
+	for _, tt := range tests {
+		if got := evaluate(tt.operands, tt.operators); got != tt.want {
+			t.Errorf("evaluate(%v, %v) = %d, want %d", tt.operands, tt.operators, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateMath(t *testing.T) {
+	service := NewService("../../assets")
+	opts := DefaultMathOptions()
+	opts.MinResult = 10
+	opts.MaxResult = 20
+
+	for i := 0; i < 20; i++ {
+		captcha, err := service.GenerateMath(opts)
+		if err != nil {
+			t.Fatalf("Failed to generate math captcha: %v", err)
+		}
+
+		if len(captcha.Image) == 0 {
+			t.Error("Generated image is empty")
+		}
+
+		result, err := strconv.Atoi(captcha.Answer)
+		if err != nil {
+			t.Fatalf("Answer %q is not a number", captcha.Answer)
+		}
+		if result < opts.MinResult || result > opts.MaxResult {
+			t.Errorf("Result %d is outside of [%d, %d]", result, opts.MinResult, opts.MaxResult)
+		}
+	}
+}
+
+func TestMathOptionsValidate(t *testing.T) {
+	opts := DefaultMathOptions()
+	opts.Operators = []string{"/"}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for an unsupported operator")
+	}
+
+	opts = DefaultMathOptions()
+	opts.MinOperand, opts.MaxOperand = 10, 1
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for an inverted operand range")
+	}
+}
+
+func TestMathVerify(t *testing.T) {
+	provider, err := NewMathProvider(NewService(""), DefaultMathOptions())
+	if err != nil {
+		t.Fatalf("Failed to create math provider: %v", err)
+	}
+
+	if !provider.Verify("17", " 17 ") {
+		t.Error("Expected answer with surrounding spaces to be accepted")
+	}
+	if !provider.Verify("7", "07") {
+		t.Error("Expected answer with a leading zero to be accepted")
+	}
+	if provider.Verify("17", "18") {
+		t.Error("Expected wrong answer to be rejected")
+	}
+	if provider.Verify("17", "seventeen") {
+		t.Error("Expected non-numeric answer to be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+package captcha
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeOptions selects the optional steps of NormalizeAnswer
+type NormalizeOptions struct {
+	// FoldCase makes letters case-insensitive, for letter captchas
+	FoldCase bool
+
+	// KeepSign keeps a leading minus sign, for numeric answers
+	KeepSign bool
+
+	// KeepPunctuation keeps punctuation and symbols, for free-form answers
+	// where "C++" and "C" differ
+	KeepPunctuation bool
+}
+
+// minusSigns are the dashes users type for a negative number
+const minusSigns = "-−‐‑‒–—﹣－"
+
+// NormalizeAnswer brings a typed answer to a canonical form, so answers
+// differing only in the way they were typed compare equal:
+//   - compatibility forms are folded (full-width "１２", circled "①", superscripts)
+//   - decimal digits of every script become ASCII digits ("١٢", "१२", "๑๒")
+//   - whitespace, invisible formatting characters, punctuation and symbols are dropped
+func NormalizeAnswer(answer string, opts NormalizeOptions) string {
+	answer = norm.NFKC.String(answer)
+
+	var sb strings.Builder
+	sb.Grow(len(answer))
+
+	for _, r := range answer {
+		switch {
+		case unicode.IsDigit(r):
+			sb.WriteRune(asciiDigit(r))
+		case opts.KeepSign && sb.Len() == 0 && strings.ContainsRune(minusSigns, r):
+			sb.WriteByte('-')
+		case unicode.IsSpace(r), unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			// Spaces, RTL marks and zero-width characters are never part of an answer
+		case !opts.KeepPunctuation && (unicode.IsPunct(r) || unicode.IsSymbol(r)):
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	normalized := sb.String()
+	if opts.FoldCase {
+		normalized = cases.Fold().String(normalized)
+	}
+	return normalized
+}
+
+// asciiDigit maps a decimal digit of any script to its ASCII form. Unicode
+// encodes decimal digits in runs starting at zero, so the value is the
+// offset from the start of the run.
+func asciiDigit(r rune) rune {
+	start := r
+	for unicode.IsDigit(start - 1) {
+		start--
+	}
+	return '0' + (r-start)%10
+}
@@ -0,0 +1,159 @@
+package captcha
+
+import (
+	"testing"
+	"unicode"
+)
+
+func TestNormalizeAnswerDigits(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+	}{
+		{"ascii", "1234"},
+		{"full-width", "１２３４"},
+		{"arabic-indic", "١٢٣٤"},
+		{"extended arabic-indic", "۱۲۳۴"},
+		{"devanagari", "१२३४"},
+		{"bengali", "১২৩৪"},
+		{"gurmukhi", "੧੨੩੪"},
+		{"tamil", "௧௨௩௪"},
+		{"thai", "๑๒๓๔"},
+		{"lao", "໑໒໓໔"},
+		{"tibetan", "༡༢༣༤"},
+		{"myanmar", "၁၂၃၄"},
+		{"khmer", "១២៣៤"},
+		{"mongolian", "᠑᠒᠓᠔"},
+		{"nko", "߁߂߃߄"},
+		{"mathematical bold", "𝟏𝟐𝟑𝟒"},
+		{"mathematical monospace", "𝟷𝟸𝟹𝟺"},
+		{"circled", "①②③④"},
+		{"superscript", "¹²³⁴"},
+		{"mixed scripts", "1٢३๔"},
+		{"inner space", "12 34"},
+		{"trailing dot", "1234."},
+		{"thousands separator", "1,234"},
+		{"surrounding whitespace", "\t 1234 \n"},
+		{"no-break space", "12\u00a034"},
+		{"ideographic space", "１２\u3000３４"},
+		{"right-to-left mark", "\u200f١٢٣٤"},
+		{"zero-width space", "12\u200b34"},
+		{"quotes", "«1234»"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeAnswer(tt.answer, NormalizeOptions{}); got != "1234" {
+			t.Errorf("%s: NormalizeAnswer(%q) = %q, want \"1234\"", tt.name, tt.answer, got)
+		}
+	}
+}
+
+func TestNormalizeAnswerZero(t *testing.T) {
+	for _, answer := range []string{"0", "０", "٠", "۰", "०", "০", "๐", "𝟎"} {
+		if got := NormalizeAnswer(answer, NormalizeOptions{}); got != "0" {
+			t.Errorf("NormalizeAnswer(%q) = %q, want \"0\"", answer, got)
+		}
+	}
+}
+
+func TestNormalizeAnswerCase(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"AB12", "ab12"},
+		{"ＡＢ１２", "ab12"},
+		{"ПРИВЕТ", "привет"},
+		{"ΣΟΦΙΑ", "σοφια"},
+		{"STRASSE", "straße"},
+		{"Ab 12!", "aB12"},
+	}
+
+	for _, tt := range tests {
+		a := NormalizeAnswer(tt.a, NormalizeOptions{FoldCase: true})
+		b := NormalizeAnswer(tt.b, NormalizeOptions{FoldCase: true})
+		if a != b {
+			t.Errorf("Expected %q and %q to match, got %q and %q", tt.a, tt.b, a, b)
+		}
+	}
+
+	if NormalizeAnswer("AB", NormalizeOptions{}) == NormalizeAnswer("ab", NormalizeOptions{}) {
+		t.Error("Expected case to be kept without FoldCase")
+	}
+}
+
+func TestNormalizeAnswerSign(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"-12", "-12"},
+		{" − 12", "-12"},
+		{"–١٢", "-12"},
+		{"－１２", "-12"},
+		{"12-", "12"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeAnswer(tt.answer, NormalizeOptions{KeepSign: true}); got != tt.want {
+			t.Errorf("NormalizeAnswer(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+
+	if got := NormalizeAnswer("-12", NormalizeOptions{}); got != "12" {
+		t.Errorf("Expected the sign to be dropped without KeepSign, got %q", got)
+	}
+}
+
+func TestNormalizeAnswerPunctuation(t *testing.T) {
+	if got := NormalizeAnswer(" C++ ", NormalizeOptions{KeepPunctuation: true}); got != "C++" {
+		t.Errorf("Expected punctuation to be kept, got %q", got)
+	}
+	if got := NormalizeAnswer("C++", NormalizeOptions{}); got != "C" {
+		t.Errorf("Expected punctuation to be dropped, got %q", got)
+	}
+}
+
+// Every decimal digit must map to an ASCII digit, which relies on digits
+// being encoded in runs of ten starting at zero
+func TestAsciiDigitCoversAllScripts(t *testing.T) {
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if !unicode.IsDigit(r) {
+			continue
+		}
+		d := asciiDigit(r)
+		if d < '0' || d > '9' {
+			t.Fatalf("Digit %U maps to %q", r, d)
+		}
+		if d == '0' && unicode.IsDigit(r-1) && asciiDigit(r-1) != '9' {
+			t.Errorf("Digit run before %U does not end with nine", r)
+		}
+	}
+}
+
+func TestVerifyNormalizedAnswers(t *testing.T) {
+	service := NewService("")
+	if !service.Verify("AB12", " ａｂ１２。") {
+		t.Error("Expected full-width lower case answer to be accepted")
+	}
+
+	hashed, err := HashAnswer("4821")
+	if err != nil {
+		t.Fatalf("Failed to hash answer: %v", err)
+	}
+	if !service.Verify(hashed, "٤٨٢١") {
+		t.Error("Expected Arabic-Indic digits to match a hashed answer")
+	}
+
+	math := &MathProvider{}
+	if !math.Verify("-7", "−７") || math.Verify("-7", "7") {
+		t.Error("Expected math answers to be compared with their sign")
+	}
+
+	hashed, err = HashAnswer("-7")
+	if err != nil {
+		t.Fatalf("Failed to hash answer: %v", err)
+	}
+	if !VerifyHashedAnswer(hashed, "−７") || VerifyHashedAnswer(hashed, "7") {
+		t.Error("Expected hashed math answers to be compared with their sign")
+	}
+}
@@ -0,0 +1,159 @@
+package captcha
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Difficulty selects a predefined set of generation options
+type Difficulty string
+
+const (
+	DifficultyEasy   Difficulty = "easy"
+	DifficultyNormal Difficulty = "normal"
+	DifficultyHard   Difficulty = "hard"
+	DifficultyCustom Difficulty = "custom"
+)
+
+// Difficulties lists all supported difficulty profiles
+var Difficulties = []Difficulty{DifficultyEasy, DifficultyNormal, DifficultyHard, DifficultyCustom}
+
+// ParseDifficulty converts a string into a difficulty profile
+func ParseDifficulty(value string) (Difficulty, error) {
+	d := Difficulty(strings.ToLower(strings.TrimSpace(value)))
+	for _, known := range Difficulties {
+		if d == known {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("unknown difficulty %q", value)
+}
+
+// Alphabet selects the characters used in text captchas
+type Alphabet string
+
+const (
+	AlphabetDigits  Alphabet = "digits"
+	AlphabetLetters Alphabet = "letters"
+	AlphabetMixed   Alphabet = "mixed"
+)
+
+const (
+	digitChars  = "0123456789"
+	letterChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	// ambiguousChars look alike in distorted images (0/O/Q/D, 1/I/L/J, 2/Z, 5/S, 6/G, 8/B, U/V)
+	ambiguousChars = "0OQD1ILJ2Z5S6G8BUV"
+)
+
+// Options configures text captcha generation
+type Options struct {
+	Length           int
+	Alphabet         Alphabet
+	ExcludeAmbiguous bool
+	Width            int
+	Height           int
+	NoiseLines       int
+	NoiseDots        int
+
+	// Localized takes letters from the alphabet of the member's language
+	// instead of Latin, see LocaleAlphabet
+	Localized bool
+
+	// Distortion scales glyph rotation, scaling, warping and strike lines;
+	// 0 disables them and 1 is the normal level
+	Distortion float64
+}
+
+// DefaultOptions returns the options of the normal difficulty profile
+func DefaultOptions() Options {
+	return Options{
+		Length:     4,
+		Alphabet:   AlphabetDigits,
+		Width:      200,
+		Height:     80,
+		NoiseLines: 10,
+		NoiseDots:  100,
+		Distortion: 1,
+	}
+}
+
+// ProfileOptions returns the predefined options of a difficulty profile.
+// The custom profile starts from the normal one and is meant to be overridden.
+func ProfileOptions(d Difficulty) (Options, error) {
+	opts := DefaultOptions()
+
+	switch d {
+	case DifficultyEasy:
+		opts.NoiseLines = 4
+		opts.NoiseDots = 40
+		opts.Distortion = 0.5
+	case DifficultyNormal, DifficultyCustom:
+	case DifficultyHard:
+		opts.Length = 6
+		opts.Alphabet = AlphabetMixed
+		opts.ExcludeAmbiguous = true
+		opts.Localized = true
+		opts.Width = 240
+		opts.NoiseLines = 16
+		opts.NoiseDots = 250
+		opts.Distortion = 1.6
+	default:
+		return Options{}, fmt.Errorf("unknown difficulty %q", d)
+	}
+
+	return opts, nil
+}
+
+// Validate checks that the options can produce a readable captcha
+func (o Options) Validate() error {
+	if o.Length < 1 || o.Length > 12 {
+		return fmt.Errorf("length must be between 1 and 12, got %d", o.Length)
+	}
+	if len(o.Charset()) == 0 {
+		return fmt.Errorf("unknown alphabet %q", o.Alphabet)
+	}
+	if o.Width < 50 || o.Height < 30 {
+		return fmt.Errorf("image size %dx%d is too small", o.Width, o.Height)
+	}
+	if o.NoiseLines < 0 || o.NoiseDots < 0 {
+		return fmt.Errorf("noise amounts must not be negative")
+	}
+	if o.Distortion < 0 || o.Distortion > 3 {
+		return fmt.Errorf("distortion must be between 0 and 3, got %g", o.Distortion)
+	}
+	return nil
+}
+
+// Charset returns the characters answers are built from with Latin letters
+func (o Options) Charset() []rune {
+	return o.LocaleCharset(LatinAlphabet)
+}
+
+// LocaleCharset returns the characters answers are built from with the
+// letters of the alphabet
+func (o Options) LocaleCharset(alphabet LocaleAlphabet) []rune {
+	var chars string
+	switch o.Alphabet {
+	case AlphabetDigits:
+		chars = digitChars
+	case AlphabetLetters:
+		chars = alphabet.Letters
+	case AlphabetMixed:
+		chars = digitChars + alphabet.Letters
+	default:
+		return nil
+	}
+
+	if !o.ExcludeAmbiguous {
+		return []rune(chars)
+	}
+
+	var out []rune
+	for _, r := range chars {
+		if !strings.ContainsRune(ambiguousChars, r) && !strings.ContainsRune(alphabet.Ambiguous, r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
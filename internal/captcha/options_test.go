@@ -0,0 +1,69 @@
+package captcha
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileOptions(t *testing.T) {
+	for _, d := range Difficulties {
+		opts, err := ProfileOptions(d)
+		if err != nil {
+			t.Fatalf("Failed to get %s profile: %v", d, err)
+		}
+		if err := opts.Validate(); err != nil {
+			t.Errorf("Profile %s is invalid: %v", d, err)
+		}
+	}
+
+	if _, err := ProfileOptions("impossible"); err == nil {
+		t.Error("Expected an error for an unknown difficulty")
+	}
+}
+
+func TestParseDifficulty(t *testing.T) {
+	d, err := ParseDifficulty(" Hard ")
+	if err != nil || d != DifficultyHard {
+		t.Errorf("ParseDifficulty(\" Hard \") = %q, %v", d, err)
+	}
+
+	if _, err := ParseDifficulty("extreme"); err == nil {
+		t.Error("Expected an error for an unknown difficulty")
+	}
+}
+
+func TestCharsetExcludesAmbiguous(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Alphabet = AlphabetMixed
+	opts.ExcludeAmbiguous = true
+
+	for _, r := range opts.Charset() {
+		if strings.ContainsRune(ambiguousChars, r) {
+			t.Errorf("Charset contains ambiguous character %q", r)
+		}
+	}
+}
+
+func TestGenerateWithHardProfile(t *testing.T) {
+	service := NewService("")
+	opts := service.Options(DifficultyHard)
+	charset := string(opts.Charset())
+
+	captcha, err := service.GenerateWithOptions(opts)
+	if err != nil {
+		t.Fatalf("Failed to generate captcha: %v", err)
+	}
+
+	if len(captcha.Answer) != opts.Length {
+		t.Errorf("Expected answer length %d, got %d", opts.Length, len(captcha.Answer))
+	}
+	for _, r := range captcha.Answer {
+		if !strings.ContainsRune(charset, r) {
+			t.Errorf("Answer contains character %q outside of the alphabet", r)
+		}
+	}
+
+	if !service.Verify(captcha.Answer, strings.ToLower(captcha.Answer)) {
+		t.Error("Expected lowercase answer to be accepted")
+	}
+}
@@ -0,0 +1,413 @@
+package captcha
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ManifestFile is the name of the manifest inside an asset pack directory
+const ManifestFile = "manifest.json"
+
+const (
+	packVersion = 1
+
+	// packFileBytes is the length of random file names, so names say nothing about answers
+	packFileBytes = 16
+
+	hashedAnswerPrefix = "sha256:"
+	answerSaltBytes    = 16
+)
+
+// packFileName matches the opaque file names of pack items
+var packFileName = regexp.MustCompile(`^[0-9a-f]{32}\.(png|gif|ogg)$`)
+
+// packExtensions maps media kinds to the file extensions used in packs
+var packExtensions = map[MediaKind]string{
+	MediaPhoto:     ".png",
+	MediaAnimation: ".gif",
+	MediaVoice:     ".ogg",
+}
+
+// Manifest describes the challenges of an asset pack
+type Manifest struct {
+	Version int        `json:"version"`
+	Items   []PackItem `json:"items"`
+}
+
+// PackItem is a single pre-rendered challenge of an asset pack
+type PackItem struct {
+	File       string     `json:"file"`
+	Type       string     `json:"type"`
+	Media      MediaKind  `json:"media"`
+	Difficulty Difficulty `json:"difficulty"`
+	// Locale is empty for challenges suitable for any language
+	Locale   string `json:"locale,omitempty"`
+	PromptID string `json:"prompt"`
+	// Answer is the salted hash of the answer, see HashAnswer
+	Answer string `json:"answer"`
+	// Checksum is the hex encoded SHA-256 of the file contents
+	Checksum string `json:"sha256"`
+}
+
+// Pack is a validated asset pack loaded from a directory
+type Pack struct {
+	dir   string
+	items []PackItem
+}
+
+// LoadPack reads the manifest of the pack in the directory and validates
+// every item and the checksum of its file
+func LoadPack(dir string) (*Pack, error) {
+	return LoadPackManifest(filepath.Join(dir, ManifestFile), dir)
+}
+
+// LoadPackManifest loads a pack whose manifest is kept apart from its files
+func LoadPackManifest(manifestPath, dir string) (*Pack, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Version != packVersion {
+		return nil, fmt.Errorf("unsupported pack version %d", manifest.Version)
+	}
+
+	pack := &Pack{dir: dir, items: manifest.Items}
+	files := make(map[string]bool, len(manifest.Items))
+	for i, item := range manifest.Items {
+		if err := item.validate(); err != nil {
+			return nil, fmt.Errorf("invalid item %d: %w", i, err)
+		}
+		if files[item.File] {
+			return nil, fmt.Errorf("invalid item %d: duplicate file %s", i, item.File)
+		}
+		files[item.File] = true
+
+		if _, err := pack.Read(item); err != nil {
+			return nil, fmt.Errorf("invalid item %d: %w", i, err)
+		}
+	}
+
+	return pack, nil
+}
+
+func (item PackItem) validate() error {
+	if !packFileName.MatchString(item.File) {
+		return fmt.Errorf("file name %q is not an opaque pack name", item.File)
+	}
+	if item.Type == "" {
+		return fmt.Errorf("missing challenge type")
+	}
+	if ext, ok := packExtensions[item.Media]; !ok || filepath.Ext(item.File) != ext {
+		return fmt.Errorf("media %q does not match file %s", item.Media, item.File)
+	}
+	if _, err := ParseDifficulty(string(item.Difficulty)); err != nil {
+		return err
+	}
+	if item.PromptID == "" {
+		return fmt.Errorf("missing prompt")
+	}
+	if !IsHashedAnswer(item.Answer) {
+		return fmt.Errorf("answer is not hashed")
+	}
+	if _, err := hex.DecodeString(item.Checksum); err != nil || len(item.Checksum) != 2*sha256.Size {
+		return fmt.Errorf("invalid checksum %q", item.Checksum)
+	}
+	return nil
+}
+
+// Items returns all items of the pack
+func (p *Pack) Items() []PackItem {
+	return p.items
+}
+
+// Types returns the challenge types present in the pack
+func (p *Pack) Types() []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, item := range p.items {
+		if !seen[item.Type] {
+			seen[item.Type] = true
+			types = append(types, item.Type)
+		}
+	}
+	return types
+}
+
+// Read returns the file contents of the item, checking its checksum
+func (p *Pack) Read(item PackItem) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(p.dir, item.File))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", item.File, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != item.Checksum {
+		return nil, fmt.Errorf("checksum mismatch for %s", item.File)
+	}
+
+	return data, nil
+}
+
+// match returns the items of the type usable for the difficulty and language;
+// an empty difficulty matches any
+func (p *Pack) match(typ string, difficulty Difficulty, languageCode string) []PackItem {
+	lang := poolLanguage(languageCode)
+
+	var items []PackItem
+	for _, item := range p.items {
+		if item.Type != typ {
+			continue
+		}
+		if difficulty != "" && item.Difficulty != difficulty {
+			continue
+		}
+		if item.Locale != "" && item.Locale != lang {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// PackWriter writes challenges into a new asset pack
+type PackWriter struct {
+	dir          string
+	manifestPath string
+	manifest     Manifest
+}
+
+// NewPackWriter creates the pack directory; an existing pack is never overwritten
+func NewPackWriter(dir string) (*PackWriter, error) {
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+		return nil, fmt.Errorf("pack already exists in %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	return &PackWriter{
+		dir:          dir,
+		manifestPath: filepath.Join(dir, ManifestFile),
+		manifest:     Manifest{Version: packVersion},
+	}, nil
+}
+
+// SetManifestPath writes the manifest to the path instead of the pack directory
+func (w *PackWriter) SetManifestPath(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("manifest %s already exists", path)
+	}
+	w.manifestPath = path
+	return nil
+}
+
+// Add stores the challenge media under a random name and records it in the manifest.
+// Only challenges answered with text can be packed.
+func (w *PackWriter) Add(challenge *Challenge, difficulty Difficulty, locale string) (PackItem, error) {
+	if challenge.Media == nil || challenge.Modality != ModalityText || len(challenge.Buttons) > 0 {
+		return PackItem{}, fmt.Errorf("%s challenges cannot be packed", challenge.Type)
+	}
+
+	ext, ok := packExtensions[challenge.Media.Kind]
+	if !ok {
+		return PackItem{}, fmt.Errorf("unsupported media kind %q", challenge.Media.Kind)
+	}
+
+	name, err := randomHex(packFileBytes)
+	if err != nil {
+		return PackItem{}, fmt.Errorf("failed to generate file name: %w", err)
+	}
+
+	answer, err := HashAnswer(challenge.Answer)
+	if err != nil {
+		return PackItem{}, err
+	}
+
+	sum := sha256.Sum256(challenge.Media.Data)
+	item := PackItem{
+		File:       name + ext,
+		Type:       challenge.Type,
+		Media:      challenge.Media.Kind,
+		Difficulty: difficulty,
+		Locale:     locale,
+		PromptID:   challenge.PromptID,
+		Answer:     answer,
+		Checksum:   hex.EncodeToString(sum[:]),
+	}
+	if err := item.validate(); err != nil {
+		return PackItem{}, err
+	}
+
+	if err := os.WriteFile(filepath.Join(w.dir, item.File), challenge.Media.Data, 0644); err != nil {
+		return PackItem{}, fmt.Errorf("failed to write %s: %w", item.File, err)
+	}
+
+	w.manifest.Items = append(w.manifest.Items, item)
+	return item, nil
+}
+
+// Close writes the manifest
+func (w *PackWriter) Close() error {
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := os.WriteFile(w.manifestPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// HashAnswer returns a salted SHA-256 hash of the answer in the form
+// "sha256:<salt>:<hash>". Answers are compared ignoring case and whitespace.
+// Short answers can still be brute-forced, so manifests are not meant to be public.
+func HashAnswer(answer string) (string, error) {
+	salt := make([]byte, answerSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return hashedAnswerPrefix + hex.EncodeToString(salt) + ":" + hashAnswer(salt, answer), nil
+}
+
+// IsHashedAnswer reports whether the expected answer was produced by HashAnswer
+func IsHashedAnswer(expected string) bool {
+	_, _, err := parseHashedAnswer(expected)
+	return err == nil
+}
+
+// VerifyHashedAnswer checks the answer against a hash produced by HashAnswer
+func VerifyHashedAnswer(expected, answer string) bool {
+	salt, hash, err := parseHashedAnswer(expected)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashAnswer(salt, answer)), []byte(hash)) == 1
+}
+
+func parseHashedAnswer(expected string) ([]byte, string, error) {
+	rest, ok := strings.CutPrefix(expected, hashedAnswerPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("missing %q prefix", hashedAnswerPrefix)
+	}
+
+	saltHex, hash, ok := strings.Cut(rest, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("missing hash")
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil || len(salt) != answerSaltBytes {
+		return nil, "", fmt.Errorf("invalid salt")
+	}
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+		return nil, "", fmt.Errorf("invalid hash")
+	}
+
+	return salt, hash, nil
+}
+
+func hashAnswer(salt []byte, answer string) string {
+	// Upper case keeps hashes of packs written before normalization valid.
+	// The sign tells math answers apart, text answers never start with one.
+	canonical := strings.ToUpper(NormalizeAnswer(answer, NormalizeOptions{FoldCase: true, KeepSign: true}))
+	sum := sha256.Sum256(append(append([]byte(nil), salt...), canonical...))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// PackProvider serves challenges of the wrapped provider's type from an asset
+// pack and falls back to the provider when the pack has none for the request
+type PackProvider struct {
+	provider ChallengeProvider
+	pack     *Pack
+}
+
+// NewPackProvider wraps the provider with the asset pack
+func NewPackProvider(provider ChallengeProvider, pack *Pack) *PackProvider {
+	return &PackProvider{provider: provider, pack: pack}
+}
+
+// Type returns the challenge type of the wrapped provider
+func (p *PackProvider) Type() string {
+	return p.provider.Type()
+}
+
+// NewChallenge picks a random pack item matching the request. Pack items
+// have no seed, requests replaying a seed are passed to the wrapped provider.
+func (p *PackProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	items := p.pack.match(p.Type(), req.Difficulty, req.LanguageCode)
+	if len(items) == 0 || req.Seed != 0 {
+		return p.provider.NewChallenge(ctx, req)
+	}
+
+	n, err := randomInt(len(items))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick pack item: %w", err)
+	}
+	item := items[n]
+
+	data, err := p.pack.Read(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Challenge{
+		Type:     item.Type,
+		Modality: ModalityText,
+		Media: &Media{
+			Kind: item.Media,
+			Data: data,
+			// The opaque pack name is not sent either
+			Filename: "captcha" + packExtensions[item.Media],
+		},
+		PromptID: item.PromptID,
+		Answer:   item.Answer,
+	}, nil
+}
+
+// LanguageVariant returns the locale of the pack items served for the
+// request, or the variant of the wrapped provider when the pack has none
+func (p *PackProvider) LanguageVariant(req Request) string {
+	items := p.pack.match(p.Type(), req.Difficulty, req.LanguageCode)
+	if len(items) == 0 {
+		return languageVariant(p.provider, req)
+	}
+	for _, item := range items {
+		if item.Locale != "" {
+			return item.Locale
+		}
+	}
+	return ""
+}
+
+// Verify checks hashed pack answers and delegates others to the wrapped provider
+func (p *PackProvider) Verify(expected, answer string) bool {
+	if IsHashedAnswer(expected) {
+		return VerifyHashedAnswer(expected, answer)
+	}
+	return verifyAnswer(p.provider, expected, answer)
+}
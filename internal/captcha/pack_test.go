@@ -0,0 +1,157 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashAnswer(t *testing.T) {
+	hashed, err := HashAnswer("aB12")
+	if err != nil {
+		t.Fatalf("Failed to hash answer: %v", err)
+	}
+
+	if strings.Contains(hashed, "aB12") || !IsHashedAnswer(hashed) {
+		t.Fatalf("Unexpected hashed answer %q", hashed)
+	}
+
+	for _, answer := range []string{"aB12", "AB12", " ab 12 "} {
+		if !VerifyHashedAnswer(hashed, answer) {
+			t.Errorf("Answer %q was rejected", answer)
+		}
+	}
+	if VerifyHashedAnswer(hashed, "AB13") {
+		t.Error("Wrong answer was accepted")
+	}
+
+	again, _ := HashAnswer("aB12")
+	if again == hashed {
+		t.Error("Hashes of the same answer must use different salts")
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	service := NewService("")
+
+	writer, err := NewPackWriter(dir)
+	if err != nil {
+		t.Fatalf("Failed to create pack writer: %v", err)
+	}
+
+	answers := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		challenge, err := service.NewChallenge(context.Background(), Request{})
+		if err != nil {
+			t.Fatalf("Failed to generate challenge: %v", err)
+		}
+		item, err := writer.Add(challenge, DifficultyNormal, "")
+		if err != nil {
+			t.Fatalf("Failed to add challenge: %v", err)
+		}
+		if strings.Contains(item.File, challenge.Answer) {
+			t.Errorf("File name %s reveals the answer %s", item.File, challenge.Answer)
+		}
+		answers[item.Answer] = challenge.Answer
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	if _, err := NewPackWriter(dir); err == nil {
+		t.Error("Expected an existing pack not to be overwritten")
+	}
+
+	pack, err := LoadPack(dir)
+	if err != nil {
+		t.Fatalf("Failed to load pack: %v", err)
+	}
+
+	provider := NewPackProvider(service, pack)
+	challenge, err := provider.NewChallenge(context.Background(), Request{Difficulty: DifficultyNormal})
+	if err != nil {
+		t.Fatalf("Failed to get pack challenge: %v", err)
+	}
+	if challenge.Media.Filename != "captcha.png" {
+		t.Errorf("Pack file name %q is sent to users", challenge.Media.Filename)
+	}
+
+	plain, ok := answers[challenge.Answer]
+	if !ok {
+		t.Fatalf("Challenge answer %q is not from the pack", challenge.Answer)
+	}
+	if !provider.Verify(challenge.Answer, plain) {
+		t.Errorf("Correct answer %q was rejected", plain)
+	}
+
+	// Other difficulties are not in the pack and are generated
+	generated, err := provider.NewChallenge(context.Background(), Request{Difficulty: DifficultyHard})
+	if err != nil {
+		t.Fatalf("Failed to generate fallback challenge: %v", err)
+	}
+	if IsHashedAnswer(generated.Answer) || !provider.Verify(generated.Answer, generated.Answer) {
+		t.Errorf("Expected a generated challenge, got answer %q", generated.Answer)
+	}
+
+	// Answers of providers without a Verifier are normalized like the registry does
+	if !NewPackProvider(&countingProvider{}, pack).Verify("12", " １２ ") {
+		t.Error("Expected a full-width answer with spaces to be accepted")
+	}
+}
+
+func TestLoadPackValidation(t *testing.T) {
+	dir := t.TempDir()
+
+	writer, err := NewPackWriter(dir)
+	if err != nil {
+		t.Fatalf("Failed to create pack writer: %v", err)
+	}
+	challenge, err := NewService("").NewChallenge(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	item, err := writer.Add(challenge, DifficultyNormal, "")
+	if err != nil {
+		t.Fatalf("Failed to add challenge: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	writeManifest := func(item PackItem) {
+		data, _ := json.Marshal(Manifest{Version: packVersion, Items: []PackItem{item}})
+		if err := os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(item *PackItem)
+	}{
+		{"answer in file name", func(item *PackItem) { item.File = "1234.png" }},
+		{"plain answer", func(item *PackItem) { item.Answer = "1234" }},
+		{"unknown difficulty", func(item *PackItem) { item.Difficulty = "insane" }},
+		{"media mismatch", func(item *PackItem) { item.Media = MediaVoice }},
+		{"wrong checksum", func(item *PackItem) { item.Checksum = strings.Repeat("0", 64) }},
+	}
+
+	for _, tt := range tests {
+		modified := item
+		tt.modify(&modified)
+		writeManifest(modified)
+
+		if _, err := LoadPack(dir); err == nil {
+			t.Errorf("Expected pack with %s to be rejected", tt.name)
+		}
+	}
+
+	writeManifest(item)
+	if _, err := LoadPack(dir); err != nil {
+		t.Errorf("Failed to load valid pack: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolOptions configures a pool of pre-generated challenges
+type PoolOptions struct {
+	// Size is the number of ready challenges kept per difficulty and language
+	Size int
+	// Workers is the number of goroutines refilling the pool
+	Workers int
+	// StatsInterval is how often pool stats are logged, 0 disables logging
+	StatsInterval time.Duration
+}
+
+// DefaultPoolOptions returns options suitable for join raids of a few hundred accounts
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		Size:          50,
+		Workers:       2,
+		StatsInterval: 5 * time.Minute,
+	}
+}
+
+// Validate checks that the pool can hold and refill challenges
+func (o PoolOptions) Validate() error {
+	if o.Size < 1 {
+		return fmt.Errorf("pool size must be positive, got %d", o.Size)
+	}
+	if o.Workers < 1 {
+		return fmt.Errorf("at least one worker is required, got %d", o.Workers)
+	}
+	if o.StatsInterval < 0 {
+		return fmt.Errorf("stats interval must not be negative")
+	}
+	return nil
+}
+
+// poolRetryDelay is the pause after a failed generation before refilling again
+const poolRetryDelay = time.Second
+
+// poolKey identifies challenges that can be served to the same requests
+type poolKey struct {
+	difficulty Difficulty
+	// language is the variant of LanguageDependent providers, languages
+	// sharing an alphabet or samples share a bucket
+	language string
+}
+
+func (k poolKey) String() string {
+	difficulty, language := string(k.difficulty), k.language
+	if difficulty == "" {
+		difficulty = "default"
+	}
+	if language == "" {
+		language = "default"
+	}
+	return difficulty + "/" + language
+}
+
+// Pool keeps ready challenges of a provider and refills them in the background.
+// It is a ChallengeProvider itself and is registered in place of the wrapped one.
+// Challenges must not depend on the chat or user of the request.
+type Pool struct {
+	provider ChallengeProvider
+	opts     PoolOptions
+
+	mu      sync.Mutex
+	buckets map[poolKey]chan *Challenge
+	refill  chan poolKey
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	generated atomic.Int64
+	failures  atomic.Int64
+}
+
+// PoolStats is a snapshot of pool metrics
+type PoolStats struct {
+	Type     string
+	Capacity int
+	// Depth is the number of ready challenges per difficulty/language bucket
+	Depth map[string]int
+	// Hits are requests served from the pool, Misses fell back to synchronous generation
+	Hits      int64
+	Misses    int64
+	Generated int64
+	Failures  int64
+}
+
+// NewPool wraps the provider with a pool of pre-generated challenges
+func NewPool(provider ChallengeProvider, opts PoolOptions) (*Pool, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pool options: %w", err)
+	}
+
+	return &Pool{
+		provider: provider,
+		opts:     opts,
+		buckets:  make(map[poolKey]chan *Challenge),
+		refill:   make(chan poolKey, 64),
+	}, nil
+}
+
+// Type returns the challenge type of the wrapped provider
+func (p *Pool) Type() string {
+	return p.provider.Type()
+}
+
+// NewChallenge returns a ready challenge or generates one synchronously if
+// the pool is empty. Requests replaying a seed bypass the pool.
+func (p *Pool) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	if req.Seed != 0 {
+		return p.provider.NewChallenge(ctx, req)
+	}
+
+	key := poolKey{difficulty: req.Difficulty, language: languageVariant(p.provider, req)}
+	bucket := p.bucket(key)
+
+	select {
+	case challenge := <-bucket:
+		p.hits.Add(1)
+		p.requestRefill(key)
+		return challenge, nil
+	default:
+	}
+
+	p.misses.Add(1)
+	p.requestRefill(key)
+	return p.provider.NewChallenge(ctx, req)
+}
+
+// Verify delegates to the wrapped provider's Verifier, and checks answers
+// of providers without one like the registry does
+func (p *Pool) Verify(expected, answer string) bool {
+	return verifyAnswer(p.provider, expected, answer)
+}
+
+// Run refills the pool until the context is cancelled. The default bucket is
+// filled right away, other buckets are created on first use.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	p.bucket(poolKey{})
+	p.requestRefill(poolKey{})
+
+	if p.opts.StatsInterval > 0 {
+		ticker := time.NewTicker(p.opts.StatsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			case <-ticker.C:
+				p.logStats()
+			}
+		}
+	}
+
+	wg.Wait()
+}
+
+// Stats returns a snapshot of the pool metrics
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	depth := make(map[string]int, len(p.buckets))
+	for key, bucket := range p.buckets {
+		depth[key.String()] = len(bucket)
+	}
+	p.mu.Unlock()
+
+	return PoolStats{
+		Type:      p.Type(),
+		Capacity:  p.opts.Size,
+		Depth:     depth,
+		Hits:      p.hits.Load(),
+		Misses:    p.misses.Load(),
+		Generated: p.generated.Load(),
+		Failures:  p.failures.Load(),
+	}
+}
+
+func (p *Pool) logStats() {
+	stats := p.Stats()
+	log.Printf("Captcha pool %s: depth %v of %d, hits %d, misses %d, generated %d, failures %d",
+		stats.Type, stats.Depth, stats.Capacity, stats.Hits, stats.Misses, stats.Generated, stats.Failures)
+}
+
+// bucket returns the channel holding ready challenges for the key
+func (p *Pool) bucket(key poolKey) chan *Challenge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bucket, ok := p.buckets[key]
+	if !ok {
+		bucket = make(chan *Challenge, p.opts.Size)
+		p.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// requestRefill asks the workers to top up the bucket without blocking the caller
+func (p *Pool) requestRefill(key poolKey) {
+	select {
+	case p.refill <- key:
+	default:
+		// Workers are busy, the bucket is refilled with a later request
+	}
+}
+
+// work fills requested buckets up to their capacity
+func (p *Pool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-p.refill:
+			p.fill(ctx, key)
+		}
+	}
+}
+
+func (p *Pool) fill(ctx context.Context, key poolKey) {
+	bucket := p.bucket(key)
+	req := Request{Difficulty: key.difficulty, LanguageCode: key.language}
+
+	for len(bucket) < cap(bucket) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		challenge, err := p.provider.NewChallenge(ctx, req)
+		if errors.Is(err, ErrNoChallenge) {
+			return
+		}
+		if err != nil {
+			p.failures.Add(1)
+			log.Printf("Failed to pre-generate %s captcha for %s: %v", p.Type(), key, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(poolRetryDelay):
+			}
+			return
+		}
+		if challenge.Type == "" {
+			challenge.Type = p.Type()
+		}
+
+		select {
+		case bucket <- challenge:
+			p.generated.Add(1)
+		default:
+			// Filled concurrently by another worker
+			return
+		}
+	}
+}
+
+// languageVariant returns the language variant of the request for providers
+// that depend on it
+func languageVariant(provider ChallengeProvider, req Request) string {
+	if dependent, ok := provider.(LanguageDependent); ok {
+		return dependent.LanguageVariant(req)
+	}
+	return ""
+}
+
+// poolLanguage reduces a language code to its primary subtag, so "en-US" and "en" share a bucket
+func poolLanguage(languageCode string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	return lang
+}
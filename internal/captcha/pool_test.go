@@ -0,0 +1,131 @@
+package captcha
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	calls atomic.Int64
+}
+
+func (p *countingProvider) Type() string { return "counting" }
+
+func (p *countingProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	n := p.calls.Add(1)
+	return &Challenge{Modality: ModalityText, Answer: strconv.FormatInt(n, 10)}, nil
+}
+
+func TestPoolFallback(t *testing.T) {
+	provider := &countingProvider{}
+	pool, err := NewPool(provider, PoolOptions{Size: 3, Workers: 1})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+
+	// Without a running worker every request is generated synchronously
+	for i := 0; i < 5; i++ {
+		if _, err := pool.NewChallenge(context.Background(), Request{}); err != nil {
+			t.Fatalf("Failed to get challenge: %v", err)
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.Misses != 5 || stats.Hits != 0 {
+		t.Errorf("Expected 5 misses and no hits, got %d and %d", stats.Misses, stats.Hits)
+	}
+
+	// Answers of providers without a Verifier are normalized like the registry does
+	if !pool.Verify("12", " １２ ") {
+		t.Error("Expected the pool to accept a full-width answer with spaces")
+	}
+}
+
+func TestPoolRefill(t *testing.T) {
+	provider := &countingProvider{}
+	pool, err := NewPool(provider, PoolOptions{Size: 3, Workers: 2})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitForDepth(t, pool, "default/default", 3)
+
+	challenge, err := pool.NewChallenge(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Failed to get challenge: %v", err)
+	}
+	if challenge.Type != "counting" {
+		t.Errorf("Expected pooled challenge type to be set, got %q", challenge.Type)
+	}
+	if stats := pool.Stats(); stats.Hits != 1 {
+		t.Errorf("Expected a pool hit, got %d", stats.Hits)
+	}
+
+	// The taken challenge is replaced in the background
+	waitForDepth(t, pool, "default/default", 3)
+
+	// Challenges that do not depend on the language are shared
+	if _, err := pool.NewChallenge(context.Background(), Request{LanguageCode: "ru-RU"}); err != nil {
+		t.Fatalf("Failed to get challenge: %v", err)
+	}
+	if _, ok := pool.Stats().Depth["default/ru"]; ok {
+		t.Errorf("Expected no ru bucket for a provider ignoring the language")
+	}
+}
+
+func TestPoolLanguageBuckets(t *testing.T) {
+	service := NewService("")
+	opts := service.Options(DifficultyNormal)
+	opts.Localized = true
+	if err := service.SetProfile(DifficultyNormal, opts); err != nil {
+		t.Fatalf("Failed to set profile: %v", err)
+	}
+	pool, err := NewPool(service, PoolOptions{Size: 2, Workers: 1})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+
+	for _, lang := range []string{"ru-RU", "ru", "en", "fr", "de-DE"} {
+		if _, err := pool.NewChallenge(context.Background(), Request{Difficulty: DifficultyNormal, LanguageCode: lang}); err != nil {
+			t.Fatalf("Failed to get challenge: %v", err)
+		}
+	}
+
+	// Languages without an alphabet of their own share the Latin bucket
+	depth := pool.Stats().Depth
+	if len(depth) != 2 {
+		t.Errorf("Expected a ru and a Latin bucket, got %v", depth)
+	}
+	for _, bucket := range []string{"normal/ru", "normal/default"} {
+		if _, ok := depth[bucket]; !ok {
+			t.Errorf("Expected bucket %s, got %v", bucket, depth)
+		}
+	}
+}
+
+func waitForDepth(t *testing.T, pool *Pool, bucket string, depth int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if pool.Stats().Depth[bucket] == depth {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Bucket %s did not reach depth %d: %v", bucket, depth, pool.Stats().Depth)
+}
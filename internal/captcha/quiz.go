@@ -0,0 +1,213 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// TypeQuiz is the type of custom questions defined by chat administrators
+const TypeQuiz = "quiz"
+
+// Limits of quiz questions; choices must fit an inline keyboard button
+const (
+	quizMaxQuestion = 300
+	quizMaxChoice   = 64
+	quizMaxChoices  = 8
+	quizChoicesRow  = 2
+)
+
+// quizSeparator separates accepted answers and choices in the stored answer
+const quizSeparator = "\n"
+
+// QuizQuestion is a question with the answers accepted for it. With choices
+// the question is answered with buttons, otherwise with a text message.
+type QuizQuestion struct {
+	Question string
+	Answers  []string
+	Choices  []string
+}
+
+// Validate checks that the question can be asked and answered
+func (q QuizQuestion) Validate() error {
+	if strings.TrimSpace(q.Question) == "" {
+		return errors.New("question is empty")
+	}
+	if utf8.RuneCountInString(q.Question) > quizMaxQuestion {
+		return fmt.Errorf("question is longer than %d characters", quizMaxQuestion)
+	}
+	if len(q.Answers) == 0 {
+		return errors.New("no accepted answers")
+	}
+	for _, answer := range q.Answers {
+		if normalizeQuizAnswer(answer) == "" {
+			return errors.New("accepted answer is empty")
+		}
+	}
+
+	if len(q.Choices) == 0 {
+		return nil
+	}
+	if len(q.Choices) > quizMaxChoices {
+		return fmt.Errorf("more than %d choices", quizMaxChoices)
+	}
+	correct := 0
+	for _, choice := range q.Choices {
+		if normalizeQuizAnswer(choice) == "" {
+			return errors.New("choice is empty")
+		}
+		if utf8.RuneCountInString(choice) > quizMaxChoice {
+			return fmt.Errorf("choice is longer than %d characters", quizMaxChoice)
+		}
+		if q.accepts(choice) {
+			correct++
+		}
+	}
+	if correct == 0 {
+		return errors.New("none of the choices is an accepted answer")
+	}
+	if correct == len(q.Choices) {
+		return errors.New("every choice is an accepted answer")
+	}
+
+	return nil
+}
+
+// accepts reports whether the answer matches one of the accepted answers
+func (q QuizQuestion) accepts(answer string) bool {
+	answer = normalizeQuizAnswer(answer)
+	for _, accepted := range q.Answers {
+		if normalizeQuizAnswer(accepted) == answer {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseQuizQuestion parses "question | answer; answer | choice; choice",
+// where the part with choices is optional
+func ParseQuizQuestion(text string) (QuizQuestion, error) {
+	parts := strings.Split(text, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return QuizQuestion{}, errors.New("expected a question and answers separated by |")
+	}
+
+	q := QuizQuestion{
+		Question: strings.TrimSpace(parts[0]),
+		Answers:  splitQuizList(parts[1]),
+	}
+	if len(parts) == 3 {
+		q.Choices = splitQuizList(parts[2])
+	}
+
+	return q, q.Validate()
+}
+
+func splitQuizList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// normalizeQuizAnswer folds case, digits and whitespace, so "Go Lang" matches
+// "golang"; punctuation is kept, so "C++" does not match "C"
+func normalizeQuizAnswer(answer string) string {
+	return NormalizeAnswer(answer, NormalizeOptions{FoldCase: true, KeepPunctuation: true})
+}
+
+// QuizSource returns the questions defined for a chat
+type QuizSource func(ctx context.Context, chatID int64) ([]QuizQuestion, error)
+
+// QuizProvider asks one of the custom questions of the chat
+type QuizProvider struct {
+	source QuizSource
+}
+
+// NewQuizProvider creates a quiz challenge provider reading questions from the source
+func NewQuizProvider(source QuizSource) *QuizProvider {
+	return &QuizProvider{source: source}
+}
+
+// Type returns the challenge type produced by the provider
+func (p *QuizProvider) Type() string {
+	return TypeQuiz
+}
+
+// NewChallenge asks a random question of the chat. Chats without questions
+// get ErrNoChallenge, so another challenge type is used for them.
+func (p *QuizProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	questions, err := p.source(ctx, req.ChatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quiz questions: %w", err)
+	}
+	if len(questions) == 0 {
+		return nil, ErrNoChallenge
+	}
+
+	source, seed, err := challengeSource(cryptoSource{}, req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := source.Intn(len(questions))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick quiz question: %w", err)
+	}
+	q := questions[n]
+
+	challenge := &Challenge{
+		Type:       TypeQuiz,
+		Modality:   ModalityText,
+		PromptID:   "captcha_quiz_prompt",
+		PromptData: map[string]any{"Question": q.Question},
+		Answer:     strings.Join(q.Answers, quizSeparator),
+		Seed:       seed,
+	}
+	if len(q.Choices) == 0 {
+		return challenge, nil
+	}
+
+	// Buttons carry random tokens, the answer lists the tokens of all correct choices
+	choices, err := randomSample(source, q.Choices, len(q.Choices))
+	if err != nil {
+		return nil, fmt.Errorf("failed to shuffle quiz choices: %w", err)
+	}
+
+	challenge.Modality = ModalityButton
+	challenge.PromptID = "captcha_quiz_choice_prompt"
+
+	var correct []string
+	var row []Button
+	for _, choice := range choices {
+		token, err := randomToken(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate button token: %w", err)
+		}
+		if q.accepts(choice) {
+			correct = append(correct, token)
+		}
+
+		row = append(row, Button{Text: choice, Value: token})
+		if len(row) == quizChoicesRow {
+			challenge.Buttons = append(challenge.Buttons, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		challenge.Buttons = append(challenge.Buttons, row)
+	}
+	challenge.Answer = strings.Join(correct, quizSeparator)
+
+	return challenge, nil
+}
+
+// Verify accepts any of the answers, ignoring case and whitespace
+func (p *QuizProvider) Verify(expected, answer string) bool {
+	return QuizQuestion{Answers: strings.Split(expected, quizSeparator)}.accepts(answer)
+}
@@ -0,0 +1,109 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseQuizQuestion(t *testing.T) {
+	q, err := ParseQuizQuestion("What is this group about? | Go; golang | Go; Rust; Python")
+	if err != nil {
+		t.Fatalf("Failed to parse question: %v", err)
+	}
+
+	if q.Question != "What is this group about?" {
+		t.Errorf("Unexpected question %q", q.Question)
+	}
+	if len(q.Answers) != 2 || len(q.Choices) != 3 {
+		t.Errorf("Expected 2 answers and 3 choices, got %v and %v", q.Answers, q.Choices)
+	}
+
+	invalid := []string{
+		"no answers",
+		" | go",
+		"question |  ; ",
+		"question | go | rust; python",
+		"question | go; golang | go; golang",
+		"a | b | c | d",
+	}
+	for _, text := range invalid {
+		if _, err := ParseQuizQuestion(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestQuizTextChallenge(t *testing.T) {
+	provider := NewQuizProvider(func(ctx context.Context, chatID int64) ([]QuizQuestion, error) {
+		return []QuizQuestion{{Question: "Capital of France?", Answers: []string{"Paris", "Paris city"}}}, nil
+	})
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{ChatID: 1})
+	if err != nil {
+		t.Fatalf("Failed to generate quiz challenge: %v", err)
+	}
+	if challenge.Modality != ModalityText {
+		t.Errorf("Expected modality %q, got %q", ModalityText, challenge.Modality)
+	}
+
+	for _, answer := range []string{"paris", "  PARIS ", "Paris City", "pariscity"} {
+		if !provider.Verify(challenge.Answer, answer) {
+			t.Errorf("Expected %q to be accepted", answer)
+		}
+	}
+	for _, answer := range []string{"", "London", "Paris, France"} {
+		if provider.Verify(challenge.Answer, answer) {
+			t.Errorf("Expected %q to be rejected", answer)
+		}
+	}
+}
+
+func TestQuizChoiceChallenge(t *testing.T) {
+	provider := NewQuizProvider(func(ctx context.Context, chatID int64) ([]QuizQuestion, error) {
+		return []QuizQuestion{{
+			Question: "What is this group about?",
+			Answers:  []string{"Go", "golang"},
+			Choices:  []string{"Go", "Rust", "Python", "Golang"},
+		}}, nil
+	})
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{ChatID: 1})
+	if err != nil {
+		t.Fatalf("Failed to generate quiz challenge: %v", err)
+	}
+	if challenge.Modality != ModalityButton {
+		t.Errorf("Expected modality %q, got %q", ModalityButton, challenge.Modality)
+	}
+
+	correct := 0
+	for _, row := range challenge.Buttons {
+		for _, button := range row {
+			accepted := provider.Verify(challenge.Answer, button.Value)
+			if accepted != (button.Text == "Go" || button.Text == "Golang") {
+				t.Errorf("Button %q accepted: %v", button.Text, accepted)
+			}
+			if accepted {
+				correct++
+			}
+		}
+	}
+	if correct != 2 {
+		t.Errorf("Expected 2 correct buttons, got %d", correct)
+	}
+
+	// Typing the answer does not solve a multiple-choice question
+	if provider.Verify(challenge.Answer, "go") {
+		t.Error("Expected the typed answer to be rejected")
+	}
+}
+
+func TestQuizWithoutQuestions(t *testing.T) {
+	provider := NewQuizProvider(func(ctx context.Context, chatID int64) ([]QuizQuestion, error) {
+		return nil, nil
+	})
+
+	if _, err := provider.NewChallenge(context.Background(), Request{ChatID: 1}); !errors.Is(err, ErrNoChallenge) {
+		t.Errorf("Expected ErrNoChallenge, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Registry keeps the available challenge providers by type
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]ChallengeProvider
+	order     []string
+	defaults  []string
+}
+
+// NewRegistry creates a new registry with the given providers
+func NewRegistry(providers ...ChallengeProvider) (*Registry, error) {
+	r := &Registry{
+		providers: make(map[string]ChallengeProvider),
+	}
+
+	for _, p := range providers {
+		if err := r.Register(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Register adds a provider to the registry
+func (r *Registry) Register(p ChallengeProvider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	typ := p.Type()
+	if _, exists := r.providers[typ]; exists {
+		return fmt.Errorf("challenge type %q is already registered", typ)
+	}
+
+	r.providers[typ] = p
+	r.order = append(r.order, typ)
+	return nil
+}
+
+// SetDefaults sets the types used when a chat has no types configured
+func (r *Registry) SetDefaults(types ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, typ := range types {
+		if _, ok := r.providers[typ]; !ok {
+			return fmt.Errorf("unknown challenge type %q", typ)
+		}
+	}
+
+	r.defaults = append([]string(nil), types...)
+	return nil
+}
+
+// Get returns the provider of the given type
+func (r *Registry) Get(typ string) (ChallengeProvider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[typ]
+	return p, ok
+}
+
+// Types returns all registered types in registration order
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// Generate creates a challenge of a random type among the given ones.
+// Unknown types are skipped; with no usable types the defaults are used.
+// Providers returning ErrNoChallenge are skipped in favour of the others,
+// and when none of the given types has a challenge the defaults are tried.
+func (r *Registry) Generate(ctx context.Context, req Request, types ...string) (*Challenge, error) {
+	challenge, err := r.generate(ctx, req, r.candidates(types))
+	if errors.Is(err, ErrNoChallenge) && len(types) > 0 {
+		return r.generate(ctx, req, r.candidates(nil))
+	}
+	return challenge, err
+}
+
+func (r *Registry) generate(ctx context.Context, req Request, candidates []ChallengeProvider) (*Challenge, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no challenge providers registered")
+	}
+
+	for len(candidates) > 0 {
+		n, err := randomInt(len(candidates))
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick challenge type: %w", err)
+		}
+
+		p := candidates[n]
+		challenge, err := p.NewChallenge(ctx, req)
+		if errors.Is(err, ErrNoChallenge) {
+			candidates = append(candidates[:n], candidates[n+1:]...)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s challenge: %w", p.Type(), err)
+		}
+
+		challenge.Type = p.Type()
+		return challenge, nil
+	}
+
+	return nil, ErrNoChallenge
+}
+
+// Verify checks the answer against the expected one using the provider of the given type
+func (r *Registry) Verify(typ, expected, answer string) bool {
+	p, _ := r.Get(typ)
+	return verifyAnswer(p, expected, answer)
+}
+
+// verifyAnswer checks the answer with the Verifier of the provider, or
+// compares both normalized when it has none
+func verifyAnswer(p ChallengeProvider, expected, answer string) bool {
+	if v, ok := p.(Verifier); ok {
+		return v.Verify(expected, answer)
+	}
+	return NormalizeAnswer(answer, NormalizeOptions{}) == NormalizeAnswer(expected, NormalizeOptions{})
+}
+
+func (r *Registry) candidates(types []string) []ChallengeProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pick := func(types []string) []ChallengeProvider {
+		var out []ChallengeProvider
+		for _, typ := range types {
+			if p, ok := r.providers[typ]; ok {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+
+	if out := pick(types); len(out) > 0 {
+		return out
+	}
+	if out := pick(r.defaults); len(out) > 0 {
+		return out
+	}
+	return pick(r.order)
+}
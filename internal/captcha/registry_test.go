@@ -0,0 +1,73 @@
+package captcha
+
+import (
+	"context"
+	"testing"
+)
+
+type emptyProvider struct{}
+
+func (emptyProvider) Type() string { return "empty" }
+
+func (emptyProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	return nil, ErrNoChallenge
+}
+
+func TestRegistryGenerate(t *testing.T) {
+	registry, err := NewRegistry(NewService("../../assets"), emptyProvider{})
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	// The empty provider never produces a challenge, so the digits one must be used
+	for i := 0; i < 10; i++ {
+		challenge, err := registry.Generate(context.Background(), Request{}, "empty", TypeDigits)
+		if err != nil {
+			t.Fatalf("Failed to generate challenge: %v", err)
+		}
+
+		if challenge.Type != TypeDigits {
+			t.Errorf("Expected challenge type %q, got %q", TypeDigits, challenge.Type)
+		}
+
+		if !registry.Verify(challenge.Type, challenge.Answer, challenge.Answer) {
+			t.Error("Expected the challenge answer to be accepted")
+		}
+	}
+}
+
+func TestRegistryDuplicateType(t *testing.T) {
+	_, err := NewRegistry(NewService(""), NewService(""))
+	if err == nil {
+		t.Error("Expected an error when registering the same type twice")
+	}
+}
+
+func TestRegistryOnlyUnavailable(t *testing.T) {
+	registry, err := NewRegistry(emptyProvider{})
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	if _, err := registry.Generate(context.Background(), Request{}); err != ErrNoChallenge {
+		t.Errorf("Expected ErrNoChallenge, got %v", err)
+	}
+}
+
+func TestRegistryFallsBackToDefaults(t *testing.T) {
+	registry, err := NewRegistry(NewService(""), emptyProvider{})
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	if err := registry.SetDefaults(TypeDigits); err != nil {
+		t.Fatalf("Failed to set defaults: %v", err)
+	}
+
+	challenge, err := registry.Generate(context.Background(), Request{}, "empty")
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	if challenge.Type != TypeDigits {
+		t.Errorf("Expected challenge type %q, got %q", TypeDigits, challenge.Type)
+	}
+}
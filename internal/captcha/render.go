@@ -0,0 +1,315 @@
+package captcha
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// Distortion amounts at the normal level, scaled by Options.Distortion
+const (
+	glyphPadding     = 20
+	glyphScaleSpread = 0.175
+	glyphMaxRotation = 25 * math.Pi / 180
+	glyphJitterY     = 6
+	waveAmplitude    = 3.0
+	waveLength       = 40.0
+	strikeLines      = 2
+	backgroundBlobs  = 6
+)
+
+var (
+	captchaFont     *opentype.Font
+	captchaFontErr  error
+	captchaFontOnce sync.Once
+)
+
+// loadFont parses the embedded Go Bold TrueType font once
+func loadFont() (*opentype.Font, error) {
+	captchaFontOnce.Do(func() {
+		captchaFont, captchaFontErr = opentype.Parse(gobold.TTF)
+	})
+	return captchaFont, captchaFontErr
+}
+
+// newFace creates a face of the embedded font with the given size in pixels
+func newFace(size int) (font.Face, error) {
+	f, err := loadFont()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(size),
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	return face, nil
+}
+
+// randomizer collects the first error of a series of random draws,
+// so rendering code does not have to check every call
+type randomizer struct {
+	source Source
+	err    error
+}
+
+func (r *randomizer) intn(n int) int {
+	if r.err != nil || n <= 0 {
+		return 0
+	}
+	v, err := r.source.Intn(n)
+	if err != nil {
+		r.err = err
+	}
+	return v
+}
+
+func (r *randomizer) float(min, max float64) float64 {
+	const precision = 1 << 20
+	return min + (max-min)*float64(r.intn(precision))/precision
+}
+
+// perm returns a random permutation of [0, n)
+func (r *randomizer) perm(n int) []int {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j := r.intn(i + 1)
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+func (r *randomizer) color(min, max int) color.RGBA {
+	return color.RGBA{
+		R: uint8(min + r.intn(max-min+1)),
+		G: uint8(min + r.intn(max-min+1)),
+		B: uint8(min + r.intn(max-min+1)),
+		A: 255,
+	}
+}
+
+// render draws the text with distorted glyphs and noise and encodes the image to PNG
+func (s *Service) render(text string, opts Options, source Source) ([]byte, error) {
+	img, err := s.drawText(text, opts, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// drawText renders the text on a colored background with per-glyph rotation
+// and scaling, overlapping glyphs and sine-wave warping
+func (s *Service) drawText(text string, opts Options, source Source) (*image.RGBA, error) {
+	// Glyph size follows the image height, neighbours overlap by a quarter
+	fontSize := opts.Height / 2
+	slotWidth := fontSize * 3 / 4
+	distortion := opts.Distortion
+
+	face, err := newFace(fontSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	glyphs := []rune(text)
+	rnd := &randomizer{source: source}
+
+	// Widen the image for texts that do not fit
+	width := opts.Width
+	if w := len(glyphs)*slotWidth + 2*glyphPadding; w > width {
+		width = w
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, opts.Height))
+	drawBackground(img, rnd)
+
+	// Add some noise lines
+	for i := 0; i < opts.NoiseLines; i++ {
+		s.drawNoiseLine(img, rnd)
+	}
+
+	// Center the glyphs horizontally
+	startX := (width - len(glyphs)*slotWidth) / 2
+	rotation := glyphMaxRotation * distortion
+	jitter := int(glyphJitterY * distortion)
+	textColors := make([]color.RGBA, 0, len(glyphs))
+	for i, glyph := range glyphs {
+		col := rnd.color(20, 110)
+		textColors = append(textColors, col)
+
+		center := image.Point{
+			X: startX + i*slotWidth + slotWidth/2,
+			Y: opts.Height/2 + rnd.intn(2*jitter+1) - jitter,
+		}
+		drawGlyph(img, face, fontSize, glyph, col, center,
+			rnd.float(-rotation, rotation),
+			rnd.float(1-glyphScaleSpread*distortion, 1+glyphScaleSpread*distortion))
+	}
+
+	img = warp(img, rnd, waveAmplitude*distortion)
+
+	// Strike lines in text colors make glyph segmentation harder
+	for i := 0; i < int(math.Round(strikeLines*distortion)) && len(textColors) > 0; i++ {
+		drawWave(img, rnd, textColors[rnd.intn(len(textColors))])
+	}
+
+	// Add more noise dots
+	for i := 0; i < opts.NoiseDots; i++ {
+		s.drawNoiseDot(img, rnd)
+	}
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	return img, nil
+}
+
+// drawBackground fills the image with a horizontal gradient of two light
+// colors and a few translucent blobs
+func drawBackground(img *image.RGBA, rnd *randomizer) {
+	bounds := img.Bounds()
+	from, to := rnd.color(190, 250), rnd.color(190, 250)
+
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		t := float64(x-bounds.Min.X) / float64(bounds.Dx())
+		col := color.RGBA{
+			R: uint8(float64(from.R) + t*(float64(to.R)-float64(from.R))),
+			G: uint8(float64(from.G) + t*(float64(to.G)-float64(from.G))),
+			B: uint8(float64(from.B) + t*(float64(to.B)-float64(from.B))),
+			A: 255,
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			img.SetRGBA(x, y, col)
+		}
+	}
+
+	for i := 0; i < backgroundBlobs; i++ {
+		base := rnd.color(150, 240)
+		col := color.NRGBA{R: base.R, G: base.G, B: base.B, A: 70}
+		cx, cy := bounds.Min.X+rnd.intn(bounds.Dx()), bounds.Min.Y+rnd.intn(bounds.Dy())
+		r := 8 + rnd.intn(20)
+		fillCircle(img, cx, cy, r, col)
+	}
+}
+
+// fillCircle blends a filled circle into the image
+func fillCircle(img *image.RGBA, cx, cy, r int, col color.NRGBA) {
+	src := image.NewUniform(col)
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) > r*r {
+				continue
+			}
+			draw.Draw(img, image.Rect(x, y, x+1, y+1), src, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// drawGlyph renders a single glyph, rotated and scaled around its center
+func drawGlyph(img *image.RGBA, face font.Face, fontSize int, glyph rune, col color.RGBA, center image.Point, angle, scale float64) {
+	metrics := face.Metrics()
+	advance, ok := face.GlyphAdvance(glyph)
+	if !ok {
+		advance = fixed.I(fontSize / 2)
+	}
+
+	// Render the glyph unrotated into its own canvas
+	size := 2 * fontSize
+	glyphImg := image.NewRGBA(image.Rect(0, 0, size, size))
+	baseline := (size + (metrics.Ascent - metrics.Descent).Ceil()) / 2
+	d := &font.Drawer{
+		Dst:  glyphImg,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.I(size/2) - advance/2, Y: fixed.I(baseline)},
+	}
+	d.DrawString(string(glyph))
+
+	// Map the glyph canvas center onto the target point
+	cx, cy := float64(size)/2, float64(size)/2
+	sin, cos := math.Sincos(angle)
+	a, b := scale*cos, -scale*sin
+	c, e := scale*sin, scale*cos
+	transform := f64.Aff3{
+		a, b, float64(center.X) - a*cx - b*cy,
+		c, e, float64(center.Y) - c*cx - e*cy,
+	}
+
+	xdraw.BiLinear.Transform(img, transform, glyphImg, glyphImg.Bounds(), xdraw.Over, nil)
+}
+
+// warp shifts rows and columns along sine waves with random phases
+func warp(src *image.RGBA, rnd *randomizer, amplitude float64) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+
+	phaseX := rnd.float(0, 2*math.Pi)
+	phaseY := rnd.float(0, 2*math.Pi)
+	lengthX := rnd.float(waveLength*0.75, waveLength*1.25)
+	lengthY := rnd.float(waveLength*0.75, waveLength*1.25)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sx := x + int(amplitude*math.Sin(2*math.Pi*float64(y)/lengthY+phaseY))
+			sy := y + int(amplitude*math.Sin(2*math.Pi*float64(x)/lengthX+phaseX))
+			sx = clamp(sx, bounds.Min.X, bounds.Max.X-1)
+			sy = clamp(sy, bounds.Min.Y, bounds.Max.Y-1)
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+
+	return dst
+}
+
+// drawWave draws a thin sine curve across the whole image
+func drawWave(img *image.RGBA, rnd *randomizer, col color.RGBA) {
+	bounds := img.Bounds()
+	amplitude := rnd.float(4, float64(bounds.Dy())/4)
+	length := rnd.float(float64(bounds.Dx())/3, float64(bounds.Dx()))
+	phase := rnd.float(0, 2*math.Pi)
+	baseY := float64(bounds.Dy())/3 + rnd.float(0, float64(bounds.Dy())/3)
+
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		y := int(baseY + amplitude*math.Sin(2*math.Pi*float64(x)/length+phase))
+		if y >= bounds.Min.Y && y+1 < bounds.Max.Y {
+			img.SetRGBA(x, y, col)
+			img.SetRGBA(x, y+1, col)
+		}
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
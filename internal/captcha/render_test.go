@@ -0,0 +1,41 @@
+package captcha
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	service := NewService("")
+	opts := DefaultOptions()
+
+	tests := []struct {
+		text     string
+		minWidth int
+	}{
+		{"1234", opts.Width},
+		{"7+5×2=?", opts.Width},
+		{"0123456789", 10 * opts.Height / 2 * 3 / 4},
+	}
+
+	for _, tt := range tests {
+		data, err := service.render(tt.text, opts, NewSeededSource(1))
+		if err != nil {
+			t.Fatalf("Failed to render %q: %v", tt.text, err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to decode rendered %q: %v", tt.text, err)
+		}
+
+		bounds := img.Bounds()
+		if bounds.Dx() < tt.minWidth {
+			t.Errorf("Image for %q is %d px wide, want at least %d", tt.text, bounds.Dx(), tt.minWidth)
+		}
+		if bounds.Dy() != opts.Height {
+			t.Errorf("Image for %q is %d px high, want %d", tt.text, bounds.Dy(), opts.Height)
+		}
+	}
+}
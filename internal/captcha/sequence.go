@@ -0,0 +1,276 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// TypeSequence is the type of "tap the emoji in order" challenges
+const TypeSequence = "sequence"
+
+// Sequence layout in pixels and keyboard size
+const (
+	sequenceMinLength = 3
+	sequenceMaxLength = 5
+	sequenceButtons   = 8
+	sequenceRow       = 4
+	sequenceCellSize  = 76
+	sequenceHeight    = 100
+)
+
+// sequenceSeparator separates the tokens of the pressed buttons
+const sequenceSeparator = ","
+
+// sequenceEmoji is an emoji drawn with the shape primitives, so the image
+// needs no emoji font
+type sequenceEmoji struct {
+	emoji string
+	shape string
+	col   color.RGBA
+}
+
+var (
+	emojiRed    = color.RGBA{R: 221, G: 46, B: 68, A: 255}
+	emojiOrange = color.RGBA{R: 244, G: 144, B: 12, A: 255}
+	emojiYellow = color.RGBA{R: 253, G: 203, B: 88, A: 255}
+	emojiGreen  = color.RGBA{R: 120, G: 177, B: 89, A: 255}
+	emojiBlue   = color.RGBA{R: 85, G: 172, B: 238, A: 255}
+	emojiPurple = color.RGBA{R: 170, G: 142, B: 214, A: 255}
+)
+
+// sequenceEmojiSet are emoji whose look is a plain colored shape
+var sequenceEmojiSet = []sequenceEmoji{
+	{"🔴", "circle", emojiRed},
+	{"🟠", "circle", emojiOrange},
+	{"🟡", "circle", emojiYellow},
+	{"🟢", "circle", emojiGreen},
+	{"🔵", "circle", emojiBlue},
+	{"🟣", "circle", emojiPurple},
+	{"🟥", "square", emojiRed},
+	{"🟧", "square", emojiOrange},
+	{"🟨", "square", emojiYellow},
+	{"🟩", "square", emojiGreen},
+	{"🟦", "square", emojiBlue},
+	{"🟪", "square", emojiPurple},
+	{"🔺", "triangle", emojiRed},
+	{"⭐", "star", emojiYellow},
+	{"🔶", "diamond", emojiOrange},
+	{"🔷", "diamond", emojiBlue},
+}
+
+// SequenceProvider produces challenges showing emoji in an image that are
+// tapped in the same order on a keyboard with decoys
+type SequenceProvider struct {
+	service *Service
+}
+
+// NewSequenceProvider creates an emoji sequence provider rendering with the service
+func NewSequenceProvider(service *Service) *SequenceProvider {
+	return &SequenceProvider{service: service}
+}
+
+// Type returns the challenge type produced by the provider
+func (p *SequenceProvider) Type() string {
+	return TypeSequence
+}
+
+// NewChallenge creates a sequence challenge. The first emoji of a random
+// permutation form the sequence and the rest are decoys; every button
+// carries a random token, so callback data does not reveal the answer.
+// Tokens are not derived from the seed, a replay only has the same image.
+func (p *SequenceProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	opts := p.service.Options(req.Difficulty)
+
+	source, seed, err := p.service.challengeSource(req.Seed)
+	if err != nil {
+		return nil, err
+	}
+	rnd := &randomizer{source: source}
+
+	length := clamp(opts.Length, sequenceMinLength, sequenceMaxLength)
+	perm := rnd.perm(len(sequenceEmojiSet))
+	sequence := make([]sequenceEmoji, length)
+	for i := range sequence {
+		sequence[i] = sequenceEmojiSet[perm[i]]
+	}
+
+	data, err := p.service.renderSequence(sequence, opts, rnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shuffle the sequence and the decoys on the keyboard
+	keyboard := perm[:sequenceButtons]
+	tokens := make(map[int]string, len(keyboard))
+	challenge := &Challenge{
+		Type:     TypeSequence,
+		Modality: ModalitySequence,
+		Media: &Media{
+			Kind:     MediaPhoto,
+			Data:     data,
+			Filename: "captcha.png",
+		},
+		PromptID: "captcha_sequence_prompt",
+		Seed:     seed,
+	}
+
+	var row []Button
+	for _, i := range rnd.perm(len(keyboard)) {
+		token, err := randomToken(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate button token: %w", err)
+		}
+		tokens[i] = token
+
+		row = append(row, Button{Text: sequenceEmojiSet[keyboard[i]].emoji, Value: token})
+		if len(row) == sequenceRow {
+			challenge.Buttons = append(challenge.Buttons, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		challenge.Buttons = append(challenge.Buttons, row)
+	}
+	challenge.Buttons = append(challenge.Buttons, []Button{{TextID: "captcha_sequence_undo", Value: SequenceUndoValue}})
+
+	answer := make([]string, length)
+	for i := range answer {
+		answer[i] = tokens[i]
+	}
+	challenge.Answer = strings.Join(answer, sequenceSeparator)
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	return challenge, nil
+}
+
+// Verify requires the buttons to be pressed exactly in the expected order
+func (p *SequenceProvider) Verify(expected, answer string) bool {
+	return answer == expected
+}
+
+// PressSequenceButton adds the button to the pressed ones, or removes the
+// last one for the undo button. It reports false for unknown and already
+// pressed buttons.
+func PressSequenceButton(buttons [][]Button, selection, value string) (string, bool) {
+	pressed := splitSequence(selection)
+
+	if value == SequenceUndoValue {
+		if len(pressed) == 0 {
+			return selection, false
+		}
+		return strings.Join(pressed[:len(pressed)-1], sequenceSeparator), true
+	}
+
+	if sequencePosition(pressed, value) > 0 || !hasButton(buttons, value) {
+		return selection, false
+	}
+	return strings.Join(append(pressed, value), sequenceSeparator), true
+}
+
+// SequenceComplete reports whether as many buttons were pressed as the answer has
+func SequenceComplete(answer, selection string) bool {
+	return len(splitSequence(selection)) >= len(splitSequence(answer))
+}
+
+// SequenceButtons returns the buttons with the position of each pressed button
+func SequenceButtons(buttons [][]Button, selection string) [][]Button {
+	pressed := splitSequence(selection)
+
+	marked := make([][]Button, len(buttons))
+	for i, row := range buttons {
+		marked[i] = append([]Button(nil), row...)
+		for j, button := range marked[i] {
+			if n := sequencePosition(pressed, button.Value); n > 0 {
+				marked[i][j].Text = button.Text + " " + strconv.Itoa(n)
+			}
+		}
+	}
+	return marked
+}
+
+func splitSequence(selection string) []string {
+	if selection == "" {
+		return nil
+	}
+	return strings.Split(selection, sequenceSeparator)
+}
+
+// sequencePosition returns the 1-based position of the value among the pressed ones or 0
+func sequencePosition(pressed []string, value string) int {
+	for i, v := range pressed {
+		if v == value {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func hasButton(buttons [][]Button, value string) bool {
+	for _, row := range buttons {
+		for _, button := range row {
+			if button.Value == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderSequence draws the emoji from left to right and encodes the image to PNG
+func (s *Service) renderSequence(sequence []sequenceEmoji, opts Options, rnd *randomizer) ([]byte, error) {
+	width := len(sequence)*sequenceCellSize + 2*glyphPadding
+	img := image.NewRGBA(image.Rect(0, 0, width, sequenceHeight))
+	drawBackground(img, rnd)
+
+	for i := 0; i < opts.NoiseLines; i++ {
+		s.drawNoiseLine(img, rnd)
+	}
+
+	distortion := opts.Distortion
+	jitter := int(glyphJitterY * distortion)
+	for i, e := range sequence {
+		x := glyphPadding + i*sequenceCellSize
+		cell := img.SubImage(image.Rect(x, 0, x+sequenceCellSize, sequenceHeight)).(*image.RGBA)
+
+		// Shades vary a little, but must stay close enough to tell the colors apart
+		col := e.col
+		col.R = uint8(clamp(int(col.R)+rnd.intn(31)-15, 0, 255))
+		col.G = uint8(clamp(int(col.G)+rnd.intn(31)-15, 0, 255))
+		col.B = uint8(clamp(int(col.B)+rnd.intn(31)-15, 0, 255))
+
+		// Shapes only tilt a little, a rotated square could pass for a diamond
+		drawShape(cell, gridShape{
+			name:  e.shape,
+			angle: rnd.float(-glyphMaxRotation, glyphMaxRotation) * distortion / 2,
+			scale: rnd.float(0.8, 1),
+			offset: image.Point{
+				X: rnd.intn(2*jitter+1) - jitter,
+				Y: rnd.intn(2*jitter+1) - jitter,
+			},
+			col: col,
+		})
+	}
+
+	for i := 0; i < opts.NoiseDots; i++ {
+		s.drawNoiseDot(img, rnd)
+	}
+
+	if rnd.err != nil {
+		return nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
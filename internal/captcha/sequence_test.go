@@ -0,0 +1,87 @@
+package captcha
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSequenceChallenge(t *testing.T) {
+	provider := NewSequenceProvider(NewService(""))
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("Failed to generate sequence challenge: %v", err)
+	}
+
+	if challenge.Modality != ModalitySequence {
+		t.Errorf("Expected modality %q, got %q", ModalitySequence, challenge.Modality)
+	}
+
+	answer := strings.Split(challenge.Answer, sequenceSeparator)
+	if len(answer) != DefaultOptions().Length {
+		t.Errorf("Expected a sequence of %d emoji, got %d", DefaultOptions().Length, len(answer))
+	}
+
+	emoji := make(map[string]bool)
+	for _, row := range challenge.Buttons {
+		for _, button := range row {
+			if button.Value != SequenceUndoValue {
+				emoji[button.Text] = true
+			}
+		}
+	}
+	if len(emoji) != sequenceButtons {
+		t.Errorf("Expected %d distinct emoji buttons, got %d", sequenceButtons, len(emoji))
+	}
+
+	// Pressing the buttons in order solves the challenge, undo removes the last press
+	selection := ""
+	for i, token := range answer {
+		var ok bool
+		if selection, ok = PressSequenceButton(challenge.Buttons, selection, token); !ok {
+			t.Fatalf("Button %d was not pressed", i)
+		}
+		if SequenceComplete(challenge.Answer, selection) != (i == len(answer)-1) {
+			t.Errorf("Unexpected completion after %d buttons", i+1)
+		}
+	}
+	if !provider.Verify(challenge.Answer, selection) {
+		t.Errorf("Selection %q does not solve %q", selection, challenge.Answer)
+	}
+
+	undone, _ := PressSequenceButton(challenge.Buttons, selection, SequenceUndoValue)
+	if undone != strings.Join(answer[:len(answer)-1], sequenceSeparator) {
+		t.Errorf("Undo left %q", undone)
+	}
+
+	if provider.Verify(challenge.Answer, strings.Join(append(answer[1:], answer[0]), sequenceSeparator)) {
+		t.Error("Expected the sequence in another order to be rejected")
+	}
+}
+
+func TestPressSequenceButton(t *testing.T) {
+	buttons := [][]Button{{{Text: "🔴", Value: "aa"}, {Text: "🟦", Value: "bb"}}}
+
+	selection, ok := PressSequenceButton(buttons, "", "bb")
+	if !ok || selection != "bb" {
+		t.Fatalf("Expected selection \"bb\", got %q", selection)
+	}
+
+	for _, value := range []string{"bb", "cc", "audio"} {
+		if _, ok := PressSequenceButton(buttons, selection, value); ok {
+			t.Errorf("Value %q was pressed", value)
+		}
+	}
+	if _, ok := PressSequenceButton(buttons, "", SequenceUndoValue); ok {
+		t.Error("Undo with nothing pressed was accepted")
+	}
+
+	marked := SequenceButtons(buttons, "bb,aa")
+	if marked[0][0].Text != "🔴 2" || marked[0][1].Text != "🟦 1" {
+		t.Errorf("Unexpected marked buttons %v", marked)
+	}
+	if buttons[0][1].Text != "🟦" {
+		t.Error("Marking changed the original buttons")
+	}
+}
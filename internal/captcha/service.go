@@ -1,171 +1,274 @@
 package captcha
 
 import (
-	"bytes"
-	"crypto/rand"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/png"
+	"io/fs"
 	"math"
-	"math/big"
-	"os"
 	"path/filepath"
-)
-
-const (
-	captchaWidth    = 200
-	captchaHeight   = 80
-	digitCount      = 4
-	noiseLinesCount = 10
-	noiseDotsCount  = 100
+	"sync"
 )
 
 // CaptchaImage represents a captcha image with its answer
 type CaptchaImage struct {
 	Image  []byte
 	Answer string
+
+	// Seed regenerates the same image with GenerateWithSeed
+	Seed uint64
 }
 
 // Service handles captcha generation
 type Service struct {
 	assetsDir string
+
+	mu         sync.RWMutex
+	profiles   map[Difficulty]Options
+	difficulty Difficulty
+	source     Source
+	// languages with a localized alphabet, nil allows all of them
+	languages map[string]bool
 }
 
 // NewService creates a new captcha service
 func NewService(assetsDir string) *Service {
-	return &Service{assetsDir: assetsDir}
+	profiles := make(map[Difficulty]Options, len(Difficulties))
+	for _, d := range Difficulties {
+		opts, _ := ProfileOptions(d)
+		profiles[d] = opts
+	}
+
+	return &Service{
+		assetsDir:  assetsDir,
+		profiles:   profiles,
+		difficulty: DifficultyNormal,
+		source:     cryptoSource{},
+	}
 }
 
-// Generate creates a new captcha image
-func (s *Service) Generate() (*CaptchaImage, error) {
-	// Generate random digits
-	answer := ""
-	for i := 0; i < digitCount; i++ {
-		n, err := rand.Int(rand.Reader, big.NewInt(10))
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate random digit: %w", err)
-		}
-		answer += n.String()
+// SetSource replaces the source the seeds of challenges are drawn from,
+// e.g. with NewSeededSource for reproducible offline generation
+func (s *Service) SetSource(source Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source = source
+}
+
+// randomSource returns the current source of randomness
+func (s *Service) randomSource() Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.source
+}
+
+// challengeSource returns the source of a single challenge, drawing a new
+// seed from the service source when seed is zero
+func (s *Service) challengeSource(seed uint64) (Source, uint64, error) {
+	return challengeSource(s.randomSource(), seed)
+}
+
+// SetLanguages limits localized alphabets to the languages the bot speaks,
+// members of other languages get Latin letters
+func (s *Service) SetLanguages(languages []string) {
+	enabled := make(map[string]bool, len(languages))
+	for _, lang := range languages {
+		enabled[poolLanguage(lang)] = true
 	}
 
-	// Create image
-	img := image.NewRGBA(image.Rect(0, 0, captchaWidth, captchaHeight))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.languages = enabled
+}
 
-	// Fill background with light gray
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{240, 240, 240, 255}}, image.Point{}, draw.Src)
+// alphabet returns the letters of challenges for members of the language
+func (s *Service) alphabet(opts Options, languageCode string) LocaleAlphabet {
+	if !opts.Localized {
+		return LatinAlphabet
+	}
 
-	// Add some noise lines
-	for i := 0; i < noiseLinesCount; i++ {
-		s.drawNoiseLine(img)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.languages != nil && !s.languages[poolLanguage(languageCode)] {
+		return LatinAlphabet
 	}
+	return localeAlphabet(languageCode)
+}
 
-	// Draw digits
-	digitWidth := captchaWidth / digitCount
-	for i, digit := range answer {
-		x := i*digitWidth + digitWidth/4
-		y := captchaHeight / 2
-		s.drawDigit(img, digit, x, y)
+// LanguageVariant returns the language whose alphabet challenges for the
+// request use, "" for the Latin alphabet
+func (s *Service) LanguageVariant(req Request) string {
+	lang := poolLanguage(req.LanguageCode)
+	if s.alphabet(s.Options(req.Difficulty), lang) == LatinAlphabet {
+		return ""
 	}
+	return lang
+}
 
-	// Add more noise dots
-	for i := 0; i < noiseDotsCount; i++ {
-		s.drawNoiseDot(img)
+// SetProfile overrides the options of a difficulty profile
+func (s *Service) SetProfile(d Difficulty, opts Options) error {
+	if _, err := ProfileOptions(d); err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid %s profile: %w", d, err)
 	}
 
-	// Encode to PNG
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		return nil, fmt.Errorf("failed to encode image: %w", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[d] = opts
+	return nil
+}
+
+// SetDefaultDifficulty sets the profile used when a request has no difficulty
+func (s *Service) SetDefaultDifficulty(d Difficulty) error {
+	if _, err := ProfileOptions(d); err != nil {
+		return err
 	}
 
-	return &CaptchaImage{
-		Image:  buf.Bytes(),
-		Answer: answer,
-	}, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.difficulty = d
+	return nil
 }
 
-// LoadFromAssets loads a random captcha from the assets directory
-func (s *Service) LoadFromAssets() (*CaptchaImage, error) {
-	// Check if assets directory exists
-	captchaDir := filepath.Join(s.assetsDir, "captcha")
-	if _, err := os.Stat(captchaDir); os.IsNotExist(err) {
-		// If no assets, generate a new captcha
-		return s.Generate()
+// Options returns the options of a difficulty profile, falling back to the default one
+func (s *Service) Options(d Difficulty) Options {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if opts, ok := s.profiles[d]; ok {
+		return opts
 	}
+	return s.profiles[s.difficulty]
+}
 
-	// List all PNG files
-	files, err := filepath.Glob(filepath.Join(captchaDir, "*.png"))
-	if err != nil || len(files) == 0 {
-		// If no files found, generate a new captcha
-		return s.Generate()
+// Generate creates a new captcha image with the default difficulty
+func (s *Service) Generate() (*CaptchaImage, error) {
+	return s.GenerateWithOptions(s.Options(""))
+}
+
+// GenerateWithOptions creates a new captcha image with the given options
+func (s *Service) GenerateWithOptions(opts Options) (*CaptchaImage, error) {
+	return s.GenerateWithSeed(opts, 0)
+}
+
+// GenerateWithSeed creates the captcha image of the seed: the same seed and
+// options always produce the same bytes. A zero seed draws a new one.
+func (s *Service) GenerateWithSeed(opts Options, seed uint64) (*CaptchaImage, error) {
+	return s.GenerateForLanguage(opts, "", seed)
+}
+
+// GenerateForLanguage creates the captcha image of the seed for members of
+// the language, whose alphabet is used when the options are localized
+func (s *Service) GenerateForLanguage(opts Options, languageCode string, seed uint64) (*CaptchaImage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid captcha options: %w", err)
 	}
 
-	// Pick a random file
-	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(files))))
+	source, seed, err := s.challengeSource(seed)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate random index: %w", err)
+		return nil, err
 	}
 
-	selectedFile := files[n.Int64()]
-
-	// Extract answer from filename (e.g., "5647.png" -> "5647")
-	filename := filepath.Base(selectedFile)
-	answer := filename[:len(filename)-4] // Remove .png extension
+	answer, err := randomAnswer(source, opts.Length, opts.LocaleCharset(s.alphabet(opts, languageCode)))
+	if err != nil {
+		return nil, err
+	}
 
-	// Read image file
-	data, err := os.ReadFile(selectedFile)
+	data, err := s.render(answer, opts, source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read captcha file: %w", err)
+		return nil, err
 	}
 
 	return &CaptchaImage{
 		Image:  data,
 		Answer: answer,
+		Seed:   seed,
 	}, nil
 }
 
-// drawDigit draws a single digit on the image
-func (s *Service) drawDigit(img *image.RGBA, digit rune, x, y int) {
-	// Simple 7-segment style digit drawing
-	segments := s.getDigitSegments(digit)
-	segmentColor := color.RGBA{50, 50, 50, 255}
-
-	for _, seg := range segments {
-		s.drawSegment(img, seg, x, y, segmentColor)
+// randomAnswer builds a random answer of length characters from the charset
+func randomAnswer(source Source, length int, charset []rune) (string, error) {
+	answer := make([]rune, length)
+	for i := range answer {
+		n, err := source.Intn(len(charset))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random character: %w", err)
+		}
+		answer[i] = charset[n]
 	}
+	return string(answer), nil
 }
 
-type segment struct {
-	x1, y1, x2, y2 int
+// Type returns the challenge type produced by the service
+func (s *Service) Type() string {
+	return TypeDigits
 }
 
-func (s *Service) getDigitSegments(digit rune) []segment {
-	// Simple representation of digits using segments
-	// This is a simplified version - in production you'd use a font
-	baseSegments := map[rune][]segment{
-		'0': {{-10, -20, 10, -20}, {10, -20, 10, 20}, {10, 20, -10, 20}, {-10, 20, -10, -20}},
-		'1': {{0, -20, 0, 20}},
-		'2': {{-10, -20, 10, -20}, {10, -20, 10, 0}, {10, 0, -10, 0}, {-10, 0, -10, 20}, {-10, 20, 10, 20}},
-		'3': {{-10, -20, 10, -20}, {10, -20, 10, 20}, {10, 20, -10, 20}, {-5, 0, 10, 0}},
-		'4': {{-10, -20, -10, 0}, {-10, 0, 10, 0}, {10, -20, 10, 20}},
-		'5': {{10, -20, -10, -20}, {-10, -20, -10, 0}, {-10, 0, 10, 0}, {10, 0, 10, 20}, {10, 20, -10, 20}},
-		'6': {{10, -20, -10, -20}, {-10, -20, -10, 20}, {-10, 20, 10, 20}, {10, 20, 10, 0}, {10, 0, -10, 0}},
-		'7': {{-10, -20, 10, -20}, {10, -20, 10, 20}},
-		'8': {{-10, -20, 10, -20}, {10, -20, 10, 20}, {10, 20, -10, 20}, {-10, 20, -10, -20}, {-10, 0, 10, 0}},
-		'9': {{10, 20, 10, -20}, {10, -20, -10, -20}, {-10, -20, -10, 0}, {-10, 0, 10, 0}},
+// NewChallenge creates a digits challenge to be answered with a text message
+func (s *Service) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	img, err := s.GenerateForLanguage(s.Options(req.Difficulty), req.LanguageCode, req.Seed)
+	if err != nil {
+		return nil, err
 	}
-	return baseSegments[digit]
+
+	return &Challenge{
+		Type:     TypeDigits,
+		Modality: ModalityText,
+		Media: &Media{
+			Kind:     MediaPhoto,
+			Data:     img.Image,
+			Filename: "captcha.png",
+		},
+		PromptID: "captcha_prompt",
+		Answer:   img.Answer,
+		Seed:     img.Seed,
+	}, nil
 }
 
-func (s *Service) drawSegment(img *image.RGBA, seg segment, offsetX, offsetY int, col color.RGBA) {
-	x1 := offsetX + seg.x1
-	y1 := offsetY + seg.y1
-	x2 := offsetX + seg.x2
-	y2 := offsetY + seg.y2
+// Verify compares the normalized answer ignoring case, see NormalizeAnswer
+func (s *Service) Verify(expected, answer string) bool {
+	if IsHashedAnswer(expected) {
+		return VerifyHashedAnswer(expected, answer)
+	}
+	return NormalizeAnswer(answer, NormalizeOptions{FoldCase: true}) == NormalizeAnswer(expected, NormalizeOptions{FoldCase: true})
+}
 
-	s.drawLine(img, x1, y1, x2, y2, col)
+// LoadFromAssets loads a random digits captcha from the asset pack in the
+// assets directory. Pack answers are hashed, check them with Verify.
+func (s *Service) LoadFromAssets() (*CaptchaImage, error) {
+	// If there is no pack, generate a new captcha
+	pack, err := LoadPack(filepath.Join(s.assetsDir, "captcha"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Generate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load captcha pack: %w", err)
+	}
+
+	items := pack.match(TypeDigits, "", "")
+	if len(items) == 0 {
+		return s.Generate()
+	}
+
+	// Pick a random item
+	n, err := randomInt(len(items))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random index: %w", err)
+	}
+
+	data, err := pack.Read(items[n])
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptchaImage{
+		Image:  data,
+		Answer: items[n].Answer,
+	}, nil
 }
 
 func (s *Service) drawLine(img *image.RGBA, x1, y1, x2, y2 int, col color.RGBA) {
@@ -181,14 +284,16 @@ func (s *Service) drawLine(img *image.RGBA, x1, y1, x2, y2 int, col color.RGBA)
 	}
 	err := dx - dy
 
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
 	for {
-		if x1 >= 0 && x1 < captchaWidth && y1 >= 0 && y1 < captchaHeight {
+		if x1 >= 0 && x1 < width && y1 >= 0 && y1 < height {
 			img.Set(x1, y1, col)
 			// Make line thicker
-			if x1+1 < captchaWidth {
+			if x1+1 < width {
 				img.Set(x1+1, y1, col)
 			}
-			if y1+1 < captchaHeight {
+			if y1+1 < height {
 				img.Set(x1, y1+1, col)
 			}
 		}
@@ -209,20 +314,23 @@ func (s *Service) drawLine(img *image.RGBA, x1, y1, x2, y2 int, col color.RGBA)
 	}
 }
 
-func (s *Service) drawNoiseLine(img *image.RGBA) {
-	x1, _ := rand.Int(rand.Reader, big.NewInt(captchaWidth))
-	y1, _ := rand.Int(rand.Reader, big.NewInt(captchaHeight))
-	x2, _ := rand.Int(rand.Reader, big.NewInt(captchaWidth))
-	y2, _ := rand.Int(rand.Reader, big.NewInt(captchaHeight))
+func (s *Service) drawNoiseLine(img *image.RGBA, rnd *randomizer) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	x1, y1 := rnd.intn(width), rnd.intn(height)
+	x2, y2 := rnd.intn(width), rnd.intn(height)
 
 	col := color.RGBA{200, 200, 200, 255}
-	s.drawLine(img, int(x1.Int64()), int(y1.Int64()), int(x2.Int64()), int(y2.Int64()), col)
+	s.drawLine(img, x1, y1, x2, y2, col)
 }
 
-func (s *Service) drawNoiseDot(img *image.RGBA) {
-	x, _ := rand.Int(rand.Reader, big.NewInt(captchaWidth))
-	y, _ := rand.Int(rand.Reader, big.NewInt(captchaHeight))
+func (s *Service) drawNoiseDot(img *image.RGBA, rnd *randomizer) {
+	x, y := rnd.intn(img.Bounds().Dx()), rnd.intn(img.Bounds().Dy())
 
 	col := color.RGBA{180, 180, 180, 255}
-	img.Set(int(x.Int64()), int(y.Int64()), col)
+	img.Set(x, y, col)
+}
+
+// randomInt returns a uniform random number in [0, max) from crypto/rand
+func randomInt(max int) (int, error) {
+	return cryptoSource{}.Intn(max)
 }
@@ -16,8 +16,8 @@ func TestGenerate(t *testing.T) {
 		t.Fatal("Generated captcha is nil")
 	}
 
-	if len(captcha.Answer) != digitCount {
-		t.Errorf("Expected answer length %d, got %d", digitCount, len(captcha.Answer))
+	if len(captcha.Answer) != DefaultOptions().Length {
+		t.Errorf("Expected answer length %d, got %d", DefaultOptions().Length, len(captcha.Answer))
 	}
 
 	if len(captcha.Image) == 0 {
@@ -66,7 +66,7 @@ func TestServiceWithInvalidPath(t *testing.T) {
 		t.Fatal("Generated captcha is nil")
 	}
 
-	if len(captcha.Answer) != digitCount {
-		t.Errorf("Expected answer length %d, got %d", digitCount, len(captcha.Answer))
+	if len(captcha.Answer) != DefaultOptions().Length {
+		t.Errorf("Expected answer length %d, got %d", DefaultOptions().Length, len(captcha.Answer))
 	}
 }
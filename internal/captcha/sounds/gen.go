@@ -0,0 +1,52 @@
+//go:build ignore
+
+// gen copies the built-in per-digit speech samples from the recordings of
+// github.com/dchest/captcha, which are MIT licensed. Every language gets a
+// directory with 0.wav to 9.wav, the English one is the default pack.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const module = "github.com/dchest/captcha@v1.1.0"
+
+var languages = []string{"en", "ru", "zh", "ja", "pt"}
+
+func main() {
+	out, err := exec.Command("go", "mod", "download", "-json", module).Output()
+	if err != nil {
+		log.Fatalf("Failed to download %s: %v", module, err)
+	}
+	var download struct{ Dir string }
+	if err := json.Unmarshal(out, &download); err != nil {
+		log.Fatalf("Failed to parse the download of %s: %v", module, err)
+	}
+
+	dir := filepath.Join("sounds", "digits")
+	for _, lang := range languages {
+		if err := os.MkdirAll(filepath.Join(dir, lang), 0o755); err != nil {
+			log.Fatalf("Failed to create %s: %v", lang, err)
+		}
+		for digit := 0; digit <= 9; digit++ {
+			name := filepath.Join(lang, fmt.Sprintf("%d.wav", digit))
+			copyFile(filepath.Join(download.Dir, "capgensounds", name), filepath.Join(dir, name))
+		}
+	}
+	copyFile(filepath.Join(download.Dir, "LICENSE"), filepath.Join(dir, "LICENSE"))
+}
+
+func copyFile(from, to string) {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", from, err)
+	}
+	if err := os.WriteFile(to, data, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", to, err)
+	}
+}
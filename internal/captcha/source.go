@@ -0,0 +1,76 @@
+package captcha
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	mrand "math/rand/v2"
+	"sync"
+)
+
+// Source provides the random numbers challenges are generated from
+type Source interface {
+	// Intn returns a uniform random number in [0, n)
+	Intn(n int) (int, error)
+}
+
+// cryptoSource draws numbers from crypto/rand and is the default source
+type cryptoSource struct{}
+
+func (cryptoSource) Intn(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// seededSource is a deterministic source safe for concurrent use
+type seededSource struct {
+	mu  sync.Mutex
+	rng *mrand.Rand
+}
+
+// NewSeededSource returns a deterministic source: the same seed produces
+// the same sequence of numbers. ChaCha8 does not reveal its state through
+// its output, so the seed cannot be recovered from a rendered challenge.
+func NewSeededSource(seed uint64) Source {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	return &seededSource{rng: mrand.New(mrand.NewChaCha8(key))}
+}
+
+func (s *seededSource) Intn(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.IntN(n), nil
+}
+
+// subSource returns a source for a part of a challenge generated apart from
+// it, like the Mini App puzzle. It replays with the seed of the challenge but
+// is independent of the challenge's own source.
+func subSource(seed, part uint64) Source {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	binary.LittleEndian.PutUint64(key[8:], part)
+	return &seededSource{rng: mrand.New(mrand.NewChaCha8(key))}
+}
+
+// challengeSource returns the source a single challenge is generated from.
+// A zero seed draws a new non-zero seed from seeds; passing a logged seed
+// regenerates the exact same challenge.
+func challengeSource(seeds Source, seed uint64) (Source, uint64, error) {
+	for seed == 0 {
+		hi, err := seeds.Intn(1 << 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to generate seed: %w", err)
+		}
+		lo, err := seeds.Intn(1 << 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to generate seed: %w", err)
+		}
+		seed = uint64(hi)<<32 | uint64(lo)
+	}
+	return NewSeededSource(seed), seed, nil
+}
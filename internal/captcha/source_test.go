@@ -0,0 +1,29 @@
+package captcha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeededSourceIsDeterministic(t *testing.T) {
+	generate := func(seed uint64) *CaptchaImage {
+		service := NewService("")
+		service.SetSource(NewSeededSource(seed))
+
+		img, err := service.GenerateWithOptions(DefaultOptions())
+		if err != nil {
+			t.Fatalf("Failed to generate captcha: %v", err)
+		}
+		return img
+	}
+
+	a, b := generate(42), generate(42)
+	if a.Answer != b.Answer || !bytes.Equal(a.Image, b.Image) {
+		t.Errorf("Same seed produced different captchas")
+	}
+
+	c := generate(43)
+	if a.Answer == c.Answer && bytes.Equal(a.Image, c.Image) {
+		t.Errorf("Different seeds produced the same captcha")
+	}
+}
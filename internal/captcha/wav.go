@@ -0,0 +1,79 @@
+package captcha
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// wavFormatPCM is the format tag of uncompressed PCM samples
+const wavFormatPCM = 1
+
+// decodeWAV reads an 8-bit or 16-bit mono PCM WAV file and returns its
+// samples, widened to 16 bits, and sample rate
+func decodeWAV(data []byte) ([]int16, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("not a WAV file")
+	}
+
+	var (
+		sampleRate int
+		samples    []int16
+		bits       uint16
+		haveFormat bool
+	)
+
+	// Walk the chunks, skipping the ones we do not need
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		if size > len(body) {
+			return nil, 0, fmt.Errorf("truncated %q chunk", id)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, fmt.Errorf("invalid format chunk")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels := binary.LittleEndian.Uint16(body[2:4])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format != wavFormatPCM || channels != 1 || (bits != 8 && bits != 16) {
+				return nil, 0, fmt.Errorf("unsupported format %d with %d channels and %d bits, want 8-bit or 16-bit mono PCM",
+					format, channels, bits)
+			}
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, 0, fmt.Errorf("data chunk before format chunk")
+			}
+			if bits == 8 {
+				// 8-bit samples are unsigned around 128
+				samples = make([]int16, size)
+				for i, b := range body {
+					samples[i] = (int16(b) - 128) << 8
+				}
+				break
+			}
+			samples = make([]int16, size/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(body[2*i:]))
+			}
+		}
+
+		// Chunks are padded to an even size
+		pos += 8 + size + size%2
+	}
+
+	if !haveFormat {
+		return nil, 0, fmt.Errorf("missing format chunk")
+	}
+	if samples == nil {
+		return nil, 0, fmt.Errorf("missing data chunk")
+	}
+
+	return samples, sampleRate, nil
+}
@@ -0,0 +1,208 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// TypeWebApp is the type of challenges solved in a Telegram Mini App
+const TypeWebApp = "webapp"
+
+// Slider puzzle bounds in percent of the track
+const (
+	webAppMinTarget = 10
+	webAppMaxTarget = 90
+
+	// WebAppTolerance is how far the slider may stop from the target
+	WebAppTolerance = 4
+)
+
+// Slider puzzle images in pixels; the piece is as high as the track
+const (
+	webAppTrackWidth  = 400
+	webAppTrackHeight = 64
+	webAppShapeScale  = 0.6
+	webAppDecoys      = 3
+
+	// webAppDecoyGap is the least distance of a decoy hole from the target
+	// and other holes, in percent of the track
+	webAppDecoyGap = 3 * WebAppTolerance
+
+	// webAppPuzzlePart tells the source of the puzzle from the one of the target
+	webAppPuzzlePart = 1
+)
+
+var (
+	webAppHoleColor  = color.RGBA{R: 70, G: 70, B: 70, A: 255}
+	webAppPieceColor = color.RGBA{R: 36, G: 129, B: 204, A: 255}
+)
+
+// WebAppProvider produces challenges with a button opening a Mini App, where
+// the user drags a slider onto a target. Telegram signs the data the app
+// posts back, so the answer also proves a real client opened it.
+type WebAppProvider struct {
+	link string
+}
+
+// NewWebAppProvider creates a provider opening the Mini App of the direct
+// link registered with BotFather, like https://t.me/<bot>/<app>
+func NewWebAppProvider(link string) (*WebAppProvider, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Mini App link %q", link)
+	}
+	return &WebAppProvider{link: link}, nil
+}
+
+// Type returns the challenge type produced by the provider
+func (p *WebAppProvider) Type() string {
+	return TypeWebApp
+}
+
+// NewChallenge creates a slider challenge. The chat is passed to the app as
+// the start parameter, as Mini Apps opened from groups do not know it.
+func (p *WebAppProvider) NewChallenge(ctx context.Context, req Request) (*Challenge, error) {
+	source, seed, err := challengeSource(cryptoSource{}, req.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := source.Intn(webAppMaxTarget - webAppMinTarget + 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate slider target: %w", err)
+	}
+
+	link, err := url.Parse(p.link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Mini App link: %w", err)
+	}
+	query := link.Query()
+	query.Set("startapp", WebAppStartParam(req.ChatID))
+	link.RawQuery = query.Encode()
+
+	return &Challenge{
+		Type:     TypeWebApp,
+		Modality: ModalityWebApp,
+		PromptID: "captcha_webapp_prompt",
+		Buttons:  [][]Button{{{TextID: "captcha_webapp_button", URL: link.String()}}},
+		Answer:   strconv.Itoa(webAppMinTarget + n),
+		Seed:     seed,
+	}, nil
+}
+
+// Verify accepts slider positions within WebAppTolerance of the target
+func (p *WebAppProvider) Verify(expected, answer string) bool {
+	target, err := strconv.Atoi(expected)
+	if err != nil {
+		return false
+	}
+	position, err := strconv.Atoi(answer)
+	if err != nil {
+		return false
+	}
+	return position >= target-WebAppTolerance && position <= target+WebAppTolerance
+}
+
+// RenderWebAppPuzzle draws the track of a slider challenge with a hole
+// shaped like the piece at the target and decoy holes of other shapes, and
+// the piece the slider drags. The target is only sent as pixels, so a client
+// replaying the API cannot read it. The puzzle is drawn from the seed of the
+// challenge, so reopening the app shows the same decoys rather than telling
+// the target apart as the one hole that stays.
+func RenderWebAppPuzzle(target int, seed uint64) (track, piece []byte, err error) {
+	rnd := &randomizer{source: subSource(seed, webAppPuzzlePart)}
+	shape := gridShape{
+		name:  gridShapes[rnd.intn(len(gridShapes))],
+		angle: rnd.float(0, 2*math.Pi),
+		scale: webAppShapeScale,
+		col:   webAppHoleColor,
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, webAppTrackWidth, webAppTrackHeight))
+	drawBackground(img, rnd)
+	drawWebAppHole(img, target, shape)
+
+	holes := []int{target}
+	for len(holes) <= webAppDecoys && rnd.err == nil {
+		position := webAppMinTarget + rnd.intn(webAppMaxTarget-webAppMinTarget+1)
+		if nearHole(holes, position, webAppDecoyGap) {
+			continue
+		}
+		holes = append(holes, position)
+
+		decoy := shape
+		decoy.name = gridShapes[rnd.intn(len(gridShapes)-1)]
+		if decoy.name == shape.name {
+			decoy.name = gridShapes[len(gridShapes)-1]
+		}
+		decoy.angle = rnd.float(0, 2*math.Pi)
+		drawWebAppHole(img, position, decoy)
+	}
+
+	// Waves cross the holes so their outlines are not clean
+	for range 2 {
+		drawWave(img, rnd, rnd.color(90, 170))
+	}
+
+	pieceImg := image.NewRGBA(image.Rect(0, 0, webAppTrackHeight, webAppTrackHeight))
+	shape.col = webAppPieceColor
+	drawShape(pieceImg, shape)
+
+	if rnd.err != nil {
+		return nil, nil, fmt.Errorf("failed to generate random value: %w", rnd.err)
+	}
+
+	if track, err = encodePNG(img); err != nil {
+		return nil, nil, err
+	}
+	if piece, err = encodePNG(pieceImg); err != nil {
+		return nil, nil, err
+	}
+	return track, piece, nil
+}
+
+// drawWebAppHole draws the shape centered at the position in percent of the track
+func drawWebAppHole(img *image.RGBA, position int, shape gridShape) {
+	x := position * webAppTrackWidth / 100
+	half := webAppTrackHeight / 2
+	drawShape(img.SubImage(image.Rect(x-half, 0, x+half, webAppTrackHeight)).(*image.RGBA), shape)
+}
+
+// nearHole reports whether a hole lies closer than gap to the position
+func nearHole(positions []int, position, gap int) bool {
+	for _, p := range positions {
+		if p-position < gap && position-p < gap {
+			return true
+		}
+	}
+	return false
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WebAppStartParam encodes the chat ID as a Mini App start parameter
+func WebAppStartParam(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
+
+// ParseWebAppStartParam decodes the chat ID of a Mini App start parameter
+func ParseWebAppStartParam(param string) (int64, error) {
+	chatID, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid start parameter %q", param)
+	}
+	return chatID, nil
+}
@@ -0,0 +1,101 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestWebAppChallenge(t *testing.T) {
+	if _, err := NewWebAppProvider("http://example.com/app"); err == nil {
+		t.Errorf("Expected plain HTTP links to be rejected")
+	}
+
+	provider, err := NewWebAppProvider("https://t.me/gofency_bot/verify")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	challenge, err := provider.NewChallenge(context.Background(), Request{ChatID: -1001234567890})
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+	if challenge.Modality != ModalityWebApp {
+		t.Errorf("Expected modality %q, got %q", ModalityWebApp, challenge.Modality)
+	}
+
+	link, err := url.Parse(challenge.Buttons[0][0].URL)
+	if err != nil {
+		t.Fatalf("Invalid button link: %v", err)
+	}
+	chatID, err := ParseWebAppStartParam(link.Query().Get("startapp"))
+	if err != nil || chatID != -1001234567890 {
+		t.Errorf("Expected chat -1001234567890 in the start parameter, got %d (%v)", chatID, err)
+	}
+
+	target, err := strconv.Atoi(challenge.Answer)
+	if err != nil {
+		t.Fatalf("Invalid answer %q", challenge.Answer)
+	}
+	tests := []struct {
+		position int
+		want     bool
+	}{
+		{target, true},
+		{target - WebAppTolerance, true},
+		{target + WebAppTolerance, true},
+		{target + WebAppTolerance + 1, false},
+		{target - WebAppTolerance - 1, false},
+	}
+	for _, tt := range tests {
+		if got := provider.Verify(challenge.Answer, strconv.Itoa(tt.position)); got != tt.want {
+			t.Errorf("Verify(%d) at target %d = %v, want %v", tt.position, target, got, tt.want)
+		}
+	}
+	if provider.Verify(challenge.Answer, "left") {
+		t.Errorf("Expected non-numeric positions to be rejected")
+	}
+}
+
+func TestWebAppPuzzle(t *testing.T) {
+	track, piece, err := RenderWebAppPuzzle(37, goldenSeed)
+	if err != nil {
+		t.Fatalf("Failed to render puzzle: %v", err)
+	}
+
+	trackImg, err := png.Decode(bytes.NewReader(track))
+	if err != nil {
+		t.Fatalf("Invalid track image: %v", err)
+	}
+	if b := trackImg.Bounds(); b.Dx() != webAppTrackWidth || b.Dy() != webAppTrackHeight {
+		t.Errorf("Track is %dx%d", b.Dx(), b.Dy())
+	}
+
+	pieceImg, err := png.Decode(bytes.NewReader(piece))
+	if err != nil {
+		t.Fatalf("Invalid piece image: %v", err)
+	}
+	// The piece covers its center and leaves the corners transparent
+	half := webAppTrackHeight / 2
+	if _, _, _, a := pieceImg.At(half, half).RGBA(); a == 0 {
+		t.Errorf("Expected the piece to cover its center")
+	}
+	if _, _, _, a := pieceImg.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("Expected the piece corners to be transparent")
+	}
+
+	// Reopening the app shows the same puzzle, the target is no steadier than the decoys
+	replay, _, err := RenderWebAppPuzzle(37, goldenSeed)
+	if err != nil {
+		t.Fatalf("Failed to render puzzle again: %v", err)
+	}
+	if !bytes.Equal(replay, track) {
+		t.Errorf("Expected the puzzle of a seed to be drawn the same")
+	}
+	if other, _, _ := RenderWebAppPuzzle(37, goldenSeed+1); bytes.Equal(other, track) {
+		t.Errorf("Expected another seed to draw another puzzle")
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"gofency/internal/captcha"
 	"gofency/internal/database"
 
 	"github.com/joho/godotenv"
@@ -14,6 +16,63 @@ import (
 type Config struct {
 	TelegramToken string
 	Database      database.Config
+	Captcha       CaptchaConfig
+	Scheduler     SchedulerConfig
+}
+
+type CaptchaConfig struct {
+	Types      []string
+	Math       captcha.MathOptions
+	Difficulty captcha.Difficulty
+	Custom     captcha.Options
+	AudioDir   string
+	// PackDir is an asset pack directory served before generating challenges
+	PackDir string
+	// PackManifest overrides the location of the pack manifest
+	PackManifest string
+
+	// PoolTypes are the challenge types served from pre-generated pools
+	PoolTypes []string
+	Pool      captcha.PoolOptions
+
+	// Attempts is how many answers a member may give before punishment
+	Attempts int
+	// Refreshes is how many times a member may ask for a new challenge
+	Refreshes int
+	// MinAnswerTime is the least time a human needs to answer, faster answers count as wrong
+	MinAnswerTime time.Duration
+
+	// WebApp configures verification in a Telegram Mini App
+	WebApp WebAppConfig
+
+	// StateStore is where pending verifications are kept
+	StateStore string
+}
+
+// Stores of pending verifications and scheduled jobs
+const (
+	StateStoreDatabase = "database"
+	StateStoreMemory   = "memory"
+)
+
+// SchedulerConfig configures the runner of delayed actions
+type SchedulerConfig struct {
+	// Store is where scheduled jobs are kept
+	Store string
+	// Workers is how many jobs may run at once
+	Workers int
+}
+
+// WebAppConfig configures the Mini App verification server
+type WebAppConfig struct {
+	// Listen is the address of the embedded HTTP server, empty disables it
+	Listen string
+	// Link is the direct link of the Mini App registered with BotFather
+	Link string
+	// MaxAge limits how long after opening the app its data is accepted
+	MaxAge time.Duration
+	// MinSolveTime is the time a human needs at least to solve the challenge
+	MinSolveTime time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -29,12 +88,30 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to load database config: %w", err)
 	}
 
+	captchaConfig, err := loadCaptchaConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load captcha config: %w", err)
+	}
+
+	schedulerConfig, err := loadSchedulerConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scheduler config: %w", err)
+	}
+
 	return &Config{
 		TelegramToken: token,
 		Database:      dbConfig,
+		Captcha:       captchaConfig,
+		Scheduler:     schedulerConfig,
 	}, nil
 }
 
+// LoadCaptchaConfig loads only the captcha settings, for tools that do not connect to Telegram
+func LoadCaptchaConfig() (CaptchaConfig, error) {
+	_ = godotenv.Load()
+	return loadCaptchaConfig()
+}
+
 func loadDatabaseConfig() (database.Config, error) {
 	port, err := strconv.Atoi(getEnvOrDefault("DB_PORT", "5432"))
 	if err != nil {
@@ -69,6 +146,231 @@ func loadDatabaseConfig() (database.Config, error) {
 	}, nil
 }
 
+func loadCaptchaConfig() (CaptchaConfig, error) {
+	math := captcha.DefaultMathOptions()
+
+	if operators := os.Getenv("CAPTCHA_MATH_OPERATORS"); operators != "" {
+		math.Operators = nil
+		for _, op := range splitList(operators) {
+			// Allow ASCII spellings of the multiplication sign in env files
+			if op == "*" || op == "x" {
+				op = captcha.OperatorMultiply
+			}
+			math.Operators = append(math.Operators, op)
+		}
+	}
+
+	ints := []struct {
+		key    string
+		target *int
+	}{
+		{"CAPTCHA_MATH_OPERANDS", &math.Operands},
+		{"CAPTCHA_MATH_MIN_OPERAND", &math.MinOperand},
+		{"CAPTCHA_MATH_MAX_OPERAND", &math.MaxOperand},
+		{"CAPTCHA_MATH_MIN_RESULT", &math.MinResult},
+		{"CAPTCHA_MATH_MAX_RESULT", &math.MaxResult},
+	}
+	for _, v := range ints {
+		value, err := strconv.Atoi(getEnvOrDefault(v.key, strconv.Itoa(*v.target)))
+		if err != nil {
+			return CaptchaConfig{}, fmt.Errorf("invalid %s: %w", v.key, err)
+		}
+		*v.target = value
+	}
+
+	if err := math.Validate(); err != nil {
+		return CaptchaConfig{}, fmt.Errorf("invalid math captcha settings: %w", err)
+	}
+
+	difficulty, err := captcha.ParseDifficulty(getEnvOrDefault("CAPTCHA_DIFFICULTY", string(captcha.DifficultyNormal)))
+	if err != nil {
+		return CaptchaConfig{}, fmt.Errorf("invalid CAPTCHA_DIFFICULTY: %w", err)
+	}
+
+	custom, err := loadCustomCaptchaOptions()
+	if err != nil {
+		return CaptchaConfig{}, err
+	}
+
+	pool, err := loadCaptchaPoolOptions()
+	if err != nil {
+		return CaptchaConfig{}, err
+	}
+
+	attempts, err := strconv.Atoi(getEnvOrDefault("CAPTCHA_ATTEMPTS", "3"))
+	if err != nil || attempts < 1 {
+		return CaptchaConfig{}, fmt.Errorf("invalid CAPTCHA_ATTEMPTS: must be a positive number")
+	}
+
+	refreshes, err := strconv.Atoi(getEnvOrDefault("CAPTCHA_REFRESHES", "2"))
+	if err != nil || refreshes < 0 {
+		return CaptchaConfig{}, fmt.Errorf("invalid CAPTCHA_REFRESHES: must not be negative")
+	}
+
+	minAnswerTime, err := time.ParseDuration(getEnvOrDefault("CAPTCHA_MIN_ANSWER_TIME", "1500ms"))
+	if err != nil || minAnswerTime < 0 {
+		return CaptchaConfig{}, fmt.Errorf("invalid CAPTCHA_MIN_ANSWER_TIME: must not be negative")
+	}
+
+	webApp, err := loadWebAppConfig()
+	if err != nil {
+		return CaptchaConfig{}, err
+	}
+
+	stateStore := getEnvOrDefault("CAPTCHA_STATE_STORE", StateStoreDatabase)
+	if stateStore != StateStoreDatabase && stateStore != StateStoreMemory {
+		return CaptchaConfig{}, fmt.Errorf("invalid CAPTCHA_STATE_STORE: must be %s or %s", StateStoreDatabase, StateStoreMemory)
+	}
+
+	return CaptchaConfig{
+		Types:         splitList(getEnvOrDefault("CAPTCHA_TYPES", captcha.TypeDigits)),
+		Math:          math,
+		Difficulty:    difficulty,
+		Custom:        custom,
+		AudioDir:      os.Getenv("CAPTCHA_AUDIO_DIR"),
+		PackDir:       os.Getenv("CAPTCHA_PACK_DIR"),
+		PackManifest:  os.Getenv("CAPTCHA_PACK_MANIFEST"),
+		PoolTypes:     splitList(getEnvOrDefault("CAPTCHA_POOL_TYPES", "digits,math,animated")),
+		Pool:          pool,
+		Attempts:      attempts,
+		Refreshes:     refreshes,
+		MinAnswerTime: minAnswerTime,
+		WebApp:        webApp,
+		StateStore:    stateStore,
+	}, nil
+}
+
+// loadSchedulerConfig reads where delayed actions are kept and how many run at once
+func loadSchedulerConfig() (SchedulerConfig, error) {
+	store := getEnvOrDefault("SCHEDULER_STORE", StateStoreDatabase)
+	if store != StateStoreDatabase && store != StateStoreMemory {
+		return SchedulerConfig{}, fmt.Errorf("invalid SCHEDULER_STORE: must be %s or %s", StateStoreDatabase, StateStoreMemory)
+	}
+
+	workers, err := strconv.Atoi(getEnvOrDefault("SCHEDULER_WORKERS", "8"))
+	if err != nil || workers < 1 {
+		return SchedulerConfig{}, fmt.Errorf("invalid SCHEDULER_WORKERS: must be a positive number")
+	}
+
+	return SchedulerConfig{Store: store, Workers: workers}, nil
+}
+
+// loadWebAppConfig reads the Mini App settings; the server and the link are set together
+func loadWebAppConfig() (WebAppConfig, error) {
+	cfg := WebAppConfig{
+		Listen: os.Getenv("CAPTCHA_WEBAPP_LISTEN"),
+		Link:   os.Getenv("CAPTCHA_WEBAPP_LINK"),
+	}
+	if (cfg.Listen == "") != (cfg.Link == "") {
+		return WebAppConfig{}, fmt.Errorf("CAPTCHA_WEBAPP_LISTEN and CAPTCHA_WEBAPP_LINK must be set together")
+	}
+
+	maxAge, err := time.ParseDuration(getEnvOrDefault("CAPTCHA_WEBAPP_MAX_AGE", "10m"))
+	if err != nil || maxAge <= 0 {
+		return WebAppConfig{}, fmt.Errorf("invalid CAPTCHA_WEBAPP_MAX_AGE: must be a positive duration")
+	}
+	cfg.MaxAge = maxAge
+
+	minSolveTime, err := time.ParseDuration(getEnvOrDefault("CAPTCHA_WEBAPP_MIN_SOLVE_TIME", "1s"))
+	if err != nil || minSolveTime < 0 {
+		return WebAppConfig{}, fmt.Errorf("invalid CAPTCHA_WEBAPP_MIN_SOLVE_TIME: must not be negative")
+	}
+	cfg.MinSolveTime = minSolveTime
+
+	return cfg, nil
+}
+
+// loadCaptchaPoolOptions reads the pre-generated pool settings; a size of 0 disables pooling
+func loadCaptchaPoolOptions() (captcha.PoolOptions, error) {
+	opts := captcha.DefaultPoolOptions()
+
+	size, err := strconv.Atoi(getEnvOrDefault("CAPTCHA_POOL_SIZE", strconv.Itoa(opts.Size)))
+	if err != nil {
+		return captcha.PoolOptions{}, fmt.Errorf("invalid CAPTCHA_POOL_SIZE: %w", err)
+	}
+	opts.Size = size
+
+	workers, err := strconv.Atoi(getEnvOrDefault("CAPTCHA_POOL_WORKERS", strconv.Itoa(opts.Workers)))
+	if err != nil {
+		return captcha.PoolOptions{}, fmt.Errorf("invalid CAPTCHA_POOL_WORKERS: %w", err)
+	}
+	opts.Workers = workers
+
+	interval, err := time.ParseDuration(getEnvOrDefault("CAPTCHA_POOL_STATS_INTERVAL", opts.StatsInterval.String()))
+	if err != nil {
+		return captcha.PoolOptions{}, fmt.Errorf("invalid CAPTCHA_POOL_STATS_INTERVAL: %w", err)
+	}
+	opts.StatsInterval = interval
+
+	if opts.Size == 0 {
+		return opts, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return captcha.PoolOptions{}, fmt.Errorf("invalid captcha pool settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// loadCustomCaptchaOptions reads the custom difficulty profile, starting from the normal one
+func loadCustomCaptchaOptions() (captcha.Options, error) {
+	opts := captcha.DefaultOptions()
+
+	ints := []struct {
+		key    string
+		target *int
+	}{
+		{"CAPTCHA_LENGTH", &opts.Length},
+		{"CAPTCHA_WIDTH", &opts.Width},
+		{"CAPTCHA_HEIGHT", &opts.Height},
+		{"CAPTCHA_NOISE_LINES", &opts.NoiseLines},
+		{"CAPTCHA_NOISE_DOTS", &opts.NoiseDots},
+	}
+	for _, v := range ints {
+		value, err := strconv.Atoi(getEnvOrDefault(v.key, strconv.Itoa(*v.target)))
+		if err != nil {
+			return captcha.Options{}, fmt.Errorf("invalid %s: %w", v.key, err)
+		}
+		*v.target = value
+	}
+
+	opts.Alphabet = captcha.Alphabet(getEnvOrDefault("CAPTCHA_ALPHABET", string(opts.Alphabet)))
+
+	excludeAmbiguous, err := strconv.ParseBool(getEnvOrDefault("CAPTCHA_EXCLUDE_AMBIGUOUS", strconv.FormatBool(opts.ExcludeAmbiguous)))
+	if err != nil {
+		return captcha.Options{}, fmt.Errorf("invalid CAPTCHA_EXCLUDE_AMBIGUOUS: %w", err)
+	}
+	opts.ExcludeAmbiguous = excludeAmbiguous
+
+	localized, err := strconv.ParseBool(getEnvOrDefault("CAPTCHA_LOCALIZED", strconv.FormatBool(opts.Localized)))
+	if err != nil {
+		return captcha.Options{}, fmt.Errorf("invalid CAPTCHA_LOCALIZED: %w", err)
+	}
+	opts.Localized = localized
+
+	distortion, err := strconv.ParseFloat(getEnvOrDefault("CAPTCHA_DISTORTION", strconv.FormatFloat(opts.Distortion, 'g', -1, 64)), 64)
+	if err != nil {
+		return captcha.Options{}, fmt.Errorf("invalid CAPTCHA_DISTORTION: %w", err)
+	}
+	opts.Distortion = distortion
+
+	if err := opts.Validate(); err != nil {
+		return captcha.Options{}, fmt.Errorf("invalid custom captcha settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -2,82 +2,266 @@ package fsm
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"log"
+	"slices"
 	"time"
-)
-
-// CaptchaState represents the FSM state for captcha verification
-type CaptchaState string
 
-const (
-	StateIdle          CaptchaState = "idle"
-	StateWaitingAnswer CaptchaState = "waiting_answer"
+	"gofency/internal/captcha"
 )
 
 // CaptchaData holds the verification data for a user
 type CaptchaData struct {
+	// State is where the verification is in the state machine
+	State CaptchaState
+	// Version counts the changes of the verification, so a change based on
+	// an outdated read is rejected
+	Version int
+
 	ChatID         int64
 	UserID         int64
+	TopicID        int
+	Type           string
+	Modality       captcha.Modality
 	Answer         string
 	ExpiresAt      time.Time
 	PhotoMessageID int
+
+	// Seed regenerates the current challenge, see captcha.Request.Seed
+	Seed uint64
+
+	// Mention is the Markdown mention of the user in chat messages
+	Mention string
+	// LanguageCode is the language of the chat messages sent when no update
+	// tells it, like the timeout notice
+	LanguageCode string
+
+	// Selection holds the tiles toggled so far in selection challenges and
+	// the buttons pressed so far in sequence challenges
+	Selection string
+
+	// Buttons are the inline buttons the challenge was sent with
+	Buttons [][]captcha.Button
+
+	// AttemptsLeft is how many answers the user may still give, the last
+	// wrong one is punished
+	AttemptsLeft int
+	// RefreshesLeft is how many times the user may still ask for a new challenge
+	RefreshesLeft int
+
+	// Restricted is set when the user may not send messages until verified
+	Restricted bool
+	// Appealed is set once the user appealed, a rejected appeal is final
+	Appealed bool
+
+	// ShownAt is when the current challenge was sent, answers are timed from it
+	ShownAt time.Time
+	// MinAnswerTime is the least time a human needs to read the challenge and
+	// answer it; faster answers count as wrong
+	MinAnswerTime time.Duration
+	// AnswerTimes are the times the user took for each answer given
+	AnswerTimes []time.Duration
+}
+
+// CaptchaKey identifies a pending verification. A user joining several
+// chats, or several topics of a forum, is verified in each separately.
+type CaptchaKey struct {
+	ChatID int64
+	UserID int64
+	// TopicID is the forum topic the challenge was sent to, 0 outside forums
+	TopicID int
+}
+
+// Key returns the key the verification is stored under
+func (d *CaptchaData) Key() CaptchaKey {
+	return CaptchaKey{ChatID: d.ChatID, UserID: d.UserID, TopicID: d.TopicID}
 }
 
-// CaptchaFSM manages captcha verification states
+// CaptchaFSM manages captcha verification states. Verifications change
+// state only along the transition table, when the guards of the transition
+// pass. Store errors are logged and treated as a missing verification by
+// the state accessors, handlers have no way to recover.
 type CaptchaFSM struct {
-	mu     sync.RWMutex
-	states map[int64]*CaptchaData // key: userID
+	store  StateStore
+	guards map[CaptchaState][]Guard
+	hooks  []Hook
 }
 
-// NewCaptchaFSM creates a new captcha FSM manager
+// NewCaptchaFSM creates a new captcha FSM manager keeping states in memory
 func NewCaptchaFSM() *CaptchaFSM {
+	return NewCaptchaFSMWithStore(NewMemoryStore())
+}
+
+// NewCaptchaFSMWithStore creates a captcha FSM manager keeping states in the store
+func NewCaptchaFSMWithStore(store StateStore) *CaptchaFSM {
 	return &CaptchaFSM{
-		states: make(map[int64]*CaptchaData),
+		store:  store,
+		guards: make(map[CaptchaState][]Guard),
 	}
 }
 
-// SetState sets the captcha state for a user
-func (f *CaptchaFSM) SetState(userID int64, data *CaptchaData) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	f.states[userID] = data
+// AddGuard adds a check to the transitions into the state. Guards and hooks
+// are added before the FSM is used.
+func (f *CaptchaFSM) AddGuard(to CaptchaState, guard Guard) {
+	f.guards[to] = append(f.guards[to], guard)
 }
 
-// GetState gets the captcha state for a user
-func (f *CaptchaFSM) GetState(userID int64) (*CaptchaData, bool) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	data, ok := f.states[userID]
-	return data, ok
+// OnTransition adds a hook called after each transition, e.g. for audit logs
+func (f *CaptchaFSM) OnTransition(hook Hook) {
+	f.hooks = append(f.hooks, hook)
 }
 
-// DeleteState removes the captcha state for a user
-func (f *CaptchaFSM) DeleteState(userID int64) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	delete(f.states, userID)
+// SetState starts the verification in the pending state, replacing any
+// verification with the same key
+func (f *CaptchaFSM) SetState(key CaptchaKey, data *CaptchaData) {
+	data.State = StatePending
+	data.Version = 1
+	if err := f.store.Save(context.Background(), key, data); err != nil {
+		log.Printf("Failed to save captcha state of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+		return
+	}
+	f.notify(StateNone, data)
+}
+
+// Transition moves the verification as read by the caller to the state. The
+// change is applied to a copy of the data first, then the table and guards
+// are checked. The verification is removed when the state is final. The
+// new data is returned.
+func (f *CaptchaFSM) Transition(data *CaptchaData, to CaptchaState, change func(next *CaptchaData), extra ...Guard) (*CaptchaData, error) {
+	next := *data
+	if change != nil {
+		change(&next)
+	}
+	next.State = to
+	next.Version = data.Version + 1
+
+	if err := checkTransition(data, &next, slices.Concat(f.guards[to], extra)); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	key := data.Key()
+
+	var ok bool
+	var err error
+	if to.Final() {
+		ok, err = f.store.Delete(ctx, key, data.Version)
+	} else {
+		ok, err = f.store.Update(ctx, key, data.Version, &next)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to move verification of user %d in chat %d to %q: %w", key.UserID, key.ChatID, to, err)
+	}
+	if !ok {
+		return nil, ErrConflict
+	}
+
+	f.notify(data.State, &next)
+	return &next, nil
+}
+
+// UpdateState saves changes of a verification that stays in its state, e.g.
+// a new challenge. Only verifications waiting for the member are changed and
+// only if they did not change since they were read, so a late update cannot
+// bring back a verification that already ended.
+func (f *CaptchaFSM) UpdateState(key CaptchaKey, data *CaptchaData) bool {
+	if !data.State.Active() {
+		return false
+	}
+
+	next := *data
+	next.Version++
+	ok, err := f.store.Update(context.Background(), key, data.Version, &next)
+	if err != nil {
+		log.Printf("Failed to update captcha state of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+		return false
+	}
+	if ok {
+		data.Version = next.Version
+	}
+	return ok
+}
+
+// GetState gets the verification that waits for the member
+func (f *CaptchaFSM) GetState(key CaptchaKey) (*CaptchaData, bool) {
+	data, err := f.store.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("Failed to get captcha state of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+		return nil, false
+	}
+	if data == nil || !data.State.Active() {
+		return nil, false
+	}
+	return data, true
+}
+
+// Lookup gets the verification in any state, e.g. one waiting for chat
+// admins or kept for an appeal
+func (f *CaptchaFSM) Lookup(key CaptchaKey) (*CaptchaData, bool) {
+	data, err := f.store.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("Failed to get captcha state of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+		return nil, false
+	}
+	return data, data != nil
+}
+
+// FindState gets the verification of the user in the chat that waits for
+// them in any topic, for callers that do not know the topic
+func (f *CaptchaFSM) FindState(chatID, userID int64) (*CaptchaData, bool) {
+	data, err := f.store.Find(context.Background(), chatID, userID)
+	if err != nil {
+		log.Printf("Failed to find captcha state of user %d in chat %d: %v", userID, chatID, err)
+		return nil, false
+	}
+	if data == nil || !data.State.Active() {
+		return nil, false
+	}
+	return data, true
+}
+
+// TakeExpired removes and returns a verification that expired in any state.
+// It clears verifications that ended once they can no longer be appealed.
+func (f *CaptchaFSM) TakeExpired(key CaptchaKey) (*CaptchaData, bool) {
+	data, err := f.store.TakeExpired(context.Background(), key, time.Now())
+	if err != nil {
+		log.Printf("Failed to take expired captcha state of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+		return nil, false
+	}
+	return data, data != nil
 }
 
 // IsExpired checks if the captcha has expired
-func (f *CaptchaFSM) IsExpired(userID int64) bool {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	data, ok := f.states[userID]
+func (f *CaptchaFSM) IsExpired(key CaptchaKey) bool {
+	data, ok := f.GetState(key)
 	if !ok {
 		return true
 	}
 	return time.Now().After(data.ExpiresAt)
 }
 
+// Pending returns all stored verifications, e.g. to resume them after a
+// restart; the ones that ended are kept until they expire
+func (f *CaptchaFSM) Pending(ctx context.Context) ([]*CaptchaData, error) {
+	return f.store.List(ctx)
+}
+
 // CleanupExpired removes all expired states
 func (f *CaptchaFSM) CleanupExpired() {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	now := time.Now()
-	for userID, data := range f.states {
-		if now.After(data.ExpiresAt) {
-			delete(f.states, userID)
-		}
+	states, err := f.Pending(context.Background())
+	if err != nil {
+		log.Printf("Failed to list captcha states: %v", err)
+		return
+	}
+	for _, data := range states {
+		f.TakeExpired(data.Key())
+	}
+}
+
+// notify calls the hooks of a transition
+func (f *CaptchaFSM) notify(from CaptchaState, data *CaptchaData) {
+	for _, hook := range f.hooks {
+		hook(from, data.State, data)
 	}
 }
 
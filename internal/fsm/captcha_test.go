@@ -0,0 +1,257 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pendingData(chatID, userID int64, topicID int) *CaptchaData {
+	return &CaptchaData{
+		ChatID:    chatID,
+		UserID:    userID,
+		TopicID:   topicID,
+		Answer:    fmt.Sprintf("%d/%d/%d", chatID, userID, topicID),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+}
+
+// challengedData starts the verification and delivers its challenge
+func challengedData(t *testing.T, f *CaptchaFSM, data *CaptchaData) *CaptchaData {
+	t.Helper()
+	f.SetState(data.Key(), data)
+	challenged, err := f.Transition(data, StateChallenged, func(next *CaptchaData) {
+		next.PhotoMessageID = 1
+	})
+	if err != nil {
+		t.Fatalf("Failed to challenge %+v: %v", data.Key(), err)
+	}
+	return challenged
+}
+
+func TestStatesOfChatsAreIndependent(t *testing.T) {
+	f := NewCaptchaFSM()
+
+	first := pendingData(-100, 42, 0)
+	second := pendingData(-200, 42, 0)
+	topic := pendingData(-200, 42, 7)
+	for _, data := range []*CaptchaData{first, second, topic} {
+		f.SetState(data.Key(), data)
+	}
+
+	for _, want := range []*CaptchaData{first, second, topic} {
+		got, ok := f.GetState(want.Key())
+		if !ok || got.Answer != want.Answer {
+			t.Errorf("Expected state %q for %+v, got %v", want.Answer, want.Key(), got)
+		}
+	}
+
+	// Ending the verification in one chat keeps the others
+	if _, err := f.Transition(first, StateFailed, nil); err != nil {
+		t.Errorf("Expected the first verification to fail, got %v", err)
+	}
+	if _, err := f.Transition(first, StateFailed, nil); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a second transition from the same read to conflict, got %v", err)
+	}
+	if _, ok := f.GetState(first.Key()); ok {
+		t.Error("Expected the failed verification not to be pending")
+	}
+	if _, ok := f.GetState(second.Key()); !ok {
+		t.Error("Expected the state in the second chat to remain")
+	}
+	if data, ok := f.FindState(-200, 42); !ok || data.ChatID != -200 {
+		t.Errorf("Expected to find the state in the second chat, got %v", data)
+	}
+}
+
+func TestUpdateStateDoesNotRestoreEndedVerification(t *testing.T) {
+	f := NewCaptchaFSM()
+	data := pendingData(-100, 42, 0)
+
+	if f.UpdateState(data.Key(), data) {
+		t.Error("Expected no update without a pending verification")
+	}
+	if _, ok := f.GetState(data.Key()); ok {
+		t.Error("Expected the update not to create a state")
+	}
+
+	f.SetState(data.Key(), data)
+	updated := *data
+	updated.AttemptsLeft = 2
+	if !f.UpdateState(data.Key(), &updated) {
+		t.Error("Expected the pending state to be updated")
+	}
+	if got, _ := f.GetState(data.Key()); got.AttemptsLeft != 2 {
+		t.Errorf("Expected 2 attempts left, got %d", got.AttemptsLeft)
+	}
+}
+
+func TestTakeExpired(t *testing.T) {
+	f := NewCaptchaFSM()
+
+	pending := pendingData(-100, 42, 0)
+	expired := pendingData(-200, 42, 0)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	f.SetState(pending.Key(), pending)
+	f.SetState(expired.Key(), expired)
+
+	if _, ok := f.TakeExpired(pending.Key()); ok {
+		t.Error("Expected a pending state not to be taken")
+	}
+	if data, ok := f.TakeExpired(expired.Key()); !ok || data.Answer != expired.Answer {
+		t.Error("Expected the expired state to be taken")
+	}
+	if _, ok := f.TakeExpired(expired.Key()); ok {
+		t.Error("Expected an expired state to be taken once")
+	}
+}
+
+func TestConcurrentJoins(t *testing.T) {
+	f := NewCaptchaFSM()
+
+	const chats, users = 8, 50
+
+	// Every user joins every chat at the same time and answers in each
+	var wg sync.WaitGroup
+	for chat := range chats {
+		for user := range users {
+			wg.Add(1)
+			go func(chatID, userID int64) {
+				defer wg.Done()
+
+				data := pendingData(chatID, userID, 0)
+				f.SetState(data.Key(), data)
+
+				got, ok := f.GetState(data.Key())
+				if !ok || got.Answer != data.Answer {
+					t.Errorf("User %d in chat %d got state %v", userID, chatID, got)
+					return
+				}
+
+				updated := *got
+				updated.AttemptsLeft = 1
+				if !f.UpdateState(data.Key(), &updated) {
+					t.Errorf("Failed to update state of user %d in chat %d", userID, chatID)
+				}
+			}(int64(-100-chat), int64(user))
+		}
+	}
+	wg.Wait()
+
+	for chat := range chats {
+		for user := range users {
+			key := CaptchaKey{ChatID: int64(-100 - chat), UserID: int64(user)}
+			data, ok := f.GetState(key)
+			if !ok {
+				t.Fatalf("State of %+v was lost", key)
+			}
+			if data.ChatID != key.ChatID || data.UserID != key.UserID || data.AttemptsLeft != 1 {
+				t.Errorf("State of %+v was overwritten: %+v", key, data)
+			}
+		}
+	}
+}
+
+func TestConcurrentAnswersEndVerificationOnce(t *testing.T) {
+	f := NewCaptchaFSM()
+	data := pendingData(-100, 42, 0)
+	data.ExpiresAt = time.Now().Add(-time.Second)
+	data = challengedData(t, f, data)
+
+	// Answers and the timeout race to end the same verification
+	var ended atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			to := StateVerified
+			if i%2 == 1 {
+				to = StateTimedOut
+			}
+			if _, err := f.Transition(data, to, nil); err == nil {
+				ended.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ended.Load() != 1 {
+		t.Errorf("Expected the verification to end once, ended %d times", ended.Load())
+	}
+}
+
+// failingStore fails every operation, like a database that is down
+type failingStore struct{}
+
+var errStoreDown = errors.New("store is down")
+
+func (failingStore) Save(ctx context.Context, key CaptchaKey, data *CaptchaData) error {
+	return errStoreDown
+}
+
+func (failingStore) Update(ctx context.Context, key CaptchaKey, version int, data *CaptchaData) (bool, error) {
+	return false, errStoreDown
+}
+
+func (failingStore) Get(ctx context.Context, key CaptchaKey) (*CaptchaData, error) {
+	return nil, errStoreDown
+}
+
+func (failingStore) Find(ctx context.Context, chatID, userID int64) (*CaptchaData, error) {
+	return nil, errStoreDown
+}
+
+func (failingStore) Delete(ctx context.Context, key CaptchaKey, version int) (bool, error) {
+	return false, errStoreDown
+}
+
+func (failingStore) TakeExpired(ctx context.Context, key CaptchaKey, now time.Time) (*CaptchaData, error) {
+	return nil, errStoreDown
+}
+
+func (failingStore) List(ctx context.Context) ([]*CaptchaData, error) {
+	return nil, errStoreDown
+}
+
+func TestStoreErrorsEndNothing(t *testing.T) {
+	f := NewCaptchaFSMWithStore(failingStore{})
+	data := pendingData(-100, 42, 0)
+
+	f.SetState(data.Key(), data)
+	if _, ok := f.GetState(data.Key()); ok {
+		t.Error("Expected no state when the store fails")
+	}
+	if f.UpdateState(data.Key(), data) {
+		t.Error("Expected updates to report failure")
+	}
+	if _, err := f.Transition(data, StateFailed, nil); !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected the store error from a transition, got %v", err)
+	}
+	if _, ok := f.TakeExpired(data.Key()); ok {
+		t.Error("Expected nothing to be taken when the store fails")
+	}
+	if _, err := f.Pending(context.Background()); !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected the store error from Pending, got %v", err)
+	}
+}
+
+func TestPending(t *testing.T) {
+	f := NewCaptchaFSM()
+	for chat := range 3 {
+		data := pendingData(int64(-100-chat), 42, 0)
+		f.SetState(data.Key(), data)
+	}
+
+	states, err := f.Pending(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list pending verifications: %v", err)
+	}
+	if len(states) != 3 {
+		t.Errorf("Expected 3 pending verifications, got %d", len(states))
+	}
+}
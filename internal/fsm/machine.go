@@ -0,0 +1,132 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CaptchaState represents the FSM state for captcha verification
+type CaptchaState string
+
+const (
+	// StateNone is the state of a verification that did not start yet
+	StateNone CaptchaState = ""
+	// StatePending is a started verification whose challenge is being delivered
+	StatePending CaptchaState = "pending"
+	// StateChallenged waits for the first answer to the challenge
+	StateChallenged CaptchaState = "challenged"
+	// StateRetrying waits for another answer after a wrong one
+	StateRetrying CaptchaState = "retrying"
+	// StateVerified is a member who passed; the verification is removed
+	StateVerified CaptchaState = "verified"
+	// StateFailed is a member who answered wrong or pressed a honeypot
+	StateFailed CaptchaState = "failed"
+	// StateTimedOut is a member who did not answer in time
+	StateTimedOut CaptchaState = "timed_out"
+	// StatePendingApproval waits for a chat admin to let the member in
+	StatePendingApproval CaptchaState = "pending_admin_approval"
+	// StateAppealed is a failed or timed out member asking admins to reconsider
+	StateAppealed CaptchaState = "appealed"
+)
+
+// transitions is the table of allowed transitions. States without outgoing
+// transitions are final, verifications entering them are removed.
+var transitions = map[CaptchaState][]CaptchaState{
+	StateNone:            {StatePending},
+	StatePending:         {StateChallenged, StateFailed, StateTimedOut},
+	StateChallenged:      {StateRetrying, StateVerified, StateFailed, StateTimedOut, StatePendingApproval},
+	StateRetrying:        {StateRetrying, StateVerified, StateFailed, StateTimedOut, StatePendingApproval},
+	StatePendingApproval: {StateVerified, StateFailed},
+	StateFailed:          {StateAppealed},
+	StateTimedOut:        {StateAppealed},
+	StateAppealed:        {StateVerified, StateFailed},
+}
+
+// CanTransition reports whether the table allows going from one state to another
+func CanTransition(from, to CaptchaState) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Final reports whether a verification in the state is over for good
+func (s CaptchaState) Final() bool {
+	return len(transitions[s]) == 0
+}
+
+// Active reports whether the verification waits for the member: its challenge
+// is being delivered or answered
+func (s CaptchaState) Active() bool {
+	return s == StatePending || s == StateChallenged || s == StateRetrying
+}
+
+var (
+	// ErrIllegalTransition is returned for transitions the table does not allow
+	ErrIllegalTransition = errors.New("illegal transition")
+	// ErrConflict is returned when the verification changed since it was read,
+	// e.g. because a concurrent answer or the timeout ended it
+	ErrConflict = errors.New("verification changed concurrently")
+
+	// Errors of the built-in guards
+	ErrNotDelivered   = errors.New("challenge was not delivered")
+	ErrNoAttemptsLeft = errors.New("no attempts left")
+	ErrNotExpired     = errors.New("verification did not expire")
+)
+
+// Guard checks a transition from the current data to the next one and
+// returns an error to reject it
+type Guard func(current, next *CaptchaData) error
+
+// guards are the built-in checks of transitions into a state
+var guards = map[CaptchaState][]Guard{
+	StateChallenged: {challengeDelivered},
+	StateRetrying:   {attemptsLeft},
+	StateTimedOut:   {expired},
+}
+
+// challengeDelivered lets a verification be challenged once its message was sent
+func challengeDelivered(current, next *CaptchaData) error {
+	if next.PhotoMessageID == 0 {
+		return ErrNotDelivered
+	}
+	return nil
+}
+
+// attemptsLeft lets a member retry while they have answers left
+func attemptsLeft(current, next *CaptchaData) error {
+	if next.AttemptsLeft <= 0 {
+		return ErrNoAttemptsLeft
+	}
+	return nil
+}
+
+// expired lets a verification time out once its deadline passed
+func expired(current, next *CaptchaData) error {
+	if time.Now().Before(current.ExpiresAt) {
+		return ErrNotExpired
+	}
+	return nil
+}
+
+// Hook is called after a verification changed its state
+type Hook func(from, to CaptchaState, data *CaptchaData)
+
+// checkTransition checks the table and the guards of a transition
+func checkTransition(current, next *CaptchaData, extra []Guard) error {
+	from, to := current.State, next.State
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w from %q to %q", ErrIllegalTransition, from, to)
+	}
+
+	for _, guard := range slices.Concat(guards[to], extra) {
+		if err := guard(current, next); err != nil {
+			return fmt.Errorf("transition from %q to %q rejected: %w", from, to, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package fsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransitionTable(t *testing.T) {
+	for _, tt := range []struct {
+		from, to CaptchaState
+		allowed  bool
+	}{
+		{StateNone, StatePending, true},
+		{StatePending, StateChallenged, true},
+		{StatePending, StateVerified, false},
+		{StateChallenged, StateRetrying, true},
+		{StateRetrying, StateRetrying, true},
+		{StateRetrying, StatePendingApproval, true},
+		{StatePendingApproval, StateVerified, true},
+		{StatePendingApproval, StateTimedOut, false},
+		{StateFailed, StateAppealed, true},
+		{StateFailed, StateVerified, false},
+		{StateTimedOut, StateAppealed, true},
+		{StateAppealed, StateVerified, true},
+		{StateVerified, StateFailed, false},
+	} {
+		if got := CanTransition(tt.from, tt.to); got != tt.allowed {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.allowed)
+		}
+	}
+
+	if !StateVerified.Final() || StateFailed.Final() {
+		t.Error("Expected only verified members to be final")
+	}
+	if !StateRetrying.Active() || StateFailed.Active() || StatePendingApproval.Active() {
+		t.Error("Expected only verifications waiting for an answer to be active")
+	}
+}
+
+func TestIllegalTransitionChangesNothing(t *testing.T) {
+	f := NewCaptchaFSM()
+	data := pendingData(-100, 42, 0)
+	f.SetState(data.Key(), data)
+
+	if _, err := f.Transition(data, StateVerified, nil); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Expected an illegal transition, got %v", err)
+	}
+	if got, ok := f.GetState(data.Key()); !ok || got.State != StatePending || got.Version != data.Version {
+		t.Errorf("Expected the verification to stay pending, got %+v", got)
+	}
+}
+
+func TestGuards(t *testing.T) {
+	f := NewCaptchaFSM()
+
+	data := pendingData(-100, 42, 0)
+	f.SetState(data.Key(), data)
+	if _, err := f.Transition(data, StateChallenged, nil); !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("Expected a challenge without a message to be rejected, got %v", err)
+	}
+
+	data = challengedData(t, f, data)
+	if _, err := f.Transition(data, StateTimedOut, nil); !errors.Is(err, ErrNotExpired) {
+		t.Errorf("Expected a timeout before the deadline to be rejected, got %v", err)
+	}
+	if _, err := f.Transition(data, StateRetrying, nil); !errors.Is(err, ErrNoAttemptsLeft) {
+		t.Errorf("Expected a retry without attempts to be rejected, got %v", err)
+	}
+
+	// Extra guards are checked after the built-in ones
+	errBanned := errors.New("member is banned")
+	f.AddGuard(StateVerified, func(current, next *CaptchaData) error {
+		return errBanned
+	})
+	if _, err := f.Transition(data, StateVerified, nil); !errors.Is(err, errBanned) {
+		t.Errorf("Expected the added guard to reject the transition, got %v", err)
+	}
+	if _, err := f.Transition(data, StateFailed, nil, func(current, next *CaptchaData) error {
+		return errBanned
+	}); !errors.Is(err, errBanned) {
+		t.Errorf("Expected the guard of the call to reject the transition, got %v", err)
+	}
+	if _, ok := f.GetState(data.Key()); !ok {
+		t.Error("Expected rejected transitions to keep the verification")
+	}
+}
+
+func TestHooksSeeEveryTransition(t *testing.T) {
+	f := NewCaptchaFSM()
+	var seen []CaptchaState
+	f.OnTransition(func(from, to CaptchaState, data *CaptchaData) {
+		seen = append(seen, from, to)
+	})
+
+	data := pendingData(-100, 42, 0)
+	data.AttemptsLeft = 2
+	data = challengedData(t, f, data)
+	data, err := f.Transition(data, StateRetrying, func(next *CaptchaData) {
+		next.AttemptsLeft--
+	})
+	if err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+	if _, err := f.Transition(data, StateVerified, nil); err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+
+	want := []CaptchaState{
+		StateNone, StatePending,
+		StatePending, StateChallenged,
+		StateChallenged, StateRetrying,
+		StateRetrying, StateVerified,
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("Expected transitions %v, got %v", want, seen)
+		}
+	}
+
+	if states, _ := f.Pending(t.Context()); len(states) != 0 {
+		t.Errorf("Expected the verified member to be removed, got %d states", len(states))
+	}
+}
+
+func TestEndedVerificationIsKeptForAppeal(t *testing.T) {
+	f := NewCaptchaFSM()
+	data := challengedData(t, f, pendingData(-100, 42, 0))
+
+	failed, err := f.Transition(data, StateFailed, func(next *CaptchaData) {
+		next.ExpiresAt = time.Now().Add(time.Minute)
+	})
+	if err != nil {
+		t.Fatalf("Failed to fail the verification: %v", err)
+	}
+	if _, ok := f.GetState(data.Key()); ok {
+		t.Error("Expected a failed verification not to wait for answers")
+	}
+	if f.UpdateState(failed.Key(), failed) {
+		t.Error("Expected a failed verification not to be updated")
+	}
+	if kept, ok := f.Lookup(data.Key()); !ok || kept.State != StateFailed {
+		t.Errorf("Expected the failed verification to be kept, got %v", kept)
+	}
+
+	appealed, err := f.Transition(failed, StateAppealed, nil)
+	if err != nil {
+		t.Fatalf("Failed to appeal: %v", err)
+	}
+	if appealed.Version != data.Version+2 {
+		t.Errorf("Expected version %d, got %d", data.Version+2, appealed.Version)
+	}
+	if _, err := f.Transition(appealed, StateVerified, nil); err != nil {
+		t.Errorf("Expected the appeal to be granted, got %v", err)
+	}
+}
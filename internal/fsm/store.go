@@ -0,0 +1,114 @@
+package fsm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StateStore keeps pending verifications. Getters return nil when there is
+// no verification for the key.
+type StateStore interface {
+	// Save stores the verification, replacing any with the same key
+	Save(ctx context.Context, key CaptchaKey, data *CaptchaData) error
+	// Update replaces the verification if it is still at the version and
+	// reports whether it was
+	Update(ctx context.Context, key CaptchaKey, version int, data *CaptchaData) (bool, error)
+	Get(ctx context.Context, key CaptchaKey) (*CaptchaData, error)
+	// Find returns the verification of the user in the chat in any topic
+	Find(ctx context.Context, chatID, userID int64) (*CaptchaData, error)
+	// Delete removes the verification if it is still at the version and
+	// reports whether it was
+	Delete(ctx context.Context, key CaptchaKey, version int) (bool, error)
+	// TakeExpired removes and returns a verification that expired before now
+	TakeExpired(ctx context.Context, key CaptchaKey, now time.Time) (*CaptchaData, error)
+	// List returns all pending verifications
+	List(ctx context.Context) ([]*CaptchaData, error)
+}
+
+// memoryStore keeps copies of verifications in a map; they are lost on restart
+type memoryStore struct {
+	mu     sync.RWMutex
+	states map[CaptchaKey]*CaptchaData
+}
+
+// NewMemoryStore creates a state store that keeps verifications in memory
+func NewMemoryStore() StateStore {
+	return &memoryStore{
+		states: make(map[CaptchaKey]*CaptchaData),
+	}
+}
+
+func (s *memoryStore) Save(ctx context.Context, key CaptchaKey, data *CaptchaData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = copyData(data)
+	return nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, key CaptchaKey, version int, data *CaptchaData) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.states[key]; !ok || stored.Version != version {
+		return false, nil
+	}
+	s.states[key] = copyData(data)
+	return true, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key CaptchaKey) (*CaptchaData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyData(s.states[key]), nil
+}
+
+func (s *memoryStore) Find(ctx context.Context, chatID, userID int64) (*CaptchaData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, data := range s.states {
+		if key.ChatID == chatID && key.UserID == userID {
+			return copyData(data), nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key CaptchaKey, version int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.states[key]; !ok || stored.Version != version {
+		return false, nil
+	}
+	delete(s.states, key)
+	return true, nil
+}
+
+func (s *memoryStore) TakeExpired(ctx context.Context, key CaptchaKey, now time.Time) (*CaptchaData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.states[key]
+	if !ok || !now.After(data.ExpiresAt) {
+		return nil, nil
+	}
+	delete(s.states, key)
+	return data, nil
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]*CaptchaData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make([]*CaptchaData, 0, len(s.states))
+	for _, data := range s.states {
+		states = append(states, copyData(data))
+	}
+	return states, nil
+}
+
+// copyData copies a verification, so callers cannot change stored ones
+func copyData(data *CaptchaData) *CaptchaData {
+	if data == nil {
+		return nil
+	}
+	copied := *data
+	return &copied
+}
@@ -0,0 +1,322 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gofency/internal/scheduler"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// jobDeleteMessages deletes the due messages of a chat in one request
+	jobDeleteMessages = "delete_messages"
+
+	// batchWindow lets messages due shortly after the first one go with it
+	batchWindow = 2 * time.Second
+	// maxBatchSize is the most messages Telegram deletes in one request
+	maxBatchSize = 100
+	// maxDeleteAttempts is how many times a deletion is tried on transient errors
+	maxDeleteAttempts = 5
+	// deleteLease is how long deletions stay in flight; when the bot stops
+	// before removing them, they are due again after it
+	deleteLease = time.Minute
+
+	// MinTTL and MaxTTL bound the TTL of chat messages; bots may only
+	// delete messages younger than 48 hours
+	MinTTL = time.Second
+	MaxTTL = 47 * time.Hour
+)
+
+// Deletion is a message waiting to be deleted
+type Deletion struct {
+	ChatID    int64
+	MessageID int
+	DeleteAt  time.Time
+	// Attempts is how many times deleting the message failed
+	Attempts int
+}
+
+// DeletionStore keeps pending deletions, so they happen after a restart
+type DeletionStore interface {
+	// Add stores the deletion, replacing one of the same message
+	Add(ctx context.Context, deletion *Deletion) error
+	// LeaseDue returns up to limit deletions of the chat due before now and
+	// postpones them to until, so no one else takes them while in flight
+	LeaseDue(ctx context.Context, chatID int64, now, until time.Time, limit int) ([]*Deletion, error)
+	// Remove removes the deletions of the messages in the chat
+	Remove(ctx context.Context, chatID int64, messageIDs []int) error
+	// Next returns when the next deletion in the chat is due, zero when none is
+	Next(ctx context.Context, chatID int64) (time.Time, error)
+	// List returns all pending deletions
+	List(ctx context.Context) ([]*Deletion, error)
+}
+
+// TTLSource returns the TTL a chat set for bot messages, 0 when it did not
+type TTLSource func(ctx context.Context, chatID int64) (time.Duration, error)
+
+// Service sends bot messages that delete themselves after a TTL. Deletions
+// of a chat are batched in one request from a scheduler job.
+type Service struct {
+	api       *bot.Bot
+	store     DeletionStore
+	scheduler *scheduler.Scheduler
+	chatTTL   TTLSource
+}
+
+// NewService creates the messages service and registers its job with the scheduler
+func NewService(api *bot.Bot, store DeletionStore, s *scheduler.Scheduler, chatTTL TTLSource) *Service {
+	service := &Service{
+		api:       api,
+		store:     store,
+		scheduler: s,
+		chatTTL:   chatTTL,
+	}
+	s.Handle(jobDeleteMessages, service.deleteDue)
+	return service
+}
+
+// Send sends the message and deletes it after the TTL set for the chat, or
+// after ttl when the chat has none
+func (s *Service) Send(ctx context.Context, params *bot.SendMessageParams, ttl time.Duration) (*models.Message, error) {
+	msg, err := s.api.SendMessage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	s.DeleteLater(ctx, msg.Chat.ID, msg.ID, ttl)
+	return msg, nil
+}
+
+// Delete deletes a message right away, with the retries of delayed deletions
+func (s *Service) Delete(ctx context.Context, chatID int64, messageID int) {
+	s.add(ctx, &Deletion{ChatID: chatID, MessageID: messageID, DeleteAt: time.Now()})
+}
+
+// DeleteLater deletes a message after the TTL set for the chat, or after ttl
+// when the chat has none
+func (s *Service) DeleteLater(ctx context.Context, chatID int64, messageID int, ttl time.Duration) {
+	s.add(ctx, &Deletion{
+		ChatID:    chatID,
+		MessageID: messageID,
+		DeleteAt:  time.Now().Add(s.TTL(ctx, chatID, ttl)),
+	})
+}
+
+// add stores the deletion and schedules the deletion job of its chat
+func (s *Service) add(ctx context.Context, deletion *Deletion) {
+	if err := s.store.Add(ctx, deletion); err != nil {
+		log.Printf("Failed to store deletion of message %d in chat %d: %v", deletion.MessageID, deletion.ChatID, err)
+		return
+	}
+
+	s.scheduleChat(ctx, deletion.ChatID)
+}
+
+// TTL returns the TTL of bot messages in the chat, fallback when the chat did not set one
+func (s *Service) TTL(ctx context.Context, chatID int64, fallback time.Duration) time.Duration {
+	if s.chatTTL == nil {
+		return fallback
+	}
+
+	ttl, err := s.chatTTL(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to get message TTL of chat %d: %v", chatID, err)
+		return fallback
+	}
+	if ttl <= 0 {
+		return fallback
+	}
+	return ttl
+}
+
+// Resume schedules the deletions pending when the bot stopped; the overdue
+// ones happen as soon as the scheduler runs
+func (s *Service) Resume(ctx context.Context) error {
+	deletions, err := s.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pending deletions: %w", err)
+	}
+
+	chats := make(map[int64]bool)
+	for _, deletion := range deletions {
+		if !chats[deletion.ChatID] {
+			chats[deletion.ChatID] = true
+			s.scheduleChat(ctx, deletion.ChatID)
+		}
+	}
+
+	log.Printf("Resumed %d pending message deletions in %d chats", len(deletions), len(chats))
+	return nil
+}
+
+// scheduleChat (re)schedules the deletion job of the chat at its next deletion
+func (s *Service) scheduleChat(ctx context.Context, chatID int64) {
+	next, err := s.store.Next(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to get next deletion in chat %d: %v", chatID, err)
+		return
+	}
+	if next.IsZero() {
+		return
+	}
+
+	id := fmt.Sprintf("%s:%d", jobDeleteMessages, chatID)
+	if _, err := s.scheduler.Schedule(ctx, id, jobDeleteMessages, next, chatID); err != nil {
+		log.Printf("Failed to schedule message deletion in chat %d: %v", chatID, err)
+	}
+}
+
+// deleteDue deletes the messages of the chat that are due, retries the ones
+// that failed transiently and schedules the next run
+func (s *Service) deleteDue(ctx context.Context, job *scheduler.Job) error {
+	var chatID int64
+	if err := job.Decode(&chatID); err != nil {
+		return err
+	}
+	defer s.scheduleChat(ctx, chatID)
+
+	for {
+		now := time.Now()
+		deletions, err := s.store.LeaseDue(ctx, chatID, now.Add(batchWindow), now.Add(deleteLease), maxBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to lease due deletions in chat %d: %w", chatID, err)
+		}
+		if len(deletions) == 0 {
+			return nil
+		}
+
+		messageIDs := make([]int, len(deletions))
+		for i, deletion := range deletions {
+			messageIDs[i] = deletion.MessageID
+		}
+
+		// Messages that are already gone are skipped by Telegram
+		_, err = s.api.DeleteMessages(ctx, &bot.DeleteMessagesParams{
+			ChatID:     chatID,
+			MessageIDs: messageIDs,
+		})
+		if err != nil {
+			s.retry(ctx, deletions, err)
+			return nil
+		}
+		s.remove(ctx, chatID, deletions)
+
+		if len(deletions) < maxBatchSize {
+			return nil
+		}
+	}
+}
+
+// remove removes the deletions that are done; the ones it fails to remove
+// are tried again when their lease ends
+func (s *Service) remove(ctx context.Context, chatID int64, deletions []*Deletion) {
+	if len(deletions) == 0 {
+		return
+	}
+
+	messageIDs := make([]int, len(deletions))
+	for i, deletion := range deletions {
+		messageIDs[i] = deletion.MessageID
+	}
+	if err := s.store.Remove(ctx, chatID, messageIDs); err != nil {
+		log.Printf("Failed to remove %d deletions in chat %d: %v", len(deletions), chatID, err)
+	}
+}
+
+// retry stores the deletions again after a transient error, with a backoff
+// or the delay Telegram asked for. After a permanent error a batch is
+// deleted message by message, so one message that can't be deleted does not
+// keep the others; a single message is dropped.
+func (s *Service) retry(ctx context.Context, deletions []*Deletion, err error) {
+	chatID := deletions[0].ChatID
+	if !isTransient(err) {
+		if len(deletions) > 1 {
+			log.Printf("Failed to delete %d messages in chat %d, deleting them one by one: %v", len(deletions), chatID, err)
+			s.deleteEach(ctx, deletions)
+			return
+		}
+		log.Printf("Failed to delete message %d in chat %d, giving up: %v", deletions[0].MessageID, chatID, err)
+		s.remove(ctx, chatID, deletions)
+		return
+	}
+
+	delay := time.Duration(1<<min(deletions[0].Attempts, 6)) * time.Second
+	var tooManyRequests *bot.TooManyRequestsError
+	if errors.As(err, &tooManyRequests) {
+		delay = max(delay, time.Duration(tooManyRequests.RetryAfter)*time.Second)
+	}
+
+	retried := 0
+	var dropped []*Deletion
+	for _, deletion := range deletions {
+		deletion.Attempts++
+		if deletion.Attempts >= maxDeleteAttempts {
+			log.Printf("Failed to delete message %d in chat %d after %d attempts: %v", deletion.MessageID, chatID, deletion.Attempts, err)
+			dropped = append(dropped, deletion)
+			continue
+		}
+
+		deletion.DeleteAt = time.Now().Add(delay)
+		if err := s.store.Add(ctx, deletion); err != nil {
+			log.Printf("Failed to store deletion of message %d in chat %d: %v", deletion.MessageID, chatID, err)
+			continue
+		}
+		retried++
+	}
+	s.remove(ctx, chatID, dropped)
+
+	log.Printf("Failed to delete %d messages in chat %d, retrying %d in %v: %v", len(deletions), chatID, retried, delay, err)
+}
+
+// deleteEach deletes the messages of a batch Telegram rejected one at a time
+// and drops the ones it rejects again
+func (s *Service) deleteEach(ctx context.Context, deletions []*Deletion) {
+	chatID := deletions[0].ChatID
+	var done []*Deletion
+	for i, deletion := range deletions {
+		_, err := s.api.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    chatID,
+			MessageID: deletion.MessageID,
+		})
+		if err != nil && isTransient(err) {
+			// The rest would most likely fail the same way
+			s.retry(ctx, deletions[i:], err)
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to delete message %d in chat %d, giving up: %v", deletion.MessageID, chatID, err)
+		}
+		done = append(done, deletion)
+	}
+	s.remove(ctx, chatID, done)
+}
+
+// isTransient reports whether a failed request may succeed when repeated:
+// rate limits, server and network errors. Requests Telegram rejected are not.
+func isTransient(err error) bool {
+	for _, permanent := range []error{bot.ErrorBadRequest, bot.ErrorForbidden, bot.ErrorUnauthorized, bot.ErrorNotFound} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return !bot.IsMigrateError(err)
+}
+
+type serviceKey struct{}
+
+// WithService returns a new context with the messages service
+func WithService(ctx context.Context, s *Service) context.Context {
+	return context.WithValue(ctx, serviceKey{}, s)
+}
+
+// GetService returns the messages service from the context
+func GetService(ctx context.Context) (*Service, bool) {
+	s, ok := ctx.Value(serviceKey{}).(*Service)
+	return s, ok
+}
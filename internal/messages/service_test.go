@@ -0,0 +1,237 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gofency/internal/scheduler"
+
+	"github.com/go-telegram/bot"
+)
+
+// fakeTelegram answers deleteMessages and deleteMessage requests with the
+// queued responses, then with success, and records the deleted message IDs
+// of each request
+type fakeTelegram struct {
+	mu        sync.Mutex
+	responses []string
+	requests  [][]int
+	received  chan struct{}
+	// onRequest runs before a request is answered
+	onRequest func()
+}
+
+func newFakeTelegram(responses ...string) *fakeTelegram {
+	return &fakeTelegram{responses: responses, received: make(chan struct{}, 100)}
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ids []int
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/deleteMessages"):
+		if err := json.Unmarshal([]byte(r.FormValue("message_ids")), &ids); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case strings.HasSuffix(r.URL.Path, "/deleteMessage"):
+		id, err := strconv.Atoi(r.FormValue("message_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ids = []int{id}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if f.onRequest != nil {
+		f.onRequest()
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, ids)
+	response := `{"ok":true,"result":true}`
+	if len(f.responses) > 0 {
+		response, f.responses = f.responses[0], f.responses[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, response)
+	f.received <- struct{}{}
+}
+
+// wait waits for n requests and returns the message IDs of all requests
+func (f *fakeTelegram) wait(t *testing.T, n int, timeout time.Duration) [][]int {
+	t.Helper()
+	for range n {
+		select {
+		case <-f.received:
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %d delete requests", n)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.requests)
+}
+
+// newTestService runs a messages service against the fake Telegram
+func newTestService(t *testing.T, fake *fakeTelegram, chatTTL TTLSource) (*Service, DeletionStore) {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	api, err := bot.New("test-token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store := NewMemoryStore()
+	s := scheduler.New(nil, 1)
+	service := NewService(api, store, s, chatTTL)
+	go s.Run(ctx)
+	return service, store
+}
+
+func TestDeletionsAreBatchedPerChat(t *testing.T) {
+	fake := newFakeTelegram()
+	service, store := newTestService(t, fake, nil)
+	ctx := context.Background()
+
+	// Messages due close together go in one request per chat
+	service.DeleteLater(ctx, -100, 1, 50*time.Millisecond)
+	service.DeleteLater(ctx, -100, 2, 60*time.Millisecond)
+	service.DeleteLater(ctx, -100, 3, 70*time.Millisecond)
+	service.DeleteLater(ctx, -200, 4, 50*time.Millisecond)
+
+	requests := fake.wait(t, 2, 2*time.Second)
+	var sizes []int
+	for _, ids := range requests {
+		sizes = append(sizes, len(ids))
+	}
+	slices.Sort(sizes)
+	if !slices.Equal(sizes, []int{1, 3}) {
+		t.Errorf("Expected one request per chat, got %v", requests)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := store.List(ctx); len(pending) != 0 {
+		t.Errorf("Expected no pending deletions, got %d", len(pending))
+	}
+}
+
+func TestDeletionsStayStoredWhileInFlight(t *testing.T) {
+	fake := newFakeTelegram()
+	service, store := newTestService(t, fake, nil)
+	ctx := context.Background()
+
+	inFlight := make(chan []*Deletion, 1)
+	fake.onRequest = func() {
+		pending, _ := store.List(ctx)
+		again, _ := store.LeaseDue(ctx, -100, time.Now().Add(batchWindow), time.Now().Add(deleteLease), maxBatchSize)
+		if len(again) != 0 {
+			t.Errorf("Expected a deletion in flight not to be leased again, got %d", len(again))
+		}
+		inFlight <- pending
+	}
+
+	service.DeleteLater(ctx, -100, 1, 0)
+
+	fake.wait(t, 1, 2*time.Second)
+	if pending := <-inFlight; len(pending) != 1 || pending[0].DeleteAt.Before(time.Now()) {
+		t.Errorf("Expected the deletion to be stored and leased during the request, got %v", pending)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := store.List(ctx); len(pending) != 0 {
+		t.Errorf("Expected the deletion to be removed after the request, %d pending", len(pending))
+	}
+}
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	fake := newFakeTelegram(`{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":1}}`)
+	service, store := newTestService(t, fake, nil)
+	ctx := context.Background()
+
+	service.DeleteLater(ctx, -100, 1, 0)
+
+	requests := fake.wait(t, 2, 5*time.Second)
+	if len(requests) != 2 || !slices.Equal(requests[1], []int{1}) {
+		t.Errorf("Expected the deletion to be retried, got %v", requests)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := store.List(ctx); len(pending) != 0 {
+		t.Errorf("Expected no pending deletions after the retry, got %d", len(pending))
+	}
+}
+
+func TestPermanentErrorsAreDropped(t *testing.T) {
+	fake := newFakeTelegram(`{"ok":false,"error_code":400,"description":"Bad Request: message can't be deleted"}`)
+	service, store := newTestService(t, fake, nil)
+	ctx := context.Background()
+
+	service.DeleteLater(ctx, -100, 1, 0)
+
+	fake.wait(t, 1, 2*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := store.List(ctx); len(pending) != 0 {
+		t.Errorf("Expected the deletion to be dropped, %d pending", len(pending))
+	}
+}
+
+func TestRejectedBatchIsDeletedOneByOne(t *testing.T) {
+	const rejected = `{"ok":false,"error_code":400,"description":"Bad Request: message can't be deleted"}`
+	fake := newFakeTelegram(rejected, `{"ok":true,"result":true}`, rejected)
+	service, store := newTestService(t, fake, nil)
+	ctx := context.Background()
+
+	// One message that can't be deleted must not keep the others
+	service.DeleteLater(ctx, -100, 1, 50*time.Millisecond)
+	service.DeleteLater(ctx, -100, 2, 50*time.Millisecond)
+	service.DeleteLater(ctx, -100, 3, 50*time.Millisecond)
+
+	requests := fake.wait(t, 4, 2*time.Second)
+	if want := [][]int{{1, 2, 3}, {1}, {2}, {3}}; fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("Expected the batch to be deleted one by one, got %v", requests)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pending, _ := store.List(ctx); len(pending) != 0 {
+		t.Errorf("Expected every deletion to be done or dropped, %d pending", len(pending))
+	}
+}
+
+func TestChatTTL(t *testing.T) {
+	chatTTL := func(ctx context.Context, chatID int64) (time.Duration, error) {
+		if chatID == -100 {
+			return time.Minute, nil
+		}
+		return 0, nil
+	}
+	service, _ := newTestService(t, newFakeTelegram(), chatTTL)
+	ctx := context.Background()
+
+	if ttl := service.TTL(ctx, -100, 10*time.Second); ttl != time.Minute {
+		t.Errorf("Expected the TTL of the chat, got %v", ttl)
+	}
+	if ttl := service.TTL(ctx, -200, 10*time.Second); ttl != 10*time.Second {
+		t.Errorf("Expected the default TTL for a chat without one, got %v", ttl)
+	}
+}
@@ -0,0 +1,93 @@
+package messages
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// deletionKey identifies the message of a deletion
+type deletionKey struct {
+	ChatID    int64
+	MessageID int
+}
+
+// memoryStore keeps deletions in a map; they are lost on restart
+type memoryStore struct {
+	mu        sync.Mutex
+	deletions map[deletionKey]*Deletion
+}
+
+// NewMemoryStore creates a deletion store that keeps deletions in memory
+func NewMemoryStore() DeletionStore {
+	return &memoryStore{
+		deletions: make(map[deletionKey]*Deletion),
+	}
+}
+
+func (s *memoryStore) Add(ctx context.Context, deletion *Deletion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *deletion
+	s.deletions[deletionKey{deletion.ChatID, deletion.MessageID}] = &stored
+	return nil
+}
+
+func (s *memoryStore) LeaseDue(ctx context.Context, chatID int64, now, until time.Time, limit int) ([]*Deletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Deletion
+	for _, deletion := range s.deletions {
+		if deletion.ChatID == chatID && !deletion.DeleteAt.After(now) {
+			due = append(due, deletion)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].DeleteAt.Before(due[j].DeleteAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	leased := make([]*Deletion, len(due))
+	for i, deletion := range due {
+		deletion.DeleteAt = until
+		copied := *deletion
+		leased[i] = &copied
+	}
+	return leased, nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, chatID int64, messageIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, messageID := range messageIDs {
+		delete(s.deletions, deletionKey{chatID, messageID})
+	}
+	return nil
+}
+
+func (s *memoryStore) Next(ctx context.Context, chatID int64) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, deletion := range s.deletions {
+		if deletion.ChatID == chatID && (next.IsZero() || deletion.DeleteAt.Before(next)) {
+			next = deletion.DeleteAt
+		}
+	}
+	return next, nil
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]*Deletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deletions := make([]*Deletion, 0, len(s.deletions))
+	for _, deletion := range s.deletions {
+		deletions = append(deletions, deletion)
+	}
+	return deletions, nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// CaptchaState is a verification of a user in a chat topic. The
+// verification data is stored as JSON, only the key, state, version and
+// deadline are queried.
+type CaptchaState struct {
+	ChatID    int64     `gorm:"primaryKey;autoIncrement:false;column:chat_id" json:"chat_id"`
+	UserID    int64     `gorm:"primaryKey;autoIncrement:false;column:user_id" json:"user_id"`
+	TopicID   int       `gorm:"primaryKey;autoIncrement:false;column:topic_id" json:"topic_id"`
+	State     string    `gorm:"type:varchar(32);not null;default:''" json:"state"`
+	Version   int       `gorm:"not null;default:0" json:"version"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	Data      string    `gorm:"type:text;not null" json:"data"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (CaptchaState) TableName() string {
+	return "captcha_states"
+}
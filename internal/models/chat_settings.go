@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+type ChatSettings struct {
+	ChatID         int64  `gorm:"primaryKey;column:chat_id" json:"chat_id"`
+	Difficulty     string `gorm:"type:varchar(16)" json:"difficulty"`
+	ChallengeTypes string `gorm:"type:varchar(255)" json:"challenge_types"`
+	Language       string `gorm:"type:varchar(10)" json:"language"`
+	MessageTTL     int    `gorm:"column:message_ttl" json:"message_ttl"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (ChatSettings) TableName() string {
+	return "chat_settings"
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// PendingDeletion is a bot message waiting to be deleted
+type PendingDeletion struct {
+	ChatID    int64     `gorm:"primaryKey;autoIncrement:false;column:chat_id" json:"chat_id"`
+	MessageID int       `gorm:"primaryKey;autoIncrement:false;column:message_id" json:"message_id"`
+	DeleteAt  time.Time `gorm:"index;not null" json:"delete_at"`
+	Attempts  int       `gorm:"not null;default:0" json:"attempts"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (PendingDeletion) TableName() string {
+	return "pending_deletions"
+}
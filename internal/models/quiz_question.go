@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// QuizQuestion is a custom captcha question of a chat. Answers and choices
+// are stored one per line.
+type QuizQuestion struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	ChatID    int64  `gorm:"index;not null;column:chat_id" json:"chat_id"`
+	Question  string `gorm:"type:text;not null" json:"question"`
+	Answers   string `gorm:"type:text;not null" json:"answers"`
+	Choices   string `gorm:"type:text" json:"choices"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (QuizQuestion) TableName() string {
+	return "quiz_questions"
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// ScheduledJob is a delayed action of the scheduler waiting to run
+type ScheduledJob struct {
+	ID        string    `gorm:"primaryKey;size:255" json:"id"`
+	Kind      string    `gorm:"size:64;not null" json:"kind"`
+	RunAt     time.Time `gorm:"index;not null" json:"run_at"`
+	Payload   string    `gorm:"type:text;not null" json:"payload"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (ScheduledJob) TableName() string {
+	return "scheduled_jobs"
+}
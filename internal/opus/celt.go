@@ -0,0 +1,816 @@
+package opus
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// CELT parameters of 20 ms mono frames at 48 kHz, see RFC 6716 section 4.3
+const (
+	celtSampleRate  = 48000
+	celtFrameSize   = 960
+	celtOverlap     = 120
+	celtLM          = 3
+	celtMaxFineBits = 8
+	celtFineOffset  = 21
+	celtPreemphasis = 0.8500061
+	celtIntraBeta   = 4915.0 / 32768
+	celtSpread      = 2
+	celtTrim        = 5
+)
+
+// celtEncoder encodes narrowband audio into CELT frames. Every frame codes
+// its energies without prediction from the previous one, so the only state
+// is the overlap of the transform and the pre-emphasis filter.
+type celtEncoder struct {
+	history [celtOverlap]float64
+	last    float64
+}
+
+// celtTransform holds the window and the forward MDCT basis of the coded bins
+type celtTransform struct {
+	window [celtOverlap]float64
+	basis  [][]float64
+}
+
+var celtTransformOnce = sync.OnceValue(func() *celtTransform {
+	t := &celtTransform{}
+	for i := range t.window {
+		s := math.Sin(0.5 * math.Pi * (float64(i) + 0.5) / celtOverlap)
+		t.window[i] = math.Sin(0.5 * math.Pi * s * s)
+	}
+
+	// The transform sees the overlap of the previous frame followed by the
+	// new samples, centred in a block of twice the frame size
+	const (
+		size    = 2 * celtFrameSize
+		padding = (celtFrameSize - celtOverlap) / 2
+	)
+	t.basis = make([][]float64, celtBandEdges[celtEndBand]<<celtLM)
+	for k := range t.basis {
+		t.basis[k] = make([]float64, celtFrameSize+celtOverlap)
+		for i := range t.basis[k] {
+			n := float64(i + padding)
+			t.basis[k][i] = math.Cos(2*math.Pi/size*(n+0.5+size/4)*(float64(k)+0.5)) * 4 / size
+		}
+	}
+	return t
+})
+
+// encode codes a frame of celtFrameSize samples into exactly size bytes
+func (c *celtEncoder) encode(pcm []float64, size int) []byte {
+	t := celtTransformOnce()
+
+	input := make([]float64, celtOverlap+celtFrameSize)
+	copy(input, c.history[:])
+	for i, sample := range pcm {
+		input[celtOverlap+i] = sample - celtPreemphasis*c.last
+		c.last = sample
+	}
+	copy(c.history[:], input[celtFrameSize:])
+
+	for i := range celtOverlap {
+		input[i] *= t.window[i]
+		input[len(input)-1-i] *= t.window[i]
+	}
+
+	coeffs := make([]float64, len(t.basis))
+	for k, basis := range t.basis {
+		var sum float64
+		for i, v := range input {
+			sum += v * basis[i]
+		}
+		coeffs[k] = sum
+	}
+
+	silent := true
+	for _, v := range input {
+		silent = silent && math.Abs(v) < 1
+	}
+
+	var bandE, logE [celtBands]float64
+	for band := range celtEndBand {
+		energy := 1e-27
+		for _, v := range coeffs[celtBandEdges[band]<<celtLM : celtBandEdges[band+1]<<celtLM] {
+			energy += v * v
+		}
+		bandE[band] = math.Sqrt(energy)
+		logE[band] = math.Log2(bandE[band]) - celtEnergyMeans[band]
+	}
+
+	enc := newRangeEncoder(size)
+	frame := &celtFrame{enc: enc, totalBits: size * 8}
+
+	enc.encodeBitLogP(silent, 15)
+	if silent {
+		data, _ := enc.done()
+		return data
+	}
+
+	frame.encodeHeader()
+	frame.encodeCoarseEnergy(logE)
+	frame.encodeAllocationHeader()
+	frame.allocate()
+	frame.encodeFineEnergy(logE)
+
+	for band := range celtEndBand {
+		for i := celtBandEdges[band] << celtLM; i < celtBandEdges[band+1]<<celtLM; i++ {
+			coeffs[i] /= bandE[band]
+		}
+	}
+	frame.encodeBands(coeffs)
+	frame.finalizeFineEnergy(logE)
+
+	// The budget checks above keep every symbol inside the frame
+	data, _ := enc.done()
+	return data
+}
+
+// celtFrame is the state of the frame being coded, the budgets are derived
+// from what is already written exactly as the decoder derives them
+type celtFrame struct {
+	enc       *rangeEncoder
+	totalBits int
+
+	// energy is the band energy as the decoder will reconstruct it
+	energy [celtBands]float64
+
+	pulses       [celtBands]int
+	fineQuant    [celtBands]int
+	finePriority [celtBands]int
+	balance      int
+	codedBands   int
+}
+
+// encodeHeader codes the frame flags: no post-filter, no transient and intra
+// coded energies
+func (f *celtFrame) encodeHeader() {
+	if f.enc.tell()+16 <= f.totalBits {
+		f.enc.encodeBitLogP(false, 1)
+	}
+	if f.enc.tell()+3 <= f.totalBits {
+		f.enc.encodeBitLogP(false, 3)
+	}
+	if f.enc.tell()+3 <= f.totalBits {
+		f.enc.encodeBitLogP(true, 3)
+	}
+}
+
+// encodeCoarseEnergy codes the band energies in whole steps of 6 dB
+func (f *celtFrame) encodeCoarseEnergy(logE [celtBands]float64) {
+	var prediction float64
+	for band := range celtEndBand {
+		q := int(math.Floor(logE[band] - prediction + 0.5))
+
+		// Leave enough room for the remaining bands
+		tell := f.enc.tell()
+		if left := f.totalBits - tell - 3*(celtEndBand-band); band > 0 && left < 30 {
+			if left < 24 {
+				q = min(q, 1)
+			}
+			if left < 16 {
+				q = max(q, -1)
+			}
+		}
+
+		switch left := f.totalBits - tell; {
+		case left >= 15:
+			q = f.enc.encodeLaplace(q, celtIntraProbModel[2*band]<<7, celtIntraProbModel[2*band+1]<<6)
+		case left >= 2:
+			q = max(-1, min(q, 1))
+			f.enc.encodeICDF(2*q^-boolInt(q < 0), celtSmallEnergyICDF, 2)
+		case left >= 1:
+			q = min(q, 0)
+			f.enc.encodeBitLogP(q < 0, 1)
+		default:
+			q = -1
+		}
+
+		f.energy[band] = prediction + float64(q)
+		prediction += float64(q) - celtIntraBeta*float64(q)
+	}
+}
+
+// encodeAllocationHeader codes the time-frequency resolution, spreading,
+// band boosts and allocation trim, all at their defaults
+func (f *celtFrame) encodeAllocationHeader() {
+	tell := f.enc.tell()
+	budget := f.totalBits
+	if tell+4+1 <= budget {
+		// The tf_select bit is reserved but never coded for long frames
+		budget--
+	}
+	logP := uint(4)
+	for range celtEndBand {
+		if tell+int(logP) <= budget {
+			f.enc.encodeBitLogP(false, logP)
+			tell = f.enc.tell()
+		}
+		logP = 5
+	}
+
+	if f.enc.tell()+4 <= f.totalBits {
+		f.enc.encodeICDF(celtSpread, celtSpreadICDF, 5)
+	}
+
+	totalEighths := f.totalBits << bitResolution
+	for range celtEndBand {
+		if f.enc.tellFrac()+6<<bitResolution < totalEighths {
+			f.enc.encodeBitLogP(false, 6)
+		}
+	}
+
+	if f.enc.tellFrac()+6<<bitResolution <= totalEighths {
+		f.enc.encodeICDF(celtTrim, celtTrimICDF, 7)
+	}
+}
+
+// celtBandWidth returns the width of the band in bins of a 2.5 ms frame
+func celtBandWidth(band int) int {
+	return celtBandEdges[band+1] - celtBandEdges[band]
+}
+
+// celtBandCap returns the largest allocation of the band in 1/8 bits
+func celtBandCap(band int) int {
+	return (celtCaps[band] + 64) * (celtBandWidth(band) << celtLM) >> 2
+}
+
+// allocate splits the remaining bits between the bands as in RFC 6716
+// section 4.3.3, coding the skip decision so that every band is kept
+func (f *celtFrame) allocate() {
+	const (
+		start   = 0
+		end     = celtEndBand
+		floor   = 1 << bitResolution
+		maxStep = 1 << 6
+	)
+
+	total := max(f.totalBits<<bitResolution-f.enc.tellFrac()-1, 0)
+	skipReserved := 0
+	if total >= 1<<bitResolution {
+		skipReserved = 1 << bitResolution
+	}
+	total -= skipReserved
+
+	var threshold, trimOffset, caps [celtBands]int
+	for band := start; band < end; band++ {
+		width := celtBandWidth(band)
+		threshold[band] = max(floor, (3*width<<celtLM<<bitResolution)>>4)
+		trimOffset[band] = width * (celtTrim - 5 - celtLM) * (end - band - 1) * (1 << (celtLM + bitResolution)) >> 6
+		caps[band] = celtBandCap(band)
+	}
+
+	vectorBits := func(vector, band int) int {
+		b := celtBandWidth(band) * celtAllocation[vector][band] << celtLM >> 2
+		if b > 0 {
+			b = max(0, b+trimOffset[band])
+		}
+		return b
+	}
+
+	// Find the last static allocation vector that fits
+	lo, hi := 1, len(celtAllocation)-1
+	for lo <= hi {
+		mid := (lo + hi) >> 1
+		sum := 0
+		done := false
+		for band := end - 1; band >= start; band-- {
+			b := vectorBits(mid, band)
+			if b >= threshold[band] || done {
+				done = true
+				sum += min(b, caps[band])
+			} else if b >= floor {
+				sum += floor
+			}
+		}
+		if sum > total {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+	hi = lo
+	lo--
+
+	var bits1, bits2 [celtBands]int
+	for band := start; band < end; band++ {
+		b1 := vectorBits(lo, band)
+		b2 := caps[band]
+		if hi < len(celtAllocation) {
+			b2 = vectorBits(hi, band)
+		}
+		bits1[band] = b1
+		bits2[band] = max(0, b2-b1)
+	}
+
+	// Interpolate between the two vectors in 1/64 steps
+	lo, hi = 0, maxStep
+	for range 6 {
+		mid := (lo + hi) >> 1
+		sum := 0
+		done := false
+		for band := end - 1; band >= start; band-- {
+			b := bits1[band] + mid*bits2[band]>>6
+			if b >= threshold[band] || done {
+				done = true
+				sum += min(b, caps[band])
+			} else if b >= floor {
+				sum += floor
+			}
+		}
+		if sum > total {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	var allocation [celtBands]int
+	sum := 0
+	done := false
+	for band := end - 1; band >= start; band-- {
+		b := bits1[band] + lo*bits2[band]>>6
+		if b < threshold[band] && !done {
+			if b >= floor {
+				b = floor
+			} else {
+				b = 0
+			}
+		} else {
+			done = true
+		}
+		allocation[band] = min(b, caps[band])
+		sum += allocation[band]
+	}
+
+	// The decoder drops bands from the top until one is worth its bits and
+	// the encoder tells it to keep that one
+	codedBands := end
+	for {
+		band := codedBands - 1
+		if band <= start {
+			total += skipReserved
+			break
+		}
+
+		left := total - sum
+		perBin := left / (celtBandEdges[codedBands] - celtBandEdges[start])
+		left -= (celtBandEdges[codedBands] - celtBandEdges[start]) * perBin
+		rem := max(left-(celtBandEdges[band]-celtBandEdges[start]), 0)
+		bandBits := allocation[band] + perBin*celtBandWidth(band) + rem
+		if bandBits >= max(threshold[band], floor+1<<bitResolution) {
+			f.enc.encodeBitLogP(true, 1)
+			break
+		}
+
+		sum -= allocation[band]
+		if bandBits >= floor {
+			sum += floor
+			allocation[band] = floor
+		} else {
+			allocation[band] = 0
+		}
+		codedBands--
+	}
+
+	// Spread what is left over the coded bins
+	left := total - sum
+	perBin := left / (celtBandEdges[codedBands] - celtBandEdges[start])
+	left -= (celtBandEdges[codedBands] - celtBandEdges[start]) * perBin
+	for band := start; band < codedBands; band++ {
+		allocation[band] += perBin * celtBandWidth(band)
+	}
+	for band := start; band < codedBands; band++ {
+		extra := min(left, celtBandWidth(band))
+		allocation[band] += extra
+		left -= extra
+	}
+
+	// Split each band budget between fine energy and shape
+	balance := 0
+	band := start
+	for ; band < codedBands; band++ {
+		n := celtBandWidth(band) << celtLM
+		allocation[band] += balance
+
+		excess := max(allocation[band]-caps[band], 0)
+		allocation[band] -= excess
+		logN := n * (celtLogN[band] + celtLM<<bitResolution)
+		offset := logN>>1 - n*celtFineOffset
+		if allocation[band]+offset < n*2<<bitResolution {
+			offset += logN >> 2
+		} else if allocation[band]+offset < n*3<<bitResolution {
+			offset += logN >> 3
+		}
+		fine := max(0, (allocation[band]+offset+n<<(bitResolution-1))/(n<<bitResolution))
+		fine = min(fine, allocation[band]>>bitResolution, celtMaxFineBits)
+		f.finePriority[band] = boolInt(fine*(n<<bitResolution) >= allocation[band]+offset)
+		allocation[band] -= fine << bitResolution
+
+		if excess > 0 {
+			extra := min(excess>>bitResolution, celtMaxFineBits-fine)
+			fine += extra
+			f.finePriority[band] = boolInt(extra<<bitResolution >= excess-balance)
+			excess -= extra << bitResolution
+		}
+		f.fineQuant[band] = fine
+		balance = excess
+	}
+	for ; band < end; band++ {
+		f.fineQuant[band] = allocation[band] >> bitResolution
+		allocation[band] = 0
+		f.finePriority[band] = boolInt(f.fineQuant[band] < 1)
+	}
+
+	f.pulses = allocation
+	f.balance = balance
+	f.codedBands = codedBands
+}
+
+// encodeFineEnergy refines the coarse energies with the allocated raw bits
+func (f *celtFrame) encodeFineEnergy(logE [celtBands]float64) {
+	for band := range celtEndBand {
+		fine := f.fineQuant[band]
+		if fine <= 0 {
+			continue
+		}
+		q := int(math.Floor((logE[band] - f.energy[band] + 0.5) * float64(int(1)<<fine)))
+		q = max(0, min(q, 1<<fine-1))
+		f.enc.encodeRawBits(uint32(q), uint(fine))
+		f.energy[band] += (float64(q)+0.5)/float64(int(1)<<fine) - 0.5
+	}
+}
+
+// finalizeFineEnergy spends the bits left after the shapes on one more
+// energy bit per band
+func (f *celtFrame) finalizeFineEnergy(logE [celtBands]float64) {
+	left := f.totalBits - f.enc.tell()
+	for priority := range 2 {
+		for band := 0; band < celtEndBand && left >= 1; band++ {
+			fine := f.fineQuant[band]
+			if fine >= celtMaxFineBits || f.finePriority[band] != priority {
+				continue
+			}
+			up := logE[band] >= f.energy[band]
+			f.enc.encodeRawBits(uint32(boolInt(up)), 1)
+			f.energy[band] += (float64(boolInt(up)) - 0.5) / float64(int(2)<<fine)
+			left--
+		}
+	}
+}
+
+// encodeBands codes the normalised shape of every band, RFC 6716 section 4.3.4
+func (f *celtFrame) encodeBands(x []float64) {
+	totalBits := f.totalBits << bitResolution
+	balance := f.balance
+	for band := range celtEndBand {
+		tell := f.enc.tellFrac()
+		if band > 0 {
+			balance -= tell
+		}
+		remaining := totalBits - tell - 1
+		bandBits := 0
+		if band < f.codedBands {
+			current := balance / min(3, f.codedBands-band)
+			bandBits = max(0, min(16383, remaining+1, f.pulses[band]+current))
+		}
+
+		f.encodePartition(x[celtBandEdges[band]<<celtLM:celtBandEdges[band+1]<<celtLM], band, celtLM, bandBits, &remaining)
+		balance += f.pulses[band] + tell
+	}
+}
+
+// encodePartition codes a band or a half of it, splitting it further when
+// its budget is larger than the biggest codebook
+func (f *celtFrame) encodePartition(x []float64, band, lm, bandBits int, remaining *int) {
+	n := len(x)
+	if lm == -1 || n <= 2 || bandBits <= celtMaxCodebookBits(band, lm)+12 {
+		f.encodePulses(x, band, lm, bandBits, remaining)
+		return
+	}
+
+	n >>= 1
+	x, y := x[:n], x[n:]
+	lm--
+
+	pulseCap := celtLogN[band] + lm<<bitResolution
+	qn := celtThetaSteps(n, bandBits, pulseCap>>1-4, pulseCap)
+	tell := f.enc.tellFrac()
+	theta := 0
+	if qn != 1 {
+		var mid, side float64
+		for i := range n {
+			mid += x[i] * x[i]
+			side += y[i] * y[i]
+		}
+		theta = int(math.Floor(0.5 + 16384*2/math.Pi*math.Atan2(math.Sqrt(side+1e-15), math.Sqrt(mid+1e-15))))
+		theta = (theta*qn + 8192) >> 14
+
+		// The angle has a triangular distribution peaking at equal halves
+		half := qn >> 1
+		total := uint32((half + 1) * (half + 1))
+		if theta <= half {
+			low := uint32(theta * (theta + 1) >> 1)
+			f.enc.encode(low, low+uint32(theta+1), total)
+		} else {
+			low := total - uint32((qn+1-theta)*(qn+2-theta)>>1)
+			f.enc.encode(low, low+uint32(qn+1-theta), total)
+		}
+		theta = theta * 16384 / qn
+	}
+	used := f.enc.tellFrac() - tell
+	bandBits -= used
+	*remaining -= used
+
+	var delta int
+	switch theta {
+	case 0:
+		delta = -16384
+	case 16384:
+		delta = 16384
+	default:
+		delta = celtFracMul16((n-1)<<7, celtLog2Tan(celtCos(16384-theta), celtCos(theta)))
+	}
+
+	midBits := max(0, min(bandBits, (bandBits-delta)/2))
+	sideBits := bandBits - midBits
+	before := *remaining
+	if midBits >= sideBits {
+		f.encodePartition(x, band, lm, midBits, remaining)
+		if rebalance := midBits - (before - *remaining); rebalance > 3<<bitResolution && theta != 0 {
+			sideBits += rebalance - 3<<bitResolution
+		}
+		f.encodePartition(y, band, lm, sideBits, remaining)
+	} else {
+		f.encodePartition(y, band, lm, sideBits, remaining)
+		if rebalance := sideBits - (before - *remaining); rebalance > 3<<bitResolution && theta != 16384 {
+			midBits += rebalance - 3<<bitResolution
+		}
+		f.encodePartition(x, band, lm, midBits, remaining)
+	}
+}
+
+// encodePulses codes the shape with the largest pulse count the budget allows
+func (f *celtFrame) encodePulses(x []float64, band, lm, bandBits int, remaining *int) {
+	q := celtBitsToPulses(band, lm, bandBits)
+	cost := celtPulsesToBits(band, lm, q)
+	*remaining -= cost
+	for *remaining < 0 && q > 0 {
+		*remaining += cost
+		q--
+		cost = celtPulsesToBits(band, lm, q)
+		*remaining -= cost
+	}
+	if q == 0 {
+		// The decoder folds a lower band in
+		return
+	}
+
+	k := celtPulseCount(q)
+	celtSpreadRotation(x, k)
+	y := pvqSearch(x, k)
+	index, total := pvqIndex(y, k)
+	f.enc.encodeUint(index, total)
+}
+
+// celtCache returns the pulse cost run of the band at the frame size
+func celtCache(band, lm int) []int {
+	return celtCacheBits[celtCacheIndex[(lm+1)*celtBands+band]:]
+}
+
+// celtMaxCodebookBits returns the cost of the largest codebook of the band
+func celtMaxCodebookBits(band, lm int) int {
+	cache := celtCache(band, lm)
+	return cache[cache[0]]
+}
+
+// celtPulseCount expands a cached pulse count index
+func celtPulseCount(q int) int {
+	if q < 8 {
+		return q
+	}
+	return (8 + q&7) << (q>>3 - 1)
+}
+
+// celtBitsToPulses returns the pulse count index whose cost is closest to
+// the budget
+func celtBitsToPulses(band, lm, budget int) int {
+	if budget <= 0 {
+		return 0
+	}
+	cache := celtCache(band, lm)
+	lo, hi := 0, cache[0]
+	budget--
+	for range 6 {
+		mid := (lo + hi + 1) >> 1
+		if cache[mid] >= budget {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	loBits := -1
+	if lo != 0 {
+		loBits = cache[lo]
+	}
+	if budget-loBits <= cache[hi]-budget {
+		return lo
+	}
+	return hi
+}
+
+// celtPulsesToBits returns the cost of a pulse count index in 1/8 bits
+func celtPulsesToBits(band, lm, q int) int {
+	if q == 0 {
+		return 0
+	}
+	return celtCache(band, lm)[q] + 1
+}
+
+// celtThetaSteps returns the resolution of the angle between split halves
+func celtThetaSteps(n, budget, offset, pulseCap int) int {
+	exp2 := [8]int{16384, 17866, 19483, 21247, 23170, 25267, 27554, 30048}
+	n2 := 2*n - 1
+	qb := min(budget-pulseCap-4<<bitResolution, (budget+n2*offset)/n2, 8<<bitResolution)
+	if qb < 1<<bitResolution>>1 {
+		return 1
+	}
+	return ((exp2[qb&7] >> (14 - qb>>bitResolution)) + 1) >> 1 << 1
+}
+
+// celtCos is the bit-exact cosine of the reference decoder
+func celtCos(x int) int {
+	x2 := (4096 + x*x) >> 13
+	x2 = (32767 - x2) + celtFracMul16(x2, -7651+celtFracMul16(x2, 8277+celtFracMul16(-626, x2)))
+	return 1 + x2
+}
+
+// celtLog2Tan is the bit-exact log2 of sin/cos of the reference decoder
+func celtLog2Tan(sin, cos int) int {
+	lc := bits.Len(uint(cos))
+	ls := bits.Len(uint(sin))
+	cos <<= 15 - lc
+	sin <<= 15 - ls
+	return (ls-lc)<<11 +
+		celtFracMul16(sin, celtFracMul16(sin, -2597)+7932) -
+		celtFracMul16(cos, celtFracMul16(cos, -2597)+7932)
+}
+
+func celtFracMul16(a, b int) int {
+	return (16384 + int(int16(a))*int(int16(b))) >> 15
+}
+
+// celtSpreadRotation applies the spreading rotation the decoder undoes
+func celtSpreadRotation(x []float64, k int) {
+	n := len(x)
+	if 2*k >= n {
+		return
+	}
+
+	factor := [3]int{15, 10, 5}[celtSpread-1]
+	gain := float64(n) / float64(n+factor*k)
+	theta := 0.5 * gain * gain
+	c := math.Cos(0.5 * math.Pi * theta)
+	s := math.Sin(0.5 * math.Pi * theta)
+
+	stride := 0
+	if n >= 8 {
+		stride = 1
+		for stride*stride+stride < n {
+			stride++
+		}
+	}
+
+	rotate(x, 1, c, -s)
+	if stride != 0 {
+		rotate(x, stride, s, -c)
+	}
+}
+
+// rotate applies the Givens rotations of one spreading pass
+func rotate(x []float64, stride int, c, s float64) {
+	n := len(x)
+	for i := 0; i < n-stride; i++ {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+	for i := n - 2*stride - 1; i >= 0; i-- {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+}
+
+// pvqSearch finds the vector of k pulses closest in direction to x
+func pvqSearch(x []float64, k int) []int {
+	n := len(x)
+	y := make([]int, n)
+	abs := make([]float64, n)
+	var sum float64
+	for i, v := range x {
+		abs[i] = math.Abs(v)
+		sum += abs[i]
+	}
+
+	// Start from a scaled down projection when there are many pulses
+	left := k
+	var xy, yy float64
+	twice := make([]float64, n)
+	if k > n>>1 {
+		if !(sum > 1e-15 && sum < 64) {
+			clear(abs)
+			abs[0] = 1
+			sum = 1
+		}
+		scale := (float64(k) + 0.8) / sum
+		for i := range n {
+			y[i] = int(math.Floor(scale * abs[i]))
+			yy += float64(y[i] * y[i])
+			xy += abs[i] * float64(y[i])
+			twice[i] = 2 * float64(y[i])
+			left -= y[i]
+		}
+	}
+	if left > n+3 {
+		yy += float64(left*left) + float64(left)*twice[0]
+		y[0] += left
+		twice[0] += 2 * float64(left)
+		left = 0
+	}
+
+	// Add the remaining pulses one at a time where they help most
+	for range left {
+		yy++
+		best := 0
+		bestNum := (xy + abs[0]) * (xy + abs[0])
+		bestDen := yy + twice[0]
+		for i := 1; i < n; i++ {
+			num := (xy + abs[i]) * (xy + abs[i])
+			den := yy + twice[i]
+			if bestDen*num > den*bestNum {
+				best, bestNum, bestDen = i, num, den
+			}
+		}
+		xy += abs[best]
+		yy += twice[best]
+		twice[best] += 2
+		y[best]++
+	}
+
+	for i, v := range x {
+		if v < 0 {
+			y[i] = -y[i]
+		}
+	}
+	return y
+}
+
+// pvqIndex returns the index of the pulse vector among all vectors of k
+// pulses and the number of such vectors, RFC 6716 section 4.3.4.2
+func pvqIndex(y []int, k int) (uint32, uint32) {
+	n := len(y)
+
+	// u[m][j] counts the vectors of m dimensions with j pulses and a
+	// positive first entry
+	u := make([][]uint32, n+1)
+	for m := 1; m <= n; m++ {
+		u[m] = make([]uint32, k+2)
+		for j := 1; j < k+2; j++ {
+			if m == 1 {
+				u[m][j] = 1
+			} else {
+				u[m][j] = u[m-1][j] + u[m][j-1] + u[m-1][j-1]
+			}
+		}
+	}
+
+	j := n - 1
+	index := uint32(boolInt(y[j] < 0))
+	pulses := abs(y[j])
+	for j > 0 {
+		j--
+		index += u[n-j][pulses]
+		pulses += abs(y[j])
+		if y[j] < 0 {
+			index += u[n-j][pulses+1]
+		}
+	}
+
+	return index, u[n][k] + u[n][k+1]
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
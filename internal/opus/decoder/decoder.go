@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pion/opus"
+	"github.com/pion/opus/pkg/oggreader"
+)
+
+// Opus decoder output parameters
+const (
+	sampleRate = 48000
+
+	// maxFrameSize is the longest Opus frame, 120 ms at 48 kHz
+	maxFrameSize = 5760
+)
+
+// Decode decodes an Ogg Opus voice message and returns its mono samples at
+// 48 kHz without the encoder delay
+func Decode(data []byte) ([]int16, int, error) {
+	reader, header, err := oggreader.NewWith(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read Ogg stream: %w", err)
+	}
+	if header.Channels != 1 {
+		return nil, 0, fmt.Errorf("unsupported voice message with %d channels", header.Channels)
+	}
+
+	decoder := opus.NewDecoder()
+	frame := make([]int16, maxFrameSize)
+	var samples []int16
+	for {
+		packet, _, err := reader.ParseNextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read Ogg page: %w", err)
+		}
+		// The reader also returns the header packets
+		if bytes.HasPrefix(packet, []byte("Opus")) {
+			continue
+		}
+
+		n, err := decoder.DecodeToInt16(packet, frame)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode Opus packet: %w", err)
+		}
+		samples = append(samples, frame[:n]...)
+	}
+
+	skip := min(int(header.PreSkip), len(samples))
+	return samples[skip:], sampleRate, nil
+}
@@ -0,0 +1,160 @@
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Opus stream parameters: narrowband CELT frames of 20 ms sized for 16 kbit/s
+const (
+	opusFrameBytes = 40
+
+	// opusTOC selects CELT-only narrowband 20 ms frames, one mono frame per
+	// packet, RFC 6716 section 3.1
+	opusTOC = 19 << 3
+
+	// opusPreSkip is the delay of the decoder output, in 48 kHz samples
+	opusPreSkip = celtOverlap
+
+	oggSerial       = 0x67666e63
+	oggMaxSegments  = 255
+	oggPagePackets  = 50
+	oggHeaderType   = 0
+	oggBeginning    = 0x02
+	oggEnd          = 0x04
+	oggCRCPoly      = 0x04c11db7
+	oggVendor       = "gofency"
+	oggHeaderLength = 27
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ oggCRCPoly
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// Encode encodes the samples as a mono Ogg Opus file, the format of
+// Telegram voice messages
+func Encode(samples []int16, sampleRate int) []byte {
+	pcm := resample(samples, sampleRate, celtSampleRate)
+
+	// Pad the end so the decoder flushes the last samples, the granule
+	// position of the last page tells players to drop the padding
+	length := len(pcm) + opusPreSkip
+	frames := (len(pcm) + opusPreSkip + celtFrameSize - 1) / celtFrameSize
+	pcm = append(pcm, make([]float64, frames*celtFrameSize-len(pcm))...)
+
+	var ogg oggWriter
+	ogg.writePage([][]byte{opusHead(sampleRate)}, 0, oggBeginning)
+	ogg.writePage([][]byte{opusTags()}, 0, oggHeaderType)
+
+	var (
+		encoder celtEncoder
+		packets [][]byte
+	)
+	for i := range frames {
+		frame := encoder.encode(pcm[i*celtFrameSize:(i+1)*celtFrameSize], opusFrameBytes)
+		packets = append(packets, append([]byte{opusTOC}, frame...))
+
+		if len(packets) == oggPagePackets || i == frames-1 {
+			granule, flags := (i+1)*celtFrameSize, byte(oggHeaderType)
+			if i == frames-1 {
+				granule, flags = length, oggEnd
+			}
+			ogg.writePage(packets, uint64(granule), flags)
+			packets = nil
+		}
+	}
+
+	return ogg.buf.Bytes()
+}
+
+// resample converts the samples to another rate with linear interpolation
+func resample(samples []int16, from, to int) []float64 {
+	out := make([]float64, len(samples)*to/from)
+	for i := range out {
+		pos := float64(i) * float64(from) / float64(to)
+		j := int(pos)
+		next := j + 1
+		if next >= len(samples) {
+			next = j
+		}
+		frac := pos - float64(j)
+		out[i] = float64(samples[j])*(1-frac) + float64(samples[next])*frac
+	}
+	return out
+}
+
+// opusHead is the identification header of RFC 7845 section 5.1
+func opusHead(sampleRate int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OpusHead")
+	buf.WriteByte(1) // version
+	buf.WriteByte(1) // channels
+	binary.Write(&buf, binary.LittleEndian, uint16(opusPreSkip))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, int16(0)) // output gain
+	buf.WriteByte(0)                                  // channel mapping family
+	return buf.Bytes()
+}
+
+// opusTags is the comment header of RFC 7845 section 5.2
+func opusTags() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OpusTags")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(oggVendor)))
+	buf.WriteString(oggVendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	return buf.Bytes()
+}
+
+// oggWriter writes the pages of a single logical Ogg stream, RFC 3533
+type oggWriter struct {
+	buf      bytes.Buffer
+	sequence uint32
+}
+
+// writePage writes the packets as one page, the caller keeps them within
+// the segment limit of a page
+func (w *oggWriter) writePage(packets [][]byte, granule uint64, flags byte) {
+	var segments, body []byte
+	for _, packet := range packets {
+		for n := len(packet); ; n -= oggMaxSegments {
+			if n < oggMaxSegments {
+				segments = append(segments, byte(n))
+				break
+			}
+			segments = append(segments, oggMaxSegments)
+		}
+		body = append(body, packet...)
+	}
+
+	page := make([]byte, oggHeaderLength, oggHeaderLength+len(segments)+len(body))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], oggSerial)
+	binary.LittleEndian.PutUint32(page[18:], w.sequence)
+	page[26] = byte(len(segments))
+	page = append(page, segments...)
+	page = append(page, body...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+
+	w.buf.Write(page)
+	w.sequence++
+}
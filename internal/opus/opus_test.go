@@ -0,0 +1,75 @@
+package opus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"gofency/internal/opus/decoder"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// A falling tone at 8 kHz, within the narrowband the encoder keeps
+	samples := make([]int16, 8000)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*(600-0.02*float64(i))*float64(i)/8000))
+	}
+
+	decoded, rate, err := decoder.Decode(Encode(samples, 8000))
+	if err != nil {
+		t.Fatalf("Failed to decode voice: %v", err)
+	}
+	if rate != celtSampleRate {
+		t.Fatalf("Sample rate is %d, want %d", rate, celtSampleRate)
+	}
+
+	want := resample(samples, 8000, celtSampleRate)
+	if len(decoded) < len(want) {
+		t.Fatalf("Decoded %d samples, want at least %d", len(decoded), len(want))
+	}
+	var signal, noise float64
+	for i, v := range want {
+		signal += v * v
+		noise += (v - float64(decoded[i])) * (v - float64(decoded[i]))
+	}
+	if snr := 10 * math.Log10(signal/noise); snr < 10 {
+		t.Errorf("Decoded audio has a signal to noise ratio of %.1f dB", snr)
+	}
+}
+
+func TestOggPages(t *testing.T) {
+	// Three seconds of silence span several pages of 50 packets
+	data := Encode(make([]int16, 3*8000), 8000)
+
+	pages := bytes.Count(data, []byte("OggS"))
+	if want := 2 + (3*celtSampleRate/celtFrameSize+oggPagePackets)/oggPagePackets; pages != want {
+		t.Errorf("Wrote %d pages, want %d", pages, want)
+	}
+	if !bytes.Contains(data, []byte("OpusHead")) || !bytes.Contains(data, []byte("OpusTags")) {
+		t.Error("Expected the identification and comment headers")
+	}
+}
+
+func TestRangeCoder(t *testing.T) {
+	e := newRangeEncoder(4)
+	start := e.tell()
+	e.encodeRawBits(0xa5, 8)
+	if bits := e.tell() - start; bits != 8 {
+		t.Errorf("Raw bits took %d bits, want 8", bits)
+	}
+
+	// Raw bits are written from the end of the frame
+	frame, ok := e.done()
+	if !ok || len(frame) != 4 || frame[3] != 0xa5 {
+		t.Errorf("Frame is %x, %v, want the raw bits last", frame, ok)
+	}
+
+	// Symbols that don't fit are reported rather than written past the frame
+	e = newRangeEncoder(2)
+	for i := range 8 {
+		e.encodeUint(uint32(i), 256)
+	}
+	if frame, ok := e.done(); ok || len(frame) != 2 {
+		t.Errorf("Expected the overflow to be reported, got %x, %v", frame, ok)
+	}
+}
@@ -0,0 +1,278 @@
+package opus
+
+import "math/bits"
+
+// Range coder constants from RFC 6716 section 4.1
+const (
+	rangeSymbolBits = 8
+	rangeCodeBits   = 32
+	rangeSymbolMax  = 1<<rangeSymbolBits - 1
+	rangeCodeShift  = rangeCodeBits - rangeSymbolBits - 1
+	rangeCodeTop    = 1 << (rangeCodeBits - 1)
+	rangeCodeBottom = rangeCodeTop >> rangeSymbolBits
+	rangeUintBits   = 8
+	rangeWindowSize = 32
+
+	// bitResolution is the number of fractional bits used for bit budgets
+	bitResolution = 3
+)
+
+// rangeEncoder is the entropy coder of RFC 6716 section 5.1. Range coded
+// symbols are written from the start of a fixed size buffer and raw bits
+// from its end.
+type rangeEncoder struct {
+	buf []byte
+
+	offset     int
+	endOffset  int
+	endWindow  uint32
+	endBits    int
+	totalBits  int
+	value      uint32
+	rng        uint32
+	remainder  int
+	extension  int
+	overflowed bool
+}
+
+// newRangeEncoder creates an encoder filling exactly size bytes
+func newRangeEncoder(size int) *rangeEncoder {
+	return &rangeEncoder{
+		buf:       make([]byte, size),
+		totalBits: rangeCodeBits + 1,
+		rng:       rangeCodeTop,
+		remainder: -1,
+	}
+}
+
+func (e *rangeEncoder) writeByte(b int) {
+	if e.offset+e.endOffset >= len(e.buf) {
+		e.overflowed = true
+		return
+	}
+	e.buf[e.offset] = byte(b)
+	e.offset++
+}
+
+func (e *rangeEncoder) writeByteAtEnd(b uint32) {
+	if e.offset+e.endOffset >= len(e.buf) {
+		e.overflowed = true
+		return
+	}
+	e.endOffset++
+	e.buf[len(e.buf)-e.endOffset] = byte(b)
+}
+
+// carryOut buffers a byte until it is known whether a carry propagates into it
+func (e *rangeEncoder) carryOut(c int) {
+	if c == rangeSymbolMax {
+		e.extension++
+		return
+	}
+
+	carry := c >> rangeSymbolBits
+	if e.remainder >= 0 {
+		e.writeByte(e.remainder + carry)
+	}
+	for ; e.extension > 0; e.extension-- {
+		e.writeByte((rangeSymbolMax + carry) & rangeSymbolMax)
+	}
+	e.remainder = c & rangeSymbolMax
+}
+
+func (e *rangeEncoder) normalize() {
+	for e.rng <= rangeCodeBottom {
+		e.carryOut(int(e.value >> rangeCodeShift))
+		e.value = (e.value << rangeSymbolBits) & (rangeCodeTop - 1)
+		e.rng <<= rangeSymbolBits
+		e.totalBits += rangeSymbolBits
+	}
+}
+
+// encode codes the symbol occupying [low, high) of total
+func (e *rangeEncoder) encode(low, high, total uint32) {
+	r := e.rng / total
+	if low > 0 {
+		e.value += e.rng - r*(total-low)
+		e.rng = r * (high - low)
+	} else {
+		e.rng -= r * (total - high)
+	}
+	e.normalize()
+}
+
+// encodeBin is encode with a total of 1<<totalBits
+func (e *rangeEncoder) encodeBin(low, high uint32, totalBits uint) {
+	r := e.rng >> totalBits
+	if low > 0 {
+		e.value += e.rng - r*((1<<totalBits)-low)
+		e.rng = r * (high - low)
+	} else {
+		e.rng -= r * ((1 << totalBits) - high)
+	}
+	e.normalize()
+}
+
+// encodeBitLogP codes a bit that is one with probability 1/(1<<logp)
+func (e *rangeEncoder) encodeBitLogP(bit bool, logp uint) {
+	s := e.rng >> logp
+	r := e.rng - s
+	if bit {
+		e.value += r
+		e.rng = s
+	} else {
+		e.rng = r
+	}
+	e.normalize()
+}
+
+// encodeICDF codes symbol s of an inverse cumulative distribution with a
+// total of 1<<totalBits
+func (e *rangeEncoder) encodeICDF(s int, icdf []uint8, totalBits uint) {
+	r := e.rng >> totalBits
+	if s > 0 {
+		e.value += e.rng - r*uint32(icdf[s-1])
+		e.rng = r * uint32(icdf[s-1]-icdf[s])
+	} else {
+		e.rng -= r * uint32(icdf[s])
+	}
+	e.normalize()
+}
+
+// encodeUint codes a value uniformly distributed in [0, total)
+func (e *rangeEncoder) encodeUint(value, total uint32) {
+	total--
+	totalBits := bits.Len32(total)
+	if totalBits <= rangeUintBits {
+		e.encode(value, value+1, total+1)
+		return
+	}
+
+	totalBits -= rangeUintBits
+	high := (total >> totalBits) + 1
+	e.encode(value>>totalBits, (value>>totalBits)+1, high)
+	e.encodeRawBits(value&(1<<totalBits-1), uint(totalBits))
+}
+
+// encodeRawBits writes bits that bypass the range coder
+func (e *rangeEncoder) encodeRawBits(value uint32, n uint) {
+	window := e.endWindow
+	used := e.endBits
+	if used+int(n) > rangeWindowSize {
+		for used >= rangeSymbolBits {
+			e.writeByteAtEnd(window & rangeSymbolMax)
+			window >>= rangeSymbolBits
+			used -= rangeSymbolBits
+		}
+	}
+	window |= value << used
+	used += int(n)
+
+	e.endWindow = window
+	e.endBits = used
+	e.totalBits += int(n)
+}
+
+// encodeLaplace codes a value with the Laplace-like distribution used for
+// coarse band energies, clamping it to what the distribution can represent.
+// It returns the value that was coded.
+func (e *rangeEncoder) encodeLaplace(value int, fs, decay uint32) int {
+	const minP = 1
+
+	low := uint32(0)
+	if value != 0 {
+		sign := 0
+		if value < 0 {
+			sign = -1
+		}
+		magnitude := (value + sign) ^ sign
+
+		low = fs
+		fs = (32768 - 2*16*minP - fs) * (16384 - decay) >> 15
+		i := 1
+		for ; fs > 0 && i < magnitude; i++ {
+			fs *= 2
+			low += fs + 2*minP
+			fs = fs * decay >> 15
+		}
+
+		if fs == 0 {
+			maxStep := (int(32768-low+minP-1) - sign) >> 1
+			step := min(magnitude-i, maxStep-1)
+			low += uint32((2*step + 1 + sign) * minP)
+			fs = min(minP, 32768-low)
+			value = (i + step + sign) ^ sign
+		} else {
+			fs += minP
+			if sign == 0 {
+				low += fs
+			}
+		}
+	}
+
+	e.encodeBin(low, low+fs, 15)
+	return value
+}
+
+// tell returns the number of whole bits written so far, rounded up
+func (e *rangeEncoder) tell() int {
+	return e.totalBits - bits.Len32(e.rng)
+}
+
+// tellFrac returns the number of bits written so far in 1/8 bit units
+func (e *rangeEncoder) tellFrac() int {
+	lg := bits.Len32(e.rng)
+	r := uint64(e.rng >> (lg - 16))
+	for range bitResolution {
+		r = r * r >> 15
+		bit := int(r >> 16)
+		lg = 2*lg + bit
+		if bit != 0 {
+			r >>= 1
+		}
+	}
+
+	return e.totalBits<<bitResolution - lg
+}
+
+// done flushes the coder and returns the frame, or false when the symbols
+// did not fit
+func (e *rangeEncoder) done() ([]byte, bool) {
+	l := rangeCodeBits - bits.Len32(e.rng)
+	mask := uint32(rangeCodeTop-1) >> l
+	end := (e.value + mask) &^ mask
+	if end|mask >= e.value+e.rng {
+		l++
+		mask >>= 1
+		end = (e.value + mask) &^ mask
+	}
+	for ; l > 0; l -= rangeSymbolBits {
+		e.carryOut(int(end >> rangeCodeShift))
+		end = (end << rangeSymbolBits) & (rangeCodeTop - 1)
+	}
+	if e.remainder >= 0 || e.extension > 0 {
+		e.carryOut(0)
+	}
+
+	window := e.endWindow
+	used := e.endBits
+	for ; used >= rangeSymbolBits; used -= rangeSymbolBits {
+		e.writeByteAtEnd(window & rangeSymbolMax)
+		window >>= rangeSymbolBits
+	}
+
+	// Whatever is left between the two ends is already zero
+	if used > 0 {
+		if e.endOffset >= len(e.buf) {
+			e.overflowed = true
+		} else {
+			l = -l
+			if e.offset+e.endOffset >= len(e.buf) && l < used {
+				e.overflowed = true
+			}
+			e.buf[len(e.buf)-e.endOffset-1] |= byte(window)
+		}
+	}
+
+	return e.buf, !e.overflowed
+}
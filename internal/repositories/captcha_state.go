@@ -0,0 +1,172 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gofency/internal/fsm"
+	"gofency/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CaptchaStateRepository keeps verifications in the database, so they
+// survive restarts
+type CaptchaStateRepository interface {
+	fsm.StateStore
+}
+
+type captchaStateRepository struct {
+	db *gorm.DB
+}
+
+func NewCaptchaStateRepository(db *gorm.DB) CaptchaStateRepository {
+	return &captchaStateRepository{db: db}
+}
+
+func (r *captchaStateRepository) Save(ctx context.Context, key fsm.CaptchaKey, data *fsm.CaptchaData) error {
+	state, err := captchaStateRecord(key, data)
+	if err != nil {
+		return err
+	}
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "user_id"}, {Name: "topic_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"state", "version", "expires_at", "data", "updated_at"}),
+	}).Create(state)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save captcha state: %w", result.Error)
+	}
+
+	return nil
+}
+
+// Update replaces the state only at the version read by the caller, so
+// concurrent changes of one verification cannot both apply
+func (r *captchaStateRepository) Update(ctx context.Context, key fsm.CaptchaKey, version int, data *fsm.CaptchaData) (bool, error) {
+	state, err := captchaStateRecord(key, data)
+	if err != nil {
+		return false, err
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.CaptchaState{}).
+		Where("chat_id = ? AND user_id = ? AND topic_id = ? AND version = ?", key.ChatID, key.UserID, key.TopicID, version).
+		Updates(map[string]any{
+			"state":      state.State,
+			"version":    state.Version,
+			"expires_at": state.ExpiresAt,
+			"data":       state.Data,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update captcha state: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *captchaStateRepository) Get(ctx context.Context, key fsm.CaptchaKey) (*fsm.CaptchaData, error) {
+	var states []models.CaptchaState
+
+	result := r.db.WithContext(ctx).
+		Where("chat_id = ? AND user_id = ? AND topic_id = ?", key.ChatID, key.UserID, key.TopicID).
+		Limit(1).Find(&states)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get captcha state of user %d in chat %d: %w", key.UserID, key.ChatID, result.Error)
+	}
+
+	return firstCaptchaState(states)
+}
+
+func (r *captchaStateRepository) Find(ctx context.Context, chatID, userID int64) (*fsm.CaptchaData, error) {
+	var states []models.CaptchaState
+
+	result := r.db.WithContext(ctx).Where("chat_id = ? AND user_id = ?", chatID, userID).Limit(1).Find(&states)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find captcha state of user %d in chat %d: %w", userID, chatID, result.Error)
+	}
+
+	return firstCaptchaState(states)
+}
+
+func (r *captchaStateRepository) Delete(ctx context.Context, key fsm.CaptchaKey, version int) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("chat_id = ? AND user_id = ? AND topic_id = ? AND version = ?", key.ChatID, key.UserID, key.TopicID, version).
+		Delete(&models.CaptchaState{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete captcha state: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// TakeExpired deletes the expired state and returns it in one statement, so
+// concurrent callers cannot both take it
+func (r *captchaStateRepository) TakeExpired(ctx context.Context, key fsm.CaptchaKey, now time.Time) (*fsm.CaptchaData, error) {
+	var states []models.CaptchaState
+
+	result := r.db.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("chat_id = ? AND user_id = ? AND topic_id = ? AND expires_at < ?", key.ChatID, key.UserID, key.TopicID, now).
+		Delete(&states)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to take expired captcha state: %w", result.Error)
+	}
+
+	return firstCaptchaState(states)
+}
+
+func (r *captchaStateRepository) List(ctx context.Context) ([]*fsm.CaptchaData, error) {
+	var states []models.CaptchaState
+
+	result := r.db.WithContext(ctx).Order("expires_at").Find(&states)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list captcha states: %w", result.Error)
+	}
+
+	list := make([]*fsm.CaptchaData, 0, len(states))
+	for _, state := range states {
+		data, err := decodeCaptchaState(state)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, data)
+	}
+
+	return list, nil
+}
+
+// captchaStateRecord encodes the verification into its database record
+func captchaStateRecord(key fsm.CaptchaKey, data *fsm.CaptchaData) (*models.CaptchaState, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode captcha state: %w", err)
+	}
+
+	return &models.CaptchaState{
+		ChatID:    key.ChatID,
+		UserID:    key.UserID,
+		TopicID:   key.TopicID,
+		State:     string(data.State),
+		Version:   data.Version,
+		ExpiresAt: data.ExpiresAt,
+		Data:      string(encoded),
+	}, nil
+}
+
+// firstCaptchaState decodes the first record, or returns nil if there is none
+func firstCaptchaState(states []models.CaptchaState) (*fsm.CaptchaData, error) {
+	if len(states) == 0 {
+		return nil, nil
+	}
+	return decodeCaptchaState(states[0])
+}
+
+func decodeCaptchaState(state models.CaptchaState) (*fsm.CaptchaData, error) {
+	var data fsm.CaptchaData
+	if err := json.Unmarshal([]byte(state.Data), &data); err != nil {
+		return nil, fmt.Errorf("failed to decode captcha state of user %d in chat %d: %w", state.UserID, state.ChatID, err)
+	}
+	return &data, nil
+}
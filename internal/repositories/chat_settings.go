@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"gofency/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ChatSettingsRepository interface {
+	GetByChatID(ctx context.Context, chatID int64) (*models.ChatSettings, error)
+	UpsertDifficulty(ctx context.Context, chatID int64, difficulty string) error
+	UpsertChallengeTypes(ctx context.Context, chatID int64, challengeTypes string) error
+	UpsertLanguage(ctx context.Context, chatID int64, language string) error
+	UpsertMessageTTL(ctx context.Context, chatID int64, seconds int) error
+}
+
+type chatSettingsRepository struct {
+	db *gorm.DB
+}
+
+func NewChatSettingsRepository(db *gorm.DB) ChatSettingsRepository {
+	return &chatSettingsRepository{db: db}
+}
+
+func (r *chatSettingsRepository) GetByChatID(ctx context.Context, chatID int64) (*models.ChatSettings, error) {
+	var settings models.ChatSettings
+
+	result := r.db.WithContext(ctx).Where("chat_id = ?", chatID).First(&settings)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get chat settings for chat %d: %w", chatID, result.Error)
+	}
+
+	return &settings, nil
+}
+
+func (r *chatSettingsRepository) UpsertDifficulty(ctx context.Context, chatID int64, difficulty string) error {
+	return r.upsert(ctx, &models.ChatSettings{ChatID: chatID, Difficulty: difficulty}, "difficulty")
+}
+
+func (r *chatSettingsRepository) UpsertChallengeTypes(ctx context.Context, chatID int64, challengeTypes string) error {
+	return r.upsert(ctx, &models.ChatSettings{ChatID: chatID, ChallengeTypes: challengeTypes}, "challenge_types")
+}
+
+func (r *chatSettingsRepository) UpsertLanguage(ctx context.Context, chatID int64, language string) error {
+	return r.upsert(ctx, &models.ChatSettings{ChatID: chatID, Language: language}, "language")
+}
+
+func (r *chatSettingsRepository) UpsertMessageTTL(ctx context.Context, chatID int64, seconds int) error {
+	return r.upsert(ctx, &models.ChatSettings{ChatID: chatID, MessageTTL: seconds}, "message_ttl")
+}
+
+func (r *chatSettingsRepository) upsert(ctx context.Context, settings *models.ChatSettings, column string) error {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{column, "updated_at"}),
+	}).Create(settings)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to update chat settings: %w", result.Error)
+	}
+
+	return nil
+}
+
+type chatSettingsRepositoryKey struct{}
+
+func WithChatSettingsRepository(ctx context.Context, repo ChatSettingsRepository) context.Context {
+	return context.WithValue(ctx, chatSettingsRepositoryKey{}, repo)
+}
+
+func GetChatSettingsRepository(ctx context.Context) (ChatSettingsRepository, bool) {
+	repo, ok := ctx.Value(chatSettingsRepositoryKey{}).(ChatSettingsRepository)
+	return repo, ok
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gofency/internal/messages"
+	"gofency/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PendingDeletionRepository keeps the deletions of self-destructing messages
+// in the database, so they happen after a restart
+type PendingDeletionRepository interface {
+	messages.DeletionStore
+}
+
+type pendingDeletionRepository struct {
+	db *gorm.DB
+}
+
+func NewPendingDeletionRepository(db *gorm.DB) PendingDeletionRepository {
+	return &pendingDeletionRepository{db: db}
+}
+
+func (r *pendingDeletionRepository) Add(ctx context.Context, deletion *messages.Deletion) error {
+	record := &models.PendingDeletion{
+		ChatID:    deletion.ChatID,
+		MessageID: deletion.MessageID,
+		DeleteAt:  deletion.DeleteAt,
+		Attempts:  deletion.Attempts,
+	}
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"delete_at", "attempts", "updated_at"}),
+	}).Create(record)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save pending deletion: %w", result.Error)
+	}
+
+	return nil
+}
+
+// LeaseDue postpones the due records and returns them in one statement, so
+// a message is deleted by one job only
+func (r *pendingDeletionRepository) LeaseDue(ctx context.Context, chatID int64, now, until time.Time, limit int) ([]*messages.Deletion, error) {
+	var records []models.PendingDeletion
+
+	due := r.db.Model(&models.PendingDeletion{}).Select("message_id").
+		Where("chat_id = ? AND delete_at <= ?", chatID, now).
+		Order("delete_at").Limit(limit)
+	result := r.db.WithContext(ctx).Model(&records).Clauses(clause.Returning{}).
+		Where("chat_id = ? AND message_id IN (?)", chatID, due).
+		Update("delete_at", until)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to lease due deletions in chat %d: %w", chatID, result.Error)
+	}
+
+	deletions := make([]*messages.Deletion, 0, len(records))
+	for _, record := range records {
+		deletions = append(deletions, pendingDeletion(record))
+	}
+
+	return deletions, nil
+}
+
+func (r *pendingDeletionRepository) Remove(ctx context.Context, chatID int64, messageIDs []int) error {
+	result := r.db.WithContext(ctx).
+		Where("chat_id = ? AND message_id IN ?", chatID, messageIDs).
+		Delete(&models.PendingDeletion{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove deletions in chat %d: %w", chatID, result.Error)
+	}
+
+	return nil
+}
+
+func (r *pendingDeletionRepository) Next(ctx context.Context, chatID int64) (time.Time, error) {
+	var records []models.PendingDeletion
+
+	result := r.db.WithContext(ctx).Where("chat_id = ?", chatID).Order("delete_at").Limit(1).Find(&records)
+	if result.Error != nil {
+		return time.Time{}, fmt.Errorf("failed to get next deletion in chat %d: %w", chatID, result.Error)
+	}
+	if len(records) == 0 {
+		return time.Time{}, nil
+	}
+
+	return records[0].DeleteAt, nil
+}
+
+func (r *pendingDeletionRepository) List(ctx context.Context) ([]*messages.Deletion, error) {
+	var records []models.PendingDeletion
+
+	result := r.db.WithContext(ctx).Order("delete_at").Find(&records)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list pending deletions: %w", result.Error)
+	}
+
+	deletions := make([]*messages.Deletion, 0, len(records))
+	for _, record := range records {
+		deletions = append(deletions, pendingDeletion(record))
+	}
+
+	return deletions, nil
+}
+
+func pendingDeletion(record models.PendingDeletion) *messages.Deletion {
+	return &messages.Deletion{
+		ChatID:    record.ChatID,
+		MessageID: record.MessageID,
+		DeleteAt:  record.DeleteAt,
+		Attempts:  record.Attempts,
+	}
+}
@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"gofency/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type QuizQuestionRepository interface {
+	ListByChatID(ctx context.Context, chatID int64) ([]models.QuizQuestion, error)
+	Create(ctx context.Context, question *models.QuizQuestion) error
+	Delete(ctx context.Context, chatID int64, id uint) (bool, error)
+}
+
+type quizQuestionRepository struct {
+	db *gorm.DB
+}
+
+func NewQuizQuestionRepository(db *gorm.DB) QuizQuestionRepository {
+	return &quizQuestionRepository{db: db}
+}
+
+func (r *quizQuestionRepository) ListByChatID(ctx context.Context, chatID int64) ([]models.QuizQuestion, error) {
+	var questions []models.QuizQuestion
+
+	result := r.db.WithContext(ctx).Where("chat_id = ?", chatID).Order("id").Find(&questions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get quiz questions for chat %d: %w", chatID, result.Error)
+	}
+
+	return questions, nil
+}
+
+func (r *quizQuestionRepository) Create(ctx context.Context, question *models.QuizQuestion) error {
+	result := r.db.WithContext(ctx).Create(question)
+	if result.Error != nil {
+		return fmt.Errorf("failed to create quiz question: %w", result.Error)
+	}
+
+	return nil
+}
+
+// Delete removes the question if it belongs to the chat and reports whether it existed
+func (r *quizQuestionRepository) Delete(ctx context.Context, chatID int64, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).Where("chat_id = ? AND id = ?", chatID, id).Delete(&models.QuizQuestion{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete quiz question %d: %w", id, result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+type quizQuestionRepositoryKey struct{}
+
+func WithQuizQuestionRepository(ctx context.Context, repo QuizQuestionRepository) context.Context {
+	return context.WithValue(ctx, quizQuestionRepositoryKey{}, repo)
+}
+
+func GetQuizQuestionRepository(ctx context.Context) (QuizQuestionRepository, bool) {
+	repo, ok := ctx.Value(quizQuestionRepositoryKey{}).(QuizQuestionRepository)
+	return repo, ok
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"gofency/internal/models"
+	"gofency/internal/scheduler"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScheduledJobRepository keeps scheduled jobs in the database, so delayed
+// actions survive restarts
+type ScheduledJobRepository interface {
+	scheduler.JobStore
+}
+
+type scheduledJobRepository struct {
+	db *gorm.DB
+}
+
+func NewScheduledJobRepository(db *gorm.DB) ScheduledJobRepository {
+	return &scheduledJobRepository{db: db}
+}
+
+func (r *scheduledJobRepository) Save(ctx context.Context, job *scheduler.Job) error {
+	record := &models.ScheduledJob{
+		ID:      job.ID,
+		Kind:    job.Kind,
+		RunAt:   job.RunAt,
+		Payload: string(job.Payload),
+	}
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"kind", "run_at", "payload", "updated_at"}),
+	}).Create(record)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save scheduled job: %w", result.Error)
+	}
+
+	return nil
+}
+
+func (r *scheduledJobRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Where("id = ?", id).Delete(&models.ScheduledJob{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete scheduled job: %w", result.Error)
+	}
+
+	return nil
+}
+
+func (r *scheduledJobRepository) List(ctx context.Context) ([]*scheduler.Job, error) {
+	var records []models.ScheduledJob
+
+	result := r.db.WithContext(ctx).Order("run_at").Find(&records)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list scheduled jobs: %w", result.Error)
+	}
+
+	jobs := make([]*scheduler.Job, 0, len(records))
+	for _, record := range records {
+		jobs = append(jobs, &scheduler.Job{
+			ID:      record.ID,
+			Kind:    record.Kind,
+			RunAt:   record.RunAt,
+			Payload: []byte(record.Payload),
+		})
+	}
+
+	return jobs, nil
+}
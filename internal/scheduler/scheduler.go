@@ -0,0 +1,336 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a delayed action. Jobs are plain data, so they can be stored and
+// run by a handler registered for their kind after a restart.
+type Job struct {
+	// ID identifies the job; scheduling a job with the ID of a pending one
+	// replaces it
+	ID    string
+	Kind  string
+	RunAt time.Time
+	// Payload is the JSON encoded argument of the handler
+	Payload []byte
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode payload of %s job %s: %w", j.Kind, j.ID, err)
+	}
+	return nil
+}
+
+// HandlerFunc runs a due job. The context carries the scheduler, so the
+// handler may schedule follow-up jobs.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// JobStore keeps scheduled jobs across restarts
+type JobStore interface {
+	// Save stores the job, replacing any with the same ID
+	Save(ctx context.Context, job *Job) error
+	Delete(ctx context.Context, id string) error
+	// List returns all stored jobs
+	List(ctx context.Context) ([]*Job, error)
+}
+
+// Scheduler runs jobs at their time from a single timer. Pending jobs are
+// kept in a min-heap ordered by time, and in the store when there is one.
+type Scheduler struct {
+	store    JobStore
+	handlers map[string]HandlerFunc
+	slots    chan struct{}
+
+	mu    sync.Mutex
+	queue jobQueue
+	jobs  map[string]*entry
+	wake  chan struct{}
+}
+
+// entry is a job waiting in the queue
+type entry struct {
+	job   *Job
+	index int
+}
+
+// Handle refers to a scheduled job
+type Handle struct {
+	s     *Scheduler
+	entry *entry
+}
+
+// New creates a scheduler running at most workers jobs at once. Jobs are
+// only kept in memory when the store is nil.
+func New(store JobStore, workers int) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		handlers: make(map[string]HandlerFunc),
+		slots:    make(chan struct{}, max(workers, 1)),
+		jobs:     make(map[string]*entry),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler of a job kind. Handlers are registered before Run.
+func (s *Scheduler) Handle(kind string, handler HandlerFunc) {
+	s.handlers[kind] = handler
+}
+
+// Schedule runs the job of the kind at the given time with the payload as
+// its argument. A job with the same ID that did not run yet is replaced.
+// Jobs that fail to be stored are still run unless the bot stops.
+func (s *Scheduler) Schedule(ctx context.Context, id, kind string, runAt time.Time, payload any) (*Handle, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload of %s job %s: %w", kind, id, err)
+	}
+
+	job := &Job{ID: id, Kind: kind, RunAt: runAt, Payload: encoded}
+	if s.store != nil {
+		if err := s.store.Save(ctx, job); err != nil {
+			log.Printf("Failed to store %s job %s: %v", kind, id, err)
+		}
+	}
+
+	return &Handle{s: s, entry: s.push(job)}, nil
+}
+
+// After runs the job after the delay, see Schedule
+func (s *Scheduler) After(ctx context.Context, id, kind string, delay time.Duration, payload any) (*Handle, error) {
+	return s.Schedule(ctx, id, kind, time.Now().Add(delay), payload)
+}
+
+// Cancel removes the pending job with the ID and reports whether there was one
+func (s *Scheduler) Cancel(ctx context.Context, id string) bool {
+	s.mu.Lock()
+	e, ok := s.jobs[id]
+	if ok {
+		s.remove(e)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.forget(ctx, e.job)
+	}
+	return ok
+}
+
+// Cancel removes the job unless it already ran or was replaced, and reports
+// whether it did
+func (h *Handle) Cancel(ctx context.Context) bool {
+	s := h.s
+	s.mu.Lock()
+	ok := s.jobs[h.entry.job.ID] == h.entry
+	if ok {
+		s.remove(h.entry)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.forget(ctx, h.entry.job)
+	}
+	return ok
+}
+
+// Pending returns how many jobs wait to run
+func (s *Scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Run restores the stored jobs and runs jobs when they are due until the
+// context is cancelled. Jobs that are running then are waited for; jobs not
+// yet run stay in the store for the next start.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.restore(ctx)
+
+	// Handlers finish their work after shutdown and may schedule jobs
+	jobCtx := WithScheduler(context.WithoutCancel(ctx), s)
+
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		job, next := s.due(time.Now())
+		if job != nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				defer func() { <-s.slots }()
+				s.run(jobCtx, job)
+			}()
+			continue
+		}
+
+		if next.IsZero() {
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// run calls the handler of the job and removes the job from the store
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	handler, ok := s.handlers[job.Kind]
+	if !ok {
+		log.Printf("No handler for %s job %s, dropping it", job.Kind, job.ID)
+	} else if err := handler(ctx, job); err != nil {
+		log.Printf("Failed to run %s job %s: %v", job.Kind, job.ID, err)
+	}
+
+	// A job replacing this one while it ran is stored under the same ID
+	s.mu.Lock()
+	_, replaced := s.jobs[job.ID]
+	s.mu.Unlock()
+	if !replaced {
+		s.forget(ctx, job)
+	}
+}
+
+// restore queues the stored jobs that were not scheduled again since start
+func (s *Scheduler) restore(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+
+	jobs, err := s.store.List(ctx)
+	if err != nil {
+		log.Printf("Failed to restore scheduled jobs: %v", err)
+		return
+	}
+
+	restored := 0
+	for _, job := range jobs {
+		s.mu.Lock()
+		_, scheduled := s.jobs[job.ID]
+		s.mu.Unlock()
+		if scheduled {
+			continue
+		}
+		s.push(job)
+		restored++
+	}
+
+	log.Printf("Restored %d scheduled jobs", restored)
+}
+
+// push queues the job, replacing a pending one with the same ID, and wakes
+// Run up when the job is the next one due
+func (s *Scheduler) push(job *Job) *entry {
+	s.mu.Lock()
+	if old, ok := s.jobs[job.ID]; ok {
+		s.remove(old)
+	}
+	e := &entry{job: job}
+	heap.Push(&s.queue, e)
+	s.jobs[job.ID] = e
+	first := e.index == 0
+	s.mu.Unlock()
+
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return e
+}
+
+// due pops the first job if it is due, or returns the time of the next one;
+// the time is zero when nothing is queued
+func (s *Scheduler) due(now time.Time) (*Job, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil, time.Time{}
+	}
+	first := s.queue[0]
+	if first.job.RunAt.After(now) {
+		return nil, first.job.RunAt
+	}
+	s.remove(first)
+	return first.job, time.Time{}
+}
+
+// remove takes the entry out of the queue; the caller holds the lock
+func (s *Scheduler) remove(e *entry) {
+	heap.Remove(&s.queue, e.index)
+	delete(s.jobs, e.job.ID)
+}
+
+// forget removes the job from the store
+func (s *Scheduler) forget(ctx context.Context, job *Job) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(ctx, job.ID); err != nil {
+		log.Printf("Failed to delete %s job %s from store: %v", job.Kind, job.ID, err)
+	}
+}
+
+// jobQueue is a min-heap of entries ordered by time
+type jobQueue []*entry
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool { return q[i].job.RunAt.Before(q[j].job.RunAt) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+type schedulerKey struct{}
+
+// WithScheduler returns a new context with the scheduler
+func WithScheduler(ctx context.Context, s *Scheduler) context.Context {
+	return context.WithValue(ctx, schedulerKey{}, s)
+}
+
+// GetScheduler returns the scheduler from the context
+func GetScheduler(ctx context.Context) (*Scheduler, bool) {
+	s, ok := ctx.Value(schedulerKey{}).(*Scheduler)
+	return s, ok
+}
@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryJobStore is a job store for tests
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{jobs: make(map[string]*Job)}
+}
+
+func (s *memoryJobStore) Save(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memoryJobStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *memoryJobStore) List(ctx context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *memoryJobStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// recorder collects the payloads of the jobs run
+type recorder struct {
+	mu  sync.Mutex
+	ran []string
+	run chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{run: make(chan struct{}, 100)}
+}
+
+func (r *recorder) handle(ctx context.Context, job *Job) error {
+	var name string
+	if err := job.Decode(&name); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.ran = append(r.ran, name)
+	r.mu.Unlock()
+	r.run <- struct{}{}
+	return nil
+}
+
+// wait waits until n jobs ran and returns their payloads in order
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	for range n {
+		select {
+		case <-r.run:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %d jobs", n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+func TestJobsRunInTimeOrder(t *testing.T) {
+	s := New(nil, 1)
+	rec := newRecorder()
+	s.Handle("test", rec.handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	now := time.Now()
+	for _, job := range []struct {
+		name  string
+		delay time.Duration
+	}{{"third", 90 * time.Millisecond}, {"first", 10 * time.Millisecond}, {"second", 50 * time.Millisecond}} {
+		if _, err := s.Schedule(ctx, job.name, "test", now.Add(job.delay), job.name); err != nil {
+			t.Fatalf("Failed to schedule job: %v", err)
+		}
+	}
+
+	ran := rec.wait(t, 3)
+	want := []string{"first", "second", "third"}
+	for i := range want {
+		if ran[i] != want[i] {
+			t.Fatalf("Expected jobs to run in order %v, got %v", want, ran)
+		}
+	}
+}
+
+func TestCancelAndReplace(t *testing.T) {
+	s := New(nil, 1)
+	rec := newRecorder()
+	s.Handle("test", rec.handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelled, _ := s.After(ctx, "cancelled", "test", 20*time.Millisecond, "cancelled")
+	replaced, _ := s.After(ctx, "replaced", "test", 20*time.Millisecond, "old")
+	s.After(ctx, "replaced", "test", 30*time.Millisecond, "new")
+	s.After(ctx, "by-id", "test", 20*time.Millisecond, "by-id")
+
+	if !cancelled.Cancel(ctx) {
+		t.Error("Expected a pending job to be cancelled")
+	}
+	if cancelled.Cancel(ctx) {
+		t.Error("Expected a job to be cancelled once")
+	}
+	if replaced.Cancel(ctx) {
+		t.Error("Expected the handle of a replaced job not to cancel its replacement")
+	}
+	if !s.Cancel(ctx, "by-id") {
+		t.Error("Expected a pending job to be cancelled by ID")
+	}
+
+	go s.Run(ctx)
+
+	ran := rec.wait(t, 1)
+	if len(ran) != 1 || ran[0] != "new" {
+		t.Errorf("Expected only the replacement to run, got %v", ran)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("Expected no pending jobs, got %d", s.Pending())
+	}
+}
+
+func TestStoredJobsSurviveRestart(t *testing.T) {
+	store := newMemoryJobStore()
+
+	// Jobs not due before the stop are kept in the store
+	s := New(store, 1)
+	s.Handle("test", newRecorder().handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.After(ctx, "later", "test", 50*time.Millisecond, "later")
+	s.After(ctx, "overwritten", "test", time.Hour, "old")
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	if store.len() != 2 {
+		t.Fatalf("Expected 2 stored jobs after stop, got %d", store.len())
+	}
+
+	// The next start runs them, unless they were scheduled again meanwhile
+	s = New(store, 1)
+	rec := newRecorder()
+	s.Handle("test", rec.handle)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	s.After(ctx, "overwritten", "test", 0, "new")
+	go s.Run(ctx)
+
+	ran := rec.wait(t, 2)
+	if len(ran) != 2 || ran[0] != "new" || ran[1] != "later" {
+		t.Errorf("Expected the replacement and the stored job to run, got %v", ran)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for store.len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if store.len() != 0 {
+		t.Errorf("Expected jobs that ran to be removed from the store, %d left", store.len())
+	}
+}
+
+func TestRunWaitsForRunningJobs(t *testing.T) {
+	s := New(nil, 2)
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	s.Handle("slow", func(ctx context.Context, job *Job) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		if ctx.Err() != nil {
+			t.Error("Expected running jobs to keep a live context after shutdown")
+		}
+		close(finished)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.After(ctx, "slow", "slow", 0, nil)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	<-started
+	cancel()
+	<-done
+
+	select {
+	case <-finished:
+	default:
+		t.Error("Expected Run to return after the running job finished")
+	}
+}
@@ -3,13 +3,18 @@ package telegrambot
 import (
 	"context"
 	"log"
+	"strings"
+	"time"
 
 	"gofency/internal/captcha"
 	"gofency/internal/fsm"
 	"gofency/internal/localization"
+	"gofency/internal/messages"
 	"gofency/internal/repositories"
+	"gofency/internal/scheduler"
 	"gofency/internal/telegrambot/handlers"
 	"gofency/internal/telegrambot/middlewares"
+	"gofency/internal/webapp"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -19,19 +24,44 @@ type Bot struct {
 	api            *bot.Bot
 	localization   *localization.Service
 	userRepository repositories.UserRepository
-	captchaService *captcha.Service
+	captchas       *captcha.Registry
 	captchaFSM     *fsm.CaptchaFSM
+	scheduler      *scheduler.Scheduler
+	messages       *messages.Service
+
+	webApp       *webapp.Server
+	webAppListen string
 }
 
 type Config struct {
-	Token               string
-	LocalizationService *localization.Service
-	UserRepository      repositories.UserRepository
-	CaptchaService      *captcha.Service
-	CaptchaFSM          *fsm.CaptchaFSM
+	Token                  string
+	LocalizationService    *localization.Service
+	UserRepository         repositories.UserRepository
+	ChatSettingsRepository repositories.ChatSettingsRepository
+	QuizQuestionRepository repositories.QuizQuestionRepository
+	CaptchaRegistry        *captcha.Registry
+	CaptchaFSM             *fsm.CaptchaFSM
+	// Scheduler runs the delayed actions of the handlers
+	Scheduler *scheduler.Scheduler
+	// MessageStore keeps the pending deletions of self-destructing messages
+	MessageStore messages.DeletionStore
+
+	// CaptchaAttempts and CaptchaRefreshes limit answers and new challenges per member
+	CaptchaAttempts  int
+	CaptchaRefreshes int
+	// CaptchaMinAnswerTime is the least time a human needs to answer a challenge
+	CaptchaMinAnswerTime time.Duration
+
+	// WebAppListen is the address of the Mini App server, empty disables it
+	WebAppListen string
+	WebApp       webapp.Config
 }
 
 func NewBot(cfg Config) (*Bot, error) {
+	// The messages service needs the API client, it is created with it below
+	// and used by handlers only once the bot starts
+	var messageService *messages.Service
+
 	localizationMiddleware := middlewares.NewLocalization(cfg.LocalizationService, cfg.UserRepository)
 
 	userRepositoryMiddleware := func(next bot.HandlerFunc) bot.HandlerFunc {
@@ -41,9 +71,19 @@ func NewBot(cfg Config) (*Bot, error) {
 		}
 	}
 
+	chatSettingsMiddleware := func(next bot.HandlerFunc) bot.HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			ctx = repositories.WithChatSettingsRepository(ctx, cfg.ChatSettingsRepository)
+			ctx = repositories.WithQuizQuestionRepository(ctx, cfg.QuizQuestionRepository)
+			next(ctx, b, update)
+		}
+	}
+
 	captchaFSMMiddleware := func(next bot.HandlerFunc) bot.HandlerFunc {
 		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
 			ctx = fsm.WithCaptchaFSM(ctx, cfg.CaptchaFSM)
+			ctx = scheduler.WithScheduler(ctx, cfg.Scheduler)
+			ctx = messages.WithService(ctx, messageService)
 			next(ctx, b, update)
 		}
 	}
@@ -51,6 +91,7 @@ func NewBot(cfg Config) (*Bot, error) {
 	opts := []bot.Option{
 		bot.WithMiddlewares(
 			userRepositoryMiddleware,
+			chatSettingsMiddleware,
 			captchaFSMMiddleware,
 			localizationMiddleware.Handler,
 			middlewares.LogMessageWithText,
@@ -59,10 +100,26 @@ func NewBot(cfg Config) (*Bot, error) {
 		// bot.WithMessageTextHandler("start", bot.MatchTypeCommand, handlers.CommandStart),
 		// bot.WithMessageTextHandler("help", bot.MatchTypeCommand, handlers.CommandHelp),
 		// bot.WithMessageTextHandler("lang", bot.MatchTypeCommand, handlers.CommandLanguage),
-		// bot.WithMessageTextHandler("testcaptcha", bot.MatchTypeCommand, handlers.CommandTestCaptcha(cfg.CaptchaService)),
+		// bot.WithMessageTextHandler("testcaptcha", bot.MatchTypeCommand, handlers.CommandTestCaptcha(cfg.CaptchaRegistry)),
+
+		// Chat administrators configure captchas per chat
+		bot.WithMessageTextHandler("captcha_difficulty", bot.MatchTypeCommand, handlers.CommandCaptchaDifficulty),
+		bot.WithMessageTextHandler("captcha_types", bot.MatchTypeCommand, handlers.CommandCaptchaTypes(cfg.CaptchaRegistry)),
+		bot.WithMessageTextHandler("captcha_language", bot.MatchTypeCommand, handlers.CommandCaptchaLanguage(cfg.LocalizationService.SupportedLanguages())),
+		bot.WithMessageTextHandler("message_ttl", bot.MatchTypeCommand, handlers.CommandMessageTTL),
+		bot.WithMessageTextHandler("quiz_add", bot.MatchTypeCommand, handlers.CommandQuizAdd),
+		bot.WithMessageTextHandler("quiz_list", bot.MatchTypeCommand, handlers.CommandQuizList),
+		bot.WithMessageTextHandler("quiz_delete", bot.MatchTypeCommand, handlers.CommandQuizDelete),
+
+		// Members who failed appeal in a private chat, admins decide with inline buttons
+		bot.WithMessageTextHandler("appeal", bot.MatchTypeCommand, handlers.CommandAppeal),
+		bot.WithCallbackQueryDataHandler("approval:", bot.MatchTypePrefix, handlers.HandleApprovalCallback),
 
 		// bot.WithCallbackQueryDataHandler("set_lang_", bot.MatchTypePrefix, handlers.HandleLanguageCallback),
 
+		// Handle captcha answers given with inline buttons
+		bot.WithCallbackQueryDataHandler("captcha:", bot.MatchTypePrefix, handlers.HandleCaptchaCallback(cfg.CaptchaRegistry)),
+
 		// Handle new chat members and text messages for captcha verification
 		bot.WithDefaultHandler(func(ctx context.Context, b *bot.Bot, update *models.Update) {
 			// Log the update for debugging
@@ -73,13 +130,17 @@ func NewBot(cfg Config) (*Bot, error) {
 
 			if update.Message != nil && update.Message.NewChatMembers != nil {
 				log.Printf("New chat members detected: %d members", len(update.Message.NewChatMembers))
-				handlers.HandleNewChatMember(cfg.CaptchaService)(ctx, b, update)
+				handlers.HandleNewChatMember(cfg.CaptchaRegistry, handlers.CaptchaLimits{
+					Attempts:      cfg.CaptchaAttempts,
+					Refreshes:     cfg.CaptchaRefreshes,
+					MinAnswerTime: cfg.CaptchaMinAnswerTime,
+				})(ctx, b, update)
 				return
 			}
-			// Check if this is a text message that might be a captcha answer
-			// Skip if it's a command (starts with /)
-			if update.Message != nil && update.Message.Text != "" && len(update.Message.Text) > 0 && update.Message.Text[0] != '/' {
-				handlers.HandleCaptchaTextAnswer(ctx, b, update)
+			// Check if this is a message of a member under verification, text
+			// might be a captcha answer. Skip if it's a command (starts with /)
+			if update.Message != nil && !strings.HasPrefix(update.Message.Text, "/") {
+				handlers.HandleCaptchaTextAnswer(cfg.CaptchaRegistry)(ctx, b, update)
 				return
 			}
 		}),
@@ -90,12 +151,42 @@ func NewBot(cfg Config) (*Bot, error) {
 		return nil, err
 	}
 
+	messageService = messages.NewService(b, cfg.MessageStore, cfg.Scheduler, chatMessageTTL(cfg.ChatSettingsRepository))
+
+	// Jobs and Mini App requests skip the middlewares, so they get the same dependencies here
+	prepare := func(ctx context.Context, languageCode string) context.Context {
+		ctx = repositories.WithUserRepository(ctx, cfg.UserRepository)
+		ctx = repositories.WithChatSettingsRepository(ctx, cfg.ChatSettingsRepository)
+		ctx = repositories.WithQuizQuestionRepository(ctx, cfg.QuizQuestionRepository)
+		ctx = fsm.WithCaptchaFSM(ctx, cfg.CaptchaFSM)
+		ctx = scheduler.WithScheduler(ctx, cfg.Scheduler)
+		ctx = messages.WithService(ctx, messageService)
+		if len(languageCode) > 2 {
+			languageCode = languageCode[:2]
+		}
+		return localization.WithLocalizer(ctx, cfg.LocalizationService.GetLocalizer(languageCode))
+	}
+
+	handlers.RegisterJobs(cfg.Scheduler, b, cfg.CaptchaFSM, prepare)
+	cfg.CaptchaFSM.OnTransition(handlers.LogTransition)
+
+	var webApp *webapp.Server
+	if cfg.WebAppListen != "" {
+		webAppConfig := cfg.WebApp
+		webAppConfig.Token = cfg.Token
+		webApp = webapp.NewServer(webAppConfig, handlers.NewWebAppVerifications(b, cfg.CaptchaRegistry, cfg.CaptchaFSM, prepare))
+	}
+
 	return &Bot{
 		api:            b,
 		localization:   cfg.LocalizationService,
 		userRepository: cfg.UserRepository,
-		captchaService: cfg.CaptchaService,
+		captchas:       cfg.CaptchaRegistry,
 		captchaFSM:     cfg.CaptchaFSM,
+		scheduler:      cfg.Scheduler,
+		messages:       messageService,
+		webApp:         webApp,
+		webAppListen:   cfg.WebAppListen,
 	}, nil
 }
 
@@ -103,7 +194,43 @@ func (b *Bot) Start(ctx context.Context) error {
 	log.Println("Starting Telegram bot...")
 	log.Printf("Supported languages: %v", b.localization.SupportedLanguages())
 
+	// Verifications pending at the last stop are finished before new updates arrive
+	ctx = scheduler.WithScheduler(ctx, b.scheduler)
+	if err := handlers.ResumeVerifications(ctx, b.captchaFSM); err != nil {
+		return err
+	}
+	if err := b.messages.Resume(ctx); err != nil {
+		return err
+	}
+
+	// Delayed actions stop with the bot, the ones running are finished first
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		b.scheduler.Run(ctx)
+	}()
+
+	if b.webApp != nil {
+		go func() {
+			if err := b.webApp.ListenAndServe(ctx, b.webAppListen); err != nil {
+				log.Printf("Mini App server stopped: %v", err)
+			}
+		}()
+	}
+
 	b.api.Start(ctx)
+	<-schedulerDone
 
 	return nil
 }
+
+// chatMessageTTL reads the TTL chat admins set for bot messages
+func chatMessageTTL(repo repositories.ChatSettingsRepository) messages.TTLSource {
+	return func(ctx context.Context, chatID int64) (time.Duration, error) {
+		settings, err := repo.GetByChatID(ctx, chatID)
+		if err != nil || settings == nil {
+			return 0, err
+		}
+		return time.Duration(settings.MessageTTL) * time.Second, nil
+	}
+}
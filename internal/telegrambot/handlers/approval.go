@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gofency/internal/fsm"
+	"gofency/internal/localization"
+
+	"github.com/go-telegram/bot"
+	tgmodels "github.com/go-telegram/bot/models"
+)
+
+// approvalCallbackPrefix prefixes callback data of the buttons chat admins
+// decide approval requests and appeals with
+const approvalCallbackPrefix = "approval:"
+
+// Decisions of chat admins
+const (
+	decisionApprove = "approve"
+	decisionReject  = "reject"
+)
+
+// CommandAppeal lets a member who failed or timed out ask the admins of the
+// chat to reconsider while their ban lasts. It is sent in a private chat
+// with the bot, as the member can no longer write in the chat.
+func CommandAppeal(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	if update.Message.Chat.Type != tgmodels.ChatTypePrivate {
+		return
+	}
+
+	captchaFSM, ok := fsm.GetCaptchaFSM(ctx)
+	if !ok {
+		log.Printf("Captcha FSM not found in context")
+		return
+	}
+
+	states, err := captchaFSM.Pending(ctx)
+	if err != nil {
+		log.Printf("Failed to list verifications: %v", err)
+		return
+	}
+
+	userID := update.Message.From.ID
+	appealed := 0
+	for _, data := range states {
+		if data.UserID != userID || data.Appealed || !fsm.CanTransition(data.State, fsm.StateAppealed) {
+			continue
+		}
+		if time.Now().After(data.ExpiresAt) {
+			continue
+		}
+
+		_, ok := requestDecision(ctx, b, captchaFSM, data, fsm.StateAppealed, "captcha_appeal_request", func(next *fsm.CaptchaData) {
+			next.Appealed = true
+		})
+		if ok {
+			appealed++
+		}
+	}
+
+	if appealed == 0 {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "appeal_none"))
+		return
+	}
+	log.Printf("User %d appealed %d failed verifications", userID, appealed)
+	replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "appeal_sent"))
+}
+
+// HandleApprovalCallback handles the decisions of chat admins on approval
+// requests and appeals
+func HandleApprovalCallback(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	query := update.CallbackQuery
+
+	// Callback data format: approval:<userID>:<decision>
+	parts := strings.SplitN(strings.TrimPrefix(query.Data, approvalCallbackPrefix), ":", 2)
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	message := query.Message.Message
+	if len(parts) != 2 || err != nil || message == nil {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+		return
+	}
+	decision := parts[1]
+
+	if !isChatAdmin(ctx, b, message.Chat.ID, query.From.ID) {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            localization.GetSimpleText(ctx, "captcha_admins_only"),
+			ShowAlert:       true,
+		})
+		return
+	}
+
+	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+
+	captchaFSM, ok := fsm.GetCaptchaFSM(ctx)
+	if !ok {
+		return
+	}
+
+	// Only the notice of the current request may decide it
+	key := fsm.CaptchaKey{ChatID: message.Chat.ID, UserID: userID, TopicID: messageTopic(message)}
+	data, ok := captchaFSM.Lookup(key)
+	if !ok || data.PhotoMessageID != message.ID {
+		return
+	}
+	if data.State != fsm.StatePendingApproval && data.State != fsm.StateAppealed {
+		return
+	}
+
+	log.Printf("Admin %d decided to %s user %d in chat %d", query.From.ID, decision, userID, data.ChatID)
+
+	switch decision {
+	case decisionApprove:
+		approveMember(ctx, b, captchaFSM, data)
+	case decisionReject:
+		rejectMember(ctx, b, captchaFSM, data)
+	}
+}
+
+// requestApproval replaces the challenge of a member who cannot solve it with
+// a request for the chat admins to let them in; admins have until the appeal
+// window ends to decide. The member is kept silent meanwhile, the request
+// must not let them skip the challenge.
+func requestApproval(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData) {
+	restricted := data.Restricted || restrictMember(ctx, b, data.ChatID, data.UserID)
+
+	waiting, ok := requestDecision(ctx, b, captchaFSM, data, fsm.StatePendingApproval, "captcha_approval_request", func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(appealWindow)
+		next.Restricted = restricted
+	})
+	if !ok {
+		if restricted && !data.Restricted {
+			liftRestrictions(ctx, b, data.ChatID, data.UserID)
+		}
+		return
+	}
+
+	deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+	scheduleTimeoutCheck(ctx, data.Key(), waiting.ExpiresAt)
+	log.Printf("User %d in chat %d asked the admins to let them in", data.UserID, data.ChatID)
+}
+
+// requestDecision posts a notice with buttons for the chat admins and moves
+// the verification to the state waiting for their decision. The notice is
+// the message of the verification from then on.
+func requestDecision(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, to fsm.CaptchaState, textID string, change func(next *fsm.CaptchaData)) (*fsm.CaptchaData, bool) {
+	text := localization.GetText(ctx, textID, map[string]any{
+		"Username": verificationMention(data),
+	})
+	msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            text,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+		ReplyMarkup:     decisionKeyboard(ctx, data.UserID),
+	})
+	if err != nil {
+		log.Printf("Failed to ask admins of chat %d about user %d: %v", data.ChatID, data.UserID, err)
+		return nil, false
+	}
+
+	next, err := captchaFSM.Transition(data, to, func(next *fsm.CaptchaData) {
+		next.PhotoMessageID = msg.ID
+		change(next)
+	})
+	if err != nil {
+		// An answer or the timeout may have ended the verification meanwhile
+		log.Printf("Failed to move verification of user %d in chat %d to %q: %v", data.UserID, data.ChatID, to, err)
+		deleteMessage(ctx, b, data.ChatID, msg.ID)
+		return nil, false
+	}
+	return next, true
+}
+
+// decisionKeyboard builds the buttons chat admins decide about the user with
+func decisionKeyboard(ctx context.Context, userID int64) *tgmodels.InlineKeyboardMarkup {
+	button := func(textID, decision string) tgmodels.InlineKeyboardButton {
+		return tgmodels.InlineKeyboardButton{
+			Text:         localization.GetSimpleText(ctx, textID),
+			CallbackData: fmt.Sprintf("%s%d:%s", approvalCallbackPrefix, userID, decision),
+		}
+	}
+	return &tgmodels.InlineKeyboardMarkup{InlineKeyboard: [][]tgmodels.InlineKeyboardButton{{
+		button("captcha_approve_button", decisionApprove),
+		button("captcha_reject_button", decisionReject),
+	}}}
+}
+
+// approveMember verifies the member the admins let in, lifting the ban of
+// an appeal or the restrictions of a pending verification
+func approveMember(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData) {
+	if _, err := captchaFSM.Transition(data, fsm.StateVerified, nil); err != nil {
+		log.Printf("Failed to approve user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		return
+	}
+	cancelTimeoutCheck(ctx, data.Key())
+
+	if data.State == fsm.StateAppealed {
+		_, err := b.UnbanChatMember(ctx, &bot.UnbanChatMemberParams{
+			ChatID:       data.ChatID,
+			UserID:       data.UserID,
+			OnlyIfBanned: true,
+		})
+		if err != nil {
+			log.Printf("Failed to unban user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		}
+	} else if data.Restricted {
+		liftRestrictions(ctx, b, data.ChatID, data.UserID)
+	}
+
+	deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+
+	text := localization.GetText(ctx, "captcha_approved", map[string]any{
+		"Username": verificationMention(data),
+	})
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            text,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to send approval message: %v", err)
+	}
+}
+
+// rejectMember fails a member waiting for approval like a wrong answer does;
+// a rejected appeal ends the verification, which is cleared with the ban
+func rejectMember(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData) {
+	if data.State == fsm.StatePendingApproval {
+		failCaptcha(ctx, b, captchaFSM, data)
+		return
+	}
+
+	if _, err := captchaFSM.Transition(data, fsm.StateFailed, nil); err != nil {
+		log.Printf("Failed to reject appeal of user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		return
+	}
+
+	deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+
+	text := localization.GetText(ctx, "captcha_appeal_rejected", map[string]any{
+		"Username": verificationMention(data),
+	})
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            text,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to send appeal rejection message: %v", err)
+	}
+}
+
+// verificationMention returns the mention of the member of a verification
+func verificationMention(data *fsm.CaptchaData) string {
+	if data.Mention == "" {
+		return GenerateMention(&tgmodels.User{ID: data.UserID})
+	}
+	return data.Mention
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/fsm"
+
+	tgmodels "github.com/go-telegram/bot/models"
+)
+
+// topicMessage is a message in topic 7 of forum -100
+func topicMessage(id int) *tgmodels.Message {
+	return &tgmodels.Message{
+		ID:              id,
+		Chat:            tgmodels.Chat{ID: -100, IsForum: true},
+		IsTopicMessage:  true,
+		MessageThreadID: 7,
+	}
+}
+
+// appealCommand is /appeal sent by user 42 in the chat
+func appealCommand(chat tgmodels.Chat) *tgmodels.Update {
+	return &tgmodels.Update{Message: &tgmodels.Message{
+		ID:   300,
+		From: &tgmodels.User{ID: 42, FirstName: "Member"},
+		Chat: chat,
+		Text: "/appeal",
+	}}
+}
+
+// failedVerification is a verification of user 42 in chat -100 failed and
+// kept for an appeal
+func failedVerification(t *testing.T, captchaFSM *fsm.CaptchaFSM) *fsm.CaptchaData {
+	t.Helper()
+	data := challenged(t, captchaFSM, digitsVerification(1, 0), time.Now())
+	failed, err := captchaFSM.Transition(data, fsm.StateFailed, func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(appealWindow)
+	})
+	if err != nil {
+		t.Fatalf("Failed to fail: %v", err)
+	}
+	return failed
+}
+
+func TestApprovalRequest(t *testing.T) {
+	b, fake := newTestBot(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+	data := webAppVerification(t, captchaFSM, 3)
+
+	HandleCaptchaCallback(newTestRegistry(t))(ctx, b, buttonPress(42, captchaCallbackPrefix+"42:"+captcha.ApprovalValue, topicMessage(100)))
+
+	waiting, ok := captchaFSM.Lookup(data.Key())
+	if !ok || waiting.State != fsm.StatePendingApproval {
+		t.Fatalf("Expected the verification to wait for admins, got %v", waiting)
+	}
+	if time.Until(waiting.ExpiresAt) < appealWindow-time.Minute {
+		t.Errorf("Expected admins to have the appeal window, expires at %v", waiting.ExpiresAt)
+	}
+	if deleted := fake.called("deleteMessage"); len(deleted) != 1 || deleted[0]["message_id"] != "100" {
+		t.Errorf("Expected the challenge to be deleted, got %v", deleted)
+	}
+	sent := fake.called("sendMessage")
+	if len(sent) != 1 || sent[0]["message_thread_id"] != "7" || !strings.Contains(sent[0]["reply_markup"], approvalCallbackPrefix+"42:"+decisionApprove) {
+		t.Fatalf("Expected a notice with decision buttons in the topic, got %v", sent)
+	}
+
+	// Members can't decide for the admins
+	fake.setStatus("member")
+	HandleApprovalCallback(ctx, b, buttonPress(42, approvalCallbackPrefix+"42:"+decisionApprove, topicMessage(waiting.PhotoMessageID)))
+	if still, ok := captchaFSM.Lookup(data.Key()); !ok || still.State != fsm.StatePendingApproval {
+		t.Fatalf("Expected a member's decision to be ignored, got %v", still)
+	}
+	if answers := fake.called("answerCallbackQuery"); answers[len(answers)-1]["show_alert"] != "true" {
+		t.Errorf("Expected the member to be told only admins decide, got %v", answers)
+	}
+
+	fake.setStatus("")
+	HandleApprovalCallback(ctx, b, buttonPress(1, approvalCallbackPrefix+"42:"+decisionApprove, topicMessage(waiting.PhotoMessageID)))
+
+	if _, ok := captchaFSM.Lookup(data.Key()); ok {
+		t.Error("Expected the approved member to be removed")
+	}
+	if lifted := fake.called("restrictChatMember"); len(lifted) != 1 || lifted[0]["user_id"] != "42" {
+		t.Errorf("Expected the restrictions to be lifted, got %v", lifted)
+	}
+	deleted := fake.called("deleteMessage")
+	if deleted[len(deleted)-1]["message_id"] != fmt.Sprint(waiting.PhotoMessageID) {
+		t.Errorf("Expected the notice to be deleted, got %v", deleted)
+	}
+}
+
+func TestApprovalRequestSilencesMember(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+	data := challenged(t, captchaFSM, digitsVerification(3, 0), time.Now().Add(-5*time.Second))
+
+	HandleCaptchaCallback(registry)(ctx, b, buttonPress(42, captchaCallbackPrefix+"42:"+captcha.ApprovalValue, chatMessage(100)))
+
+	// Asking the admins stretches the deadline, so the member can't write until they decide
+	waiting, ok := captchaFSM.Lookup(data.Key())
+	if !ok || waiting.State != fsm.StatePendingApproval || !waiting.Restricted {
+		t.Fatalf("Expected the member to wait restricted, got %v", waiting)
+	}
+	if restricted := fake.called("restrictChatMember"); len(restricted) != 1 || restricted[0]["user_id"] != "42" {
+		t.Fatalf("Expected the member to be restricted, got %v", restricted)
+	}
+
+	HandleCaptchaTextAnswer(registry)(ctx, b, textAnswer("spam"))
+	if deleted := fake.called("deleteMessage"); deleted[len(deleted)-1]["message_id"] != "200" {
+		t.Errorf("Expected the message of the waiting member to be deleted, got %v", deleted)
+	}
+
+	HandleApprovalCallback(ctx, b, buttonPress(1, approvalCallbackPrefix+"42:"+decisionApprove, chatMessage(waiting.PhotoMessageID)))
+	if lifted := fake.called("restrictChatMember"); len(lifted) != 2 {
+		t.Errorf("Expected the restrictions to be lifted once approved, got %v", lifted)
+	}
+}
+
+func TestRejectedAppealIsFinal(t *testing.T) {
+	b, fake := newTestBot(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+	data := failedVerification(t, captchaFSM)
+
+	// Appeals are only taken in private chats
+	CommandAppeal(ctx, b, appealCommand(tgmodels.Chat{ID: -100, Type: tgmodels.ChatTypeSupergroup}))
+	if sent := fake.called("sendMessage"); len(sent) != 0 {
+		t.Fatalf("Expected an appeal in the chat to be ignored, got %v", sent)
+	}
+
+	private := tgmodels.Chat{ID: 42, Type: tgmodels.ChatTypePrivate}
+	CommandAppeal(ctx, b, appealCommand(private))
+
+	appealed, ok := captchaFSM.Lookup(data.Key())
+	if !ok || appealed.State != fsm.StateAppealed || !appealed.Appealed {
+		t.Fatalf("Expected the verification to be appealed, got %v", appealed)
+	}
+	sent := fake.called("sendMessage")
+	if len(sent) != 2 || sent[0]["chat_id"] != "-100" || sent[1]["text"] != "appeal_sent" {
+		t.Fatalf("Expected a notice in the chat and a reply to the member, got %v", sent)
+	}
+
+	HandleApprovalCallback(ctx, b, buttonPress(1, approvalCallbackPrefix+"42:"+decisionReject, chatMessage(appealed.PhotoMessageID)))
+
+	rejected, ok := captchaFSM.Lookup(data.Key())
+	if !ok || rejected.State != fsm.StateFailed {
+		t.Fatalf("Expected the appeal to be rejected, got %v", rejected)
+	}
+	if unbans := fake.called("unbanChatMember"); len(unbans) != 0 {
+		t.Errorf("Expected the ban to stay, got %v", unbans)
+	}
+
+	// A member appeals once
+	CommandAppeal(ctx, b, appealCommand(private))
+	sent = fake.called("sendMessage")
+	if last := sent[len(sent)-1]; last["chat_id"] != "42" || last["text"] != "appeal_none" {
+		t.Errorf("Expected a second appeal to be refused, got %v", last)
+	}
+}
+
+func TestApprovedAppealLiftsBan(t *testing.T) {
+	b, fake := newTestBot(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+	data := failedVerification(t, captchaFSM)
+
+	CommandAppeal(ctx, b, appealCommand(tgmodels.Chat{ID: 42, Type: tgmodels.ChatTypePrivate}))
+	appealed, ok := captchaFSM.Lookup(data.Key())
+	if !ok || appealed.State != fsm.StateAppealed {
+		t.Fatalf("Expected the verification to be appealed, got %v", appealed)
+	}
+
+	// Decisions on an outdated notice are ignored
+	HandleApprovalCallback(ctx, b, buttonPress(1, approvalCallbackPrefix+"42:"+decisionApprove, chatMessage(100)))
+	if _, ok := captchaFSM.Lookup(data.Key()); !ok {
+		t.Fatal("Expected a decision on another message to be ignored")
+	}
+
+	HandleApprovalCallback(ctx, b, buttonPress(1, approvalCallbackPrefix+"42:"+decisionApprove, chatMessage(appealed.PhotoMessageID)))
+
+	if _, ok := captchaFSM.Lookup(data.Key()); ok {
+		t.Error("Expected the approved member to be removed")
+	}
+	unbans := fake.called("unbanChatMember")
+	if len(unbans) != 1 || unbans[0]["user_id"] != "42" || unbans[0]["only_if_banned"] != "true" {
+		t.Errorf("Expected the ban to be lifted, got %v", unbans)
+	}
+}
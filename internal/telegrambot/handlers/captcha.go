@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"gofency/internal/captcha"
@@ -15,7 +16,20 @@ import (
 	tgmodels "github.com/go-telegram/bot/models"
 )
 
-func HandleNewChatMember(captchaService *captcha.Service) bot.HandlerFunc {
+// CaptchaLimits bounds how many times a member may answer and refresh a challenge
+type CaptchaLimits struct {
+	Attempts  int
+	Refreshes int
+	// MinAnswerTime is the least time a human needs to answer, faster answers count as wrong
+	MinAnswerTime time.Duration
+}
+
+// appealWindow is how long members who failed are banned for; their
+// verifications are kept as long, so they may appeal while the ban lasts.
+// Chat admins have as long to decide approval requests.
+const appealWindow = 10 * time.Minute
+
+func HandleNewChatMember(registry *captcha.Registry, limits CaptchaLimits) bot.HandlerFunc {
 	return func(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
 		log.Printf("HandleNewChatMember called")
 
@@ -27,6 +41,10 @@ func HandleNewChatMember(captchaService *captcha.Service) bot.HandlerFunc {
 		chatID := update.Message.Chat.ID
 		log.Printf("Processing %d new member(s) in chat %d", len(update.Message.NewChatMembers), chatID)
 
+		// Per-chat settings override the configured defaults
+		settings := chatChallengeSettings(ctx, chatID)
+		difficulty := settings.Difficulty
+
 		for _, newMember := range update.Message.NewChatMembers {
 			if newMember.IsBot {
 				log.Printf("Skipping bot: %s", newMember.Username)
@@ -35,38 +53,34 @@ func HandleNewChatMember(captchaService *captcha.Service) bot.HandlerFunc {
 
 			log.Printf("Processing new member: %s (ID: %d)", newMember.FirstName, newMember.ID)
 
-			// Generate captcha
-			captchaImg, err := captchaService.Generate()
+			// Challenges are sent to the topic the member joined in
+			key := fsm.CaptchaKey{ChatID: chatID, UserID: newMember.ID, TopicID: messageTopic(update.Message)}
+
+			// Generate challenge
+			challenge, err := registry.Generate(ctx, captcha.Request{
+				ChatID:       chatID,
+				UserID:       newMember.ID,
+				LanguageCode: settings.language(&newMember),
+				Difficulty:   difficulty,
+			}, settings.Types...)
 			if err != nil {
 				log.Printf("Failed to generate captcha: %v", err)
 				continue
 			}
 
-			log.Printf("Generated captcha with answer: %s", captchaImg.Answer)
+			// The seed regenerates the challenge with generate-captchas -replay
+			log.Printf("Generated %s captcha (difficulty %q, seed %d) with answer: %s", challenge.Type, difficulty, challenge.Seed, challenge.Answer)
 
-			username := GenerateMention(update.Message.From)
+			username := GenerateMention(&newMember)
 
 			// Send welcome message with captcha
 			welcomeText := localization.GetText(ctx, "captcha_welcome", map[string]any{
 				"Username": username,
 			})
-			welcomeText += "\n\n" + localization.GetText(ctx, "captcha_prompt", nil)
+			welcomeText += "\n\n" + challengePrompt(ctx, challenge)
 
-			log.Printf("Sending captcha image to chat %d", chatID)
-
-			// Send captcha image
-			photoMsg, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
-				ChatID:    chatID,
-				Photo:     &tgmodels.InputFileUpload{Data: bytes.NewReader(captchaImg.Image)},
-				Caption:   welcomeText,
-				ParseMode: tgmodels.ParseModeMarkdownV1,
-			})
-			if err != nil {
-				log.Printf("Failed to send captcha image: %v", err)
-				continue
-			}
-
-			log.Printf("Captcha image sent, message ID: %d", photoMsg.ID)
+			// Offer an audio version and a new challenge to members who cannot solve this one
+			addChallengeControls(ctx, registry, challenge, limits.Refreshes)
 
 			// Get FSM from context
 			captchaFSM, ok := fsm.GetCaptchaFSM(ctx)
@@ -75,51 +89,249 @@ func HandleNewChatMember(captchaService *captcha.Service) bot.HandlerFunc {
 				continue
 			}
 
-			// Save state in FSM
-			captchaFSM.SetState(newMember.ID, &fsm.CaptchaData{
-				ChatID:         chatID,
-				UserID:         newMember.ID,
-				Answer:         captchaImg.Answer,
-				ExpiresAt:      time.Now().Add(30 * time.Second),
-				PhotoMessageID: photoMsg.ID,
+			// Start the verification before the challenge is sent, so an answer
+			// arriving right after it finds the verification
+			deadline := time.Now().Add(30 * time.Second)
+			data := &fsm.CaptchaData{
+				ChatID:        chatID,
+				UserID:        newMember.ID,
+				TopicID:       key.TopicID,
+				Type:          challenge.Type,
+				Modality:      challenge.Modality,
+				Answer:        challenge.Answer,
+				Seed:          challenge.Seed,
+				ExpiresAt:     deadline,
+				Buttons:       challenge.Buttons,
+				AttemptsLeft:  max(limits.Attempts, 1),
+				RefreshesLeft: limits.Refreshes,
+				MinAnswerTime: limits.MinAnswerTime,
+				Mention:       username,
+				LanguageCode:  settings.language(&newMember),
+			}
+			captchaFSM.SetState(key, data)
+
+			log.Printf("Sending captcha to chat %d", chatID)
+
+			// Send captcha
+			photoMsg, err := sendChallenge(ctx, b, key, challenge, welcomeText, tgmodels.ParseModeMarkdownV1)
+			if err != nil {
+				log.Printf("Failed to send captcha: %v", err)
+				// The verification ends without a punishment and is cleared
+				// right away, as the member never saw the challenge
+				_, err := captchaFSM.Transition(data, fsm.StateFailed, func(next *fsm.CaptchaData) {
+					next.ExpiresAt = time.Now()
+				})
+				if err != nil {
+					log.Printf("Failed to end verification of user %d in chat %d: %v", newMember.ID, chatID, err)
+				}
+				scheduleTimeoutCheck(ctx, key, time.Now())
+				continue
+			}
+
+			log.Printf("Captcha sent, message ID: %d", photoMsg.ID)
+
+			// Mini App challenges are not answered in the chat, so the member
+			// is kept silent until verified. Only members who got the
+			// challenge are restricted, nothing else would lift it.
+			restricted := challenge.Modality == captcha.ModalityWebApp && restrictMember(ctx, b, chatID, newMember.ID)
+
+			_, err = captchaFSM.Transition(data, fsm.StateChallenged, func(next *fsm.CaptchaData) {
+				next.PhotoMessageID = photoMsg.ID
+				next.ShownAt = time.Now()
+				next.Restricted = restricted
 			})
+			if err != nil {
+				log.Printf("Failed to challenge user %d in chat %d: %v", newMember.ID, chatID, err)
+				deleteMessage(ctx, b, chatID, photoMsg.ID)
+				if restricted {
+					liftRestrictions(ctx, b, chatID, newMember.ID)
+				}
+				continue
+			}
 
-			log.Printf("FSM state saved for user %d", newMember.ID)
+			log.Printf("FSM state saved for user %d in chat %d", newMember.ID, chatID)
 
 			// Schedule timeout check
-			go scheduleTimeoutCheck(b, chatID, newMember.ID, username, photoMsg.ID, captchaFSM)
+			scheduleTimeoutCheck(ctx, key, deadline)
 
 			log.Printf("Timeout check scheduled for user %d", newMember.ID)
 		}
 	}
 }
 
-// scheduleTimeoutCheck checks if user completed captcha within timeout
-func scheduleTimeoutCheck(b *bot.Bot, chatID, userID int64, username string, photoMsgID int, captchaFSM *fsm.CaptchaFSM) {
-	time.Sleep(30 * time.Second)
+// sendChallenge sends the challenge payload with the caption and its inline
+// keyboard to the chat and topic of the verification
+func sendChallenge(ctx context.Context, b *bot.Bot, key fsm.CaptchaKey, challenge *captcha.Challenge, caption string, parseMode tgmodels.ParseMode) (*tgmodels.Message, error) {
+	var replyMarkup tgmodels.ReplyMarkup
+	if len(challenge.Buttons) > 0 {
+		replyMarkup = challengeKeyboard(ctx, key.UserID, challenge.Buttons)
+	}
 
-	// Check if state still exists (if it does, user didn't complete it)
-	if _, ok := captchaFSM.GetState(userID); !ok {
-		// User already verified or removed
-		return
+	if challenge.Media == nil {
+		return b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          key.ChatID,
+			MessageThreadID: key.TopicID,
+			Text:            caption,
+			ParseMode:       parseMode,
+			ReplyMarkup:     replyMarkup,
+		})
 	}
 
-	// Check if expired
-	if !captchaFSM.IsExpired(userID) {
-		// Still within time window
-		return
+	media := &tgmodels.InputFileUpload{
+		Filename: challenge.Media.Filename,
+		Data:     bytes.NewReader(challenge.Media.Data),
+	}
+
+	switch challenge.Media.Kind {
+	case captcha.MediaPhoto:
+		return b.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:          key.ChatID,
+			MessageThreadID: key.TopicID,
+			Photo:           media,
+			Caption:         caption,
+			ParseMode:       parseMode,
+			ReplyMarkup:     replyMarkup,
+		})
+	case captcha.MediaAnimation:
+		return b.SendAnimation(ctx, &bot.SendAnimationParams{
+			ChatID:          key.ChatID,
+			MessageThreadID: key.TopicID,
+			Animation:       media,
+			Caption:         caption,
+			ParseMode:       parseMode,
+			ReplyMarkup:     replyMarkup,
+		})
+	case captcha.MediaVoice:
+		return b.SendVoice(ctx, &bot.SendVoiceParams{
+			ChatID:          key.ChatID,
+			MessageThreadID: key.TopicID,
+			Voice:           media,
+			Caption:         caption,
+			ParseMode:       parseMode,
+			ReplyMarkup:     replyMarkup,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported media kind %q", challenge.Media.Kind)
+	}
+}
+
+// challengePrompt returns the localized prompt of the challenge. Prompt data
+// may come from chat admins, so it is escaped for Markdown.
+func challengePrompt(ctx context.Context, challenge *captcha.Challenge) string {
+	data := make(map[string]any, len(challenge.PromptData))
+	for key, value := range challenge.PromptData {
+		if text, ok := value.(string); ok {
+			value = escapeMarkdownV1(text)
+		}
+		data[key] = value
+	}
+	return localization.GetText(ctx, challenge.PromptID, data)
+}
+
+// escapeMarkdownV1 escapes the characters that start entities in legacy Markdown
+func escapeMarkdownV1(text string) string {
+	for _, char := range []string{"_", "*", "`", "["} {
+		text = strings.ReplaceAll(text, char, "\\"+char)
+	}
+	return text
+}
+
+// addChallengeControls adds a row with a button switching a visual text
+// challenge to an audio one and a button requesting a new challenge, and a
+// button asking the chat admins for approval
+func addChallengeControls(ctx context.Context, registry *captcha.Registry, challenge *captcha.Challenge, refreshesLeft int) {
+	var row []captcha.Button
+
+	if challenge.Modality == captcha.ModalityText && challenge.Media != nil && challenge.Type != captcha.TypeAudio {
+		if _, ok := registry.Get(captcha.TypeAudio); ok {
+			row = append(row, captcha.Button{TextID: "captcha_audio_button", Value: captcha.AudioValue})
+		}
+	}
+
+	if refreshesLeft > 0 {
+		row = append(row, captcha.Button{TextID: "captcha_refresh_button", Value: captcha.RefreshValue})
 	}
 
-	ctx := context.Background()
+	if len(row) > 0 {
+		challenge.Buttons = append(challenge.Buttons, row)
+	}
+
+	// Members who cannot solve any challenge may ask the admins instead
+	challenge.Buttons = append(challenge.Buttons, []captcha.Button{{TextID: "captcha_approval_button", Value: captcha.ApprovalValue}})
+}
 
-	// Delete state
-	captchaFSM.DeleteState(userID)
+// messageTopic returns the forum topic of the message, 0 outside forums.
+// Replies in other supergroups carry a thread ID as well, which is not a topic.
+func messageTopic(msg *tgmodels.Message) int {
+	if msg.Chat.IsForum && msg.IsTopicMessage {
+		return msg.MessageThreadID
+	}
+	return 0
+}
+
+// challengeKeyboard builds the inline keyboard of a challenge addressed to the user
+func challengeKeyboard(ctx context.Context, userID int64, buttons [][]captcha.Button) *tgmodels.InlineKeyboardMarkup {
+	keyboard := make([][]tgmodels.InlineKeyboardButton, 0, len(buttons))
+	for _, row := range buttons {
+		keyboardRow := make([]tgmodels.InlineKeyboardButton, 0, len(row))
+		for _, button := range row {
+			text := button.Text
+			if button.TextID != "" {
+				text = localization.GetSimpleText(ctx, button.TextID)
+			}
+			if button.URL != "" {
+				keyboardRow = append(keyboardRow, tgmodels.InlineKeyboardButton{Text: text, URL: button.URL})
+				continue
+			}
+			keyboardRow = append(keyboardRow, tgmodels.InlineKeyboardButton{
+				Text:         text,
+				CallbackData: fmt.Sprintf("%s%d:%s", captchaCallbackPrefix, userID, button.Value),
+			})
+		}
+		keyboard = append(keyboard, keyboardRow)
+	}
+	return &tgmodels.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// ResumeVerifications schedules the timeout checks of the verifications
+// stored when the bot stopped; the pending ones that expired meanwhile are
+// punished as soon as the scheduler runs, the ended ones are cleared
+func ResumeVerifications(ctx context.Context, captchaFSM *fsm.CaptchaFSM) error {
+	states, err := captchaFSM.Pending(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pending verifications: %w", err)
+	}
+
+	active := 0
+	for _, data := range states {
+		if data.State.Active() {
+			active++
+		}
+		scheduleTimeoutCheck(ctx, data.Key(), data.ExpiresAt)
+	}
+
+	log.Printf("Resumed %d pending verifications, %d ended ones kept for appeals", active, len(states)-active)
+	return nil
+}
+
+// LogTransition logs the state changes of verifications for auditing
+func LogTransition(from, to fsm.CaptchaState, data *fsm.CaptchaData) {
+	if from == fsm.StateNone {
+		from = "none"
+	}
+	log.Printf("Verification of user %d in chat %d: %s -> %s", data.UserID, data.ChatID, from, to)
+}
+
+// timeoutCaptcha bans the user of an expired verification taken from the FSM
+func timeoutCaptcha(ctx context.Context, b *bot.Bot, data *fsm.CaptchaData) {
+	chatID, userID := data.ChatID, data.UserID
+	mention := verificationMention(data)
 
 	// Kick and ban user
 	_, err := b.BanChatMember(ctx, &bot.BanChatMemberParams{
 		ChatID:         chatID,
 		UserID:         userID,
-		UntilDate:      int(time.Now().Add(10 * time.Minute).Unix()),
+		UntilDate:      int(time.Now().Add(appealWindow).Unix()),
 		RevokeMessages: false,
 	})
 	if err != nil {
@@ -128,28 +340,19 @@ func scheduleTimeoutCheck(b *bot.Bot, chatID, userID int64, username string, pho
 	}
 
 	// Delete captcha messages
-	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: photoMsgID,
-	})
+	deleteMessage(ctx, b, chatID, data.PhotoMessageID)
 
 	// Send timeout message
-	timeoutText := fmt.Sprintf("⏱ Verification timeout. %s has been removed from the chat and banned for 10 minutes.", username)
-
-	msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:    chatID,
-		Text:      timeoutText,
-		ParseMode: tgmodels.ParseModeMarkdownV1,
+	timeoutText := localization.GetText(ctx, "captcha_timeout", map[string]any{
+		"Username": mention,
 	})
-	if err != nil {
+
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          chatID,
+		MessageThreadID: data.TopicID,
+		Text:            timeoutText,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+	}, noticeMessageTTL); err != nil {
 		log.Printf("Failed to send timeout message: %v", err)
-		return
 	}
-
-	// Delete timeout message after 10 seconds
-	time.Sleep(10 * time.Second)
-	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: msg.ID,
-	})
 }
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"gofency/internal/captcha"
 	"gofency/internal/fsm"
 	"gofency/internal/localization"
 
@@ -14,15 +17,33 @@ import (
 	tgmodels "github.com/go-telegram/bot/models"
 )
 
-// HandleCaptchaTextAnswer handles captcha text input from users
-func HandleCaptchaTextAnswer(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
-	if update.Message == nil || update.Message.Text == "" {
+// captchaCallbackPrefix prefixes callback data of captcha inline buttons
+const captchaCallbackPrefix = "captcha:"
+
+// wrongAnswerMessageTTL is how long the "attempts left" feedback stays in the chat
+const wrongAnswerMessageTTL = 5 * time.Second
+
+// noticeMessageTTL is how long success, failure and timeout notices stay in the chat
+const noticeMessageTTL = 10 * time.Second
+
+// HandleCaptchaTextAnswer handles captcha text input from users. Other
+// messages of members under verification are deleted, they may only write
+// once verified.
+func HandleCaptchaTextAnswer(registry *captcha.Registry) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+		handleCaptchaTextAnswer(ctx, b, update, registry)
+	}
+}
+
+func handleCaptchaTextAnswer(ctx context.Context, b *bot.Bot, update *tgmodels.Update, registry *captcha.Registry) {
+	if update.Message == nil || update.Message.From == nil {
 		return
 	}
 
 	userID := update.Message.From.ID
 	chatID := update.Message.Chat.ID
 	answer := update.Message.Text
+	key := fsm.CaptchaKey{ChatID: chatID, UserID: userID, TopicID: messageTopic(update.Message)}
 
 	// Get FSM from context
 	captchaFSM, ok := fsm.GetCaptchaFSM(ctx)
@@ -31,15 +52,25 @@ func HandleCaptchaTextAnswer(ctx context.Context, b *bot.Bot, update *tgmodels.U
 		return
 	}
 
-	// Check if user has a pending captcha
-	data, ok := captchaFSM.GetState(userID)
+	// Check if user has a pending captcha in this chat
+	data, ok := captchaFSM.GetState(key)
 	if !ok {
-		// No pending captcha for this user, ignore message
+		// Members waiting for the admins may not write either, in case
+		// they could not be restricted
+		if data, ok := captchaFSM.Lookup(key); ok && data.State == fsm.StatePendingApproval {
+			deleteMessage(ctx, b, chatID, update.Message.ID)
+		}
+		return
+	}
+
+	if data.Modality != captcha.ModalityText || answer == "" {
+		// Challenge is answered in another way, the message is no answer
+		deleteMessage(ctx, b, chatID, update.Message.ID)
 		return
 	}
 
 	// Check if expired
-	if captchaFSM.IsExpired(userID) {
+	if captchaFSM.IsExpired(key) {
 		// Already expired, will be handled by timeout goroutine
 		return
 	}
@@ -50,81 +81,384 @@ func HandleCaptchaTextAnswer(ctx context.Context, b *bot.Bot, update *tgmodels.U
 		MessageID: update.Message.ID,
 	})
 
-	// Validate answer
-	if answer == data.Answer {
-		// Correct answer - delete state
-		captchaFSM.DeleteState(userID)
-
-		// Delete captcha messages
-		b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-			ChatID:    chatID,
-			MessageID: data.PhotoMessageID,
-		})
-
-		// Send success message
-		successText := localization.GetText(ctx, "captcha_success", map[string]interface{}{
-			"Username": GenerateMention(update.Message.From),
-		})
-
-		msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:    chatID,
-			Text:      successText,
-			ParseMode: tgmodels.ParseModeMarkdownV1,
-		})
-		if err != nil {
-			log.Printf("Failed to send success message: %v", err)
+	answerCaptcha(ctx, b, registry, captchaFSM, data, answer, update.Message.From)
+}
+
+// HandleCaptchaCallback handles presses of captcha inline buttons
+func HandleCaptchaCallback(registry *captcha.Registry) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+		if update.CallbackQuery == nil {
 			return
 		}
 
-		// Delete success message after 10 seconds
-		go func() {
-			time.Sleep(10 * time.Second)
-			b.DeleteMessage(context.Background(), &bot.DeleteMessageParams{
-				ChatID:    chatID,
-				MessageID: msg.ID,
-			})
-		}()
-	} else {
-		// Wrong answer - delete state
-		captchaFSM.DeleteState(userID)
-
-		// Kick user (ban then immediately unban to just kick)
-		_, err := b.BanChatMember(ctx, &bot.BanChatMemberParams{
-			ChatID:         chatID,
-			UserID:         userID,
-			UntilDate:      int(time.Now().Add(10 * time.Minute).Unix()),
-			RevokeMessages: false,
-		})
-		if err != nil {
-			log.Printf("Failed to ban user %d: %v", userID, err)
+		query := update.CallbackQuery
+
+		// Callback data format: captcha:<userID>:<value>
+		parts := strings.SplitN(strings.TrimPrefix(query.Data, captchaCallbackPrefix), ":", 2)
+		if len(parts) != 2 {
+			b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+			return
 		}
 
-		// Delete captcha messages
-		b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-			ChatID:    chatID,
-			MessageID: data.PhotoMessageID,
-		})
-
-		// Send failure message
-		failedText := localization.GetSimpleText(ctx, "captcha_failed")
-		msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatID,
-			Text:   failedText,
-		})
+		userID, err := strconv.ParseInt(parts[0], 10, 64)
 		if err != nil {
-			log.Printf("Failed to send failure message: %v", err)
+			b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
 			return
 		}
+		value := parts[1]
 
-		// Delete failure message after 10 seconds
-		go func() {
-			time.Sleep(10 * time.Second)
-			b.DeleteMessage(context.Background(), &bot.DeleteMessageParams{
-				ChatID:    chatID,
-				MessageID: msg.ID,
+		// Only the user the captcha was sent to may answer it
+		if query.From.ID != userID {
+			b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            localization.GetSimpleText(ctx, "captcha_not_yours"),
+				ShowAlert:       true,
 			})
-		}()
+			return
+		}
+
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+
+		// The challenge message tells the chat and topic of the verification
+		message := query.Message.Message
+		if message == nil {
+			return
+		}
+		key := fsm.CaptchaKey{ChatID: message.Chat.ID, UserID: userID, TopicID: messageTopic(message)}
+
+		// Get FSM from context
+		captchaFSM, ok := fsm.GetCaptchaFSM(ctx)
+		if !ok {
+			return
+		}
+
+		// Check if user has a pending captcha in this chat
+		data, ok := captchaFSM.GetState(key)
+		if !ok {
+			return
+		}
+
+		// Check if expired
+		if captchaFSM.IsExpired(key) {
+			// Already expired, will be handled by timeout goroutine
+			return
+		}
+
+		// Automation presses buttons people ignore
+		if captcha.IsHoneypot(data.Buttons, value) {
+			log.Printf("User %d pressed a honeypot button in chat %d after %s, punishing", userID, data.ChatID, time.Since(data.ShownAt).Round(time.Millisecond))
+			failCaptcha(ctx, b, captchaFSM, data)
+			return
+		}
+
+		switch value {
+		case captcha.AudioValue:
+			switchToAudio(ctx, b, registry, captchaFSM, data, &query.From)
+			return
+		case captcha.RefreshValue:
+			refreshChallenge(ctx, b, registry, captchaFSM, data, &query.From)
+			return
+		case captcha.ApprovalValue:
+			requestApproval(ctx, b, captchaFSM, data)
+			return
+		}
+
+		if data.Modality == captcha.ModalitySelection {
+			handleSelection(ctx, b, registry, captchaFSM, data, value, &query.From)
+			return
+		}
+
+		if data.Modality == captcha.ModalitySequence {
+			handleSequence(ctx, b, registry, captchaFSM, data, value, &query.From)
+			return
+		}
+
+		if data.Modality != captcha.ModalityButton {
+			return
+		}
+
+		answerCaptcha(ctx, b, registry, captchaFSM, data, value, &query.From)
+	}
+}
+
+// handleSelection toggles a tile of a selection challenge or verifies the
+// selection when the user submits it
+func handleSelection(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, value string, user *tgmodels.User) {
+	if value == captcha.GridSubmitValue {
+		answerCaptcha(ctx, b, registry, captchaFSM, data, data.Selection, user)
+		return
+	}
+
+	selection, ok := captcha.ToggleGridTile(data.Selection, value)
+	if !ok {
+		return
+	}
+
+	updated := *data
+	updated.Selection = selection
+	if !captchaFSM.UpdateState(data.Key(), &updated) {
+		return
+	}
+
+	// Mark the selected tiles on the keyboard
+	_, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:      data.ChatID,
+		MessageID:   data.PhotoMessageID,
+		ReplyMarkup: challengeKeyboard(ctx, data.UserID, captcha.GridButtons(data.Buttons, selection)),
+	})
+	if err != nil {
+		log.Printf("Failed to update selection of user %d: %v", data.UserID, err)
+	}
+}
+
+// handleSequence records a pressed button of a sequence challenge and
+// verifies the sequence once all of its buttons were pressed
+func handleSequence(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, value string, user *tgmodels.User) {
+	selection, ok := captcha.PressSequenceButton(data.Buttons, data.Selection, value)
+	if !ok {
+		return
+	}
+
+	if captcha.SequenceComplete(data.Answer, selection) {
+		answerCaptcha(ctx, b, registry, captchaFSM, data, selection, user)
+		return
+	}
+
+	updated := *data
+	updated.Selection = selection
+	if !captchaFSM.UpdateState(data.Key(), &updated) {
+		return
+	}
+
+	// Number the pressed buttons on the keyboard
+	_, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:      data.ChatID,
+		MessageID:   data.PhotoMessageID,
+		ReplyMarkup: challengeKeyboard(ctx, data.UserID, captcha.SequenceButtons(data.Buttons, selection)),
+	})
+	if err != nil {
+		log.Printf("Failed to update sequence of user %d: %v", data.UserID, err)
+	}
+}
+
+// switchToAudio replaces the pending challenge with an audio one
+func switchToAudio(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, user *tgmodels.User) {
+	if data.Type == captcha.TypeAudio {
+		return
+	}
+
+	if replaceChallenge(ctx, b, registry, captchaFSM, data, captcha.TypeAudio, user) {
+		log.Printf("Switched user %d to audio captcha", data.UserID)
+	}
+}
+
+// refreshChallenge replaces the pending challenge with a new one of the same
+// type while the user has refreshes left
+func refreshChallenge(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, user *tgmodels.User) {
+	if data.RefreshesLeft <= 0 {
+		return
+	}
+
+	updated := *data
+	updated.RefreshesLeft--
+	if replaceChallenge(ctx, b, registry, captchaFSM, &updated, data.Type, user) {
+		log.Printf("Refreshed captcha of user %d, %d refreshes left", data.UserID, updated.RefreshesLeft)
+	}
+}
+
+// answerCaptcha verifies a complete answer and records how long the user took.
+// Answers given faster than a human could read the challenge count as wrong
+// whatever they are.
+func answerCaptcha(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, answer string, user *tgmodels.User) {
+	elapsed := time.Since(data.ShownAt)
+
+	updated := *data
+	updated.AnswerTimes = append(slices.Clip(data.AnswerTimes), elapsed)
+
+	if elapsed < data.MinAnswerTime {
+		log.Printf("Answer of user %d in chat %d after %s is faster than a human, counted as wrong", data.UserID, data.ChatID, elapsed.Round(time.Millisecond))
+		wrongAnswer(ctx, b, registry, captchaFSM, &updated, user)
+		return
+	}
+
+	// Validate answer
+	if registry.Verify(data.Type, data.Answer, answer) {
+		passCaptcha(ctx, b, captchaFSM, &updated, user)
+	} else {
+		wrongAnswer(ctx, b, registry, captchaFSM, &updated, user)
+	}
+}
+
+// wrongAnswer spends an attempt and punishes the user once none are left.
+// Challenges answered with buttons are replaced after a wrong answer, so
+// the right button cannot be found by elimination.
+func wrongAnswer(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, user *tgmodels.User) {
+	if data.AttemptsLeft <= 1 {
+		log.Printf("User %d failed %s captcha in chat %d, answer times %v", data.UserID, data.Type, data.ChatID, roundDurations(data.AnswerTimes))
+		failCaptcha(ctx, b, captchaFSM, data)
+		return
+	}
+
+	updated, err := captchaFSM.Transition(data, fsm.StateRetrying, func(next *fsm.CaptchaData) {
+		next.AttemptsLeft--
+		next.Selection = ""
+	})
+	if err != nil {
+		// A concurrent answer or the timeout may have ended the verification
+		log.Printf("Failed to record wrong answer of user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		return
+	}
+
+	if data.Modality != captcha.ModalityText {
+		replaceChallenge(ctx, b, registry, captchaFSM, updated, data.Type, user)
+	}
+
+	log.Printf("Wrong captcha answer from user %d, %d attempts left", data.UserID, updated.AttemptsLeft)
+
+	// Send feedback and delete it after a few seconds
+	text := localization.GetPluralText(ctx, "captcha_wrong_answer", updated.AttemptsLeft, map[string]any{
+		"Username": GenerateMention(user),
+	})
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            text,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+	}, wrongAnswerMessageTTL); err != nil {
+		log.Printf("Failed to send wrong answer message: %v", err)
+	}
+}
+
+// replaceChallenge sends a new challenge of the given type in place of the
+// pending one; the deadline and the attempts left stay the same
+func replaceChallenge(ctx context.Context, b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, typ string, user *tgmodels.User) bool {
+	settings := chatChallengeSettings(ctx, data.ChatID)
+	difficulty := settings.Difficulty
+	challenge, err := registry.Generate(ctx, captcha.Request{
+		ChatID:       data.ChatID,
+		UserID:       data.UserID,
+		LanguageCode: settings.language(user),
+		Difficulty:   difficulty,
+	}, typ)
+	if err != nil {
+		log.Printf("Failed to generate %s captcha: %v", typ, err)
+		return false
+	}
+	log.Printf("Generated %s captcha (difficulty %q, seed %d) for user %d", challenge.Type, difficulty, challenge.Seed, data.UserID)
+
+	caption := localization.GetText(ctx, "captcha_welcome", map[string]any{
+		"Username": GenerateMention(user),
+	})
+	caption += "\n\n" + challengePrompt(ctx, challenge)
+
+	addChallengeControls(ctx, registry, challenge, data.RefreshesLeft)
+
+	msg, err := sendChallenge(ctx, b, data.Key(), challenge, caption, tgmodels.ParseModeMarkdownV1)
+	if err != nil {
+		log.Printf("Failed to send %s captcha: %v", typ, err)
+		return false
+	}
+
+	updated := *data
+	updated.Type = challenge.Type
+	updated.Modality = challenge.Modality
+	updated.Answer = challenge.Answer
+	updated.Seed = challenge.Seed
+	updated.PhotoMessageID = msg.ID
+	updated.Buttons = challenge.Buttons
+	updated.Selection = ""
+	updated.ShownAt = time.Now()
+
+	// Remove the previous challenge, or the new one if the verification
+	// ended while it was sent
+	obsolete := data.PhotoMessageID
+	replaced := captchaFSM.UpdateState(data.Key(), &updated)
+	if !replaced {
+		obsolete = msg.ID
+	}
+	deleteMessage(ctx, b, data.ChatID, obsolete)
+
+	return replaced
+}
+
+// passCaptcha verifies the member and greets them
+func passCaptcha(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, user *tgmodels.User) {
+	// Correct answer - a concurrent answer may have ended the verification
+	if _, err := captchaFSM.Transition(data, fsm.StateVerified, nil); err != nil {
+		log.Printf("Failed to verify user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		return
+	}
+	cancelTimeoutCheck(ctx, data.Key())
+	log.Printf("User %d passed %s captcha in chat %d, answer times %v", data.UserID, data.Type, data.ChatID, roundDurations(data.AnswerTimes))
+
+	if data.Restricted {
+		liftRestrictions(ctx, b, data.ChatID, data.UserID)
+	}
+
+	// Delete captcha messages
+	deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+
+	// Send success message
+	successText := localization.GetText(ctx, "captcha_success", map[string]interface{}{
+		"Username": GenerateMention(user),
+	})
+
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            successText,
+		ParseMode:       tgmodels.ParseModeMarkdownV1,
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to send success message: %v", err)
+	}
+}
+
+// failCaptcha fails the verification and bans the user for the appeal
+// window; the verification is kept for an appeal until the ban ends
+func failCaptcha(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData) {
+	// Wrong answer - a concurrent answer may have ended the verification
+	failed, err := captchaFSM.Transition(data, fsm.StateFailed, func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(appealWindow)
+	})
+	if err != nil {
+		log.Printf("Failed to fail verification of user %d in chat %d: %v", data.UserID, data.ChatID, err)
+		return
+	}
+	// The timeout check clears the verification once it can't be appealed
+	scheduleTimeoutCheck(ctx, data.Key(), failed.ExpiresAt)
+
+	// Kick user (ban then immediately unban to just kick)
+	_, err = b.BanChatMember(ctx, &bot.BanChatMemberParams{
+		ChatID:         data.ChatID,
+		UserID:         data.UserID,
+		UntilDate:      int(time.Now().Add(appealWindow).Unix()),
+		RevokeMessages: false,
+	})
+	if err != nil {
+		log.Printf("Failed to ban user %d: %v", data.UserID, err)
+	}
+
+	// Delete captcha messages
+	deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+
+	// Send failure message
+	failedText := localization.GetSimpleText(ctx, "captcha_failed")
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          data.ChatID,
+		MessageThreadID: data.TopicID,
+		Text:            failedText,
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to send failure message: %v", err)
+	}
+}
+
+// roundDurations rounds answer times to milliseconds for logs
+func roundDurations(durations []time.Duration) []time.Duration {
+	rounded := make([]time.Duration, len(durations))
+	for i, d := range durations {
+		rounded[i] = d.Round(time.Millisecond)
 	}
+	return rounded
 }
 
 func EscapeMarkdown(text string) string {
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/fsm"
+
+	"github.com/go-telegram/bot"
+	tgmodels "github.com/go-telegram/bot/models"
+)
+
+// fakeTelegram answers Bot API requests with success and records the called
+// methods with their parameters
+type fakeTelegram struct {
+	mu        sync.Mutex
+	calls     []fakeCall
+	messageID int
+	// status is the status of every chat member, administrator when empty
+	status string
+}
+
+// fakeCall is a recorded Bot API request
+type fakeCall struct {
+	method string
+	params map[string]string
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	call := fakeCall{method: path.Base(r.URL.Path), params: make(map[string]string)}
+	if r.MultipartForm != nil {
+		for name, values := range r.MultipartForm.Value {
+			call.params[name] = values[0]
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.messageID++
+	messageID := f.messageID
+	status := f.status
+	f.mu.Unlock()
+	if status == "" {
+		status = "administrator"
+	}
+
+	chatID := call.params["chat_id"]
+	if chatID == "" {
+		chatID = "0"
+	}
+
+	result := `true`
+	switch call.method {
+	case "sendMessage", "sendPhoto", "sendAnimation", "sendVoice", "editMessageReplyMarkup":
+		result = fmt.Sprintf(`{"message_id":%d,"date":%d,"chat":{"id":%s,"type":"supergroup"}}`, messageID, time.Now().Unix(), chatID)
+	case "getChat":
+		result = fmt.Sprintf(`{"id":%s,"type":"supergroup","permissions":{"can_send_messages":true}}`, chatID)
+	case "getChatMember":
+		result = fmt.Sprintf(`{"status":%q,"user":{"id":%s,"is_bot":false,"first_name":"Member"}}`, status, call.params["user_id"])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+// setStatus sets the status of every chat member
+func (f *fakeTelegram) setStatus(status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// called returns the parameters of the requests of the method
+func (f *fakeTelegram) called(method string) []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var params []map[string]string
+	for _, call := range f.calls {
+		if call.method == method {
+			params = append(params, call.params)
+		}
+	}
+	return params
+}
+
+// newTestBot creates a bot talking to a fake Telegram
+func newTestBot(t *testing.T) (*bot.Bot, *fakeTelegram) {
+	t.Helper()
+	fake := &fakeTelegram{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	b, err := bot.New("test-token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	return b, fake
+}
+
+// newTestRegistry registers the providers the tests answer
+func newTestRegistry(t *testing.T) *captcha.Registry {
+	t.Helper()
+	webApp, err := captcha.NewWebAppProvider("https://t.me/gofency_bot/verify")
+	if err != nil {
+		t.Fatalf("Failed to create Mini App provider: %v", err)
+	}
+	registry, err := captcha.NewRegistry(captcha.NewService(t.TempDir()), captcha.NewButtonProvider(), webApp)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	return registry
+}
+
+// challenged starts the verification and delivers its challenge as message 100
+func challenged(t *testing.T, captchaFSM *fsm.CaptchaFSM, data *fsm.CaptchaData, shownAt time.Time) *fsm.CaptchaData {
+	t.Helper()
+	captchaFSM.SetState(data.Key(), data)
+	data, err := captchaFSM.Transition(data, fsm.StateChallenged, func(next *fsm.CaptchaData) {
+		next.PhotoMessageID = 100
+		next.ShownAt = shownAt
+	})
+	if err != nil {
+		t.Fatalf("Failed to challenge: %v", err)
+	}
+	return data
+}
+
+// digitsVerification is a text challenge of user 42 in chat -100
+func digitsVerification(attempts int, minAnswerTime time.Duration) *fsm.CaptchaData {
+	return &fsm.CaptchaData{
+		ChatID:        -100,
+		UserID:        42,
+		Type:          captcha.TypeDigits,
+		Modality:      captcha.ModalityText,
+		Answer:        "12345",
+		ExpiresAt:     time.Now().Add(time.Minute),
+		AttemptsLeft:  attempts,
+		MinAnswerTime: minAnswerTime,
+	}
+}
+
+// textAnswer is a message of user 42 answering in chat -100
+func textAnswer(text string) *tgmodels.Update {
+	return &tgmodels.Update{Message: &tgmodels.Message{
+		ID:   200,
+		From: &tgmodels.User{ID: 42, FirstName: "Member"},
+		Chat: tgmodels.Chat{ID: -100, Type: tgmodels.ChatTypeSupergroup},
+		Text: text,
+	}}
+}
+
+// chatMessage is a message in chat -100
+func chatMessage(id int) *tgmodels.Message {
+	return &tgmodels.Message{ID: id, Chat: tgmodels.Chat{ID: -100, Type: tgmodels.ChatTypeSupergroup}}
+}
+
+// buttonPress is a press of an inline button of the message
+func buttonPress(userID int64, data string, message *tgmodels.Message) *tgmodels.Update {
+	return &tgmodels.Update{CallbackQuery: &tgmodels.CallbackQuery{
+		ID:   "1",
+		From: tgmodels.User{ID: userID},
+		Data: data,
+		Message: tgmodels.MaybeInaccessibleMessage{
+			Type:    tgmodels.MaybeInaccessibleMessageTypeMessage,
+			Message: message,
+		},
+	}}
+}
+
+func TestHoneypotIsPunished(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+
+	challenge, err := captcha.NewButtonProvider().NewChallenge(ctx, captcha.Request{})
+	if err != nil {
+		t.Fatalf("Failed to create challenge: %v", err)
+	}
+	honeypot := ""
+	for _, row := range challenge.Buttons {
+		for _, button := range row {
+			if button.Honeypot {
+				honeypot = button.Value
+			}
+		}
+	}
+
+	data := challenged(t, captchaFSM, &fsm.CaptchaData{
+		ChatID:       -100,
+		UserID:       42,
+		Type:         challenge.Type,
+		Modality:     challenge.Modality,
+		Answer:       challenge.Answer,
+		Buttons:      challenge.Buttons,
+		ExpiresAt:    time.Now().Add(time.Minute),
+		AttemptsLeft: 3,
+	}, time.Now().Add(-10*time.Second))
+
+	HandleCaptchaCallback(registry)(ctx, b, buttonPress(42, captchaCallbackPrefix+"42:"+honeypot, chatMessage(100)))
+
+	// One press fails the member whatever attempts they had left
+	if failed, ok := captchaFSM.Lookup(data.Key()); !ok || failed.State != fsm.StateFailed {
+		t.Fatalf("Expected the verification to fail, got %v", failed)
+	}
+	bans := fake.called("banChatMember")
+	if len(bans) != 1 || bans[0]["user_id"] != "42" {
+		t.Errorf("Expected the member to be banned, got %v", bans)
+	}
+	deleted := fake.called("deleteMessage")
+	if len(deleted) != 1 || deleted[0]["message_id"] != "100" {
+		t.Errorf("Expected the challenge to be deleted, got %v", deleted)
+	}
+}
+
+func TestFastAnswerCountsAsWrong(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+
+	data := challenged(t, captchaFSM, digitsVerification(3, time.Minute), time.Now())
+
+	// The right answer given faster than a human reads is rejected
+	HandleCaptchaTextAnswer(registry)(ctx, b, textAnswer("12345"))
+
+	retrying, ok := captchaFSM.GetState(data.Key())
+	if !ok || retrying.State != fsm.StateRetrying {
+		t.Fatalf("Expected the member to retry, got %v", retrying)
+	}
+	if retrying.AttemptsLeft != 2 || len(retrying.AnswerTimes) != 1 {
+		t.Errorf("Expected an attempt spent and its time recorded, got %d left and %v", retrying.AttemptsLeft, retrying.AnswerTimes)
+	}
+	if bans := fake.called("banChatMember"); len(bans) != 0 {
+		t.Errorf("Expected no ban with attempts left, got %v", bans)
+	}
+}
+
+func TestWrongAnswerRetriesThenFails(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+
+	data := challenged(t, captchaFSM, digitsVerification(2, 0), time.Now().Add(-5*time.Second))
+
+	HandleCaptchaTextAnswer(registry)(ctx, b, textAnswer("54321"))
+
+	retrying, ok := captchaFSM.GetState(data.Key())
+	if !ok || retrying.State != fsm.StateRetrying || retrying.AttemptsLeft != 1 {
+		t.Fatalf("Expected the member to retry with 1 attempt left, got %v", retrying)
+	}
+	// Text challenges stay, only the answer is deleted
+	if deleted := fake.called("deleteMessage"); len(deleted) != 1 || deleted[0]["message_id"] != "200" {
+		t.Errorf("Expected the answer to be deleted, got %v", deleted)
+	}
+
+	// The last wrong answer is punished
+	HandleCaptchaTextAnswer(registry)(ctx, b, textAnswer("54321"))
+
+	if failed, ok := captchaFSM.Lookup(data.Key()); !ok || failed.State != fsm.StateFailed {
+		t.Fatalf("Expected the verification to fail, got %v", failed)
+	}
+	bans := fake.called("banChatMember")
+	if len(bans) != 1 {
+		t.Fatalf("Expected the member to be banned, got %v", bans)
+	}
+	until, _ := strconv.ParseInt(bans[0]["until_date"], 10, 64)
+	if ban := time.Until(time.Unix(until, 0)); ban < appealWindow-time.Minute || ban > appealWindow {
+		t.Errorf("Expected a ban for the appeal window, got %v", ban)
+	}
+}
+
+func TestMessagesOfOtherChallengesAreDeleted(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+
+	data := digitsVerification(3, 0)
+	data.Type, data.Modality = captcha.TypeButton, captcha.ModalityButton
+	data = challenged(t, captchaFSM, data, time.Now().Add(-5*time.Second))
+
+	// Members answering with buttons can't write meanwhile, whatever they send
+	sticker := textAnswer("")
+	sticker.Message.Sticker = &tgmodels.Sticker{FileID: "sticker"}
+	for _, update := range []*tgmodels.Update{textAnswer(data.Answer), sticker} {
+		HandleCaptchaTextAnswer(registry)(ctx, b, update)
+	}
+
+	if deleted := fake.called("deleteMessage"); len(deleted) != 2 || deleted[1]["message_id"] != "200" {
+		t.Errorf("Expected both messages to be deleted, got %v", deleted)
+	}
+	if current, ok := captchaFSM.GetState(data.Key()); !ok || current.AttemptsLeft != 3 {
+		t.Errorf("Expected the messages not to count as answers, got %v", current)
+	}
+}
+
+func TestRightAnswerPasses(t *testing.T) {
+	b, fake := newTestBot(t)
+	registry := newTestRegistry(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	ctx := fsm.WithCaptchaFSM(context.Background(), captchaFSM)
+
+	data := challenged(t, captchaFSM, digitsVerification(3, time.Second), time.Now().Add(-5*time.Second))
+
+	HandleCaptchaTextAnswer(registry)(ctx, b, textAnswer("12345"))
+
+	if _, ok := captchaFSM.Lookup(data.Key()); ok {
+		t.Error("Expected the verified member to be removed")
+	}
+	deleted := fake.called("deleteMessage")
+	if !slices.ContainsFunc(deleted, func(params map[string]string) bool { return params["message_id"] == "100" }) {
+		t.Errorf("Expected the challenge to be deleted, got %v", deleted)
+	}
+}
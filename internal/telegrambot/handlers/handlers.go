@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -11,6 +10,7 @@ import (
 	"gofency/internal/fsm"
 	"gofency/internal/localization"
 	"gofency/internal/repositories"
+	"gofency/internal/scheduler"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -116,7 +116,7 @@ func HandleLanguageCallback(ctx context.Context, b *bot.Bot, update *models.Upda
 }
 
 // CommandTestCaptcha sends a test captcha to verify the system is working
-func CommandTestCaptcha(captchaService *captcha.Service) bot.HandlerFunc {
+func CommandTestCaptcha(registry *captcha.Registry) bot.HandlerFunc {
 	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
 		if update.Message == nil {
 			return
@@ -128,7 +128,11 @@ func CommandTestCaptcha(captchaService *captcha.Service) bot.HandlerFunc {
 		log.Printf("Test captcha command from user %d in chat %d", userID, chatID)
 
 		// Generate captcha
-		captchaImg, err := captchaService.Generate()
+		challenge, err := registry.Generate(ctx, captcha.Request{
+			ChatID:       chatID,
+			UserID:       userID,
+			LanguageCode: update.Message.From.LanguageCode,
+		})
 		if err != nil {
 			log.Printf("Failed to generate captcha: %v", err)
 			b.SendMessage(ctx, &bot.SendMessageParams{
@@ -138,10 +142,10 @@ func CommandTestCaptcha(captchaService *captcha.Service) bot.HandlerFunc {
 			return
 		}
 
-		log.Printf("Generated test captcha with answer: %s", captchaImg.Answer)
+		log.Printf("Generated test %s captcha with answer: %s", challenge.Type, challenge.Answer)
 
 		// Send test message first
-		testMsg := fmt.Sprintf("🧪 **Test Captcha Mode**\n\nAnswer: `%s`\n\nThe captcha image will be sent next. Try typing the answer to test the verification system!", captchaImg.Answer)
+		testMsg := fmt.Sprintf("🧪 **Test Captcha Mode**\n\nType: `%s`\nAnswer: `%s`\n\nThe captcha will be sent next. Try answering it to test the verification system!", challenge.Type, challenge.Answer)
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID:    chatID,
 			Text:      testMsg,
@@ -157,25 +161,22 @@ func CommandTestCaptcha(captchaService *captcha.Service) bot.HandlerFunc {
 			username = "User"
 		}
 
-		// Send captcha image
-		welcomeText := fmt.Sprintf("Welcome, %s! Please enter the captcha digits within 30 seconds.", username)
+		// Send captcha
+		welcomeText := fmt.Sprintf("Welcome, %s! Please solve the captcha within 30 seconds.", username)
 
-		photoMsg, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
-			ChatID:  chatID,
-			Photo:   &models.InputFileUpload{Data: bytes.NewReader(captchaImg.Image)},
-			Caption: welcomeText,
-		})
+		key := fsm.CaptchaKey{ChatID: chatID, UserID: userID, TopicID: messageTopic(update.Message)}
+		photoMsg, err := sendChallenge(ctx, b, key, challenge, welcomeText, "")
 		if err != nil {
-			log.Printf("Failed to send captcha image: %v", err)
+			log.Printf("Failed to send captcha: %v", err)
 			b.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: chatID,
-				Text:   fmt.Sprintf("❌ Failed to send captcha image: %v", err),
+				Text:   fmt.Sprintf("❌ Failed to send captcha: %v", err),
 			})
 			return
 		}
 
 		// Send prompt message
-		promptText := "Please type the digits you see in the captcha image:"
+		promptText := localization.GetText(ctx, challenge.PromptID, challenge.PromptData)
 
 		promptMsg, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
@@ -198,69 +199,70 @@ func CommandTestCaptcha(captchaService *captcha.Service) bot.HandlerFunc {
 		}
 
 		// Save state in FSM
-		captchaFSM.SetState(userID, &fsm.CaptchaData{
-			ChatID:         chatID,
-			UserID:         userID,
-			Answer:         captchaImg.Answer,
-			ExpiresAt:      time.Now().Add(30 * time.Second),
-			PhotoMessageID: photoMsg.ID,
+		deadline := time.Now().Add(30 * time.Second)
+		data := &fsm.CaptchaData{
+			ChatID:    chatID,
+			UserID:    userID,
+			TopicID:   key.TopicID,
+			Type:      challenge.Type,
+			Modality:  challenge.Modality,
+			Answer:    challenge.Answer,
+			ExpiresAt: deadline,
+		}
+		captchaFSM.SetState(key, data)
+		_, err = captchaFSM.Transition(data, fsm.StateChallenged, func(next *fsm.CaptchaData) {
+			next.PhotoMessageID = photoMsg.ID
+			next.ShownAt = time.Now()
 		})
+		if err != nil {
+			log.Printf("Failed to challenge user %d: %v", userID, err)
+			return
+		}
 
 		log.Printf("Test captcha state saved for user %d", userID)
 
 		// Schedule timeout check
-		go scheduleTestCaptchaTimeout(b, chatID, userID, username, photoMsg.ID, promptMsg.ID, captchaFSM)
+		scheduleTestCaptchaTimeout(ctx, testCaptchaTimeout{
+			Key:             key,
+			Username:        username,
+			PhotoMessageID:  photoMsg.ID,
+			PromptMessageID: promptMsg.ID,
+		}, deadline)
 	}
 }
 
 // scheduleTestCaptchaTimeout is similar to scheduleTimeoutCheck but for test mode
-func scheduleTestCaptchaTimeout(b *bot.Bot, chatID, userID int64, username string, photoMsgID, promptMsgID int, captchaFSM *fsm.CaptchaFSM) {
-	time.Sleep(30 * time.Second)
-
-	// Check if state still exists
-	if _, ok := captchaFSM.GetState(userID); !ok {
-		// User already verified
+func scheduleTestCaptchaTimeout(ctx context.Context, payload testCaptchaTimeout, deadline time.Time) {
+	s, ok := scheduler.GetScheduler(ctx)
+	if !ok {
+		log.Printf("Scheduler not found in context, no test timeout for user %d", payload.Key.UserID)
 		return
 	}
 
-	// Check if expired
-	if !captchaFSM.IsExpired(userID) {
-		// Still within time window
-		return
+	id := fmt.Sprintf("%s:%d:%d:%d", jobTestCaptchaTimeout, payload.Key.ChatID, payload.Key.UserID, payload.Key.TopicID)
+	if _, err := s.Schedule(ctx, id, jobTestCaptchaTimeout, deadline, payload); err != nil {
+		log.Printf("Failed to schedule test timeout of user %d: %v", payload.Key.UserID, err)
 	}
+}
 
-	ctx := context.Background()
-
-	// Delete state
-	captchaFSM.DeleteState(userID)
+// timeoutTestCaptcha notifies the user of a test captcha taken from the FSM
+// after its timeout
+func timeoutTestCaptcha(ctx context.Context, b *bot.Bot, payload testCaptchaTimeout) {
+	chatID := payload.Key.ChatID
+	username := payload.Username
 
 	// Don't ban in test mode, just notify
 	// Delete captcha messages
-	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: photoMsgID,
-	})
-	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: promptMsgID,
-	})
+	deleteMessage(ctx, b, chatID, payload.PhotoMessageID)
+	deleteMessage(ctx, b, chatID, payload.PromptMessageID)
 
 	// Send timeout message
 	timeoutText := fmt.Sprintf("⏱ Test timeout! In production mode, %s would be banned for 10 minutes.", username)
 
-	msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   timeoutText,
-	})
-	if err != nil {
+	}, noticeMessageTTL); err != nil {
 		log.Printf("Failed to send timeout message: %v", err)
-		return
 	}
-
-	// Delete timeout message after 10 seconds
-	time.Sleep(10 * time.Second)
-	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: msg.ID,
-	})
 }
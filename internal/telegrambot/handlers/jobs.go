@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gofency/internal/fsm"
+	"gofency/internal/messages"
+	"gofency/internal/scheduler"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Kinds of the delayed actions the handlers schedule
+const (
+	jobCaptchaTimeout     = "captcha_timeout"
+	jobTestCaptchaTimeout = "test_captcha_timeout"
+)
+
+// testCaptchaTimeout is the payload of the test mode timeout
+type testCaptchaTimeout struct {
+	Key             fsm.CaptchaKey
+	Username        string
+	PhotoMessageID  int
+	PromptMessageID int
+}
+
+// RegisterJobs registers the handlers of the delayed actions with the
+// scheduler. Jobs run outside of any update, prepare gives their context the
+// dependencies and the localizer of the language the notices are sent in.
+func RegisterJobs(s *scheduler.Scheduler, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, prepare func(ctx context.Context, languageCode string) context.Context) {
+	s.Handle(jobCaptchaTimeout, func(ctx context.Context, job *scheduler.Job) error {
+		var key fsm.CaptchaKey
+		if err := job.Decode(&key); err != nil {
+			return err
+		}
+
+		data, err := timeOut(captchaFSM, key, appealWindow)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			clearEnded(prepare(ctx, ""), b, captchaFSM, key)
+			return nil
+		}
+
+		ctx = prepare(ctx, data.LanguageCode)
+		timeoutCaptcha(ctx, b, data)
+		// The timeout check clears the verification once it can't be appealed
+		scheduleTimeoutCheck(ctx, key, data.ExpiresAt)
+		return nil
+	})
+
+	s.Handle(jobTestCaptchaTimeout, func(ctx context.Context, job *scheduler.Job) error {
+		ctx = prepare(ctx, "")
+
+		var payload testCaptchaTimeout
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+
+		// Test verifications can't be appealed, they are cleared right away
+		data, err := timeOut(captchaFSM, payload.Key, 0)
+		captchaFSM.TakeExpired(payload.Key)
+		if err != nil || data == nil {
+			return err
+		}
+
+		timeoutTestCaptcha(ctx, b, payload)
+		return nil
+	})
+}
+
+// timeOut moves the verification to timed out if the member did not pass it
+// in time, or to failed if the admins did not let them in, and keeps it for
+// the appeal window. It returns nil when there is nothing to time out.
+func timeOut(captchaFSM *fsm.CaptchaFSM, key fsm.CaptchaKey, window time.Duration) (*fsm.CaptchaData, error) {
+	// An answer may change the verification between the read and the
+	// transition, the timeout is then retried with the new state
+	for range 3 {
+		data, ok := captchaFSM.Lookup(key)
+		if !ok {
+			return nil, nil
+		}
+
+		to := fsm.StateTimedOut
+		if data.State == fsm.StatePendingApproval {
+			to = fsm.StateFailed
+			if time.Now().Before(data.ExpiresAt) {
+				return nil, fmt.Errorf("failed to time out verification of user %d in chat %d: %w", key.UserID, key.ChatID, fsm.ErrNotExpired)
+			}
+		}
+		if !fsm.CanTransition(data.State, to) {
+			// The verification ended and is kept for an appeal
+			return nil, nil
+		}
+
+		timedOut, err := captchaFSM.Transition(data, to, func(next *fsm.CaptchaData) {
+			next.ExpiresAt = time.Now().Add(window)
+		})
+		if errors.Is(err, fsm.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to time out verification of user %d in chat %d: %w", key.UserID, key.ChatID, err)
+		}
+		return timedOut, nil
+	}
+	return nil, fmt.Errorf("failed to time out verification of user %d in chat %d: %w", key.UserID, key.ChatID, fsm.ErrConflict)
+}
+
+// clearEnded clears an ended verification once it can't be appealed, with
+// the notice of an appeal the admins did not decide
+func clearEnded(ctx context.Context, b *bot.Bot, captchaFSM *fsm.CaptchaFSM, key fsm.CaptchaKey) {
+	data, ok := captchaFSM.TakeExpired(key)
+	if ok && data.State == fsm.StateAppealed {
+		deleteMessage(ctx, b, data.ChatID, data.PhotoMessageID)
+	}
+}
+
+// scheduleTimeoutCheck checks at the deadline if user completed captcha. A
+// check already scheduled for the verification is replaced.
+func scheduleTimeoutCheck(ctx context.Context, key fsm.CaptchaKey, deadline time.Time) {
+	s, ok := scheduler.GetScheduler(ctx)
+	if !ok {
+		log.Printf("Scheduler not found in context, no timeout for user %d in chat %d", key.UserID, key.ChatID)
+		return
+	}
+
+	if _, err := s.Schedule(ctx, timeoutJobID(key), jobCaptchaTimeout, deadline, key); err != nil {
+		log.Printf("Failed to schedule timeout of user %d in chat %d: %v", key.UserID, key.ChatID, err)
+	}
+}
+
+// cancelTimeoutCheck drops the timeout check of a verification that ended
+func cancelTimeoutCheck(ctx context.Context, key fsm.CaptchaKey) {
+	if s, ok := scheduler.GetScheduler(ctx); ok {
+		s.Cancel(ctx, timeoutJobID(key))
+	}
+}
+
+// sendTemporary sends a message that deletes itself after the TTL of the
+// chat, or after ttl when the chat has none
+func sendTemporary(ctx context.Context, b *bot.Bot, params *bot.SendMessageParams, ttl time.Duration) (*models.Message, error) {
+	service, ok := messages.GetService(ctx)
+	if !ok {
+		log.Printf("Messages service not found in context, the message is kept")
+		return b.SendMessage(ctx, params)
+	}
+	return service.Send(ctx, params, ttl)
+}
+
+// deleteMessage deletes a bot message, retrying when Telegram is unavailable
+func deleteMessage(ctx context.Context, b *bot.Bot, chatID int64, messageID int) {
+	service, ok := messages.GetService(ctx)
+	if !ok {
+		b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    chatID,
+			MessageID: messageID,
+		})
+		return
+	}
+	service.Delete(ctx, chatID, messageID)
+}
+
+// timeoutJobID is the ID of the timeout check of a verification
+func timeoutJobID(key fsm.CaptchaKey) string {
+	return fmt.Sprintf("%s:%d:%d:%d", jobCaptchaTimeout, key.ChatID, key.UserID, key.TopicID)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gofency/internal/fsm"
+	"gofency/internal/localization"
+	"gofency/internal/scheduler"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// racingStore runs race before the next races updates it is given, like an
+// answer changing the verification between the read and the transition of
+// another caller
+type racingStore struct {
+	fsm.StateStore
+	races int
+	race  func()
+}
+
+func (s *racingStore) Update(ctx context.Context, key fsm.CaptchaKey, version int, data *fsm.CaptchaData) (bool, error) {
+	if s.races > 0 {
+		s.races--
+		s.race()
+	}
+	return s.StateStore.Update(ctx, key, version, data)
+}
+
+func TestTimeOutRetriesAfterConflict(t *testing.T) {
+	store := &racingStore{StateStore: fsm.NewMemoryStore()}
+	captchaFSM := fsm.NewCaptchaFSMWithStore(store)
+
+	data := challenged(t, captchaFSM, digitsVerification(3, 0), time.Now())
+	data, err := captchaFSM.Transition(data, fsm.StateRetrying, func(next *fsm.CaptchaData) {
+		next.AttemptsLeft--
+		next.ExpiresAt = time.Now().Add(-time.Second)
+	})
+	if err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+
+	// A wrong answer lands while the timeout moves the verification
+	store.races, store.race = 1, func() {
+		current, _ := captchaFSM.Lookup(data.Key())
+		if _, err := captchaFSM.Transition(current, fsm.StateRetrying, func(next *fsm.CaptchaData) {
+			next.AttemptsLeft--
+		}); err != nil {
+			t.Fatalf("Failed to record the concurrent answer: %v", err)
+		}
+	}
+
+	timedOut, err := timeOut(captchaFSM, data.Key(), appealWindow)
+	if err != nil {
+		t.Fatalf("Expected the timeout to be retried, got %v", err)
+	}
+	if timedOut == nil || timedOut.State != fsm.StateTimedOut {
+		t.Fatalf("Expected the verification to time out, got %v", timedOut)
+	}
+	if timedOut.AttemptsLeft != 1 {
+		t.Errorf("Expected the timeout to build on the concurrent answer, got %d attempts left", timedOut.AttemptsLeft)
+	}
+	if time.Until(timedOut.ExpiresAt) < appealWindow-time.Minute {
+		t.Errorf("Expected the verification to be kept for the appeal window, expires at %v", timedOut.ExpiresAt)
+	}
+}
+
+func TestTimeOutGivesUpAfterRepeatedConflicts(t *testing.T) {
+	store := &racingStore{StateStore: fsm.NewMemoryStore()}
+	captchaFSM := fsm.NewCaptchaFSMWithStore(store)
+
+	data := challenged(t, captchaFSM, digitsVerification(10, 0), time.Now())
+	data, err := captchaFSM.Transition(data, fsm.StateRetrying, func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(-time.Second)
+	})
+	if err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+
+	store.races, store.race = 3, func() {
+		current, _ := captchaFSM.Lookup(data.Key())
+		next := *current
+		next.Version++
+		store.StateStore.Update(context.Background(), current.Key(), current.Version, &next)
+	}
+
+	if _, err := timeOut(captchaFSM, data.Key(), appealWindow); !errors.Is(err, fsm.ErrConflict) {
+		t.Errorf("Expected a conflict after repeated races, got %v", err)
+	}
+}
+
+// languageLocalizer prefixes text IDs with its language
+type languageLocalizer string
+
+func (l languageLocalizer) Localize(config *i18n.LocalizeConfig) (string, error) {
+	return string(l) + ":" + config.MessageID, nil
+}
+
+func TestTimeoutNoticeIsLocalized(t *testing.T) {
+	b, fake := newTestBot(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	s := scheduler.New(nil, 1)
+	RegisterJobs(s, b, captchaFSM, func(ctx context.Context, languageCode string) context.Context {
+		ctx = scheduler.WithScheduler(ctx, s)
+		return localization.WithLocalizer(ctx, languageLocalizer(languageCode))
+	})
+
+	data := digitsVerification(3, 0)
+	data.LanguageCode = "ru"
+	data.ExpiresAt = time.Now().Add(-time.Second)
+	data = challenged(t, captchaFSM, data, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	scheduleTimeoutCheck(scheduler.WithScheduler(ctx, s), data.Key(), time.Now())
+
+	// The job has no update to take the language from, the verification keeps it
+	for deadline := time.Now().Add(time.Second); len(fake.called("sendMessage")) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := fake.called("sendMessage"); len(sent) != 1 || sent[0]["text"] != "ru:captcha_timeout" {
+		t.Errorf("Expected the timeout notice in the language of the verification, got %v", sent)
+	}
+}
+
+func TestTimeOutSkipsEndedVerifications(t *testing.T) {
+	captchaFSM := fsm.NewCaptchaFSM()
+
+	data := challenged(t, captchaFSM, digitsVerification(1, 0), time.Now())
+	if _, err := captchaFSM.Transition(data, fsm.StateFailed, func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(appealWindow)
+	}); err != nil {
+		t.Fatalf("Failed to fail: %v", err)
+	}
+
+	// Failed members are not timed out again while kept for an appeal
+	timedOut, err := timeOut(captchaFSM, data.Key(), appealWindow)
+	if err != nil || timedOut != nil {
+		t.Errorf("Expected nothing to time out, got %v, %v", timedOut, err)
+	}
+}
+
+func TestUndecidedApprovalFails(t *testing.T) {
+	store := fsm.NewMemoryStore()
+	captchaFSM := fsm.NewCaptchaFSMWithStore(store)
+
+	data := challenged(t, captchaFSM, digitsVerification(3, 0), time.Now())
+	data, err := captchaFSM.Transition(data, fsm.StatePendingApproval, func(next *fsm.CaptchaData) {
+		next.ExpiresAt = time.Now().Add(time.Minute)
+	})
+	if err != nil {
+		t.Fatalf("Failed to ask for approval: %v", err)
+	}
+
+	// Admins decide until the deadline
+	if _, err := timeOut(captchaFSM, data.Key(), appealWindow); !errors.Is(err, fsm.ErrNotExpired) {
+		t.Fatalf("Expected the request not to expire yet, got %v", err)
+	}
+
+	expired := *data
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	if ok, err := store.Update(context.Background(), data.Key(), data.Version, &expired); !ok || err != nil {
+		t.Fatalf("Failed to expire the request: %v", err)
+	}
+
+	failed, err := timeOut(captchaFSM, data.Key(), appealWindow)
+	if err != nil || failed == nil || failed.State != fsm.StateFailed {
+		t.Fatalf("Expected the member to fail when admins did not decide, got %v, %v", failed, err)
+	}
+	if time.Until(failed.ExpiresAt) < appealWindow-time.Minute {
+		t.Errorf("Expected the verification to be kept for the appeal window, expires at %v", failed.ExpiresAt)
+	}
+}
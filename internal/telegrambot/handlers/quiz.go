@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gofency/internal/captcha"
+	"gofency/internal/localization"
+	"gofency/internal/models"
+	"gofency/internal/repositories"
+
+	"github.com/go-telegram/bot"
+	tgmodels "github.com/go-telegram/bot/models"
+)
+
+// CommandQuizAdd adds a custom captcha question to the chat
+func CommandQuizAdd(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+	repo, ok := quizCommandRepository(ctx, b, update)
+	if !ok {
+		return
+	}
+
+	arg := commandArgument(update.Message.Text)
+	question, err := captcha.ParseQuizQuestion(arg)
+	if err != nil {
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "quiz_add_usage", map[string]any{
+			"Error": err.Error(),
+		}))
+		return
+	}
+
+	record := &models.QuizQuestion{
+		ChatID:   update.Message.Chat.ID,
+		Question: question.Question,
+		Answers:  strings.Join(question.Answers, "\n"),
+		Choices:  strings.Join(question.Choices, "\n"),
+	}
+	if err := repo.Create(ctx, record); err != nil {
+		log.Printf("Failed to save quiz question for chat %d: %v", update.Message.Chat.ID, err)
+		return
+	}
+
+	// The command holds the answers, so it does not stay in the chat
+	deleteMessage(ctx, b, update.Message.Chat.ID, update.Message.ID)
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          update.Message.Chat.ID,
+		MessageThreadID: update.Message.MessageThreadID,
+		Text: localization.GetText(ctx, "quiz_added", map[string]any{
+			"ID": record.ID,
+		}),
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to confirm quiz question %d: %v", record.ID, err)
+	}
+}
+
+// CommandQuizList sends the custom captcha questions of the chat with their
+// answers to the administrator in a private message
+func CommandQuizList(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+	repo, ok := quizCommandRepository(ctx, b, update)
+	if !ok {
+		return
+	}
+
+	questions, err := repo.ListByChatID(ctx, update.Message.Chat.ID)
+	if err != nil {
+		log.Printf("Failed to list quiz questions: %v", err)
+		return
+	}
+
+	if len(questions) == 0 {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "quiz_list_empty"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(localization.GetText(ctx, "quiz_list", map[string]any{
+		"Chat": update.Message.Chat.Title,
+	}))
+	for _, q := range questions {
+		fmt.Fprintf(&sb, "\n\n#%d %s\n✅ %s", q.ID, q.Question, strings.ReplaceAll(q.Answers, "\n", "; "))
+		if q.Choices != "" {
+			fmt.Fprintf(&sb, "\n🔘 %s", strings.ReplaceAll(q.Choices, "\n", "; "))
+		}
+	}
+
+	// Members must not see the answers, the notice in the chat only says
+	// where the list went
+	noticeID := "quiz_list_sent"
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.From.ID,
+		Text:   sb.String(),
+	}); err != nil {
+		log.Printf("Failed to send quiz questions to user %d: %v", update.Message.From.ID, err)
+		noticeID = "quiz_list_private_failed"
+	}
+
+	if _, err := sendTemporary(ctx, b, &bot.SendMessageParams{
+		ChatID:          update.Message.Chat.ID,
+		MessageThreadID: update.Message.MessageThreadID,
+		Text:            localization.GetSimpleText(ctx, noticeID),
+		ReplyParameters: &tgmodels.ReplyParameters{
+			MessageID: update.Message.ID,
+		},
+	}, noticeMessageTTL); err != nil {
+		log.Printf("Failed to send quiz list notice: %v", err)
+	}
+}
+
+// CommandQuizDelete removes a custom captcha question of the chat by its ID
+func CommandQuizDelete(ctx context.Context, b *bot.Bot, update *tgmodels.Update) {
+	repo, ok := quizCommandRepository(ctx, b, update)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(commandArgument(update.Message.Text), "#"), 10, 64)
+	if err != nil {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "quiz_delete_usage"))
+		return
+	}
+
+	deleted, err := repo.Delete(ctx, update.Message.Chat.ID, uint(id))
+	if err != nil {
+		log.Printf("Failed to delete quiz question: %v", err)
+		return
+	}
+
+	messageID := "quiz_deleted"
+	if !deleted {
+		messageID = "quiz_not_found"
+	}
+	replyText(ctx, b, update.Message, localization.GetText(ctx, messageID, map[string]any{
+		"ID": id,
+	}))
+}
+
+// quizCommandRepository checks that an administrator sent the command and returns the repository
+func quizCommandRepository(ctx context.Context, b *bot.Bot, update *tgmodels.Update) (repositories.QuizQuestionRepository, bool) {
+	if update.Message == nil || update.Message.From == nil {
+		return nil, false
+	}
+
+	// Questions reveal their answers, so even listing them is restricted
+	if !isChatAdmin(ctx, b, update.Message.Chat.ID, update.Message.From.ID) {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "admin_only"))
+		return nil, false
+	}
+
+	repo, ok := repositories.GetQuizQuestionRepository(ctx)
+	if !ok {
+		log.Printf("Quiz question repository not found in context")
+		return nil, false
+	}
+
+	return repo, true
+}
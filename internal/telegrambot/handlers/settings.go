@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/localization"
+	"gofency/internal/messages"
+	"gofency/internal/repositories"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// CommandCaptchaDifficulty shows or changes the captcha difficulty of the chat
+func CommandCaptchaDifficulty(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	arg := commandArgument(update.Message.Text)
+
+	if arg == "" {
+		difficulty := chatChallengeSettings(ctx, chatID).Difficulty
+		if difficulty == "" {
+			difficulty = captcha.DifficultyNormal
+		}
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_difficulty_current", map[string]any{
+			"Difficulty": string(difficulty),
+		}))
+		return
+	}
+
+	if !isChatAdmin(ctx, b, chatID, update.Message.From.ID) {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "admin_only"))
+		return
+	}
+
+	difficulty, err := captcha.ParseDifficulty(arg)
+	if err != nil {
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_difficulty_invalid", map[string]any{
+			"Difficulties": joinDifficulties(),
+		}))
+		return
+	}
+
+	repo, ok := repositories.GetChatSettingsRepository(ctx)
+	if !ok {
+		log.Printf("Chat settings repository not found in context")
+		return
+	}
+
+	if err := repo.UpsertDifficulty(ctx, chatID, string(difficulty)); err != nil {
+		log.Printf("Failed to save captcha difficulty for chat %d: %v", chatID, err)
+		return
+	}
+
+	replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_difficulty_changed", map[string]any{
+		"Difficulty": string(difficulty),
+	}))
+}
+
+// CommandCaptchaTypes shows or changes the challenge types used in the chat
+func CommandCaptchaTypes(registry *captcha.Registry) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.Message == nil || update.Message.From == nil {
+			return
+		}
+
+		chatID := update.Message.Chat.ID
+		arg := commandArgument(update.Message.Text)
+		available := strings.Join(registry.Types(), ", ")
+
+		if arg == "" {
+			current := strings.Join(chatChallengeSettings(ctx, chatID).Types, ", ")
+			if current == "" {
+				current = "-"
+			}
+			replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_types_current", map[string]any{
+				"Types":     current,
+				"Available": available,
+			}))
+			return
+		}
+
+		if !isChatAdmin(ctx, b, chatID, update.Message.From.ID) {
+			replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "admin_only"))
+			return
+		}
+
+		types := splitChallengeTypes(strings.ReplaceAll(arg, " ", ","))
+		for _, typ := range types {
+			if _, ok := registry.Get(typ); !ok {
+				replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_types_invalid", map[string]any{
+					"Type":      typ,
+					"Available": available,
+				}))
+				return
+			}
+		}
+
+		repo, ok := repositories.GetChatSettingsRepository(ctx)
+		if !ok {
+			log.Printf("Chat settings repository not found in context")
+			return
+		}
+
+		if err := repo.UpsertChallengeTypes(ctx, chatID, strings.Join(types, ",")); err != nil {
+			log.Printf("Failed to save captcha types for chat %d: %v", chatID, err)
+			return
+		}
+
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_types_changed", map[string]any{
+			"Types": strings.Join(types, ", "),
+		}))
+	}
+}
+
+// CommandCaptchaLanguage shows or changes the language whose alphabet text
+// captchas of the chat use; "auto" goes back to the language of each member
+func CommandCaptchaLanguage(languages []string) bot.HandlerFunc {
+	languages = slices.Sorted(slices.Values(languages))
+
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.Message == nil || update.Message.From == nil {
+			return
+		}
+
+		chatID := update.Message.Chat.ID
+		arg := strings.ToLower(commandArgument(update.Message.Text))
+		available := strings.Join(append([]string{captchaLanguageAuto}, languages...), ", ")
+
+		if arg == "" {
+			current := chatChallengeSettings(ctx, chatID).Language
+			if current == "" {
+				current = captchaLanguageAuto
+			}
+			replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_language_current", map[string]any{
+				"Language":  current,
+				"Available": available,
+			}))
+			return
+		}
+
+		if !isChatAdmin(ctx, b, chatID, update.Message.From.ID) {
+			replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "admin_only"))
+			return
+		}
+
+		if arg != captchaLanguageAuto && !slices.Contains(languages, arg) {
+			replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_language_invalid", map[string]any{
+				"Language":  arg,
+				"Available": available,
+			}))
+			return
+		}
+
+		repo, ok := repositories.GetChatSettingsRepository(ctx)
+		if !ok {
+			log.Printf("Chat settings repository not found in context")
+			return
+		}
+
+		language := arg
+		if language == captchaLanguageAuto {
+			language = ""
+		}
+		if err := repo.UpsertLanguage(ctx, chatID, language); err != nil {
+			log.Printf("Failed to save captcha language for chat %d: %v", chatID, err)
+			return
+		}
+
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "captcha_language_changed", map[string]any{
+			"Language": arg,
+		}))
+	}
+}
+
+// CommandMessageTTL shows or changes how long bot messages stay in the chat;
+// "default" goes back to the time of each kind of message
+func CommandMessageTTL(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	arg := strings.ToLower(commandArgument(update.Message.Text))
+
+	if arg == "" {
+		current := messageTTLDefault
+		if ttl := chatMessageTTL(ctx, chatID); ttl > 0 {
+			current = ttl.String()
+		}
+		replyText(ctx, b, update.Message, localization.GetText(ctx, "message_ttl_current", map[string]any{
+			"TTL": current,
+			"Min": messages.MinTTL.String(),
+			"Max": messages.MaxTTL.String(),
+		}))
+		return
+	}
+
+	if !isChatAdmin(ctx, b, chatID, update.Message.From.ID) {
+		replyText(ctx, b, update.Message, localization.GetSimpleText(ctx, "admin_only"))
+		return
+	}
+
+	var ttl time.Duration
+	if arg != messageTTLDefault {
+		parsed, err := time.ParseDuration(arg)
+		if err != nil || parsed < messages.MinTTL || parsed > messages.MaxTTL {
+			replyText(ctx, b, update.Message, localization.GetText(ctx, "message_ttl_invalid", map[string]any{
+				"TTL": arg,
+				"Min": messages.MinTTL.String(),
+				"Max": messages.MaxTTL.String(),
+			}))
+			return
+		}
+		ttl = parsed.Truncate(time.Second)
+	}
+
+	repo, ok := repositories.GetChatSettingsRepository(ctx)
+	if !ok {
+		log.Printf("Chat settings repository not found in context")
+		return
+	}
+
+	if err := repo.UpsertMessageTTL(ctx, chatID, int(ttl/time.Second)); err != nil {
+		log.Printf("Failed to save message TTL for chat %d: %v", chatID, err)
+		return
+	}
+
+	current := messageTTLDefault
+	if ttl > 0 {
+		current = ttl.String()
+	}
+	replyText(ctx, b, update.Message, localization.GetText(ctx, "message_ttl_changed", map[string]any{
+		"TTL": current,
+	}))
+}
+
+// captchaLanguageAuto resets the chat language to the language of each member
+const captchaLanguageAuto = "auto"
+
+// messageTTLDefault resets the TTL of bot messages to the default of each kind
+const messageTTLDefault = "default"
+
+// challengeSettings are the captcha settings a chat overrides
+type challengeSettings struct {
+	Difficulty captcha.Difficulty
+	Types      []string
+	// Language selects the alphabet of text captchas, empty means the member's language
+	Language string
+}
+
+// language returns the language challenges of the member are generated for
+func (s challengeSettings) language(member *models.User) string {
+	if s.Language != "" {
+		return s.Language
+	}
+	return member.LanguageCode
+}
+
+// chatChallengeSettings returns the captcha settings configured for the chat
+func chatChallengeSettings(ctx context.Context, chatID int64) challengeSettings {
+	repo, ok := repositories.GetChatSettingsRepository(ctx)
+	if !ok {
+		return challengeSettings{}
+	}
+
+	settings, err := repo.GetByChatID(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to get chat settings for chat %d: %v", chatID, err)
+		return challengeSettings{}
+	}
+	if settings == nil {
+		return challengeSettings{}
+	}
+
+	return challengeSettings{
+		Difficulty: captcha.Difficulty(settings.Difficulty),
+		Types:      splitChallengeTypes(settings.ChallengeTypes),
+		Language:   settings.Language,
+	}
+}
+
+// chatMessageTTL returns the TTL of bot messages set for the chat, 0 when none is
+func chatMessageTTL(ctx context.Context, chatID int64) time.Duration {
+	repo, ok := repositories.GetChatSettingsRepository(ctx)
+	if !ok {
+		return 0
+	}
+
+	settings, err := repo.GetByChatID(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to get chat settings for chat %d: %v", chatID, err)
+		return 0
+	}
+	if settings == nil {
+		return 0
+	}
+
+	return time.Duration(settings.MessageTTL) * time.Second
+}
+
+// isChatAdmin checks if the user is an administrator or the owner of the chat
+func isChatAdmin(ctx context.Context, b *bot.Bot, chatID, userID int64) bool {
+	member, err := b.GetChatMember(ctx, &bot.GetChatMemberParams{
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Failed to get chat member %d in chat %d: %v", userID, chatID, err)
+		return false
+	}
+
+	return member.Type == models.ChatMemberTypeOwner || member.Type == models.ChatMemberTypeAdministrator
+}
+
+// commandArgument returns the text following the command
+func commandArgument(text string) string {
+	parts := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+func splitChallengeTypes(value string) []string {
+	var types []string
+	for _, typ := range strings.Split(value, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+func joinDifficulties() string {
+	names := make([]string, 0, len(captcha.Difficulties))
+	for _, d := range captcha.Difficulties {
+		names = append(names, string(d))
+	}
+	return strings.Join(names, ", ")
+}
+
+func replyText(ctx context.Context, b *bot.Bot, message *models.Message, text string) {
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: message.Chat.ID,
+		Text:   text,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: message.ID,
+		},
+	})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/fsm"
+	"gofency/internal/webapp"
+
+	"github.com/go-telegram/bot"
+	tgmodels "github.com/go-telegram/bot/models"
+)
+
+// WebAppVerifications completes the verifications answered in the Mini App
+type WebAppVerifications struct {
+	bot        *bot.Bot
+	registry   *captcha.Registry
+	captchaFSM *fsm.CaptchaFSM
+
+	// prepare adds the dependencies handlers take from the context, which
+	// HTTP requests do not pass through the bot middlewares for
+	prepare func(ctx context.Context, languageCode string) context.Context
+}
+
+// NewWebAppVerifications creates the verifications used by the Mini App server
+func NewWebAppVerifications(b *bot.Bot, registry *captcha.Registry, captchaFSM *fsm.CaptchaFSM, prepare func(ctx context.Context, languageCode string) context.Context) *WebAppVerifications {
+	return &WebAppVerifications{
+		bot:        b,
+		registry:   registry,
+		captchaFSM: captchaFSM,
+		prepare:    prepare,
+	}
+}
+
+// Challenge returns the slider target and the seed of the user's pending
+// Mini App verification
+func (v *WebAppVerifications) Challenge(chatID, userID int64) (int, uint64, bool) {
+	data, ok := v.pending(chatID, userID)
+	if !ok {
+		return 0, 0, false
+	}
+
+	target, err := strconv.Atoi(data.Answer)
+	if err != nil {
+		log.Printf("Invalid Mini App answer of user %d: %v", userID, err)
+		return 0, 0, false
+	}
+	return target, data.Seed, true
+}
+
+// Answer verifies the slider position like any other captcha answer
+func (v *WebAppVerifications) Answer(ctx context.Context, chatID int64, user webapp.User, position string) (webapp.Result, error) {
+	data, ok := v.pending(chatID, user.ID)
+	if !ok {
+		return webapp.ResultFailed, nil
+	}
+
+	ctx = v.prepare(ctx, user.LanguageCode)
+	from := &tgmodels.User{
+		ID:           user.ID,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Username:     user.Username,
+		LanguageCode: user.LanguageCode,
+	}
+
+	updated := *data
+	updated.AnswerTimes = append(slices.Clip(data.AnswerTimes), time.Since(data.ShownAt))
+
+	if v.registry.Verify(data.Type, data.Answer, position) {
+		passCaptcha(ctx, v.bot, v.captchaFSM, &updated, from)
+		return webapp.ResultPassed, nil
+	}
+
+	wrongAnswer(ctx, v.bot, v.registry, v.captchaFSM, &updated, from)
+	if _, ok := v.pending(chatID, user.ID); ok {
+		return webapp.ResultRetry, nil
+	}
+	return webapp.ResultFailed, nil
+}
+
+// pending returns the unexpired Mini App verification of the user in the chat
+func (v *WebAppVerifications) pending(chatID, userID int64) (*fsm.CaptchaData, bool) {
+	// The Mini App only knows the chat, the challenge may be in any of its topics
+	data, ok := v.captchaFSM.FindState(chatID, userID)
+	if !ok || data.Modality != captcha.ModalityWebApp {
+		return nil, false
+	}
+	if v.captchaFSM.IsExpired(data.Key()) {
+		return nil, false
+	}
+	return data, true
+}
+
+// restrictMember stops a member from sending anything until verified
+func restrictMember(ctx context.Context, b *bot.Bot, chatID, userID int64) bool {
+	_, err := b.RestrictChatMember(ctx, &bot.RestrictChatMemberParams{
+		ChatID:      chatID,
+		UserID:      userID,
+		Permissions: &tgmodels.ChatPermissions{},
+	})
+	if err != nil {
+		log.Printf("Failed to restrict user %d in chat %d: %v", userID, chatID, err)
+		return false
+	}
+	return true
+}
+
+// liftRestrictions gives a verified member the default permissions of the chat
+func liftRestrictions(ctx context.Context, b *bot.Bot, chatID, userID int64) {
+	chat, err := b.GetChat(ctx, &bot.GetChatParams{ChatID: chatID})
+	if err != nil {
+		log.Printf("Failed to get permissions of chat %d: %v", chatID, err)
+		return
+	}
+
+	permissions := chat.Permissions
+	if permissions == nil {
+		permissions = &tgmodels.ChatPermissions{CanSendMessages: true}
+	}
+
+	_, err = b.RestrictChatMember(ctx, &bot.RestrictChatMemberParams{
+		ChatID:      chatID,
+		UserID:      userID,
+		Permissions: permissions,
+	})
+	if err != nil {
+		log.Printf("Failed to lift restrictions of user %d in chat %d: %v", userID, chatID, err)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"gofency/internal/captcha"
+	"gofency/internal/fsm"
+	"gofency/internal/webapp"
+)
+
+// newTestWebAppVerifications answers Mini App verifications with a fake Telegram
+func newTestWebAppVerifications(t *testing.T) (*WebAppVerifications, *fsm.CaptchaFSM, *fakeTelegram) {
+	t.Helper()
+	b, fake := newTestBot(t)
+	captchaFSM := fsm.NewCaptchaFSM()
+	prepare := func(ctx context.Context, languageCode string) context.Context {
+		return fsm.WithCaptchaFSM(ctx, captchaFSM)
+	}
+	return NewWebAppVerifications(b, newTestRegistry(t), captchaFSM, prepare), captchaFSM, fake
+}
+
+// webAppVerification is a restricted Mini App challenge of user 42 in chat -100
+func webAppVerification(t *testing.T, captchaFSM *fsm.CaptchaFSM, attempts int) *fsm.CaptchaData {
+	t.Helper()
+	data := challenged(t, captchaFSM, &fsm.CaptchaData{
+		ChatID:       -100,
+		UserID:       42,
+		TopicID:      7,
+		Type:         captcha.TypeWebApp,
+		Modality:     captcha.ModalityWebApp,
+		Answer:       "150",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		AttemptsLeft: attempts,
+	}, time.Now().Add(-5*time.Second))
+
+	data, err := captchaFSM.Transition(data, fsm.StateRetrying, func(next *fsm.CaptchaData) {
+		next.Restricted = true
+	})
+	if err != nil {
+		t.Fatalf("Failed to restrict: %v", err)
+	}
+	return data
+}
+
+func TestWebAppAnswerPasses(t *testing.T) {
+	verifications, captchaFSM, fake := newTestWebAppVerifications(t)
+	data := webAppVerification(t, captchaFSM, 3)
+
+	if target, _, ok := verifications.Challenge(-100, 42); !ok || target != 150 {
+		t.Fatalf("Expected the target of the verification, got %d", target)
+	}
+
+	result, err := verifications.Answer(context.Background(), -100, webapp.User{ID: 42, FirstName: "Member"}, strconv.Itoa(150+captcha.WebAppTolerance))
+	if err != nil || result != webapp.ResultPassed {
+		t.Fatalf("Expected the answer to pass, got %q, %v", result, err)
+	}
+	if _, ok := captchaFSM.Lookup(data.Key()); ok {
+		t.Error("Expected the verified member to be removed")
+	}
+	// The member may write again once verified
+	if lifted := fake.called("restrictChatMember"); len(lifted) != 1 || lifted[0]["user_id"] != "42" {
+		t.Errorf("Expected the restrictions to be lifted, got %v", lifted)
+	}
+}
+
+func TestWebAppWrongAnswerRetriesThenFails(t *testing.T) {
+	verifications, captchaFSM, fake := newTestWebAppVerifications(t)
+	data := webAppVerification(t, captchaFSM, 2)
+	user := webapp.User{ID: 42, FirstName: "Member"}
+
+	// Wrong answers draw a new target, the left end of the track is never one
+	result, err := verifications.Answer(context.Background(), -100, user, "0")
+	if err != nil || result != webapp.ResultRetry {
+		t.Fatalf("Expected another attempt, got %q, %v", result, err)
+	}
+	if retrying, ok := captchaFSM.GetState(data.Key()); !ok || retrying.AttemptsLeft != 1 {
+		t.Fatalf("Expected an attempt spent, got %v", retrying)
+	}
+
+	result, err = verifications.Answer(context.Background(), -100, user, "0")
+	if err != nil || result != webapp.ResultFailed {
+		t.Fatalf("Expected the verification to fail, got %q, %v", result, err)
+	}
+	if bans := fake.called("banChatMember"); len(bans) != 1 {
+		t.Errorf("Expected the member to be banned, got %v", bans)
+	}
+
+	// Nothing is left to answer
+	if result, _ := verifications.Answer(context.Background(), -100, user, "150"); result != webapp.ResultFailed {
+		t.Errorf("Expected an ended verification not to pass, got %q", result)
+	}
+}
+
+func TestWebAppAnswerOfOtherChallenges(t *testing.T) {
+	verifications, captchaFSM, _ := newTestWebAppVerifications(t)
+	challenged(t, captchaFSM, digitsVerification(3, 0), time.Now().Add(-5*time.Second))
+
+	// Challenges answered in the chat cannot be answered in the Mini App
+	result, err := verifications.Answer(context.Background(), -100, webapp.User{ID: 42}, "12345")
+	if err != nil || result != webapp.ResultFailed {
+		t.Errorf("Expected the answer to be refused, got %q, %v", result, err)
+	}
+	if data, ok := captchaFSM.GetState(fsm.CaptchaKey{ChatID: -100, UserID: 42}); !ok || data.AttemptsLeft != 3 {
+		t.Errorf("Expected the verification to be untouched, got %v", data)
+	}
+}
@@ -0,0 +1,100 @@
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors of initData validation
+var (
+	ErrInvalidSignature = errors.New("invalid initData signature")
+	ErrExpired          = errors.New("initData expired")
+)
+
+// User is the Telegram user who opened the Mini App
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+// InitData is the validated launch data Telegram passes to a Mini App
+type InitData struct {
+	User       User
+	StartParam string
+	AuthDate   time.Time
+}
+
+// ValidateInitData checks the signature Telegram computed over initData with
+// the bot token and that it is not older than maxAge, see
+// https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func ValidateInitData(initData, token string, maxAge time.Duration, now time.Time) (*InitData, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse initData: %w", err)
+	}
+
+	hash, err := hex.DecodeString(values.Get("hash"))
+	if err != nil || len(hash) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	values.Del("hash")
+
+	if !hmac.Equal(hash, signInitData(values, token)) {
+		return nil, ErrInvalidSignature
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth_date: %w", err)
+	}
+	data := &InitData{
+		StartParam: values.Get("start_param"),
+		AuthDate:   time.Unix(authDate, 0),
+	}
+	if maxAge > 0 && now.Sub(data.AuthDate) > maxAge {
+		return nil, ErrExpired
+	}
+
+	if err := json.Unmarshal([]byte(values.Get("user")), &data.User); err != nil {
+		return nil, fmt.Errorf("invalid user: %w", err)
+	}
+	if data.User.ID == 0 {
+		return nil, fmt.Errorf("initData has no user")
+	}
+
+	return data, nil
+}
+
+// signInitData computes the HMAC of the sorted "key=value" lines, keyed with
+// the HMAC of the bot token under "WebAppData"
+func signInitData(values url.Values, token string) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + values.Get(key)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return mac.Sum(nil)
+}
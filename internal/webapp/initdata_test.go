@@ -0,0 +1,59 @@
+package webapp
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testToken = "123456:TEST-token"
+
+// testInitData builds initData signed like Telegram does
+func testInitData(token string, userID int64, startParam string, authDate time.Time) string {
+	values := url.Values{}
+	values.Set("query_id", "AAHdF6IQAAAAAN0XohDhrOrc")
+	values.Set("user", `{"id":`+strconv.FormatInt(userID, 10)+`,"first_name":"Ann","username":"ann","language_code":"en"}`)
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("start_param", startParam)
+	values.Set("hash", hex.EncodeToString(signInitData(values, token)))
+	return values.Encode()
+}
+
+func TestValidateInitData(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	initData := testInitData(testToken, 42, "-1001234567890", now.Add(-time.Minute))
+
+	data, err := ValidateInitData(initData, testToken, time.Hour, now)
+	if err != nil {
+		t.Fatalf("Failed to validate initData: %v", err)
+	}
+	if data.User.ID != 42 || data.User.Username != "ann" {
+		t.Errorf("Unexpected user %+v", data.User)
+	}
+	if data.StartParam != "-1001234567890" {
+		t.Errorf("Unexpected start parameter %q", data.StartParam)
+	}
+
+	if _, err := ValidateInitData(initData, "654321:other-token", time.Hour, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for another bot, got %v", err)
+	}
+
+	if _, err := ValidateInitData(initData, testToken, 30*time.Second, now); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired, got %v", err)
+	}
+
+	// Changing any field breaks the signature
+	values, _ := url.ParseQuery(initData)
+	values.Set("start_param", "-1009999999999")
+	if _, err := ValidateInitData(values.Encode(), testToken, time.Hour, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for tampered data, got %v", err)
+	}
+
+	values.Del("hash")
+	if _, err := ValidateInitData(values.Encode(), testToken, time.Hour, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature without hash, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package webapp
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gofency/internal/captcha"
+)
+
+//go:embed page.html
+var page []byte
+
+// maxRequestSize limits the size of API request bodies
+const maxRequestSize = 64 << 10
+
+// Checks of the slider drag recorded by the app
+const (
+	// minTrajectoryPoints is the least number of pointer events of a drag
+	minTrajectoryPoints = 5
+
+	// minDragTime is the least time a human takes to drag the slider
+	minDragTime = 300 * time.Millisecond
+
+	// maxTrajectoryStep is the largest jump between two pointer events, in
+	// percent of the track
+	maxTrajectoryStep = 25
+
+	// minSpeedVariation is the least relative spread of the drag speed,
+	// scripted drags move evenly
+	minSpeedVariation = 0.05
+)
+
+// Result is the outcome of an answer given in the Mini App
+type Result string
+
+const (
+	ResultPassed Result = "passed"
+	ResultRetry  Result = "retry"
+	ResultFailed Result = "failed"
+)
+
+// Verifications gives the server access to pending verifications
+type Verifications interface {
+	// Challenge returns the slider target of the user's pending verification
+	// in the chat and the seed of its challenge; the target is drawn into
+	// the puzzle and never sent as a number
+	Challenge(chatID, userID int64) (target int, seed uint64, ok bool)
+
+	// Answer checks the slider position and completes the verification or
+	// counts a wrong answer; an empty position is always wrong
+	Answer(ctx context.Context, chatID int64, user User, position string) (Result, error)
+}
+
+// Config configures the Mini App server
+type Config struct {
+	// Token is the bot token Telegram signs initData with
+	Token string
+
+	// MaxAge limits how long after opening the app its initData is accepted
+	MaxAge time.Duration
+
+	// MinSolveTime is the time a human needs at least; faster answers are wrong
+	MinSolveTime time.Duration
+}
+
+// Server serves the verification Mini App and its API
+type Server struct {
+	cfg           Config
+	verifications Verifications
+	mux           *http.ServeMux
+	now           func() time.Time
+
+	// issued holds when the challenges not answered yet were shown; the
+	// ones older than the initData they were shown with are pruned
+	mu     sync.Mutex
+	issued map[issueKey]time.Time
+}
+
+// issueKey identifies a challenge shown in the app
+type issueKey struct {
+	chatID int64
+	userID int64
+}
+
+// NewServer creates a Mini App server completing the verifications
+func NewServer(cfg Config, verifications Verifications) *Server {
+	s := &Server{
+		cfg:           cfg,
+		verifications: verifications,
+		mux:           http.NewServeMux(),
+		now:           time.Now,
+		issued:        make(map[issueKey]time.Time),
+	}
+
+	s.mux.HandleFunc("GET /{$}", s.handlePage)
+	s.mux.HandleFunc("POST /api/challenge", s.handleChallenge)
+	s.mux.HandleFunc("POST /api/answer", s.handleAnswer)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the app on the address until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down Mini App server: %v", err)
+		}
+	}()
+
+	log.Printf("Serving Mini App on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+type challengeRequest struct {
+	InitData string `json:"initData"`
+}
+
+type challengeResponse struct {
+	// Track and Piece are PNG data URLs of the slider puzzle
+	Track string `json:"track"`
+	Piece string `json:"piece"`
+}
+
+// handleChallenge returns the puzzle of the pending verification and starts
+// measuring the time the user takes
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	var req challengeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	data, chatID, ok := s.authenticate(w, req.InitData)
+	if !ok {
+		return
+	}
+
+	target, seed, ok := s.verifications.Challenge(chatID, data.User.ID)
+	if !ok {
+		http.Error(w, "no pending verification", http.StatusNotFound)
+		return
+	}
+
+	track, piece, err := captcha.RenderWebAppPuzzle(target, seed)
+	if err != nil {
+		log.Printf("Failed to render Mini App puzzle of user %d: %v", data.User.ID, err)
+		http.Error(w, "failed to render challenge", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.pruneIssued()
+	s.issued[issueKey{chatID: chatID, userID: data.User.ID}] = s.now()
+	s.mu.Unlock()
+
+	writeJSON(w, challengeResponse{Track: pngDataURL(track), Piece: pngDataURL(piece)})
+}
+
+// pruneIssued drops the challenges that can no longer be answered, as the
+// initData of any answer to them has expired. The caller holds s.mu.
+func (s *Server) pruneIssued() {
+	if s.cfg.MaxAge <= 0 {
+		return
+	}
+	for key, issuedAt := range s.issued {
+		if s.now().Sub(issuedAt) > s.cfg.MaxAge {
+			delete(s.issued, key)
+		}
+	}
+}
+
+type answerRequest struct {
+	InitData string `json:"initData"`
+	Position int    `json:"position"`
+
+	// Trajectory holds the slider position and the milliseconds since the
+	// drag started of every pointer event
+	Trajectory [][2]float64 `json:"trajectory"`
+}
+
+type answerResponse struct {
+	Result Result `json:"result"`
+}
+
+// handleAnswer checks the slider position of a challenge shown before
+func (s *Server) handleAnswer(w http.ResponseWriter, r *http.Request) {
+	var req answerRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	data, chatID, ok := s.authenticate(w, req.InitData)
+	if !ok {
+		return
+	}
+
+	key := issueKey{chatID: chatID, userID: data.User.ID}
+	s.mu.Lock()
+	issuedAt, ok := s.issued[key]
+	delete(s.issued, key)
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "challenge was not requested", http.StatusConflict)
+		return
+	}
+
+	position := strconv.Itoa(req.Position)
+	elapsed := s.now().Sub(issuedAt)
+	if elapsed < s.cfg.MinSolveTime {
+		log.Printf("Mini App answer of user %d in chat %d after %s is too fast", data.User.ID, chatID, elapsed)
+		position = ""
+	} else if err := checkTrajectory(req.Trajectory, req.Position, elapsed); err != nil {
+		log.Printf("Mini App answer of user %d in chat %d has an invalid drag: %v", data.User.ID, chatID, err)
+		position = ""
+	}
+
+	result, err := s.verifications.Answer(r.Context(), chatID, data.User, position)
+	if err != nil {
+		log.Printf("Failed to check Mini App answer of user %d: %v", data.User.ID, err)
+		http.Error(w, "failed to check answer", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, answerResponse{Result: result})
+}
+
+// checkTrajectory checks that the slider was dragged from the start to the
+// position like a human would, within the time the challenge was shown
+func checkTrajectory(points [][2]float64, position int, elapsed time.Duration) error {
+	if len(points) < minTrajectoryPoints {
+		return fmt.Errorf("%d pointer events, want at least %d", len(points), minTrajectoryPoints)
+	}
+	if points[0][0] > maxTrajectoryStep {
+		return fmt.Errorf("drag starts at %.1f%%, away from the slider", points[0][0])
+	}
+
+	var speeds []float64
+	for i := 1; i < len(points); i++ {
+		x, t := points[i][0], points[i][1]
+		dx, dt := x-points[i-1][0], t-points[i-1][1]
+		if x < 0 || x > 100 || dt < 0 {
+			return fmt.Errorf("invalid pointer event %v", points[i])
+		}
+		if math.Abs(dx) > maxTrajectoryStep {
+			return fmt.Errorf("slider jumps by %.1f%%", dx)
+		}
+		if dt > 0 {
+			speeds = append(speeds, math.Abs(dx)/dt)
+		}
+	}
+
+	last := points[len(points)-1]
+	if math.Abs(last[0]-float64(position)) > 1 {
+		return fmt.Errorf("drag ends at %.1f%%, not at %d%%", last[0], position)
+	}
+	duration := time.Duration((last[1] - points[0][1]) * float64(time.Millisecond))
+	if duration < minDragTime {
+		return fmt.Errorf("drag takes %s", duration)
+	}
+	if duration > elapsed {
+		return fmt.Errorf("drag takes %s, longer than the %s the challenge was shown", duration, elapsed)
+	}
+
+	// Human drags speed up and slow down
+	var mean, spread float64
+	for _, speed := range speeds {
+		mean += speed / float64(len(speeds))
+	}
+	for _, speed := range speeds {
+		spread = max(spread, math.Abs(speed-mean))
+	}
+	if mean == 0 || spread/mean < minSpeedVariation {
+		return fmt.Errorf("drag moves at a constant speed")
+	}
+	return nil
+}
+
+// pngDataURL embeds a PNG image in a data URL
+func pngDataURL(data []byte) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// authenticate validates initData and returns the chat of its start parameter
+func (s *Server) authenticate(w http.ResponseWriter, initData string) (*InitData, int64, bool) {
+	data, err := ValidateInitData(initData, s.cfg.Token, s.cfg.MaxAge, s.now())
+	if err != nil {
+		http.Error(w, "invalid initData", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+
+	chatID, err := captcha.ParseWebAppStartParam(data.StartParam)
+	if err != nil {
+		http.Error(w, "invalid start parameter", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	return data, chatID, true
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package webapp
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVerifications has one pending verification and accepts exact positions
+type fakeVerifications struct {
+	chatID  int64
+	userID  int64
+	target  int
+	answers []string
+}
+
+func (f *fakeVerifications) Challenge(chatID, userID int64) (int, uint64, bool) {
+	return f.target, 1, chatID == f.chatID && userID == f.userID
+}
+
+func (f *fakeVerifications) Answer(ctx context.Context, chatID int64, user User, position string) (Result, error) {
+	f.answers = append(f.answers, position)
+	if position == strconv.Itoa(f.target) {
+		return ResultPassed, nil
+	}
+	return ResultRetry, nil
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *fakeVerifications, *time.Time) {
+	t.Helper()
+
+	verifications := &fakeVerifications{chatID: -100500, userID: 42, target: 37}
+	server := NewServer(Config{Token: testToken, MaxAge: time.Hour, MinSolveTime: time.Second}, verifications)
+
+	now := time.Unix(1700000000, 0)
+	server.now = func() time.Time { return now }
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return ts, verifications, &now
+}
+
+func post(t *testing.T, ts *httptest.Server, path string, body any) *http.Response {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// humanTrajectory drags the slider from the start to the position, speeding
+// up and slowing down like a hand
+func humanTrajectory(position int) [][2]float64 {
+	const steps = 20
+	points := make([][2]float64, steps+1)
+	for i := range points {
+		progress := float64(i) / steps
+		eased := (1 - math.Cos(math.Pi*progress)) / 2
+		points[i] = [2]float64{eased * float64(position), 900 * progress}
+	}
+	return points
+}
+
+func TestServerServesPage(t *testing.T) {
+	ts, _, _ := newTestServer(t)
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Expected an HTML page, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestServerVerifiesAnswer(t *testing.T) {
+	ts, verifications, now := newTestServer(t)
+	initData := testInitData(testToken, 42, "-100500", now.Add(-time.Minute))
+
+	resp := post(t, ts, "/api/challenge", challengeRequest{InitData: initData})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var challenge map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Invalid challenge response: %v", err)
+	}
+	for _, field := range []string{"track", "piece"} {
+		if url, _ := challenge[field].(string); !strings.HasPrefix(url, "data:image/png;base64,") {
+			t.Errorf("Expected %s to be a PNG data URL, got %.40q", field, url)
+		}
+	}
+	if len(challenge) != 2 {
+		t.Errorf("Expected only the puzzle images, got %d fields", len(challenge))
+	}
+
+	*now = now.Add(3 * time.Second)
+	resp = post(t, ts, "/api/answer", answerRequest{InitData: initData, Position: 37, Trajectory: humanTrajectory(37)})
+	var answer answerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatalf("Invalid answer response: %v", err)
+	}
+	if answer.Result != ResultPassed {
+		t.Errorf("Expected result %q, got %q", ResultPassed, answer.Result)
+	}
+
+	// Every answer needs a challenge requested before it
+	resp = post(t, ts, "/api/answer", answerRequest{InitData: initData, Position: 37, Trajectory: humanTrajectory(37)})
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 for a repeated answer, got %d", resp.StatusCode)
+	}
+	if len(verifications.answers) != 1 {
+		t.Errorf("Expected one checked answer, got %v", verifications.answers)
+	}
+}
+
+func TestServerRejectsFastAnswer(t *testing.T) {
+	ts, verifications, now := newTestServer(t)
+	initData := testInitData(testToken, 42, "-100500", now.Add(-time.Minute))
+
+	post(t, ts, "/api/challenge", challengeRequest{InitData: initData})
+
+	*now = now.Add(100 * time.Millisecond)
+	resp := post(t, ts, "/api/answer", answerRequest{InitData: initData, Position: 37})
+	var answer answerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatalf("Invalid answer response: %v", err)
+	}
+	if answer.Result == ResultPassed {
+		t.Errorf("Expected an answer given in 100ms to be rejected")
+	}
+	if len(verifications.answers) != 1 || verifications.answers[0] != "" {
+		t.Errorf("Expected the answer to be checked as wrong, got %q", verifications.answers)
+	}
+}
+
+func TestServerRejectsReplayedTarget(t *testing.T) {
+	ts, verifications, now := newTestServer(t)
+	initData := testInitData(testToken, 42, "-100500", now.Add(-time.Minute))
+
+	// A script that learned the target posts it without dragging the slider,
+	// or with a drag no hand makes
+	straight := make([][2]float64, 10)
+	for i := range straight {
+		straight[i] = [2]float64{37 * float64(i) / 9, float64(100 * i)}
+	}
+	for _, trajectory := range [][][2]float64{
+		nil,
+		{{37, 0}},
+		{{0, 0}, {37, 1}, {37, 2}, {37, 3}, {37, 4}},
+		straight,
+		humanTrajectory(80),
+	} {
+		post(t, ts, "/api/challenge", challengeRequest{InitData: initData})
+		*now = now.Add(3 * time.Second)
+
+		resp := post(t, ts, "/api/answer", answerRequest{InitData: initData, Position: 37, Trajectory: trajectory})
+		var answer answerResponse
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+			t.Fatalf("Invalid answer response: %v", err)
+		}
+		if answer.Result == ResultPassed {
+			t.Errorf("Expected the target without a drag to it to be rejected, trajectory %v", trajectory)
+		}
+	}
+
+	for _, answer := range verifications.answers {
+		if answer != "" {
+			t.Errorf("Expected every answer to be checked as wrong, got %q", verifications.answers)
+			break
+		}
+	}
+}
+
+func TestServerRejectsInvalidInitData(t *testing.T) {
+	ts, _, now := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		initData string
+		status   int
+	}{
+		{"forged", testInitData("999:forged", 42, "-100500", *now), http.StatusUnauthorized},
+		{"expired", testInitData(testToken, 42, "-100500", now.Add(-2*time.Hour)), http.StatusUnauthorized},
+		{"no chat", testInitData(testToken, 42, "", *now), http.StatusBadRequest},
+		{"not pending", testInitData(testToken, 43, "-100500", *now), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(t, ts, "/api/challenge", challengeRequest{InitData: tt.initData})
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestServerPrunesUnansweredChallenges(t *testing.T) {
+	verifications := &fakeVerifications{chatID: -100500, userID: 42, target: 37}
+	server := NewServer(Config{Token: testToken, MaxAge: time.Hour}, verifications)
+	now := time.Unix(1700000000, 0)
+	server.now = func() time.Time { return now }
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	// Challenges of members who closed the app are never answered
+	server.issued[issueKey{chatID: -100500, userID: 7}] = now.Add(-2 * time.Hour)
+	server.issued[issueKey{chatID: -100500, userID: 8}] = now.Add(-time.Minute)
+
+	post(t, ts, "/api/challenge", challengeRequest{InitData: testInitData(testToken, 42, "-100500", now)})
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.issued[issueKey{chatID: -100500, userID: 7}]; ok || len(server.issued) != 2 {
+		t.Errorf("Expected only the challenges older than the initData to be pruned, got %v", server.issued)
+	}
+}